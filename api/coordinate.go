@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CoordinateDimensionality is how many dimensions the Euclidean part of a Coordinate has
+const CoordinateDimensionality = 8
+
+// coordinateFields are the fields of a Coordinate on the wire besides its vector: error, adjustment and height
+const coordinateFields = 3
+
+const float64Size = 8
+
+// Coordinate is a Vivaldi network coordinate of a member, in seconds, the distance between the coordinates
+// of two members estimates the round trip time between them.
+// Like Serf, it is a point in Euclidean space, plus a height for the latency of the access link of the member,
+// and an adjustment for what the space can not model.
+type Coordinate struct {
+	Vec []float64 `json:"vec"`
+	// Error is how confident the member is in its coordinate, lower is better
+	Error      float64 `json:"error"`
+	Adjustment float64 `json:"adjustment"`
+	Height     float64 `json:"height"`
+}
+
+// Clone returns a copy of the coordinate that shares nothing with it
+func (c *Coordinate) Clone() *Coordinate {
+	clone := *c
+	clone.Vec = append([]float64{}, c.Vec...)
+	return &clone
+}
+
+// IsValid is false if any of the fields is not a finite number
+func (c *Coordinate) IsValid() bool {
+	for _, value := range append([]float64{c.Error, c.Adjustment, c.Height}, c.Vec...) {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsCompatibleWith is true if both coordinates have as many dimensions, only then they have a distance
+func (c *Coordinate) IsCompatibleWith(other *Coordinate) bool {
+	return len(c.Vec) == len(other.Vec)
+}
+
+// DistanceTo estimates the round trip time between the members of the coordinates, they must be compatible
+func (c *Coordinate) DistanceTo(other *Coordinate) time.Duration {
+	distance := c.RawDistanceTo(other)
+	// the adjustments can make up for what the space can not model, but not make a distance negative
+	if adjusted := distance + c.Adjustment + other.Adjustment; adjusted > 0 {
+		distance = adjusted
+	}
+	return time.Duration(distance * float64(time.Second))
+}
+
+// RawDistanceTo is the distance in seconds between the coordinates, without their adjustments
+func (c *Coordinate) RawDistanceTo(other *Coordinate) float64 {
+	sum := 0.0
+	for i := range c.Vec {
+		difference := c.Vec[i] - other.Vec[i]
+		sum += difference * difference
+	}
+	return math.Sqrt(sum) + c.Height + other.Height
+}
+
+// WithCoordinate returns a copy of the message that carries the coordinate, as big endian float64 values
+func WithCoordinate(message []byte, coordinate *Coordinate) []byte {
+	values := append([]float64{coordinate.Error, coordinate.Adjustment, coordinate.Height}, coordinate.Vec...)
+	value := make([]byte, len(values)*float64Size)
+	for i, field := range values {
+		binary.BigEndian.PutUint64(value[i*float64Size:], math.Float64bits(field))
+	}
+	extended, err := AppendExtension(message, ExtensionCoordinate, value)
+	if err != nil {
+		// a coordinate of too many dimensions is left out, as members that do not understand it do
+		return message
+	}
+	return extended
+}
+
+// ReadCoordinate returns the coordinate the message carries, if it carries a valid one
+func ReadCoordinate(rawMessage []byte) (*Coordinate, bool) {
+	value, ok := readExtension(rawMessage, ExtensionCoordinate)
+	if !ok {
+		return nil, false
+	}
+	coordinate, err := decodeCoordinate(value)
+	return coordinate, err == nil
+}
+
+func decodeCoordinate(value []byte) (*Coordinate, error) {
+	if len(value)%float64Size != 0 || len(value) <= coordinateFields*float64Size {
+		return nil, fmt.Errorf("%w: coordinate of %d bytes", ErrBadField, len(value))
+	}
+	values := make([]float64, len(value)/float64Size)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.BigEndian.Uint64(value[i*float64Size:]))
+	}
+	coordinate := &Coordinate{Error: values[0], Adjustment: values[1], Height: values[2], Vec: values[coordinateFields:]}
+	if !coordinate.IsValid() {
+		return nil, fmt.Errorf("%w: coordinate is not valid", ErrBadField)
+	}
+	return coordinate, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoordinate_DistanceTo(t *testing.T) {
+	origin := &Coordinate{Vec: []float64{0, 0}}
+	tests := []struct {
+		name  string
+		other *Coordinate
+		want  time.Duration
+	}{
+		{"Same", origin, 0},
+		{"Vector", &Coordinate{Vec: []float64{0.003, 0.004}}, 5 * time.Millisecond},
+		{"Height", &Coordinate{Vec: []float64{0.003, 0.004}, Height: 0.001}, 6 * time.Millisecond},
+		{"Adjustment", &Coordinate{Vec: []float64{0.003, 0.004}, Adjustment: 0.002}, 7 * time.Millisecond},
+		// an adjustment does not make the distance negative, then it is left out
+		{"NegativeAdjustment", &Coordinate{Vec: []float64{0.003, 0.004}, Adjustment: -0.01}, 5 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := origin.DistanceTo(tt.other); got != tt.want {
+				t.Errorf("DistanceTo() = %v, want %v", got, tt.want)
+			}
+			if got := tt.other.DistanceTo(origin); got != tt.want {
+				t.Errorf("DistanceTo() the other way = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithCoordinate(t *testing.T) {
+	message := newTestMessage()
+	coordinate := &Coordinate{Vec: []float64{0.001, -0.002, 0, 0, 0, 0, 0, 0.5}, Error: 1.5, Adjustment: -0.0001, Height: 0.00001}
+	tests := []struct {
+		name           string
+		message        []byte
+		wantCoordinate *Coordinate
+	}{
+		{name: "None", message: message},
+		{name: "Coordinate", message: WithCoordinate(message, coordinate), wantCoordinate: coordinate},
+		{name: "Traced", message: WithTraceContext(WithCoordinate(message, coordinate), testTraceContext), wantCoordinate: coordinate},
+		{name: "TooManyDimensions", message: WithCoordinate(message, &Coordinate{Vec: make([]float64, 32)})},
+		{name: "Invalid", message: WithCoordinate(message, &Coordinate{Vec: make([]float64, 2), Height: math.Inf(1)})},
+		{name: "WrongSize", message: func() []byte { m, _ := AppendExtension(message, ExtensionCoordinate, make([]byte, 30)); return m }()},
+		{name: "NoVector", message: WithCoordinate(message, &Coordinate{Error: 1.5})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ReadCoordinate(tt.message)
+			if ok != (tt.wantCoordinate != nil) || (ok && !reflect.DeepEqual(got, tt.wantCoordinate)) {
+				t.Errorf("ReadCoordinate() = %+v, %v, want %+v", got, ok, tt.wantCoordinate)
+			}
+		})
+	}
+}
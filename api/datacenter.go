@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+)
+
+// ExtensionDatacenter carries the datacenter of the member the message is about, see Member.Datacenter
+const ExtensionDatacenter byte = 0x09
+
+// MaxDatacenterSize is how many bytes the name of a datacenter can take
+const MaxDatacenterSize = 64
+
+// ValidateDatacenter checks the datacenter name consists of letters, digits, '-', '_' and '.'
+func ValidateDatacenter(datacenter string) error {
+	return validateAreaName("datacenter", datacenter, MaxDatacenterSize)
+}
+
+// WithDatacenter returns a copy of the message that carries the datacenter,
+// a datacenter that does not pass ValidateDatacenter is left out
+func WithDatacenter(message []byte, datacenter string) []byte {
+	if datacenter == "" || ValidateDatacenter(datacenter) != nil {
+		return message
+	}
+	extended, _ := AppendExtension(message, ExtensionDatacenter, []byte(datacenter)) // always fits
+	return extended
+}
+
+// ReadDatacenter returns the datacenter of the member, if the message carries it
+func ReadDatacenter(rawMessage []byte) (string, bool) {
+	value, ok := readExtension(rawMessage, ExtensionDatacenter)
+	if !ok {
+		return "", false
+	}
+	datacenter, err := decodeDatacenter(value)
+	return datacenter, err == nil
+}
+
+func decodeDatacenter(value []byte) (string, error) {
+	if len(value) == 0 {
+		return "", fmt.Errorf("%w: datacenter is empty", ErrBadField)
+	}
+	if err := ValidateDatacenter(string(value)); err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestWithDatacenter(t *testing.T) {
+	ip, _ := NewIP4Address("10.0.0.1")
+	tests := []struct {
+		name           string
+		datacenter     string
+		wantDatacenter string
+		wantOk         bool
+	}{
+		{"None", "", "", false},
+		{"Datacenter", "eu-west", "eu-west", true},
+		{"Longest", strings.Repeat("x", MaxDatacenterSize), strings.Repeat("x", MaxDatacenterSize), true},
+		{"TooLong", strings.Repeat("x", MaxDatacenterSize+1), "", false},
+		{"Invalid", "eu west", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7778", Datacenter: tt.datacenter}
+			message := HelloMessage.CreateMemberMessage(member)
+			datacenter, ok := ReadDatacenter(message)
+			if datacenter != tt.wantDatacenter || ok != tt.wantOk {
+				t.Errorf("ReadDatacenter() = %q, %v, want %q, %v", datacenter, ok, tt.wantDatacenter, tt.wantOk)
+			}
+			read, _, err := ReadMemberMessage(message, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Datacenter != tt.wantDatacenter {
+				t.Errorf("ReadMemberMessage() is in datacenter %q, want %q", read.Datacenter, tt.wantDatacenter)
+			}
+		})
+	}
+}
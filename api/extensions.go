@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// A message can carry extensions after its header, each as a type byte, a length byte and the value.
+// Members that do not know about extensions, or about a type of extension, ignore them.
+
+// ExtensionTraceContext carries the W3C trace context of the span that sent the message
+const ExtensionTraceContext byte = 0x01
+
+// ExtensionSequence carries the sequence number of a heartbeat request, which its response echoes
+const ExtensionSequence byte = 0x02
+
+// ExtensionCoordinate carries the network coordinate of the member that sent the message, see Coordinate
+const ExtensionCoordinate byte = 0x03
+
+// ExtensionTags carries the tags of the member that sent the message, see Member.Tags
+const ExtensionTags byte = 0x04
+
+const extensionHeaderSize = 2
+const maxExtensionSize = 255
+const traceContextSize = 25
+const sequenceSize = 4
+
+// TraceContext is the trace and span that sent a message, as in the W3C traceparent header
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// String formats the trace context as a version 00 traceparent header
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(tc.TraceID[:]), hex.EncodeToString(tc.SpanID[:]), tc.Flags)
+}
+
+// AppendExtension returns a copy of the message with the extension added, the message itself is left as is
+func AppendExtension(message []byte, extensionType byte, value []byte) ([]byte, error) {
+	if len(value) > maxExtensionSize {
+		return nil, fmt.Errorf("extension of %d bytes is larger than %d bytes", len(value), maxExtensionSize)
+	}
+	extended := make([]byte, len(message), len(message)+extensionHeaderSize+len(value))
+	copy(extended, message)
+	extended = append(extended, extensionType, byte(len(value)))
+	return append(extended, value...), nil
+}
+
+// ReadExtensions returns the values of the extensions of a message, by their type
+func ReadExtensions(rawMessage []byte) (map[byte][]byte, error) {
+	if len(rawMessage) == 0 {
+		return nil, fmt.Errorf("%w: message is empty", ErrTruncated)
+	}
+	messageType, ok := MessageTypeByPrefix(rawMessage[0])
+	if !ok {
+		return nil, fmt.Errorf("%w: prefix 0x%02x", ErrUnknownType, rawMessage[0])
+	}
+	if len(rawMessage) < messageType.HeaderSize() {
+		return nil, fmt.Errorf("%w: %d bytes is shorter than the %d bytes of a %s message",
+			ErrTruncated, len(rawMessage), messageType.HeaderSize(), messageType.Name)
+	}
+	extensions := make(map[byte][]byte)
+	cursor := messageType.HeaderSize()
+	for cursor < len(rawMessage) {
+		if cursor+extensionHeaderSize > len(rawMessage) {
+			return nil, fmt.Errorf("%w: extension at byte %d", ErrTruncated, cursor)
+		}
+		extensionType := rawMessage[cursor]
+		size := int(rawMessage[cursor+1])
+		cursor += extensionHeaderSize
+		if cursor+size > len(rawMessage) {
+			return nil, fmt.Errorf("%w: extension 0x%02x of %d bytes", ErrTruncated, extensionType, size)
+		}
+		extensions[extensionType] = rawMessage[cursor : cursor+size]
+		cursor += size
+	}
+	return extensions, nil
+}
+
+// readExtension returns the value of the extension of the given type, if the message carries one
+func readExtension(rawMessage []byte, extensionType byte) ([]byte, bool) {
+	extensions, err := ReadExtensions(rawMessage)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := extensions[extensionType]
+	return value, ok
+}
+
+// readMemberExtensions reads what the extensions tell about the member, the extensions we know have to hold what they
+// should, or the message is rejected with ErrBadField, those we do not know are ignored
+func readMemberExtensions(member *Member, extensions map[byte][]byte) error {
+	var err error
+	for extensionType, value := range extensions {
+		switch extensionType {
+		case ExtensionNodeID:
+			member.ID, err = decodeNodeID(value)
+		case ExtensionStarted:
+			member.Started, err = decodeStarted(value)
+		case ExtensionTags:
+			member.Tags, err = decodeTags(value)
+		case ExtensionSegment:
+			member.Segment, member.Gateway, err = decodeSegment(value)
+		case ExtensionDatacenter:
+			member.Datacenter, err = decodeDatacenter(value)
+		case ExtensionTraceContext:
+			_, err = decodeTraceContext(value)
+		case ExtensionSequence:
+			_, err = decodeSequence(value)
+		case ExtensionCoordinate:
+			_, err = decodeCoordinate(value)
+		case ExtensionNameConflict:
+			_, err = decodeNameConflict(value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExtensionSize returns an ErrBadField when the value of an extension is not of the size it should be
+func checkExtensionSize(name string, value []byte, size int) error {
+	if len(value) != size {
+		return fmt.Errorf("%w: %s of %d bytes, not %d", ErrBadField, name, len(value), size)
+	}
+	return nil
+}
+
+// WithTraceContext returns a copy of the message that carries the trace context
+func WithTraceContext(message []byte, traceContext TraceContext) []byte {
+	value := make([]byte, 0, traceContextSize)
+	value = append(value, traceContext.TraceID[:]...)
+	value = append(value, traceContext.SpanID[:]...)
+	value = append(value, traceContext.Flags)
+	extended, _ := AppendExtension(message, ExtensionTraceContext, value) // always fits
+	return extended
+}
+
+// ReadTraceContext returns the trace context the message carries, if it carries one
+func ReadTraceContext(rawMessage []byte) (TraceContext, bool) {
+	value, ok := readExtension(rawMessage, ExtensionTraceContext)
+	if !ok {
+		return TraceContext{}, false
+	}
+	traceContext, err := decodeTraceContext(value)
+	return traceContext, err == nil
+}
+
+func decodeTraceContext(value []byte) (TraceContext, error) {
+	var traceContext TraceContext
+	if err := checkExtensionSize("trace context", value, traceContextSize); err != nil {
+		return traceContext, err
+	}
+	copy(traceContext.TraceID[:], value[0:16])
+	copy(traceContext.SpanID[:], value[16:24])
+	traceContext.Flags = value[24]
+	return traceContext, nil
+}
+
+// WithSequence returns a copy of the message that carries the sequence number
+func WithSequence(message []byte, sequence uint32) []byte {
+	value := make([]byte, sequenceSize)
+	binary.BigEndian.PutUint32(value, sequence)
+	extended, _ := AppendExtension(message, ExtensionSequence, value) // always fits
+	return extended
+}
+
+// ReadSequence returns the sequence number the message carries, if it carries one
+func ReadSequence(rawMessage []byte) (uint32, bool) {
+	value, ok := readExtension(rawMessage, ExtensionSequence)
+	if !ok {
+		return 0, false
+	}
+	sequence, err := decodeSequence(value)
+	return sequence, err == nil
+}
+
+func decodeSequence(value []byte) (uint32, error) {
+	if err := checkExtensionSize("sequence number", value, sequenceSize); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(value), nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+var testTraceContext = TraceContext{
+	TraceID: [16]byte{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:  [8]byte{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	Flags:   0x01,
+}
+
+func newTestMessage() []byte {
+	ip, _ := NewIP4Address("10.0.0.1")
+	member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780", Clock: 42}
+	return HeartbeatRequestMessage.CreateMemberMessage(member)
+}
+
+func TestTraceContext_String(t *testing.T) {
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if got := testTraceContext.String(); got != want {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+}
+
+func TestWithTraceContext(t *testing.T) {
+	message := newTestMessage()
+	original := append([]byte{}, message...)
+	traced := WithTraceContext(message, testTraceContext)
+
+	if !bytes.Equal(message, original) {
+		t.Errorf("WithTraceContext() changed the original message")
+	}
+	if len(traced) != len(message)+extensionHeaderSize+traceContextSize {
+		t.Errorf("traced message is %d bytes, want %d", len(traced), len(message)+extensionHeaderSize+traceContextSize)
+	}
+	got, ok := ReadTraceContext(traced)
+	if !ok || got != testTraceContext {
+		t.Errorf("ReadTraceContext() = %v, %v, want %v, true", got, ok, testTraceContext)
+	}
+
+	// the header is read as before, so members that do not know about extensions can still read the message
+	member, messageType, err := ReadMemberMessage(traced, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7780})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType.Name != HeartbeatRequestMessage.Name || member.MemberName != "Alan" || member.Clock != 42 {
+		t.Errorf("ReadMemberMessage() = %+v, %v, want the heartbeat request of Alan", member, messageType.Name)
+	}
+}
+
+func TestWithSequence(t *testing.T) {
+	message := newTestMessage()
+	tests := []struct {
+		name         string
+		message      []byte
+		wantSequence uint32
+		wantOK       bool
+	}{
+		{name: "None", message: message},
+		{name: "Zero", message: WithSequence(message, 0), wantSequence: 0, wantOK: true},
+		{name: "Max", message: WithSequence(message, 0xffffffff), wantSequence: 0xffffffff, wantOK: true},
+		{name: "Traced", message: WithTraceContext(WithSequence(message, 7), testTraceContext), wantSequence: 7, wantOK: true},
+		{name: "WrongSize", message: func() []byte { m, _ := AppendExtension(message, ExtensionSequence, []byte{0x01}); return m }()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sequence, ok := ReadSequence(tt.message)
+			if sequence != tt.wantSequence || ok != tt.wantOK {
+				t.Errorf("ReadSequence() = %v, %v, want %v, %v", sequence, ok, tt.wantSequence, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReadExtensions(t *testing.T) {
+	message := newTestMessage()
+	unknown, _ := AppendExtension(message, 0x7f, []byte{0x01, 0x02})
+	unknownAndTraced := WithTraceContext(unknown, testTraceContext)
+	tests := []struct {
+		name             string
+		message          []byte
+		wantExtensions   int
+		wantTraceContext bool
+		wantErr          error
+	}{
+		{name: "None", message: message},
+		{name: "Unknown", message: unknown, wantExtensions: 1},
+		{name: "UnknownAndTraced", message: unknownAndTraced, wantExtensions: 2, wantTraceContext: true},
+		{name: "TruncatedHeader", message: append(append([]byte{}, message...), ExtensionTraceContext), wantErr: ErrTruncated},
+		{name: "TruncatedValue", message: unknownAndTraced[:len(unknownAndTraced)-1], wantErr: ErrTruncated},
+		{name: "TooShort", message: message[:10], wantErr: ErrTruncated},
+		{name: "UnknownType", message: append([]byte{0xff}, message[1:]...), wantErr: ErrUnknownType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extensions, err := ReadExtensions(tt.message)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadExtensions() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(extensions) != tt.wantExtensions {
+				t.Errorf("ReadExtensions() = %d extensions, want %d", len(extensions), tt.wantExtensions)
+			}
+			if _, ok := ReadTraceContext(tt.message); ok != tt.wantTraceContext {
+				t.Errorf("ReadTraceContext() found a trace context = %v, want %v", ok, tt.wantTraceContext)
+			}
+		})
+	}
+}
+
+func TestAppendExtension_TooLarge(t *testing.T) {
+	if _, err := AppendExtension(newTestMessage(), 0x7f, make([]byte, maxExtensionSize+1)); err == nil {
+		t.Errorf("AppendExtension() of %d bytes did not fail", maxExtensionSize+1)
+	}
+}
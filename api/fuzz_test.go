@@ -0,0 +1,150 @@
+//go:build go1.18
+
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fuzzSeeds are valid messages of every type, with and without extensions
+func fuzzSeeds() [][]byte {
+	ip, _ := NewIP4Address("10.0.0.1")
+	member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780", Clock: 42}
+	seeds := make([][]byte, 0)
+	for _, messageType := range MessageTypes {
+		identified := *member
+		identified.ID = NodeID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x41, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+		identified.Started = time.Unix(1760866200, 0)
+		identified.Segment = "eu-west"
+		identified.Gateway = true
+		identified.Datacenter = "dc1"
+		seeds = append(seeds, messageType.CreateMemberMessage(&identified),
+			WithNameConflict(messageType.CreateMemberMessage(&identified), NameConflict{Winner: NodeID{0x01}, Voter: NodeID{0x02}}))
+		message := messageType.CreateMemberMessage(member)
+		unknown, _ := AppendExtension(message, 0x7f, []byte{0x01, 0x02})
+		coordinate := &Coordinate{Vec: make([]float64, CoordinateDimensionality), Error: 1.5}
+		seeds = append(seeds, message, WithTraceContext(message, testTraceContext), WithSequence(message, 7),
+			WithCoordinate(message, coordinate), WithTags(message, map[string]string{"zone": "eu-west-1a"}), unknown, message[:10])
+	}
+	return seeds
+}
+
+// requireDecodeError fails unless the error is one a decoder may return
+func requireDecodeError(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrTruncated) && !errors.Is(err, ErrUnknownType) && !errors.Is(err, ErrBadField) {
+		t.Fatalf("error %v is not ErrTruncated, ErrUnknownType or ErrBadField", err)
+	}
+}
+
+func FuzzReadMemberMessage(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, rawMessage []byte) {
+		member, messageType, err := ReadMemberMessage(rawMessage, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2)})
+		if err != nil {
+			requireDecodeError(t, err)
+			return
+		}
+		// whatever we can read, we write the same way, the extensions aside
+		header := rawMessage[:messageType.HeaderSize()]
+		recreated := messageType.CreateMemberMessage(member)
+		if !bytes.Equal(recreated[:messageType.HeaderSize()], header) {
+			t.Errorf("CreateMemberMessage(ReadMemberMessage(%x)) = %x", header, recreated)
+		}
+		if id, _ := ReadNodeID(recreated); id != member.ID {
+			t.Errorf("CreateMemberMessage() carries node ID %v, want %v", id, member.ID)
+		}
+		if started, _ := ReadStarted(recreated); !started.Equal(member.Started) {
+			t.Errorf("CreateMemberMessage() carries start %v, want %v", started, member.Started)
+		}
+		if segment, gateway, _ := ReadSegment(recreated); segment != member.Segment || gateway != member.Gateway {
+			t.Errorf("CreateMemberMessage() carries segment %q (gateway %v), want %q (gateway %v)", segment, gateway, member.Segment, member.Gateway)
+		}
+		if datacenter, _ := ReadDatacenter(recreated); datacenter != member.Datacenter {
+			t.Errorf("CreateMemberMessage() carries datacenter %q, want %q", datacenter, member.Datacenter)
+		}
+		if len(member.Tags) > 0 {
+			if tags, ok := ReadTags(WithTags(header, member.Tags)); !ok || !reflect.DeepEqual(tags, member.Tags) {
+				t.Errorf("ReadTags(WithTags(%v)) = %v, %v", member.Tags, tags, ok)
+			}
+		}
+	})
+}
+
+func FuzzReadExtensions(f *testing.F) {
+	for _, seed := range fuzzSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, rawMessage []byte) {
+		extensions, err := ReadExtensions(rawMessage)
+		if err != nil {
+			requireDecodeError(t, err)
+			if _, ok := ReadTraceContext(rawMessage); ok {
+				t.Errorf("ReadTraceContext() found a trace context in a message with unreadable extensions")
+			}
+			return
+		}
+		for extensionType, value := range extensions {
+			if len(value) > maxExtensionSize {
+				t.Errorf("extension 0x%02x has %d bytes, more than fit", extensionType, len(value))
+			}
+		}
+		if traceContext, ok := ReadTraceContext(rawMessage); ok {
+			value := extensions[ExtensionTraceContext]
+			if !bytes.Equal(value[0:16], traceContext.TraceID[:]) || !bytes.Equal(value[16:24], traceContext.SpanID[:]) || value[24] != traceContext.Flags {
+				t.Errorf("ReadTraceContext() = %v, want the value of the extension %x", traceContext, value)
+			}
+		}
+	})
+}
+
+func FuzzCreateMemberMessage(f *testing.F) {
+	f.Add(uint8(0), "Alan", "Boreas", uint32(0x0a000001), uint16(7780), int64(42))
+	f.Add(uint8(1), "MySelf", "localhost-truncated", uint32(0), uint16(1), int64(-1))
+	f.Add(uint8(2), "Zoë", "Ελλάδα", uint32(0xffffffff), uint16(65535), int64(0))
+	f.Fuzz(func(t *testing.T, typeIndex uint8, name string, hostname string, ip uint32, port uint16, clock int64) {
+		messageType := MessageTypes[int(typeIndex)%len(MessageTypes)]
+		var ipBytes [4]byte
+		binary.BigEndian.PutUint32(ipBytes[:], ip)
+		ipSelf := IP4Address{A: ipBytes[0], B: ipBytes[1], C: ipBytes[2], D: ipBytes[3]}
+		member := &Member{MemberName: name, Hostname: hostname, IPSelf: &ipSelf, PortSelf: strconv.Itoa(int(port)), Clock: clock}
+
+		message := messageType.CreateMemberMessage(member)
+		if len(message) != messageType.HeaderSize() {
+			t.Fatalf("CreateMemberMessage() = %d bytes, want %d", len(message), messageType.HeaderSize())
+		}
+		read, readType, err := ReadMemberMessage(message, nil)
+		if err != nil {
+			// the fields are cut off at their size, which can make them unreadable, but the message is complete
+			if !errors.Is(err, ErrBadField) {
+				t.Fatalf("ReadMemberMessage(CreateMemberMessage()) error = %v, want only ErrBadField", err)
+			}
+			return
+		}
+		if readType.Prefix != messageType.Prefix {
+			t.Errorf("ReadMemberMessage() type = %s, want %s", readType.Name, messageType.Name)
+		}
+		if read.MemberName != truncateField(name, MemberNameField) || read.Hostname != truncateField(hostname, HostnameField) ||
+			read.PortSelf != member.PortSelf || *read.IPSelf != ipSelf || read.Clock != clock {
+			t.Errorf("ReadMemberMessage(CreateMemberMessage(%+v)) = %+v", member, read)
+		}
+	})
+}
+
+// truncateField is what is left of the value after writing it to the field, and reading it back
+func truncateField(value string, field MessageField) string {
+	if len(value) > field.Size {
+		value = value[:field.Size]
+	}
+	return strings.TrimRight(value, "\x00")
+}
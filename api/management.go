@@ -0,0 +1,194 @@
+package api
+
+import (
+	"embed"
+	"time"
+)
+
+const ManagementAddress = "127.0.0.1:7788"
+const ManagementAPIVersion = "v1"
+
+// Schemas holds the JSON schemas describing the documents of the management API
+//
+//go:embed schemas/*.json
+var Schemas embed.FS
+
+// MemberState describes how a node regards one of the members it knows about
+type MemberState string
+
+const (
+	MemberStateAlive  MemberState = "alive"
+	MemberStateFailed MemberState = "failed"
+	MemberStateLeft   MemberState = "left"
+)
+
+// MemberInfo is the management API representation of a Member.
+// Its ID is the node ID of the member, or its Name if it does not send one, see Member.Identifier.
+type MemberInfo struct {
+	ID         string         `json:"id"`
+	Name       string         `json:"name"`
+	MemberName string         `json:"memberName"`
+	Hostname   string         `json:"hostname"`
+	IP         string         `json:"ip,omitempty"`
+	IPSelf     string         `json:"ipSelf,omitempty"`
+	Port       string         `json:"port,omitempty"`
+	State      MemberState    `json:"state"`
+	LastSeen   time.Time      `json:"lastSeen"`
+	Clock      int64          `json:"clock"`
+	Heartbeat  *HeartbeatInfo `json:"heartbeat,omitempty"`
+	// Started is when the member started, if it sends it
+	Started *time.Time `json:"started,omitempty"`
+	// Tags are the key/value pairs the member describes itself with
+	Tags map[string]string `json:"tags,omitempty"`
+	// Segment is the network area the member is in, it is empty for the default segment
+	Segment string `json:"segment,omitempty"`
+	// Gateway is true for members that exchange state with the other segments
+	Gateway bool `json:"gateway,omitempty"`
+	// Datacenter is the datacenter of a member of the WAN pool
+	Datacenter string `json:"datacenter,omitempty"`
+	// Coordinate is the network coordinate the member sent us last
+	Coordinate *Coordinate `json:"coordinate,omitempty"`
+	// EstimatedRoundTrip is the round trip time in seconds from the node to the member, estimated by their coordinates
+	EstimatedRoundTrip float64 `json:"estimatedRoundTrip,omitempty"`
+}
+
+// HeartbeatInfo is the management API representation of the heartbeat tracking of a Member
+type HeartbeatInfo struct {
+	MissedResponses   int       `json:"missedResponses"`
+	LastResponse      time.Time `json:"lastResponse"`
+	LastResponseClock int64     `json:"lastResponseClock"`
+	// Phi is how suspicious it is that the member has not responded, it is considered failed from the phi threshold on
+	Phi float64 `json:"phi"`
+	// LateResponses is how many responses arrived after their request timed out, or answered no request we sent
+	LateResponses int            `json:"lateResponses"`
+	RoundTrip     *RoundTripInfo `json:"roundTrip,omitempty"`
+}
+
+// RoundTripInfo is the management API representation of how long the heartbeats of a Member take, in seconds
+type RoundTripInfo struct {
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	P99     float64 `json:"p99"`
+	// Timeout is how long we wait for the response to a heartbeat request
+	Timeout float64 `json:"timeout"`
+}
+
+// NodeInfo is the management API summary of a node and what it knows about the cluster
+type NodeInfo struct {
+	Self       MemberInfo          `json:"self"`
+	ServerPort string              `json:"serverPort"`
+	Members    map[MemberState]int `json:"members"`
+	ShortList  []string            `json:"shortList"`
+	// LocalHealth is how unhealthy the node considers itself, 0 is healthy, see membership.maxLocalHealth
+	LocalHealth int `json:"localHealth"`
+	// Datacenter is the datacenter the node is in
+	Datacenter string `json:"datacenter,omitempty"`
+	// WAN describes the WAN pool, if the node is one of the servers of its datacenter that joined it
+	WAN *WANInfo `json:"wan,omitempty"`
+}
+
+// WANInfo is the management API representation of the WAN pool, as one of its servers sees it
+type WANInfo struct {
+	ServerPort string              `json:"serverPort"`
+	Members    map[MemberState]int `json:"members"`
+	// Datacenters are the datacenters with a server in the WAN pool that is alive, ours included
+	Datacenters []string `json:"datacenters"`
+}
+
+// MemberEventType is the kind of change a MemberEvent reports
+type MemberEventType string
+
+const (
+	MemberEventJoin       MemberEventType = "member-join"
+	MemberEventLeave      MemberEventType = "member-leave"
+	MemberEventFailed     MemberEventType = "member-failed"
+	MemberEventReap       MemberEventType = "member-reap"
+	MemberEventForceLeave MemberEventType = "member-force-leave"
+	// MemberEventUpdate is a member that is still alive, but changed its name or tags
+	MemberEventUpdate MemberEventType = "member-update"
+	// MemberEventConflict is a member that claims the name of another node, see MemberEvent.ConflictsWith
+	MemberEventConflict MemberEventType = "member-conflict"
+)
+
+// MemberEvent is streamed by the management API whenever the membership of a member changes
+type MemberEvent struct {
+	Type   MemberEventType `json:"type"`
+	Time   time.Time       `json:"time"`
+	Member MemberInfo      `json:"member"`
+	// ConflictsWith is the ID of the node that claims the same name as the member, for a member-conflict
+	ConflictsWith string `json:"conflictsWith,omitempty"`
+}
+
+// ServiceState is whether a MembershipService is doing its work
+type ServiceState string
+
+const (
+	ServiceStateRunning ServiceState = "running"
+	// ServiceStateRestarting is a service that failed, and waits to be started again
+	ServiceStateRestarting ServiceState = "restarting"
+	// ServiceStateFailed is a service that failed too often in a row, which shuts the node down
+	ServiceStateFailed  ServiceState = "failed"
+	ServiceStateStopped ServiceState = "stopped"
+)
+
+// ServiceStatus is the state of a MembershipService, and how often it had to be restarted
+type ServiceStatus struct {
+	State     ServiceState `json:"state"`
+	Restarts  int          `json:"restarts"`
+	LastError string       `json:"lastError,omitempty"`
+}
+
+// HealthStatus tells if the node is alive, which is when its services are running or restarting
+type HealthStatus struct {
+	Healthy  bool                     `json:"healthy"`
+	Services map[string]ServiceStatus `json:"services"`
+}
+
+// ReadinessStatus tells if the node takes part in the cluster, and if not, why
+type ReadinessStatus struct {
+	Ready          bool     `json:"ready"`
+	Healthy        bool     `json:"healthy"`
+	HealthyMembers int      `json:"healthyMembers"`
+	MinMembers     int      `json:"minMembers"`
+	Reasons        []string `json:"reasons"`
+}
+
+// JoinRequest asks a node to announce itself to the member listening on Address
+type JoinRequest struct {
+	Address string `json:"address"`
+}
+
+// ErrorResponse is returned by the management API for every request it cannot fulfill
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewMemberInfo creates the management API representation of a Member
+func NewMemberInfo(member *Member, state MemberState) MemberInfo {
+	info := MemberInfo{
+		ID:         member.Identifier(),
+		Name:       member.Name(),
+		MemberName: member.MemberName,
+		Hostname:   member.Hostname,
+		Port:       member.PortSelf,
+		State:      state,
+		LastSeen:   member.LastSeen,
+		Clock:      member.Clock,
+		Tags:       member.Tags,
+		Segment:    member.Segment,
+		Gateway:    member.Gateway,
+		Datacenter: member.Datacenter,
+	}
+	if member.IP != nil {
+		info.IP = member.IP.String()
+	}
+	if member.IPSelf != nil {
+		info.IPSelf = member.IPSelf.String()
+	}
+	if !member.Started.IsZero() {
+		started := member.Started
+		info.Started = &started
+	}
+	return info
+}
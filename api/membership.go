@@ -1,13 +1,18 @@
 package api
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const MembershipNetwork = "udp4"
@@ -15,6 +20,8 @@ const MembershipGroupAddress = "230.0.0.0:7791"
 
 const GoodbyePrefix byte = 0x02
 const GoodbyePrefixSize = 1
+const GoodbyeAckPrefix byte = 0x03
+const GoodbyeAckPrefixSize = 1
 const HelloPrefix byte = 0x01
 const HelloPrefixSize = 1
 const HelloPort = "7777"
@@ -27,6 +34,19 @@ const HeartbeatResponsePrefixSize = 1
 const MemberFailureDetectedPrefix byte = 0x20
 const MemberFailureDetectedPrefixSize = 1
 
+const NameConflictPrefix byte = 0x30
+const NameConflictPrefixSize = 1
+
+// The errors a message can be rejected with, wrapped with the details, check for them with errors.Is
+var (
+	// ErrTruncated means the message is shorter than its header, or an extension is cut off
+	ErrTruncated = errors.New("message is truncated")
+	// ErrUnknownType means the prefix of the message is not that of a type we know
+	ErrUnknownType = errors.New("unknown message type")
+	// ErrBadField means a field does not hold what it should, such as a port that is not a number
+	ErrBadField = errors.New("message has a bad field")
+)
+
 type MessageField struct {
 	Name            string
 	Size            int
@@ -35,12 +55,15 @@ type MessageField struct {
 }
 
 type MessageType struct {
+	Name          string
 	Prefix        byte
 	PrefixSize    int
 	MessageFields []MessageField
 }
 
 type Member struct {
+	// ID identifies the member, it is zero for members that do not send one, see Identifier
+	ID         NodeID
 	MemberName string
 	Hostname   string
 	IP         *IP4Address
@@ -48,6 +71,16 @@ type Member struct {
 	IPSelf     *IP4Address
 	LastSeen   time.Time
 	Clock      int64
+	// Started is when the member started, it is zero for members that do not send it
+	Started    time.Time
+	// Tags are key/value pairs the member describes itself with, such as its zone or role, see ValidateTags
+	Tags       map[string]string
+	// Segment is the network area the member is in, the empty segment is the default segment, see ValidateSegment
+	Segment    string
+	// Gateway members exchange state with the gateways of the other segments
+	Gateway    bool
+	// Datacenter is the datacenter of a member of the WAN pool, it is empty for the members of a cluster
+	Datacenter string
 }
 
 var MemberNameField MessageField
@@ -58,9 +91,15 @@ var ClockField MessageField
 
 var HelloMessage MessageType
 var GoodbyeMessage MessageType
+var GoodbyeAckMessage MessageType
 var HeartbeatRequestMessage MessageType
 var HeartbeatResponseMessage MessageType
 var MemberFailureDetected MessageType
+// NameConflictMessage is about a member that lost its name to another node, see NameConflict
+var NameConflictMessage MessageType
+
+// MessageTypes holds every type of message we know how to read and create
+var MessageTypes []MessageType
 
 func init() {
 	MemberNameField = MessageField{
@@ -96,30 +135,69 @@ func init() {
 	}
 
 	HelloMessage = MessageType{
+		Name:          "Hello",
 		Prefix:        HelloPrefix,
 		PrefixSize:    HelloPrefixSize,
 		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
 	}
 	GoodbyeMessage = MessageType{
+		Name:          "Goodbye",
 		Prefix:        GoodbyePrefix,
 		PrefixSize:    GoodbyePrefixSize,
 		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
 	}
+	GoodbyeAckMessage = MessageType{
+		Name:          "GoodbyeAck",
+		Prefix:        GoodbyeAckPrefix,
+		PrefixSize:    GoodbyeAckPrefixSize,
+		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
+	}
 	HeartbeatRequestMessage = MessageType{
+		Name:          "HeartbeatRequest",
 		Prefix:        HeartbeatRequestPrefix,
 		PrefixSize:    HeartbeatRequestPrefixSize,
 		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
 	}
 	HeartbeatResponseMessage = MessageType{
+		Name:          "HeartbeatResponse",
 		Prefix:        HeartbeatResponsePrefix,
 		PrefixSize:    HeartbeatResponsePrefixSize,
 		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
 	}
 	MemberFailureDetected = MessageType{
+		Name:          "MemberFailureDetected",
 		Prefix:        MemberFailureDetectedPrefix,
 		PrefixSize:    MemberFailureDetectedPrefixSize,
 		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
 	}
+	NameConflictMessage = MessageType{
+		Name:          "NameConflict",
+		Prefix:        NameConflictPrefix,
+		PrefixSize:    NameConflictPrefixSize,
+		MessageFields: []MessageField{MemberNameField, HostnameField, IPField, PortField, ClockField},
+	}
+	MessageTypes = []MessageType{HelloMessage, GoodbyeMessage, GoodbyeAckMessage, HeartbeatRequestMessage, HeartbeatResponseMessage, MemberFailureDetected, NameConflictMessage}
+}
+
+// MessageTypeByName finds the message type with the given name, ignoring case and dashes, so "heartbeat-request" works too
+func MessageTypeByName(name string) (MessageType, bool) {
+	normalizedName := strings.ReplaceAll(name, "-", "")
+	for _, messageType := range MessageTypes {
+		if strings.EqualFold(messageType.Name, normalizedName) {
+			return messageType, true
+		}
+	}
+	return MessageType{}, false
+}
+
+// MessageTypeByPrefix finds the message type a message starting with the prefix is
+func MessageTypeByPrefix(prefix byte) (MessageType, bool) {
+	for _, messageType := range MessageTypes {
+		if messageType.Prefix == prefix {
+			return messageType, true
+		}
+	}
+	return MessageType{}, false
 }
 
 func (mt MessageType) HeaderSize() int {
@@ -152,10 +230,31 @@ func (mt MessageType) CreateMemberMessage(m *Member) []byte {
 		message = appendHeaderToMessage(message, cursor, cursor+field.Size, fieldValue)
 		cursor += field.Size
 	}
+	if !m.ID.IsZero() {
+		message = WithNodeID(message, m.ID)
+	}
+	if !m.Started.IsZero() {
+		message = WithStarted(message, m.Started)
+	}
+	if m.Segment != "" || m.Gateway {
+		message = WithSegment(message, m.Segment, m.Gateway)
+	}
+	if m.Datacenter != "" {
+		message = WithDatacenter(message, m.Datacenter)
+	}
 	return message
 }
 
+// Identifier is what we know the member by: its node ID, or its name if it does not send a node ID
 func (m *Member) Identifier() string {
+	if !m.ID.IsZero() {
+		return m.ID.String()
+	}
+	return m.Name()
+}
+
+// Name is MemberName@Hostname, which is how people know the member, but other members can have the same name
+func (m *Member) Name() string {
 	return m.MemberName + "@" + m.Hostname
 }
 
@@ -170,30 +269,22 @@ func appendHeaderToMessage(message []byte, start int, end int, data []byte) []by
 	return message
 }
 
+// ReadMemberMessage reads the member from a message, the origin is the address it came from, if it came from the network.
+// A message that cannot be read, its extensions included, is rejected with ErrTruncated, ErrUnknownType or ErrBadField,
+// it never panics.
 func ReadMemberMessage(rawMessage []byte, messageOriginAddress *net.UDPAddr) (*Member, MessageType, error) {
-	bytesProcessed := 0
-	var messageType MessageType
-	messagePrefix, bytesProcessed := getHeader(rawMessage, bytesProcessed, HelloPrefixSize)
-
-	if len(messagePrefix) == HelloPrefixSize {
-		prefix := messagePrefix[0]
-		switch prefix {
-		case HelloPrefix:
-			messageType = HelloMessage
-		case GoodbyePrefix:
-			messageType = GoodbyeMessage
-		case HeartbeatResponsePrefix:
-			messageType = HeartbeatResponseMessage
-		case HeartbeatRequestPrefix:
-			messageType = HeartbeatRequestMessage
-		case MemberFailureDetectedPrefix:
-			messageType = MemberFailureDetected
-		default:
-			return nil, messageType, errors.New("unknown message type")
-		}
-	} else {
-		return nil, messageType, errors.New("unreadable message")
+	if len(rawMessage) == 0 {
+		return nil, MessageType{}, fmt.Errorf("%w: message is empty", ErrTruncated)
+	}
+	messageType, ok := MessageTypeByPrefix(rawMessage[0])
+	if !ok {
+		return nil, messageType, fmt.Errorf("%w: prefix 0x%02x", ErrUnknownType, rawMessage[0])
 	}
+	if len(rawMessage) < messageType.HeaderSize() {
+		return nil, messageType, fmt.Errorf("%w: %d bytes is shorter than the %d bytes of a %s message",
+			ErrTruncated, len(rawMessage), messageType.HeaderSize(), messageType.Name)
+	}
+	bytesProcessed := messageType.PrefixSize
 
 	// TODO replace with reading from messageType struct
 
@@ -210,13 +301,25 @@ func ReadMemberMessage(rawMessage []byte, messageOriginAddress *net.UDPAddr) (*M
 		D: selfKnownIP[3],
 	}
 
+	for _, field := range []struct {
+		field MessageField
+		value []byte
+	}{{MemberNameField, memberName}, {HostnameField, hostname}, {PortField, port}} {
+		if err := checkStringField(field.field, field.value); err != nil {
+			return nil, messageType, err
+		}
+	}
 	memberName = removeEmptyBytes(memberName)
 	hostname = removeEmptyBytes(hostname)
 	port = removeEmptyBytes(port)
+	if portNumber, err := strconv.Atoi(string(port)); err != nil || portNumber < 1 || portNumber > 65535 {
+		return nil, messageType, fmt.Errorf("%w: %s %q is not a port number", ErrBadField, PortField.Name, port)
+	}
 
-	originAddress, err := NewIP4Address(messageOriginAddress.String())
-	if err != nil {
-		fmt.Printf("Ran into an error: %s\n", err)
+	// without a known origin, such as a message that did not come from the network, the IP stays empty
+	var originAddress IP4Address
+	if messageOriginAddress != nil {
+		originAddress, _ = NewIP4Address(messageOriginAddress.String())
 	}
 
 	member := &Member{
@@ -227,9 +330,33 @@ func ReadMemberMessage(rawMessage []byte, messageOriginAddress *net.UDPAddr) (*M
 		PortSelf:   string(port),
 		Clock:      int64(binary.LittleEndian.Uint64(clock)),
 	}
+	extensions, err := ReadExtensions(rawMessage)
+	if err != nil {
+		return nil, messageType, err
+	}
+	if err := readMemberExtensions(member, extensions); err != nil {
+		return nil, messageType, err
+	}
 	return member, messageType, nil
 }
 
+// checkStringField verifies the field is text, padded with zero bytes at the end, as CreateMemberMessage writes it
+func checkStringField(field MessageField, value []byte) error {
+	text := bytes.TrimRight(value, "\x00")
+	if bytes.IndexByte(text, 0) >= 0 {
+		return fmt.Errorf("%w: %s has a zero byte before its end", ErrBadField, field.Name)
+	}
+	if !utf8.Valid(text) {
+		return fmt.Errorf("%w: %s is not valid UTF-8", ErrBadField, field.Name)
+	}
+	for _, r := range string(text) {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: %s has a control character", ErrBadField, field.Name)
+		}
+	}
+	return nil
+}
+
 func removeEmptyBytes(bytesRead []byte) []byte {
 	bytesToReturn := make([]byte, 0)
 	for _, byteRead := range bytesRead {
@@ -268,8 +395,7 @@ func ConstructMemberFailureDetectedMessage(member *Member) []byte {
 func ConstructHeartbeatRequestMessage(name string, localAddress string, port string) []byte {
 	member, err := constructMemberForMessage(name, localAddress, port)
 	if err != nil {
-		fmt.Printf("Ran into an error trying to create a Member for heartbeat request message: %v\n", err)
-		panic("Cannot instantiate HeartbeatRequestMessage")
+		panic(fmt.Sprintf("Cannot instantiate HeartbeatRequestMessage: %v", err))
 	}
 	return HeartbeatRequestMessage.CreateMemberMessage(member)
 }
@@ -277,8 +403,7 @@ func ConstructHeartbeatRequestMessage(name string, localAddress string, port str
 func ConstructHeartbeatResponseMessage(name string, localAddress string, port string) []byte {
 	member, err := constructMemberForMessage(name, localAddress, port)
 	if err != nil {
-		fmt.Printf("Ran into an error trying to create a Member for heartbeat response message: %v\n", err)
-		panic("Cannot instantiate HeartbeatResponseMessage")
+		panic(fmt.Sprintf("Cannot instantiate HeartbeatResponseMessage: %v", err))
 	}
 	return HeartbeatResponseMessage.CreateMemberMessage(member)
 }
@@ -286,17 +411,23 @@ func ConstructHeartbeatResponseMessage(name string, localAddress string, port st
 func ConstructGoodbyeMessage(name string, localAddress string, port string) []byte {
 	member, err := constructMemberForMessage(name, localAddress, port)
 	if err != nil {
-		fmt.Printf("Ran into an error trying to create a Member for goodbye message: %v\n", err)
-		panic("Cannot instantiate GoodbyeMessage")
+		panic(fmt.Sprintf("Cannot instantiate GoodbyeMessage: %v", err))
 	}
 	return GoodbyeMessage.CreateMemberMessage(member)
 }
 
+func ConstructGoodbyeAckMessage(name string, localAddress string, port string) []byte {
+	member, err := constructMemberForMessage(name, localAddress, port)
+	if err != nil {
+		panic(fmt.Sprintf("Cannot instantiate GoodbyeAckMessage: %v", err))
+	}
+	return GoodbyeAckMessage.CreateMemberMessage(member)
+}
+
 func ConstructHelloMessage(name string, localAddress string, port string) []byte {
 	member, err := constructMemberForMessage(name, localAddress, port)
 	if err != nil {
-		fmt.Printf("Ran into an error trying to create a Member for hello message: %v\n", err)
-		panic("Cannot instantiate HelloMessage")
+		panic(fmt.Sprintf("Cannot instantiate HelloMessage: %v", err))
 	}
 	return HelloMessage.CreateMemberMessage(member)
 }
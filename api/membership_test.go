@@ -1,7 +1,10 @@
 package api
 
 import (
+	"errors"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -166,6 +169,9 @@ func CreateBasicTestData(memberName string, hostname string, ipAddress string, p
 		basicTestWant = append(basicTestWant, portPadding...)
 	}
 
+	// the clock of a freshly constructed member is zero
+	basicTestWant = append(basicTestWant, make([]byte, ClockField.Size)...)
+
 	return basicTestWant
 }
 
@@ -179,26 +185,77 @@ func createPadding(size int, bytes []byte) []byte {
 }
 
 func TestReadMemberMessage(t *testing.T) {
-	type args struct {
-		rawMessage []byte
+	message := CreateBasicTestData("Alan", "Boreas", "10.0.0.1", "7780")
+	withField := func(field int, value string) []byte {
+		modified := append([]byte{}, message...)
+		copy(modified[field:], value)
+		return modified
+	}
+	nameStart := HelloPrefixSize
+	portStart := HelloPrefixSize + MemberNameField.Size + HostnameField.Size + IPField.Size
+	selfIP, _ := NewIP4Address("10.0.0.1")
+	originIP, _ := NewIP4Address("10.0.0.2")
+	withExtension := func(extensionType byte, value []byte) []byte {
+		extended, _ := AppendExtension(message, extensionType, value)
+		return extended
 	}
+	identified := WithNodeID(message, NodeID{0x01})
+	traced := WithTraceContext(message, TraceContext{TraceID: [16]byte{0x01}, SpanID: [8]byte{0x02}, Flags: 0x01})
 	tests := []struct {
 		name    string
-		args    args
+		message []byte
+		origin  *net.UDPAddr
 		want    *Member
-		wantErr bool
+		wantErr error
 	}{
-		// TODO: Add test cases.
+		{
+			name:    "Hello",
+			message: message,
+			origin:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 7780},
+			want:    &Member{MemberName: "Alan", Hostname: "Boreas", IP: &originIP, IPSelf: &selfIP, PortSelf: "7780"},
+		},
+		{
+			name:    "WithoutOrigin",
+			message: message,
+			want:    &Member{MemberName: "Alan", Hostname: "Boreas", IP: &IP4Address{}, IPSelf: &selfIP, PortSelf: "7780"},
+		},
+		{name: "Empty", message: []byte{}, wantErr: ErrTruncated},
+		{name: "OnlyPrefix", message: message[:1], wantErr: ErrTruncated},
+		{name: "Truncated", message: message[:len(message)-1], wantErr: ErrTruncated},
+		{name: "UnknownType", message: append([]byte{0xff}, message[1:]...), wantErr: ErrUnknownType},
+		{name: "ZeroInName", message: withField(nameStart, "Al\x00an"), wantErr: ErrBadField},
+		{name: "InvalidUTF8", message: withField(nameStart, "Al\xffan"), wantErr: ErrBadField},
+		{name: "ControlCharacter", message: withField(nameStart, "Al\nan"), wantErr: ErrBadField},
+		{name: "PortNotANumber", message: withField(portStart, "77a0"), wantErr: ErrBadField},
+		{name: "PortOutOfRange", message: withField(portStart, "77800"), wantErr: ErrBadField},
+		{name: "NoPort", message: withField(portStart, "\x00\x00\x00\x00"), wantErr: ErrBadField},
+		{
+			name:    "WithTraceContext",
+			message: traced,
+			want:    &Member{MemberName: "Alan", Hostname: "Boreas", IP: &IP4Address{}, IPSelf: &selfIP, PortSelf: "7780"},
+		},
+		{
+			name:    "WithNodeID",
+			message: identified,
+			want:    &Member{ID: NodeID{0x01}, MemberName: "Alan", Hostname: "Boreas", IP: &IP4Address{}, IPSelf: &selfIP, PortSelf: "7780"},
+		},
+		{name: "ExtensionLengthCutOff", message: append(append([]byte{}, message...), ExtensionTraceContext), wantErr: ErrTruncated},
+		{name: "ExtensionValueCutOff", message: traced[:len(traced)-1], wantErr: ErrTruncated},
+		{name: "TraceContextTooLong", message: withExtension(ExtensionTraceContext, make([]byte, traceContextSize+1)), wantErr: ErrBadField},
+		{name: "NodeIDTooLong", message: withExtension(ExtensionNodeID, make([]byte, nodeIDSize+1)), wantErr: ErrBadField},
+		{name: "StartedTooLong", message: withExtension(ExtensionStarted, make([]byte, startedSize+1)), wantErr: ErrBadField},
+		{name: "SequenceTooLong", message: withExtension(ExtensionSequence, make([]byte, sequenceSize+1)), wantErr: ErrBadField},
+		{name: "DatacenterTooLong", message: withExtension(ExtensionDatacenter, []byte(strings.Repeat("d", MaxDatacenterSize+1))), wantErr: ErrBadField},
+		{name: "UnknownExtension", message: withExtension(0x7f, make([]byte, 200)), want: &Member{MemberName: "Alan", Hostname: "Boreas", IP: &IP4Address{}, IPSelf: &selfIP, PortSelf: "7780"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _, err := ReadMemberMessage(tt.args.rawMessage, nil)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ReadMemberMessage() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			got, _, err := ReadMemberMessage(tt.message, tt.origin)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadMemberMessage() error = %v, want %v", err, tt.wantErr)
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ReadMemberMessage() got = %v, want %v", got, tt.want)
+				t.Errorf("ReadMemberMessage() got = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
@@ -253,3 +310,25 @@ func Test_getHeader(t *testing.T) {
 		})
 	}
 }
+
+func TestMessageTypeByName(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   MessageType
+		wantOk bool
+	}{
+		{name: "Hello", want: HelloMessage, wantOk: true},
+		{name: "heartbeat-request", want: HeartbeatRequestMessage, wantOk: true},
+		{name: "HEARTBEATRESPONSE", want: HeartbeatResponseMessage, wantOk: true},
+		{name: "member-failure-detected", want: MemberFailureDetected, wantOk: true},
+		{name: "Shout", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := MessageTypeByName(tt.name)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MessageTypeByName(%q) = %v, %v, want %v, %v", tt.name, got.Name, ok, tt.want.Name, tt.wantOk)
+			}
+		})
+	}
+}
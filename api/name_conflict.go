@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+)
+
+// ExtensionNameConflict carries which node keeps the name a NameConflict message is about, and which node says so
+const ExtensionNameConflict byte = 0x07
+
+const nameConflictSize = 2 * nodeIDSize
+
+// NameConflict tells the member a NameConflict message is about that another node keeps their name
+type NameConflict struct {
+	// Winner is the node that keeps the name
+	Winner NodeID
+	// Voter is the node that decided so, and sent the message
+	Voter NodeID
+}
+
+// WithNameConflict returns a copy of the message that carries the name conflict
+func WithNameConflict(message []byte, conflict NameConflict) []byte {
+	value := make([]byte, 0, nameConflictSize)
+	value = append(value, conflict.Winner[:]...)
+	value = append(value, conflict.Voter[:]...)
+	extended, _ := AppendExtension(message, ExtensionNameConflict, value) // always fits
+	return extended
+}
+
+// ReadNameConflict returns the name conflict the message carries, if it carries one with both node IDs
+func ReadNameConflict(rawMessage []byte) (NameConflict, bool) {
+	value, ok := readExtension(rawMessage, ExtensionNameConflict)
+	if !ok {
+		return NameConflict{}, false
+	}
+	conflict, err := decodeNameConflict(value)
+	return conflict, err == nil
+}
+
+func decodeNameConflict(value []byte) (NameConflict, error) {
+	var conflict NameConflict
+	if err := checkExtensionSize("name conflict", value, nameConflictSize); err != nil {
+		return conflict, err
+	}
+	copy(conflict.Winner[:], value[:nodeIDSize])
+	copy(conflict.Voter[:], value[nodeIDSize:])
+	if conflict.Winner.IsZero() || conflict.Voter.IsZero() {
+		return conflict, fmt.Errorf("%w: name conflict without both node IDs", ErrBadField)
+	}
+	return conflict, nil
+}
+
+// OlderMember returns which of the two members started first, those that do not send when they started come last.
+// When that does not tell them apart, the one with the lowest identifier is older, so every member picks the same one.
+func OlderMember(member *Member, other *Member) *Member {
+	switch {
+	case member.Started.IsZero() != other.Started.IsZero():
+		if member.Started.IsZero() {
+			return other
+		}
+		return member
+	case !member.Started.Equal(other.Started):
+		if member.Started.Before(other.Started) {
+			return member
+		}
+		return other
+	case member.Identifier() > other.Identifier():
+		return other
+	}
+	return member
+}
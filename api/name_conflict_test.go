@@ -0,0 +1,87 @@
+package api
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWithNameConflict(t *testing.T) {
+	conflict := NameConflict{Winner: NodeID{0x01}, Voter: NodeID{0x02}}
+	message := NameConflictMessage.CreateMemberMessage(&Member{ID: NodeID{0x03}, MemberName: "Alan", Hostname: "Boreas", IPSelf: &IP4Address{}, PortSelf: "7780"})
+	tests := []struct {
+		name    string
+		message []byte
+		want    NameConflict
+		wantOK  bool
+	}{
+		{name: "None", message: message},
+		{name: "Conflict", message: WithNameConflict(message, conflict), want: conflict, wantOK: true},
+		{name: "Traced", message: WithTraceContext(WithNameConflict(message, conflict), testTraceContext), want: conflict, wantOK: true},
+		{name: "WithoutVoter", message: WithNameConflict(message, NameConflict{Winner: NodeID{0x01}}), want: NameConflict{Winner: NodeID{0x01}}},
+		{name: "WrongSize", message: func() []byte { m, _ := AppendExtension(message, ExtensionNameConflict, []byte{0x01}); return m }()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ReadNameConflict(tt.message)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ReadNameConflict() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	// the member is the one that lost its name
+	member, messageType, err := ReadMemberMessage(WithNameConflict(message, conflict), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType.Name != NameConflictMessage.Name || member.ID != (NodeID{0x03}) || member.Name() != "Alan@Boreas" {
+		t.Errorf("ReadMemberMessage() = %+v, %v, want the name conflict of Alan", member, messageType.Name)
+	}
+}
+
+func TestWithStarted(t *testing.T) {
+	ip, _ := NewIP4Address("10.0.0.1")
+	started := time.Date(2026, 10, 19, 9, 30, 0, 123, time.UTC)
+	member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780", Started: started}
+	read, _, err := ReadMemberMessage(HelloMessage.CreateMemberMessage(member), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Started.Equal(started) {
+		t.Errorf("Started = %v, want %v", read.Started, started)
+	}
+	if _, ok := ReadStarted(WithStarted(newTestMessage(), time.Unix(0, 0))); ok {
+		t.Error("ReadStarted() of the Unix epoch succeeded, want it to mean the member did not send when it started")
+	}
+}
+
+func TestOlderMember(t *testing.T) {
+	now := time.Now()
+	newMember := func(id byte, started time.Time) *Member {
+		return &Member{ID: NodeID{id}, MemberName: "Alan", Hostname: "Boreas", Started: started}
+	}
+	tests := []struct {
+		name   string
+		member *Member
+		other  *Member
+		want   int
+	}{
+		{"StartedFirst", newMember(0x02, now.Add(-time.Minute)), newMember(0x01, now), 0},
+		{"StartedLast", newMember(0x01, now), newMember(0x02, now.Add(-time.Minute)), 1},
+		{"UnknownStart", newMember(0x01, time.Time{}), newMember(0x02, now), 1},
+		{"SameStart", newMember(0x02, now), newMember(0x01, now), 1},
+		{"NeitherKnown", newMember(0x01, time.Time{}), newMember(0x02, time.Time{}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := []*Member{tt.member, tt.other}[tt.want]
+			if got := OlderMember(tt.member, tt.other); got != want {
+				t.Errorf("OlderMember() = %v, want %v", got.Identifier(), want.Identifier())
+			}
+			if got := OlderMember(tt.other, tt.member); got != want {
+				t.Errorf("OlderMember() with the members swapped = %v, want %v", got.Identifier(), want.Identifier())
+			}
+		})
+	}
+}
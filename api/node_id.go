@@ -0,0 +1,83 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ExtensionNodeID carries the node ID of the member the message is about, see Member.ID
+const ExtensionNodeID byte = 0x05
+
+const nodeIDSize = 16
+
+// NodeID identifies a node, as a random UUID. Unlike its name, no two nodes share it,
+// and a node that restarts without keeping its ID is a new node.
+type NodeID [nodeIDSize]byte
+
+// NewNodeID returns a random, version 4, UUID
+func NewNodeID() (NodeID, error) {
+	var id NodeID
+	if _, err := rand.Read(id[:]); err != nil {
+		return id, fmt.Errorf("could not generate a node ID: %w", err)
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id, nil
+}
+
+// ParseNodeID reads a node ID in the form String writes it, with or without the dashes
+func ParseNodeID(text string) (NodeID, error) {
+	var id NodeID
+	digits := strings.ReplaceAll(strings.TrimSpace(text), "-", "")
+	if len(digits) != 2*nodeIDSize {
+		return id, fmt.Errorf("%w: node ID %q does not have %d hexadecimal digits", ErrBadField, text, 2*nodeIDSize)
+	}
+	if _, err := hex.Decode(id[:], []byte(digits)); err != nil {
+		return id, fmt.Errorf("%w: node ID %q is not hexadecimal", ErrBadField, text)
+	}
+	if id.IsZero() {
+		return id, fmt.Errorf("%w: node ID is all zeros", ErrBadField)
+	}
+	return id, nil
+}
+
+// String formats the node ID as a UUID, such as 6ba7b810-9dad-41d1-80b4-00c04fd430c8
+func (id NodeID) String() string {
+	digits := hex.EncodeToString(id[:])
+	return digits[0:8] + "-" + digits[8:12] + "-" + digits[12:16] + "-" + digits[16:20] + "-" + digits[20:32]
+}
+
+// IsZero reports whether this is no node ID at all, as members that do not send one have
+func (id NodeID) IsZero() bool {
+	return id == NodeID{}
+}
+
+// WithNodeID returns a copy of the message that carries the node ID
+func WithNodeID(message []byte, id NodeID) []byte {
+	extended, _ := AppendExtension(message, ExtensionNodeID, id[:]) // always fits
+	return extended
+}
+
+// ReadNodeID returns the node ID the message carries, if it carries one
+func ReadNodeID(rawMessage []byte) (NodeID, bool) {
+	value, ok := readExtension(rawMessage, ExtensionNodeID)
+	if !ok {
+		return NodeID{}, false
+	}
+	id, err := decodeNodeID(value)
+	return id, err == nil
+}
+
+func decodeNodeID(value []byte) (NodeID, error) {
+	var id NodeID
+	if err := checkExtensionSize("node ID", value, nodeIDSize); err != nil {
+		return id, err
+	}
+	copy(id[:], value)
+	if id.IsZero() {
+		return NodeID{}, fmt.Errorf("%w: node ID is all zeros", ErrBadField)
+	}
+	return id, nil
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseNodeID(t *testing.T) {
+	want := NodeID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x41, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"UUID", "6ba7b810-9dad-41d1-80b4-00c04fd430c8", false},
+		{"WithoutDashes", "6ba7b8109dad41d180b400c04fd430c8", false},
+		{"Newline", "6ba7b810-9dad-41d1-80b4-00c04fd430c8\n", false},
+		{"TooShort", "6ba7b810-9dad-41d1-80b4", true},
+		{"NotHexadecimal", "6ba7b810-9dad-41d1-80b4-00c04fd430zz", true},
+		{"Zero", "00000000-0000-0000-0000-000000000000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := ParseNodeID(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNodeID(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrBadField) {
+				t.Errorf("ParseNodeID(%q) error = %v, want ErrBadField", tt.text, err)
+			}
+			if err == nil && id != want {
+				t.Errorf("ParseNodeID(%q) = %v, want %v", tt.text, id, want)
+			}
+		})
+	}
+	if got := want.String(); got != "6ba7b810-9dad-41d1-80b4-00c04fd430c8" {
+		t.Errorf("String() = %q, want the UUID", got)
+	}
+}
+
+func TestNewNodeID(t *testing.T) {
+	id, err := NewNodeID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.IsZero() || id[6]>>4 != 4 || id[8]>>6 != 2 {
+		t.Errorf("NewNodeID() = %v, want a random version 4 UUID", id)
+	}
+	if other, _ := NewNodeID(); other == id {
+		t.Errorf("NewNodeID() returned %v twice", id)
+	}
+	if parsed, err := ParseNodeID(id.String()); err != nil || parsed != id {
+		t.Errorf("ParseNodeID(%v) = %v, %v", id, parsed, err)
+	}
+}
+
+func TestMember_Identifier(t *testing.T) {
+	ip, _ := NewIP4Address("10.0.0.1")
+	member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780"}
+	tests := []struct {
+		name string
+		id   NodeID
+		want string
+	}{
+		{"WithoutNodeID", NodeID{}, "Alan@Boreas"},
+		{"WithNodeID", NodeID{0x6b, 0xa7, 0xb8, 0x10}, "6ba7b810-0000-0000-0000-000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member.ID = tt.id
+			read, _, err := ReadMemberMessage(HelloMessage.CreateMemberMessage(member), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := read.Identifier(); got != tt.want {
+				t.Errorf("Identifier() = %q, want %q", got, tt.want)
+			}
+			if got := read.Name(); got != "Alan@Boreas" {
+				t.Errorf("Name() = %q, want Alan@Boreas", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+)
+
+// ExtensionSegment carries the segment of the member the message is about, and whether it is a gateway, see Member.Segment
+const ExtensionSegment byte = 0x08
+
+// MaxSegmentSize is how many bytes the name of a segment can take
+const MaxSegmentSize = 64
+
+// segmentGateway is the flag the segment extension starts with when the member is a gateway
+const segmentGateway byte = 0x01
+
+// ValidateSegment checks the segment name is empty, for the default segment, or consists of letters, digits, '-', '_' and '.'
+func ValidateSegment(segment string) error {
+	return validateAreaName("segment", segment, MaxSegmentSize)
+}
+
+// validateAreaName checks the name of a network area, such as a segment or a datacenter,
+// consists of at most maxSize letters, digits, '-', '_' and '.'
+func validateAreaName(kind string, name string, maxSize int) error {
+	if len(name) > maxSize {
+		return fmt.Errorf("%w: %s %q is longer than %d bytes", ErrBadField, kind, name, maxSize)
+	}
+	for _, character := range name {
+		switch {
+		case character >= 'a' && character <= 'z', character >= 'A' && character <= 'Z', character >= '0' && character <= '9':
+		case character == '-', character == '_', character == '.':
+		default:
+			return fmt.Errorf("%w: %s %q contains %q, only letters, digits, '-', '_' and '.' are allowed", ErrBadField, kind, name, character)
+		}
+	}
+	return nil
+}
+
+// WithSegment returns a copy of the message that carries the segment and whether the member is a gateway,
+// a segment that does not pass ValidateSegment is left out
+func WithSegment(message []byte, segment string, gateway bool) []byte {
+	if ValidateSegment(segment) != nil {
+		segment = ""
+	}
+	flags := byte(0)
+	if gateway {
+		flags |= segmentGateway
+	}
+	extended, _ := AppendExtension(message, ExtensionSegment, append([]byte{flags}, segment...)) // always fits
+	return extended
+}
+
+// ReadSegment returns the segment of the member and whether it is a gateway, if the message carries them
+func ReadSegment(rawMessage []byte) (segment string, gateway bool, ok bool) {
+	value, ok := readExtension(rawMessage, ExtensionSegment)
+	if !ok {
+		return "", false, false
+	}
+	segment, gateway, err := decodeSegment(value)
+	return segment, gateway, err == nil
+}
+
+func decodeSegment(value []byte) (segment string, gateway bool, err error) {
+	if len(value) < 1 {
+		return "", false, fmt.Errorf("%w: segment has no flags", ErrBadField)
+	}
+	if err := ValidateSegment(string(value[1:])); err != nil {
+		return "", false, err
+	}
+	return string(value[1:]), value[0]&segmentGateway != 0, nil
+}
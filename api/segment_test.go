@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestValidateSegment(t *testing.T) {
+	tests := []struct {
+		name    string
+		segment string
+		wantErr bool
+	}{
+		{"Default", "", false},
+		{"Name", "eu-west_1.dc", false},
+		{"Longest", strings.Repeat("x", MaxSegmentSize), false},
+		{"TooLong", strings.Repeat("x", MaxSegmentSize+1), true},
+		{"Space", "eu west", true},
+		{"Comma", "eu,us", true},
+		{"NotASCII", "zoë", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSegment(tt.segment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateSegment(%q) error = %v, wantErr %v", tt.segment, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrBadField) {
+				t.Errorf("ValidateSegment(%q) error = %v, want ErrBadField", tt.segment, err)
+			}
+		})
+	}
+}
+
+func TestWithSegment(t *testing.T) {
+	ip, _ := NewIP4Address("10.0.0.1")
+	tests := []struct {
+		name        string
+		segment     string
+		gateway     bool
+		wantSegment string
+		wantOk      bool
+	}{
+		{"DefaultSegment", "", false, "", false},
+		{"Segment", "eu-west", false, "eu-west", true},
+		{"Gateway", "eu-west", true, "eu-west", true},
+		{"DefaultSegmentGateway", "", true, "", true},
+		{"Invalid", "eu west", true, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member := &Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780", Segment: tt.segment, Gateway: tt.gateway}
+			message := HelloMessage.CreateMemberMessage(member)
+			segment, gateway, ok := ReadSegment(message)
+			if segment != tt.wantSegment || gateway != (tt.gateway && tt.wantOk) || ok != tt.wantOk {
+				t.Errorf("ReadSegment() = %q, %v, %v, want %q, %v, %v", segment, gateway, ok, tt.wantSegment, tt.gateway, tt.wantOk)
+			}
+			read, _, err := ReadMemberMessage(message, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if read.Segment != tt.wantSegment || read.Gateway != (tt.gateway && tt.wantOk) {
+				t.Errorf("ReadMemberMessage() is in segment %q (gateway %v), want %q", read.Segment, read.Gateway, tt.wantSegment)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// ExtensionStarted carries when the member the message is about started, see Member.Started
+const ExtensionStarted byte = 0x06
+
+const startedSize = 8
+
+// WithStarted returns a copy of the message that carries when the member started, in nanoseconds since the Unix epoch
+func WithStarted(message []byte, started time.Time) []byte {
+	value := make([]byte, startedSize)
+	binary.BigEndian.PutUint64(value, uint64(started.UnixNano()))
+	extended, _ := AppendExtension(message, ExtensionStarted, value) // always fits
+	return extended
+}
+
+// ReadStarted returns when the member started, if the message carries it
+func ReadStarted(rawMessage []byte) (time.Time, bool) {
+	value, ok := readExtension(rawMessage, ExtensionStarted)
+	if !ok {
+		return time.Time{}, false
+	}
+	started, err := decodeStarted(value)
+	return started, err == nil
+}
+
+func decodeStarted(value []byte) (time.Time, error) {
+	if err := checkExtensionSize("start", value, startedSize); err != nil {
+		return time.Time{}, err
+	}
+	nanoseconds := int64(binary.BigEndian.Uint64(value))
+	if nanoseconds == 0 {
+		return time.Time{}, fmt.Errorf("%w: start is zero", ErrBadField)
+	}
+	return time.Unix(0, nanoseconds), nil
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxTagsSize is how many bytes the tags of a member can take in a message, each key and value takes its length plus one
+const MaxTagsSize = maxExtensionSize
+
+// ValidateTags checks the tags fit in a message, and can be written as key=value pairs separated by commas
+func ValidateTags(tags map[string]string) error {
+	for key, value := range tags {
+		switch {
+		case key == "":
+			return fmt.Errorf("%w: tag key is empty", ErrBadField)
+		case strings.ContainsAny(key, "=,"):
+			return fmt.Errorf("%w: tag key %q contains = or ,", ErrBadField, key)
+		case strings.Contains(value, ","):
+			return fmt.Errorf("%w: value %q of tag %q contains ,", ErrBadField, value, key)
+		case !utf8.ValidString(key) || !utf8.ValidString(value):
+			return fmt.Errorf("%w: tag %q is not valid UTF-8", ErrBadField, key)
+		}
+	}
+	if size := tagsSize(tags); size > MaxTagsSize {
+		return fmt.Errorf("%w: tags take %d bytes, more than %d", ErrBadField, size, MaxTagsSize)
+	}
+	return nil
+}
+
+func tagsSize(tags map[string]string) int {
+	size := 0
+	for key, value := range tags {
+		size += 1 + len(key) + 1 + len(value)
+	}
+	return size
+}
+
+// FormatTags writes the tags as key=value pairs separated by commas, ordered by key
+func FormatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// ParseTags reads key=value pairs separated by commas, as FormatTags writes them, and validates them
+func ParseTags(text string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(text, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("%w: tag %q is not a key=value pair", ErrBadField, pair)
+		}
+		tags[keyValue[0]] = keyValue[1]
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// MatchesTags is true if the member has every one of the tags, with the same value
+func (m *MemberInfo) MatchesTags(tags map[string]string) bool {
+	for key, value := range tags {
+		if memberValue, ok := m.Tags[key]; !ok || memberValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// WithTags returns a copy of the message that carries the tags, ordered by key, each key and value preceded by its length.
+// Tags that do not pass ValidateTags are left out.
+func WithTags(message []byte, tags map[string]string) []byte {
+	if len(tags) == 0 || ValidateTags(tags) != nil {
+		return message
+	}
+	value := make([]byte, 0, tagsSize(tags))
+	for _, key := range sortedTagKeys(tags) {
+		value = append(value, byte(len(key)))
+		value = append(value, key...)
+		value = append(value, byte(len(tags[key])))
+		value = append(value, tags[key]...)
+	}
+	extended, _ := AppendExtension(message, ExtensionTags, value) // ValidateTags made sure it fits
+	return extended
+}
+
+// ReadTags returns the tags the message carries, if it carries valid ones
+func ReadTags(rawMessage []byte) (map[string]string, bool) {
+	value, ok := readExtension(rawMessage, ExtensionTags)
+	if !ok {
+		return nil, false
+	}
+	tags, err := decodeTags(value)
+	return tags, err == nil
+}
+
+func decodeTags(value []byte) (map[string]string, error) {
+	tags := make(map[string]string)
+	for len(value) > 0 {
+		key, rest, ok := readLengthPrefixed(value)
+		if !ok {
+			return nil, fmt.Errorf("%w: tag key is cut off", ErrBadField)
+		}
+		tagValue, rest, ok := readLengthPrefixed(rest)
+		if !ok {
+			return nil, fmt.Errorf("%w: value of tag %q is cut off", ErrBadField, key)
+		}
+		tags[key] = tagValue
+		value = rest
+	}
+	if err := ValidateTags(tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func readLengthPrefixed(value []byte) (string, []byte, bool) {
+	if len(value) == 0 || len(value) < 1+int(value[0]) {
+		return "", nil, false
+	}
+	end := 1 + int(value[0])
+	return string(value[1:end]), value[end:], true
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "Empty", text: "", want: map[string]string{}},
+		{name: "One", text: "zone=eu-west-1a", want: map[string]string{"zone": "eu-west-1a"}},
+		{name: "Several", text: "zone=eu-west-1a, role=db,version=1.2", want: map[string]string{"zone": "eu-west-1a", "role": "db", "version": "1.2"}},
+		{name: "EmptyValue", text: "canary=", want: map[string]string{"canary": ""}},
+		{name: "EqualsInValue", text: "query=a=b", want: map[string]string{"query": "a=b"}},
+		{name: "NoValue", text: "zone", wantErr: true},
+		{name: "EmptyKey", text: "=eu", wantErr: true},
+		{name: "TooLarge", text: "key=" + strings.Repeat("x", MaxTagsSize), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTags(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if !errors.Is(err, ErrBadField) {
+					t.Errorf("ParseTags() error = %v, want ErrBadField", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTags() = %v, want %v", got, tt.want)
+			}
+			if reparsed, _ := ParseTags(FormatTags(got)); !reflect.DeepEqual(reparsed, got) {
+				t.Errorf("ParseTags(FormatTags(%v)) = %v", got, reparsed)
+			}
+		})
+	}
+}
+
+func TestWithTags(t *testing.T) {
+	message := newTestMessage()
+	tags := map[string]string{"zone": "eu-west-1a", "role": "db", "canary": ""}
+	tests := []struct {
+		name     string
+		message  []byte
+		wantTags map[string]string
+		// wantErr is what ReadMemberMessage rejects the message with, as the tags are not what they should be
+		wantErr error
+	}{
+		{name: "None", message: message},
+		{name: "Tags", message: WithTags(message, tags), wantTags: tags},
+		{name: "Traced", message: WithTraceContext(WithTags(message, tags), testTraceContext), wantTags: tags},
+		{name: "NoTags", message: WithTags(message, map[string]string{})},
+		{name: "Invalid", message: WithTags(message, map[string]string{"": "eu"})},
+		{name: "Truncated", message: func() []byte { m, _ := AppendExtension(message, ExtensionTags, []byte{4, 'z', 'o'}); return m }(), wantErr: ErrBadField},
+		{name: "MissingValue", message: func() []byte { m, _ := AppendExtension(message, ExtensionTags, []byte{1, 'z'}); return m }(), wantErr: ErrBadField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ReadTags(tt.message)
+			if ok != (tt.wantTags != nil) || (ok && !reflect.DeepEqual(got, tt.wantTags)) {
+				t.Errorf("ReadTags() = %v, %v, want %v", got, ok, tt.wantTags)
+			}
+			member, _, err := ReadMemberMessage(tt.message, nil)
+			if tt.wantErr != nil || err != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadMemberMessage() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if !reflect.DeepEqual(member.Tags, tt.wantTags) {
+				t.Errorf("ReadMemberMessage() tags = %v, want %v", member.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestMemberInfo_MatchesTags(t *testing.T) {
+	member := &MemberInfo{Tags: map[string]string{"zone": "eu-west-1a", "role": "db"}}
+	tests := []struct {
+		name   string
+		filter map[string]string
+		want   bool
+	}{
+		{"NoFilter", nil, true},
+		{"Match", map[string]string{"zone": "eu-west-1a"}, true},
+		{"AllMatch", map[string]string{"zone": "eu-west-1a", "role": "db"}, true},
+		{"OtherValue", map[string]string{"zone": "us-east-1a"}, false},
+		{"OneDiffers", map[string]string{"zone": "eu-west-1a", "role": "web"}, false},
+		{"Missing", map[string]string{"version": "1"}, false},
+		{"MissingEmpty", map[string]string{"canary": ""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := member.MatchesTags(tt.filter); got != tt.want {
+				t.Errorf("MatchesTags(%v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 10 * time.Second
+
+// managementClient talks to the management API of a boom-server
+type managementClient struct {
+	// rootURL is where the probes are served, baseURL where the versioned API is
+	rootURL    string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// apiError is an error response of the management API
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.StatusCode)
+}
+
+func newManagementClient(address string) *managementClient {
+	baseURL := address
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	rootURL := strings.TrimSuffix(baseURL, "/")
+	return &managementClient{
+		rootURL:    rootURL,
+		baseURL:    rootURL + "/" + api.ManagementAPIVersion,
+		httpClient: &http.Client{},
+	}
+}
+
+// Members lists the members the server knows about, only those with all the tags unless there are none,
+// and only those in the segment unless it is nil, in another datacenter unless it is empty
+func (c *managementClient) Members(ctx context.Context, tags map[string]string, segment *string, datacenter string) ([]api.MemberInfo, error) {
+	path := "/members"
+	query := url.Values{}
+	if len(tags) > 0 {
+		query.Set("tag", api.FormatTags(tags))
+	}
+	if segment != nil {
+		query.Set("segment", *segment)
+	}
+	if datacenter != "" {
+		query.Set("dc", datacenter)
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var memberInfos []api.MemberInfo
+	err := c.do(ctx, http.MethodGet, path, nil, &memberInfos)
+	return memberInfos, err
+}
+
+// Member shows a single member, of another datacenter unless it is empty
+func (c *managementClient) Member(ctx context.Context, id string, datacenter string) (*api.MemberInfo, error) {
+	path := "/members/" + url.PathEscape(id)
+	if datacenter != "" {
+		path += "?" + url.Values{"dc": {datacenter}}.Encode()
+	}
+	var memberInfo api.MemberInfo
+	err := c.do(ctx, http.MethodGet, path, nil, &memberInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &memberInfo, nil
+}
+
+// WANMembers lists the members of the WAN pool the server knows about
+func (c *managementClient) WANMembers(ctx context.Context) ([]api.MemberInfo, error) {
+	var memberInfos []api.MemberInfo
+	err := c.do(ctx, http.MethodGet, "/wan/members", nil, &memberInfos)
+	return memberInfos, err
+}
+
+func (c *managementClient) Info(ctx context.Context) (*api.NodeInfo, error) {
+	var info api.NodeInfo
+	err := c.do(ctx, http.MethodGet, "/info", nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *managementClient) Tags(ctx context.Context) (map[string]string, error) {
+	var tags map[string]string
+	err := c.do(ctx, http.MethodGet, "/self/tags", nil, &tags)
+	return tags, err
+}
+
+// SetTags replaces the tags of the server, and returns them as the server has them now
+func (c *managementClient) SetTags(ctx context.Context, tags map[string]string) (map[string]string, error) {
+	var updated map[string]string
+	err := c.do(ctx, http.MethodPut, "/self/tags", tags, &updated)
+	return updated, err
+}
+
+func (c *managementClient) Join(ctx context.Context, address string) error {
+	return c.do(ctx, http.MethodPost, "/join", api.JoinRequest{Address: address}, nil)
+}
+
+func (c *managementClient) Leave(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/leave", nil, nil)
+}
+
+func (c *managementClient) ForceLeave(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/members/"+url.PathEscape(id)+"/force-leave", nil, nil)
+}
+
+// Health asks the server if it is healthy, which it also answers when it is not
+func (c *managementClient) Health(ctx context.Context) (*api.HealthStatus, error) {
+	var health api.HealthStatus
+	err := c.probe(ctx, "/healthz", &health)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Readiness asks the server if it is ready, which it also answers when it is not
+func (c *managementClient) Readiness(ctx context.Context) (*api.ReadinessStatus, error) {
+	var readiness api.ReadinessStatus
+	err := c.probe(ctx, "/readyz", &readiness)
+	if err != nil {
+		return nil, err
+	}
+	return &readiness, nil
+}
+
+// probe reads the status document of a probe, which comes with 503 Service Unavailable when the probe fails
+func (c *managementClient) probe(ctx context.Context, path string, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.rootURL+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusServiceUnavailable {
+		return readAPIError(response)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// Watch calls handleEvent for every membership event the server streams, until the context is done
+func (c *managementClient) Watch(ctx context.Context, handleEvent func(api.MemberEvent) error) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/events", nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return readAPIError(response)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		var event api.MemberEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("could not read event: %w", err)
+		}
+		if err := handleEvent(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+func (c *managementClient) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	var requestBody io.Reader
+	if body != nil {
+		encodedBody, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(encodedBody)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, requestBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return readAPIError(response)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func readAPIError(response *http.Response) error {
+	var errorResponse api.ErrorResponse
+	if err := json.NewDecoder(response.Body).Decode(&errorResponse); err != nil || errorResponse.Error == "" {
+		errorResponse.Error = http.StatusText(response.StatusCode)
+	}
+	return &apiError{StatusCode: response.StatusCode, Message: errorResponse.Error}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const timeFormat = time.RFC3339
+
+const (
+	sortByID        = "id"
+	sortByProximity = "proximity"
+)
+
+var membersSort string
+
+var membersTags string
+
+var membersSegment optionalString
+
+var membersDatacenter string
+
+var memberDatacenter string
+
+var roundTripFrom string
+
+func membersFlags(flags *flag.FlagSet) {
+	flags.StringVar(&membersSort, "sort", sortByID, fmt.Sprintf("Order of the members: %s, or %s by the estimated round trip time", sortByID, sortByProximity))
+	flags.StringVar(&membersTags, "tag", "", "Only list the members with all these comma separated key=value tags")
+	membersSegment = optionalString{}
+	flags.Var(&membersSegment, "segment", "Only list the members in this segment, an empty segment is the default segment")
+	flags.StringVar(&membersDatacenter, "dc", "", "List the members of another datacenter, which the server asks through the WAN pool")
+}
+
+func memberFlags(flags *flag.FlagSet) {
+	flags.StringVar(&memberDatacenter, "dc", "", "Show a member of another datacenter, which the server asks through the WAN pool")
+}
+
+func roundTripFlags(flags *flag.FlagSet) {
+	flags.StringVar(&roundTripFrom, "from", "", "Member to estimate the round trip time from, instead of the server")
+}
+
+func runMembers(cli *commandContext, args []string) error {
+	if membersSort != sortByID && membersSort != sortByProximity {
+		return usageErrorf("unknown sort %q, use %s or %s", membersSort, sortByID, sortByProximity)
+	}
+	tags, err := api.ParseTags(membersTags)
+	if err != nil {
+		return usageErrorf("invalid tags %q: %s", membersTags, err)
+	}
+	var segment *string
+	if membersSegment.set {
+		segment = &membersSegment.value
+	}
+	memberInfos, err := cli.Client.Members(cli, tags, segment, membersDatacenter)
+	if err != nil {
+		return err
+	}
+	if membersSort == sortByProximity {
+		// members we have no estimate for go last
+		sort.SliceStable(memberInfos, func(i, j int) bool {
+			if memberInfos[i].EstimatedRoundTrip == 0 || memberInfos[j].EstimatedRoundTrip == 0 {
+				return memberInfos[j].EstimatedRoundTrip == 0 && memberInfos[i].EstimatedRoundTrip != 0
+			}
+			return memberInfos[i].EstimatedRoundTrip < memberInfos[j].EstimatedRoundTrip
+		})
+	}
+	return cli.Printer.print(memberInfos, func(table io.Writer) {
+		fmt.Fprintln(table, "ID\tNAME\tADDRESS\tSTATE\tLAST SEEN\tCLOCK\tMISSED HEARTBEATS\tEST. RTT\tSEGMENT\tTAGS")
+		for _, memberInfo := range memberInfos {
+			missedHeartbeats := "-"
+			if memberInfo.Heartbeat != nil {
+				missedHeartbeats = fmt.Sprint(memberInfo.Heartbeat.MissedResponses)
+			}
+			estimatedRoundTrip := "-"
+			if memberInfo.EstimatedRoundTrip > 0 {
+				estimatedRoundTrip = seconds(memberInfo.EstimatedRoundTrip)
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", memberInfo.ID, memberInfo.Name, memberAddress(memberInfo), memberInfo.State,
+				formatTime(memberInfo.LastSeen), memberInfo.Clock, missedHeartbeats, estimatedRoundTrip, formatSegment(memberInfo), formatTags(memberInfo.Tags))
+		}
+	})
+}
+
+// roundTripEstimate is the round trip time between two members, estimated by their network coordinates
+type roundTripEstimate struct {
+	From               string  `json:"from"`
+	To                 string  `json:"to"`
+	EstimatedRoundTrip float64 `json:"estimatedRoundTrip"`
+}
+
+func runRoundTrip(cli *commandContext, args []string) error {
+	info, err := cli.Client.Info(cli)
+	if err != nil {
+		return err
+	}
+	memberInfos, err := cli.Client.Members(cli, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	memberInfos = append(memberInfos, info.Self)
+	estimate := roundTripEstimate{From: roundTripFrom, To: args[0]}
+	if estimate.From == "" {
+		estimate.From = info.Self.ID
+	}
+	from, err := memberCoordinate(memberInfos, estimate.From)
+	if err != nil {
+		return err
+	}
+	to, err := memberCoordinate(memberInfos, estimate.To)
+	if err != nil {
+		return err
+	}
+	switch {
+	case from == nil:
+		return fmt.Errorf("the server knows no coordinate of %s", estimate.From)
+	case to == nil:
+		return fmt.Errorf("the server knows no coordinate of %s", estimate.To)
+	case !from.IsCompatibleWith(to):
+		return fmt.Errorf("the coordinates of %s and %s have a different number of dimensions", estimate.From, estimate.To)
+	}
+	estimate.EstimatedRoundTrip = from.DistanceTo(to).Seconds()
+	return cli.Printer.print(estimate, func(table io.Writer) {
+		fmt.Fprintf(table, "From:\t%s\n", estimate.From)
+		fmt.Fprintf(table, "To:\t%s\n", estimate.To)
+		fmt.Fprintf(table, "Estimated Round Trip:\t%s\n", seconds(estimate.EstimatedRoundTrip))
+	})
+}
+
+func runTags(cli *commandContext, args []string) error {
+	tags, err := cli.Client.Tags(cli)
+	if err != nil {
+		return err
+	}
+	return printTags(cli, tags)
+}
+
+func runSetTags(cli *commandContext, args []string) error {
+	tags, err := api.ParseTags(args[0])
+	if err != nil {
+		return usageErrorf("invalid tags %q: %s", args[0], err)
+	}
+	updated, err := cli.Client.SetTags(cli, tags)
+	if err != nil {
+		return err
+	}
+	return printTags(cli, updated)
+}
+
+func printTags(cli *commandContext, tags map[string]string) error {
+	return cli.Printer.print(tags, func(table io.Writer) {
+		fmt.Fprintln(table, "KEY\tVALUE")
+		for _, key := range sortedKeys(tags) {
+			fmt.Fprintf(table, "%s\t%s\n", key, tags[key])
+		}
+	})
+}
+
+// memberCoordinate returns the coordinate of the member with the id, or with it as its name if that is unique
+func memberCoordinate(memberInfos []api.MemberInfo, id string) (*api.Coordinate, error) {
+	var named []api.MemberInfo
+	for _, memberInfo := range memberInfos {
+		if memberInfo.ID == id {
+			return memberInfo.Coordinate, nil
+		}
+		if memberInfo.Name == id {
+			named = append(named, memberInfo)
+		}
+	}
+	if len(named) > 1 {
+		return nil, fmt.Errorf("more than one member is named %s, use its id", id)
+	}
+	if len(named) == 1 {
+		return named[0].Coordinate, nil
+	}
+	return nil, nil
+}
+
+func runMember(cli *commandContext, args []string) error {
+	memberInfo, err := cli.Client.Member(cli, args[0], memberDatacenter)
+	if err != nil {
+		return err
+	}
+	return cli.Printer.print(memberInfo, func(table io.Writer) {
+		writeMemberInfo(table, memberInfo)
+	})
+}
+
+func runInfo(cli *commandContext, args []string) error {
+	info, err := cli.Client.Info(cli)
+	if err != nil {
+		return err
+	}
+	return cli.Printer.print(info, func(table io.Writer) {
+		writeMemberInfo(table, &info.Self)
+		fmt.Fprintf(table, "Server Port:\t%s\n", info.ServerPort)
+		states := make([]string, 0, len(info.Members))
+		for state := range info.Members {
+			states = append(states, string(state))
+		}
+		sort.Strings(states)
+		for _, state := range states {
+			fmt.Fprintf(table, "Members (%s):\t%d\n", state, info.Members[api.MemberState(state)])
+		}
+		fmt.Fprintf(table, "Short List:\t%s\n", strings.Join(info.ShortList, ", "))
+		fmt.Fprintf(table, "Local Health:\t%d\n", info.LocalHealth)
+		if info.Datacenter != "" {
+			fmt.Fprintf(table, "Datacenter:\t%s\n", info.Datacenter)
+		}
+		if info.WAN != nil {
+			fmt.Fprintf(table, "WAN Server Port:\t%s\n", info.WAN.ServerPort)
+			for _, state := range []api.MemberState{api.MemberStateAlive, api.MemberStateFailed, api.MemberStateLeft} {
+				fmt.Fprintf(table, "WAN Members (%s):\t%d\n", state, info.WAN.Members[state])
+			}
+			fmt.Fprintf(table, "WAN Datacenters:\t%s\n", strings.Join(info.WAN.Datacenters, ", "))
+		}
+	})
+}
+
+func runWANMembers(cli *commandContext, args []string) error {
+	memberInfos, err := cli.Client.WANMembers(cli)
+	if err != nil {
+		return err
+	}
+	return cli.Printer.print(memberInfos, func(table io.Writer) {
+		fmt.Fprintln(table, "ID\tNAME\tADDRESS\tDATACENTER\tSTATE\tLAST SEEN\tEST. RTT")
+		for _, memberInfo := range memberInfos {
+			estimatedRoundTrip := "-"
+			if memberInfo.EstimatedRoundTrip > 0 {
+				estimatedRoundTrip = seconds(memberInfo.EstimatedRoundTrip)
+			}
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", memberInfo.ID, memberInfo.Name, memberAddress(memberInfo), memberInfo.Datacenter,
+				memberInfo.State, formatTime(memberInfo.LastSeen), estimatedRoundTrip)
+		}
+	})
+}
+
+func runStatus(cli *commandContext, args []string) error {
+	health, err := cli.Client.Health(cli)
+	if err != nil {
+		return err
+	}
+	readiness, err := cli.Client.Readiness(cli)
+	if err != nil {
+		return err
+	}
+	status := struct {
+		Health    *api.HealthStatus    `json:"health"`
+		Readiness *api.ReadinessStatus `json:"readiness"`
+	}{Health: health, Readiness: readiness}
+	err = cli.Printer.print(status, func(table io.Writer) {
+		fmt.Fprintf(table, "Healthy:\t%t\n", health.Healthy)
+		serviceNames := make([]string, 0, len(health.Services))
+		for serviceName := range health.Services {
+			serviceNames = append(serviceNames, serviceName)
+		}
+		sort.Strings(serviceNames)
+		for _, serviceName := range serviceNames {
+			serviceStatus := health.Services[serviceName]
+			fmt.Fprintf(table, "Service %s:\t%s", serviceName, serviceStatus.State)
+			if serviceStatus.LastError != "" {
+				fmt.Fprintf(table, " (restarts %d, last error: %s)", serviceStatus.Restarts, serviceStatus.LastError)
+			}
+			fmt.Fprintln(table)
+		}
+		fmt.Fprintf(table, "Ready:\t%t\n", readiness.Ready)
+		fmt.Fprintf(table, "Healthy Members:\t%d (at least %d)\n", readiness.HealthyMembers, readiness.MinMembers)
+		for _, reason := range readiness.Reasons {
+			fmt.Fprintf(table, "Not Ready Because:\t%s\n", reason)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case !health.Healthy:
+		return errors.New("server is not healthy")
+	case !readiness.Ready:
+		return errors.New("server is not ready")
+	}
+	return nil
+}
+
+func runJoin(cli *commandContext, args []string) error {
+	if _, err := net.ResolveUDPAddr(api.MembershipNetwork, args[0]); err != nil {
+		return usageErrorf("invalid address %q, expected ip:port: %s", args[0], err)
+	}
+	if err := cli.Client.Join(cli, args[0]); err != nil {
+		return err
+	}
+	return printAccepted(cli, "join", fmt.Sprintf("Sent hello to %s", args[0]))
+}
+
+func runLeave(cli *commandContext, args []string) error {
+	if err := cli.Client.Leave(cli); err != nil {
+		return err
+	}
+	return printAccepted(cli, "leave", "Server is leaving the cluster")
+}
+
+func runForceLeave(cli *commandContext, args []string) error {
+	if err := cli.Client.ForceLeave(cli, args[0]); err != nil {
+		return err
+	}
+	return printAccepted(cli, "force-leave", fmt.Sprintf("Removed %s from the cluster", args[0]))
+}
+
+func runWatch(cli *commandContext, args []string) error {
+	return cli.Client.Watch(cli, func(event api.MemberEvent) error {
+		return cli.Printer.printStream(event, func(out io.Writer) {
+			conflict := ""
+			if event.ConflictsWith != "" {
+				conflict = fmt.Sprintf(", %s claims the name %s as well", event.ConflictsWith, event.Member.Name)
+			}
+			fmt.Fprintf(out, "%s %-18s %s (%s) %s%s\n", formatTime(event.Time), event.Type, event.Member.ID,
+				memberAddress(event.Member), event.Member.State, conflict)
+		})
+	})
+}
+
+// runInterfaces lists the network interfaces, which helps finding out which address a server will announce
+func runInterfaces(cli *commandContext, args []string) error {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return err
+	}
+
+	type networkInterface struct {
+		Name           string   `json:"name"`
+		Index          int      `json:"index"`
+		MAC            string   `json:"mac"`
+		Addresses      []string `json:"addresses"`
+		MulticastAddrs []string `json:"multicastAddresses"`
+	}
+	networkInterfaces := make([]networkInterface, 0, len(interfaces))
+	for _, iface := range interfaces {
+		current := networkInterface{
+			Name:           iface.Name,
+			Index:          iface.Index,
+			MAC:            iface.HardwareAddr.String(),
+			Addresses:      make([]string, 0),
+			MulticastAddrs: make([]string, 0),
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			current.Addresses = append(current.Addresses, addr.String())
+		}
+		multiCastAddrs, _ := iface.MulticastAddrs()
+		for _, multiCastAddr := range multiCastAddrs {
+			current.MulticastAddrs = append(current.MulticastAddrs, multiCastAddr.String())
+		}
+		networkInterfaces = append(networkInterfaces, current)
+	}
+
+	return cli.Printer.print(networkInterfaces, func(table io.Writer) {
+		fmt.Fprintln(table, "NAME\tINDEX\tMAC\tADDRESSES\tMULTICAST ADDRESSES")
+		for _, iface := range networkInterfaces {
+			fmt.Fprintf(table, "%s\t%d\t%s\t%s\t%s\n", iface.Name, iface.Index, iface.MAC,
+				strings.Join(iface.Addresses, ","), strings.Join(iface.MulticastAddrs, ","))
+		}
+	})
+}
+
+// printAccepted reports a request the server has accepted, so JSON and YAML users get a document as well
+func printAccepted(cli *commandContext, action string, message string) error {
+	result := struct {
+		Action   string `json:"action"`
+		Accepted bool   `json:"accepted"`
+	}{Action: action, Accepted: true}
+	return cli.Printer.print(result, func(table io.Writer) {
+		fmt.Fprintln(table, message)
+	})
+}
+
+func writeMemberInfo(table io.Writer, memberInfo *api.MemberInfo) {
+	fmt.Fprintf(table, "ID:\t%s\n", memberInfo.ID)
+	fmt.Fprintf(table, "Name:\t%s\n", memberInfo.Name)
+	fmt.Fprintf(table, "Address:\t%s\n", memberAddress(*memberInfo))
+	fmt.Fprintf(table, "Self Known IP:\t%s\n", memberInfo.IPSelf)
+	fmt.Fprintf(table, "State:\t%s\n", memberInfo.State)
+	if memberInfo.Started != nil {
+		fmt.Fprintf(table, "Started:\t%s\n", formatTime(*memberInfo.Started))
+	}
+	fmt.Fprintf(table, "Last Seen:\t%s\n", formatTime(memberInfo.LastSeen))
+	fmt.Fprintf(table, "Clock:\t%d\n", memberInfo.Clock)
+	fmt.Fprintf(table, "Segment:\t%s\n", formatSegment(*memberInfo))
+	if memberInfo.Datacenter != "" {
+		fmt.Fprintf(table, "Datacenter:\t%s\n", memberInfo.Datacenter)
+	}
+	fmt.Fprintf(table, "Tags:\t%s\n", formatTags(memberInfo.Tags))
+	if memberInfo.EstimatedRoundTrip > 0 {
+		fmt.Fprintf(table, "Estimated Round Trip:\t%s\n", seconds(memberInfo.EstimatedRoundTrip))
+	}
+	if memberInfo.Coordinate != nil {
+		fmt.Fprintf(table, "Coordinate Error:\t%.3f\n", memberInfo.Coordinate.Error)
+	}
+	if memberInfo.Heartbeat != nil {
+		fmt.Fprintf(table, "Missed Heartbeats:\t%d\n", memberInfo.Heartbeat.MissedResponses)
+		fmt.Fprintf(table, "Last Heartbeat:\t%s\n", formatTime(memberInfo.Heartbeat.LastResponse))
+		fmt.Fprintf(table, "Last Heartbeat Clock:\t%d\n", memberInfo.Heartbeat.LastResponseClock)
+		fmt.Fprintf(table, "Late Heartbeats:\t%d\n", memberInfo.Heartbeat.LateResponses)
+		if roundTrip := memberInfo.Heartbeat.RoundTrip; roundTrip != nil {
+			fmt.Fprintf(table, "Round Trip:\tmin %s, avg %s, p99 %s (%d samples)\n", seconds(roundTrip.Min), seconds(roundTrip.Avg), seconds(roundTrip.P99), roundTrip.Samples)
+			fmt.Fprintf(table, "Heartbeat Timeout:\t%s\n", seconds(roundTrip.Timeout))
+		}
+	}
+}
+
+func memberAddress(memberInfo api.MemberInfo) string {
+	ip := memberInfo.IP
+	if ip == "" {
+		ip = memberInfo.IPSelf
+	}
+	return ip + ":" + memberInfo.Port
+}
+
+// formatSegment writes the default segment as -, and marks gateways
+func formatSegment(memberInfo api.MemberInfo) string {
+	segment := memberInfo.Segment
+	if segment == "" {
+		segment = "-"
+	}
+	if memberInfo.Gateway {
+		segment += " (gateway)"
+	}
+	return segment
+}
+
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	return api.FormatTags(tags)
+}
+
+func sortedKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// seconds formats a duration the API reports in seconds
+func seconds(value float64) string {
+	return time.Duration(value * float64(time.Second)).String()
+}
+
+func formatTime(moment time.Time) string {
+	if moment.IsZero() || moment.Unix() == 0 {
+		return "never"
+	}
+	return moment.Local().Format(timeFormat)
+}
+
+// optionalString is a flag that tells apart being set to an empty string from not being set at all
+type optionalString struct {
+	value string
+	set   bool
+}
+
+func (o *optionalString) String() string {
+	return o.value
+}
+
+func (o *optionalString) Set(value string) error {
+	o.value = value
+	o.set = true
+	return nil
+}
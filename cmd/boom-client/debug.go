@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const sniffBufferSize = 1024
+
+// decodedMessage is a membership message as it was read from the wire,
+// the Winner and Voter of a NameConflict are the node IDs of who keeps the name and who says so
+type decodedMessage struct {
+	MessageType string            `json:"messageType"`
+	NodeID      string            `json:"nodeId,omitempty"`
+	Prefix      string            `json:"prefix"`
+	Size        int               `json:"size"`
+	Origin      string            `json:"origin,omitempty"`
+	MemberName  string            `json:"memberName"`
+	Hostname    string            `json:"hostname"`
+	IPSelf      string            `json:"ipSelf"`
+	Port        string            `json:"port"`
+	Clock       int64             `json:"clock"`
+	TraceParent string            `json:"traceParent,omitempty"`
+	Sequence    *uint32           `json:"sequence,omitempty"`
+	Started     *time.Time        `json:"started,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Segment     string            `json:"segment,omitempty"`
+	Gateway     bool              `json:"gateway,omitempty"`
+	Datacenter  string            `json:"datacenter,omitempty"`
+	Winner      string            `json:"winner,omitempty"`
+	Voter       string            `json:"voter,omitempty"`
+	Raw         string            `json:"raw,omitempty"`
+}
+
+var decodeEncoding string
+var decodeOrigin string
+
+var sendFlags struct {
+	messageType string
+	to          string
+	name        string
+	hostname    string
+	ip          string
+	port        string
+	clock       int64
+	id          string
+	tags        string
+	wait        time.Duration
+}
+
+var sniffFlags struct {
+	group  string
+	listen string
+	raw    bool
+}
+
+func decodeFlags(flags *flag.FlagSet) {
+	flags.StringVar(&decodeEncoding, "encoding", "auto", "Encoding of the datagram: auto, hex or base64")
+	flags.StringVar(&decodeOrigin, "origin", "0.0.0.0:0", "Address the datagram originated from")
+}
+
+// runDecode shows which message and member a datagram, as hex or base64, represents
+func runDecode(cli *commandContext, args []string) error {
+	datagram, err := decodeDatagram(args[0], decodeEncoding)
+	if err != nil {
+		return usageErrorf("%s", err)
+	}
+	origin, err := net.ResolveUDPAddr(api.MembershipNetwork, decodeOrigin)
+	if err != nil {
+		return usageErrorf("invalid origin %q: %s", decodeOrigin, err)
+	}
+	message, err := newDecodedMessage(datagram, origin, true)
+	if err != nil {
+		return err
+	}
+	return cli.Printer.print(message, func(table io.Writer) {
+		writeDecodedMessage(table, message)
+	})
+}
+
+func sendMessageFlags(flags *flag.FlagSet) {
+	messageTypeNames := make([]string, 0, len(api.MessageTypes))
+	for _, messageType := range api.MessageTypes {
+		messageTypeNames = append(messageTypeNames, messageType.Name)
+	}
+	hostname, _ := os.Hostname()
+	flags.StringVar(&sendFlags.messageType, "type", api.HelloMessage.Name, fmt.Sprintf("Type of message: %s", strings.Join(messageTypeNames, ", ")))
+	flags.StringVar(&sendFlags.to, "to", "127.0.0.1:"+api.HelloPort, "Address to send the message to, can be the multicast group "+api.MembershipGroupAddress)
+	flags.StringVar(&sendFlags.name, "name", "boom-client", "MemberName field of the message")
+	flags.StringVar(&sendFlags.hostname, "hostname", hostname, "Hostname field of the message")
+	flags.StringVar(&sendFlags.ip, "ip", "", "IP field of the message, defaults to the local address we send from")
+	flags.StringVar(&sendFlags.port, "port", "", "Port field of the message, defaults to the local port we send from, so replies reach us")
+	flags.Int64Var(&sendFlags.clock, "clock", 0, "Clock field of the message")
+	flags.StringVar(&sendFlags.id, "id", "", "Node ID to send along with the message, none if empty")
+	flags.StringVar(&sendFlags.tags, "tags", "", "Comma separated key=value tags to send along with the message")
+	flags.DurationVar(&sendFlags.wait, "wait", 0, "How long to wait for, and print, replies")
+}
+
+// runSend crafts a membership message with the fields from the flags, sends it, and prints any replies
+func runSend(cli *commandContext, args []string) error {
+	messageType, ok := api.MessageTypeByName(sendFlags.messageType)
+	if !ok {
+		return usageErrorf("unknown message type %q", sendFlags.messageType)
+	}
+	to, err := net.ResolveUDPAddr(api.MembershipNetwork, sendFlags.to)
+	if err != nil {
+		return usageErrorf("invalid address %q: %s", sendFlags.to, err)
+	}
+	var id api.NodeID
+	if sendFlags.id != "" {
+		if id, err = api.ParseNodeID(sendFlags.id); err != nil {
+			return usageErrorf("invalid node ID %q: %s", sendFlags.id, err)
+		}
+	}
+	tags, err := api.ParseTags(sendFlags.tags)
+	if err != nil {
+		return usageErrorf("invalid tags %q: %s", sendFlags.tags, err)
+	}
+
+	connection, err := net.ListenUDP(api.MembershipNetwork, nil)
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	localAddress := connection.LocalAddr().(*net.UDPAddr)
+
+	ip, port := sendFlags.ip, sendFlags.port
+	if ip == "" {
+		ip = outboundIP(to).String()
+	}
+	if port == "" {
+		port = fmt.Sprint(localAddress.Port)
+	}
+	ipSelf, err := api.NewIP4Address(ip)
+	if err != nil {
+		return usageErrorf("invalid ip %q: %s", ip, err)
+	}
+	member := &api.Member{
+		ID:         id,
+		MemberName: sendFlags.name,
+		Hostname:   sendFlags.hostname,
+		IPSelf:     &ipSelf,
+		PortSelf:   port,
+		Clock:      sendFlags.clock,
+	}
+	datagram := api.WithTags(messageType.CreateMemberMessage(member), tags)
+	if _, err := connection.WriteToUDP(datagram, to); err != nil {
+		return err
+	}
+
+	sent, err := newDecodedMessage(datagram, nil, true)
+	if err != nil {
+		return err
+	}
+	if err := cli.Printer.printStream(sent, func(out io.Writer) {
+		fmt.Fprintf(out, "Sent %s to %s: %x\n", messageType.Name, to, datagram)
+	}); err != nil {
+		return err
+	}
+	if sendFlags.wait <= 0 {
+		return nil
+	}
+	connection.SetReadDeadline(time.Now().Add(sendFlags.wait))
+	return printIncomingMessages(cli, connection, true)
+}
+
+func sniffMessageFlags(flags *flag.FlagSet) {
+	flags.StringVar(&sniffFlags.group, "group", api.MembershipGroupAddress, "Multicast group to listen on")
+	flags.StringVar(&sniffFlags.listen, "listen", "", "Listen on this unicast address (ip:port) instead of the multicast group")
+	flags.BoolVar(&sniffFlags.raw, "raw", false, "Include the raw datagram, as hex, in the output")
+}
+
+// runSniff passively listens for membership messages, and prints them decoded, until interrupted
+func runSniff(cli *commandContext, args []string) error {
+	var connection *net.UDPConn
+	if sniffFlags.listen != "" {
+		address, err := net.ResolveUDPAddr(api.MembershipNetwork, sniffFlags.listen)
+		if err != nil {
+			return usageErrorf("invalid address %q: %s", sniffFlags.listen, err)
+		}
+		connection, err = net.ListenUDP(api.MembershipNetwork, address)
+		if err != nil {
+			return err
+		}
+	} else {
+		group, err := net.ResolveUDPAddr(api.MembershipNetwork, sniffFlags.group)
+		if err != nil {
+			return usageErrorf("invalid multicast group %q: %s", sniffFlags.group, err)
+		}
+		connection, err = net.ListenMulticastUDP(api.MembershipNetwork, nil, group)
+		if err != nil {
+			return err
+		}
+	}
+	defer connection.Close()
+	go func() {
+		<-cli.Done()
+		connection.SetReadDeadline(time.Now())
+	}()
+	return printIncomingMessages(cli, connection, sniffFlags.raw)
+}
+
+// printIncomingMessages prints every datagram the connection receives, until its read deadline passes
+func printIncomingMessages(cli *commandContext, connection *net.UDPConn, raw bool) error {
+	buffer := make([]byte, sniffBufferSize)
+	for {
+		numberOfBytes, origin, err := connection.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil
+			}
+			return err
+		}
+		message, err := newDecodedMessage(buffer[0:numberOfBytes], origin, raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not decode %x from %s: %s\n", buffer[0:numberOfBytes], origin, err)
+			continue
+		}
+		err = cli.Printer.printStream(message, func(out io.Writer) {
+			fmt.Fprintf(out, "%s %-21s from %-21s %s@%s (%s:%s) clock=%d\n", time.Now().Format(timeFormat),
+				message.MessageType, message.Origin, message.MemberName, message.Hostname, message.IPSelf, message.Port, message.Clock)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func newDecodedMessage(datagram []byte, origin *net.UDPAddr, raw bool) (*decodedMessage, error) {
+	if origin == nil {
+		origin = &net.UDPAddr{IP: net.IPv4zero}
+	}
+	member, messageType, err := api.ReadMemberMessage(datagram, origin)
+	if err != nil {
+		return nil, err
+	}
+	message := &decodedMessage{
+		MessageType: messageType.Name,
+		Prefix:      fmt.Sprintf("0x%02x", messageType.Prefix),
+		Size:        len(datagram),
+		MemberName:  member.MemberName,
+		Hostname:    member.Hostname,
+		IPSelf:      member.IPSelf.String(),
+		Port:        member.PortSelf,
+		Clock:       member.Clock,
+	}
+	if !origin.IP.IsUnspecified() {
+		message.Origin = origin.String()
+	}
+	if traceContext, ok := api.ReadTraceContext(datagram); ok {
+		message.TraceParent = traceContext.String()
+	}
+	if !member.ID.IsZero() {
+		message.NodeID = member.ID.String()
+	}
+	if sequence, ok := api.ReadSequence(datagram); ok {
+		message.Sequence = &sequence
+	}
+	if !member.Started.IsZero() {
+		message.Started = &member.Started
+	}
+	if tags, ok := api.ReadTags(datagram); ok {
+		message.Tags = tags
+	}
+	message.Segment = member.Segment
+	message.Gateway = member.Gateway
+	message.Datacenter = member.Datacenter
+	if conflict, ok := api.ReadNameConflict(datagram); ok {
+		message.Winner = conflict.Winner.String()
+		message.Voter = conflict.Voter.String()
+	}
+	if raw {
+		message.Raw = hex.EncodeToString(datagram)
+	}
+	return message, nil
+}
+
+// decodeDatagram reads a datagram as hex, with or without separators, or as base64
+func decodeDatagram(input string, encoding string) ([]byte, error) {
+	hexInput := strings.NewReplacer(" ", "", ":", "", "\n", "").Replace(strings.TrimPrefix(input, "0x"))
+	switch encoding {
+	case "hex":
+		return hex.DecodeString(hexInput)
+	case "base64":
+		return base64.StdEncoding.DecodeString(input)
+	case "auto":
+		if datagram, err := hex.DecodeString(hexInput); err == nil {
+			return datagram, nil
+		}
+		if datagram, err := base64.StdEncoding.DecodeString(input); err == nil {
+			return datagram, nil
+		}
+		return nil, fmt.Errorf("%q is neither hex nor base64", input)
+	default:
+		return nil, fmt.Errorf("unknown encoding %q, use auto, hex or base64", encoding)
+	}
+}
+
+// outboundIP is the local IP we use to reach the destination, so the member in our message can be replied to
+func outboundIP(destination *net.UDPAddr) net.IP {
+	connection, err := net.DialUDP(api.MembershipNetwork, nil, destination)
+	if err != nil {
+		return net.IPv4zero
+	}
+	defer connection.Close()
+	return connection.LocalAddr().(*net.UDPAddr).IP
+}
+
+func writeDecodedMessage(table io.Writer, message *decodedMessage) {
+	fmt.Fprintf(table, "Message Type:\t%s (%s)\n", message.MessageType, message.Prefix)
+	fmt.Fprintf(table, "Size:\t%d bytes\n", message.Size)
+	if message.Origin != "" {
+		fmt.Fprintf(table, "Origin:\t%s\n", message.Origin)
+	}
+	if message.NodeID != "" {
+		fmt.Fprintf(table, "Node ID:\t%s\n", message.NodeID)
+	}
+	fmt.Fprintf(table, "Member Name:\t%s\n", message.MemberName)
+	fmt.Fprintf(table, "Hostname:\t%s\n", message.Hostname)
+	fmt.Fprintf(table, "Self Known IP:\t%s\n", message.IPSelf)
+	fmt.Fprintf(table, "Port:\t%s\n", message.Port)
+	fmt.Fprintf(table, "Clock:\t%d\n", message.Clock)
+	if message.TraceParent != "" {
+		fmt.Fprintf(table, "Trace Parent:\t%s\n", message.TraceParent)
+	}
+	if message.Sequence != nil {
+		fmt.Fprintf(table, "Sequence:\t%d\n", *message.Sequence)
+	}
+	if message.Started != nil {
+		fmt.Fprintf(table, "Started:\t%s\n", formatTime(*message.Started))
+	}
+	if len(message.Tags) > 0 {
+		fmt.Fprintf(table, "Tags:\t%s\n", api.FormatTags(message.Tags))
+	}
+	if message.Segment != "" || message.Gateway {
+		fmt.Fprintf(table, "Segment:\t%s\n", formatSegment(api.MemberInfo{Segment: message.Segment, Gateway: message.Gateway}))
+	}
+	if message.Datacenter != "" {
+		fmt.Fprintf(table, "Datacenter:\t%s\n", message.Datacenter)
+	}
+	if message.Winner != "" {
+		fmt.Fprintf(table, "Name Kept By:\t%s\n", message.Winner)
+		fmt.Fprintf(table, "Decided By:\t%s\n", message.Voter)
+	}
+}
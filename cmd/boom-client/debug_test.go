@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/joostvdg/boom/api"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRun_Decode(t *testing.T) {
+	ip, _ := api.NewIP4Address("10.0.0.1")
+	member := &api.Member{MemberName: "Alan", Hostname: "Boreas", IPSelf: &ip, PortSelf: "7780", Clock: 42}
+	datagram := api.HeartbeatRequestMessage.CreateMemberMessage(member)
+	traceContext := api.TraceContext{TraceID: [16]byte{0x4b, 0xf9}, SpanID: [8]byte{0x00, 0xf0}, Flags: 0x01}
+	traced := api.WithTraceContext(datagram, traceContext)
+	tagged := api.WithTags(datagram, map[string]string{"zone": "eu-west-1a"})
+
+	tests := []struct {
+		name            string
+		datagram        string
+		want            int
+		wantTraceParent string
+		wantTags        map[string]string
+	}{
+		{name: "Hex", datagram: hex.EncodeToString(datagram), want: ExitOK},
+		{name: "Base64", datagram: base64.StdEncoding.EncodeToString(datagram), want: ExitOK},
+		{name: "TraceContext", datagram: hex.EncodeToString(traced), want: ExitOK, wantTraceParent: traceContext.String()},
+		{name: "Tags", datagram: hex.EncodeToString(tagged), want: ExitOK, wantTags: map[string]string{"zone": "eu-west-1a"}},
+		{name: "Truncated", datagram: hex.EncodeToString(datagram[0:10]), want: ExitError},
+		{name: "UnknownType", datagram: "ff" + hex.EncodeToString(datagram[1:]), want: ExitError},
+		{name: "Garbage", datagram: "not a datagram!", want: ExitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			got := run([]string{"decode", "-output", OutputJSON, "-origin", "10.0.0.1:7780", tt.datagram}, &stdout, &stderr)
+			if got != tt.want {
+				t.Fatalf("decode exited with %v, want %v, stderr: %s", got, tt.want, stderr.String())
+			}
+			if tt.want != ExitOK {
+				return
+			}
+			var message decodedMessage
+			if err := json.Unmarshal(stdout.Bytes(), &message); err != nil {
+				t.Fatal(err)
+			}
+			if message.MessageType != api.HeartbeatRequestMessage.Name || message.MemberName != "Alan" || message.Clock != 42 || message.Origin != "10.0.0.1:7780" {
+				t.Errorf("decode = %+v, want the heartbeat request of Alan", message)
+			}
+			if message.TraceParent != tt.wantTraceParent {
+				t.Errorf("decode trace parent = %q, want %q", message.TraceParent, tt.wantTraceParent)
+			}
+			if !reflect.DeepEqual(message.Tags, tt.wantTags) {
+				t.Errorf("decode tags = %v, want %v", message.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestRun_Send(t *testing.T) {
+	receiver, err := net.ListenUDP(api.MembershipNetwork, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+
+	var stdout, stderr bytes.Buffer
+	args := []string{"send", "-type", "member-failure-detected", "-to", receiver.LocalAddr().String(),
+		"-name", "Bas", "-hostname", "Boreas", "-ip", "10.0.0.2", "-port", "7781", "-clock", "7"}
+	if got := run(args, &stdout, &stderr); got != ExitOK {
+		t.Fatalf("send exited with %v, want %v, stderr: %s", got, ExitOK, stderr.String())
+	}
+
+	buffer := make([]byte, 1024)
+	receiver.SetReadDeadline(time.Now().Add(time.Second))
+	numberOfBytes, origin, err := receiver.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("did not receive the message: %v", err)
+	}
+	member, messageType, err := api.ReadMemberMessage(buffer[0:numberOfBytes], origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType.Name != api.MemberFailureDetected.Name || member.Identifier() != "Bas@Boreas" ||
+		member.IPSelf.String() != "10.0.0.2" || member.PortSelf != "7781" || member.Clock != 7 {
+		t.Errorf("received %s of %+v, want the crafted MemberFailureDetected of Bas@Boreas", messageType.Name, member)
+	}
+
+	if got := run([]string{"send", "-type", "shout"}, &stdout, &stderr); got != ExitUsage {
+		t.Errorf("send of an unknown message type exited with %v, want %v", got, ExitUsage)
+	}
+}
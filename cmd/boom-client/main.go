@@ -1,102 +1,253 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/joostvdg/boom/api"
-	"net"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 )
 
+// Exit codes, so scripts can tell why a command failed
 const (
-	RED    = "\x1b[31;1m"
-	GREEN  = "\x1b[32;1m"
-	YELLOW = "\x1b[33;1m"
+	ExitOK       = 0
+	ExitError    = 1
+	ExitUsage    = 2
+	ExitNotFound = 3
 )
 
-func main() {
-	fmt.Printf("Hello World, this is Boom!\n")
+const addressEnvironmentVariable = "BOOM_API_ADDRESS"
 
-	listNetworkInterfaces()
-	sendSillyMessage()
-	sendAnotherSillyMessage()
+// command is a subcommand of the boom-client
+type command struct {
+	Usage       string
+	Description string
+	Arguments   int
+	// Offline commands do not talk to a boom-server
+	Offline bool
+	// Flags registers the flags specific to this command, if any
+	Flags func(flags *flag.FlagSet)
+	Run   func(cli *commandContext, args []string) error
 }
 
-func listNetworkInterfaces() {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		fmt.Println("We found an error")
-		return
-	}
+// commandContext holds everything a command needs to do its work
+type commandContext struct {
+	context.Context
+	Client  *managementClient
+	Printer *printer
+}
 
-	fmt.Printf("Found network interfaces:\n:")
-	for _, networkInterface := range interfaces {
-		if networkInterface.Name != "eth0" {
-			continue
-		}
-		fmt.Printf("%sName:%s, Index=%d, MAC:%d%s\n", YELLOW, networkInterface.Name, networkInterface.Index, networkInterface.HardwareAddr, YELLOW)
+// usageError is returned when the command is not used correctly
+type usageError struct {
+	message string
+}
 
-		addrs, err := networkInterface.Addrs()
-		if err != nil {
-			fmt.Println("We found an error")
-		}
-		for _, addr := range addrs {
-			fmt.Printf("Addr: %s\n", addr)
-		}
+func (e *usageError) Error() string {
+	return e.message
+}
 
-		multiCastAddrs, err := networkInterface.MulticastAddrs()
-		if err != nil {
-			fmt.Println("We found an error")
-		}
-		for _, multiCastAddr := range multiCastAddrs {
-			fmt.Printf("multiCastAddr: %s\n", multiCastAddr)
-		}
+func usageErrorf(format string, a ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, a...)}
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"members": {
+			Usage:       "members",
+			Description: "List all members the server knows about, or only those with certain tags, or those of another datacenter",
+			Flags:       membersFlags,
+			Run:         runMembers,
+		},
+		"member": {
+			Usage:       "member <id>",
+			Description: "Show a single member, by its node ID, or by its MemberName@Hostname name if no other member has it",
+			Arguments:   1,
+			Flags:       memberFlags,
+			Run:         runMember,
+		},
+		"wan-members": {
+			Usage:       "wan-members",
+			Description: "List the members of the WAN pool the server knows about, the servers of every datacenter",
+			Run:         runWANMembers,
+		},
+		"tags": {
+			Usage:       "tags",
+			Description: "Show the tags the server describes itself with",
+			Run:         runTags,
+		},
+		"set-tags": {
+			Usage:       "set-tags <key=value,...>",
+			Description: "Replace the tags of the server, which lets the cluster know, an empty argument removes them all",
+			Arguments:   1,
+			Run:         runSetTags,
+		},
+		"rtt": {
+			Usage:       "rtt <id>",
+			Description: "Estimate the round trip time from the server, or another member, to a member by their network coordinates",
+			Arguments:   1,
+			Flags:       roundTripFlags,
+			Run:         runRoundTrip,
+		},
+		"join": {
+			Usage:       "join <addr>",
+			Description: "Let the server join the cluster via the member listening on addr (ip:port)",
+			Arguments:   1,
+			Run:         runJoin,
+		},
+		"leave": {
+			Usage:       "leave",
+			Description: "Let the server say goodbye to the cluster and shut down",
+			Run:         runLeave,
+		},
+		"force-leave": {
+			Usage:       "force-leave <id>",
+			Description: "Remove a member from the cluster, for example one that has failed",
+			Arguments:   1,
+			Run:         runForceLeave,
+		},
+		"watch": {
+			Usage:       "watch",
+			Description: "Stream membership events until interrupted",
+			Run:         runWatch,
+		},
+		"info": {
+			Usage:       "info",
+			Description: "Show information about the server",
+			Run:         runInfo,
+		},
+		"status": {
+			Usage:       "status",
+			Description: "Show if the server is healthy and ready, exits with 1 if it is not",
+			Run:         runStatus,
+		},
+		"decode": {
+			Usage:       "decode <datagram>",
+			Description: "Decode a membership message datagram, given as hex or base64",
+			Arguments:   1,
+			Offline:     true,
+			Flags:       decodeFlags,
+			Run:         runDecode,
+		},
+		"send": {
+			Usage:       "send",
+			Description: "Craft a membership message of any type and send it, optionally printing the replies",
+			Offline:     true,
+			Flags:       sendMessageFlags,
+			Run:         runSend,
+		},
+		"sniff": {
+			Usage:       "sniff",
+			Description: "Listen on the multicast group, or a unicast address, and print every membership message",
+			Offline:     true,
+			Flags:       sniffMessageFlags,
+			Run:         runSniff,
+		},
+		"interfaces": {
+			Usage:       "interfaces",
+			Description: "List the network interfaces of this machine",
+			Offline:     true,
+			Run:         runInterfaces,
+		},
 	}
 }
 
-func sendAnotherSillyMessage() {
-	ip, _ := api.NewIP4Address("127.0.0.1")
-	member := &api.Member{
-		MemberName: "MySelf",
-		Hostname:   "localhost",
-		IP:         &ip,
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+	cmd := commands[args[0]]
+	if cmd == nil {
+		fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return ExitUsage
 	}
 
-	message := api.HelloMessage.CreateMemberMessage(member)
-	serverAddress := "127.0.0.1:" + api.HelloPort
-	udpServer, err := net.ResolveUDPAddr("udp4", serverAddress)
-	connection, err := net.ListenUDP("udp4", nil)
-	if err != nil {
-		fmt.Println(err)
-		return
+	defaultAddress := api.ManagementAddress
+	if address, ok := os.LookupEnv(addressEnvironmentVariable); ok {
+		defaultAddress = address
+	}
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	address := &defaultAddress
+	if !cmd.Offline {
+		address = flags.String("address", defaultAddress, fmt.Sprintf("Address of the boom-server management API, can be set with %s", addressEnvironmentVariable))
+	}
+	output := flags.String("output", OutputTable, fmt.Sprintf("Output format: %s, %s or %s", OutputTable, OutputJSON, OutputYAML))
+	if cmd.Flags != nil {
+		cmd.Flags(flags)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: boom-client %s [flags]\n\n%s\n\nFlags:\n", cmd.Usage, cmd.Description)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if flags.NArg() != cmd.Arguments {
+		fmt.Fprintf(stderr, "Usage: boom-client %s\n", cmd.Usage)
+		return ExitUsage
 	}
 
-	defer connection.Close()
-	localAddr := connection.LocalAddr().(*net.UDPAddr)
-	fmt.Printf("Local Address: %s", localAddr)
-	_, err = connection.WriteToUDP(message, udpServer)
-	if err != nil {
-		fmt.Printf("Received an error: %s", err)
-		return
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	outputPrinter, err := newPrinter(*output, stdout)
+	if err == nil {
+		cli := &commandContext{
+			Context: ctx,
+			Client:  newManagementClient(*address),
+			Printer: outputPrinter,
+		}
+		err = cmd.Run(cli, flags.Args())
 	}
+	return exitCode(err, stderr)
 }
 
-func sendSillyMessage() {
-
-	serverAddress := "127.0.0.1:" + api.HelloPort
-	udpServer, err := net.ResolveUDPAddr("udp4", serverAddress)
-	connection, err := net.ListenUDP("udp4", nil)
-	if err != nil {
-		fmt.Println(err)
-		return
+func exitCode(err error, stderr io.Writer) int {
+	if err == nil {
+		return ExitOK
 	}
+	fmt.Fprintf(stderr, "Error: %s\n", err)
 
-	defer connection.Close()
-	localAddr := connection.LocalAddr().(*net.UDPAddr)
-	fmt.Printf("Local Address: %s", localAddr)
-	message := api.ConstructHelloMessage("myself", connection.LocalAddr().String(), api.HelloPort)
+	var usage *usageError
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &usage):
+		return ExitUsage
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return ExitNotFound
+	default:
+		return ExitError
+	}
+}
 
-	_, err = connection.WriteToUDP(message, udpServer)
-	if err != nil {
-		fmt.Printf("Received an error: %s", err)
-		return
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "Usage: boom-client <command> [flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-18s %s\n", commands[name].Usage, commands[name].Description)
 	}
+	fmt.Fprintf(out, "\nRun boom-client <command> -h for the flags of a command.\n")
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/server"
+	"gopkg.in/yaml.v3"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	ip, _ := api.NewIP4Address("127.0.0.1")
+	self := &api.Member{MemberName: "Self", Hostname: "localhost", IP: &ip, IPSelf: &ip, PortSelf: "7777"}
+	// the server knows no members, so it is only ready when it does not wait for any
+	serverConfig := config.Default()
+	serverConfig.API.ReadyMinMembers = 0
+	serviceContext := &server.MembershipServiceContext{
+		Context:    context.Background(),
+		Config:     serverConfig,
+		Self:       self,
+		Identity:   self.Identifier(),
+		ServerPort: self.PortSelf,
+	}
+	testServer := httptest.NewServer(server.NewManagementHandler(serviceContext))
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestRun_ExitCodes(t *testing.T) {
+	testServer := newTestServer(t)
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "NoCommand", args: []string{}, want: ExitUsage},
+		{name: "UnknownCommand", args: []string{"explode"}, want: ExitUsage},
+		{name: "MissingArgument", args: []string{"member", "-address", testServer.URL}, want: ExitUsage},
+		{name: "UnknownOutput", args: []string{"info", "-address", testServer.URL, "-output", "xml"}, want: ExitUsage},
+		{name: "InvalidJoinAddress", args: []string{"join", "-address", testServer.URL, "nowhere"}, want: ExitUsage},
+		{name: "Info", args: []string{"info", "-address", testServer.URL}, want: ExitOK},
+		{name: "Members", args: []string{"members", "-address", testServer.URL}, want: ExitOK},
+		{name: "MembersByProximity", args: []string{"members", "-address", testServer.URL, "-sort", "proximity"}, want: ExitOK},
+		{name: "UnknownSort", args: []string{"members", "-address", testServer.URL, "-sort", "age"}, want: ExitUsage},
+		{name: "MembersByTag", args: []string{"members", "-address", testServer.URL, "-tag", "zone=eu-west-1a"}, want: ExitOK},
+		{name: "MembersBySegment", args: []string{"members", "-address", testServer.URL, "-segment", "eu-west"}, want: ExitOK},
+		{name: "MembersOfDefaultSegment", args: []string{"members", "-address", testServer.URL, "-segment", ""}, want: ExitOK},
+		{name: "MembersOfOwnDatacenter", args: []string{"members", "-address", testServer.URL, "-dc", "dc1"}, want: ExitOK},
+		{name: "MembersOfDatacenterWithoutWANPool", args: []string{"members", "-address", testServer.URL, "-dc", "eu"}, want: ExitError},
+		{name: "MemberOfDatacenterWithoutWANPool", args: []string{"member", "-address", testServer.URL, "-dc", "eu", "Self@localhost"}, want: ExitError},
+		{name: "WANMembersWithoutWANPool", args: []string{"wan-members", "-address", testServer.URL}, want: ExitNotFound},
+		{name: "InvalidTag", args: []string{"members", "-address", testServer.URL, "-tag", "zone"}, want: ExitUsage},
+		{name: "Tags", args: []string{"tags", "-address", testServer.URL}, want: ExitOK},
+		{name: "SetTags", args: []string{"set-tags", "-address", testServer.URL, "zone=eu-west-1a,role=db"}, want: ExitOK},
+		{name: "SetInvalidTags", args: []string{"set-tags", "-address", testServer.URL, "zone"}, want: ExitUsage},
+		{name: "RoundTripToSelf", args: []string{"rtt", "-address", testServer.URL, "Self@localhost"}, want: ExitOK},
+		{name: "RoundTripWithoutCoordinate", args: []string{"rtt", "-address", testServer.URL, "Nobody@Nowhere"}, want: ExitError},
+		{name: "Status", args: []string{"status", "-address", testServer.URL}, want: ExitOK},
+		{name: "UnknownMember", args: []string{"member", "-address", testServer.URL, "Nobody@Nowhere"}, want: ExitNotFound},
+		{name: "UnknownForceLeave", args: []string{"force-leave", "-address", testServer.URL, "Nobody@Nowhere"}, want: ExitNotFound},
+		{name: "LeaveNotSupported", args: []string{"leave", "-address", testServer.URL}, want: ExitError},
+		{name: "ServerUnreachable", args: []string{"info", "-address", "127.0.0.1:1"}, want: ExitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := run(tt.args, &stdout, &stderr); got != tt.want {
+				t.Errorf("run(%v) = %v, want %v, stderr: %s", tt.args, got, tt.want, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_OutputFormats(t *testing.T) {
+	testServer := newTestServer(t)
+	tests := []struct {
+		format string
+		decode func(data []byte, info *api.NodeInfo) error
+	}{
+		{format: OutputJSON, decode: func(data []byte, info *api.NodeInfo) error { return json.Unmarshal(data, info) }},
+		{format: OutputYAML, decode: func(data []byte, info *api.NodeInfo) error {
+			// the YAML uses the field names of the JSON documents
+			var document map[string]interface{}
+			if err := yaml.Unmarshal(data, &document); err != nil {
+				return err
+			}
+			reEncoded, _ := json.Marshal(document)
+			return json.Unmarshal(reEncoded, info)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := run([]string{"info", "-address", testServer.URL, "-output", tt.format}, &stdout, &stderr); got != ExitOK {
+				t.Fatalf("run() = %v, want %v, stderr: %s", got, ExitOK, stderr.String())
+			}
+			var info api.NodeInfo
+			if err := tt.decode(stdout.Bytes(), &info); err != nil {
+				t.Fatalf("could not decode %s output %q: %v", tt.format, stdout.String(), err)
+			}
+			if info.Self.ID != "Self@localhost" || info.ServerPort != "7777" {
+				t.Errorf("%s output = %+v, want info on Self@localhost:7777", tt.format, info)
+			}
+		})
+	}
+
+	var stdout, stderr bytes.Buffer
+	run([]string{"info", "-address", testServer.URL}, &stdout, &stderr)
+	if !strings.Contains(stdout.String(), "Self@localhost") {
+		t.Errorf("table output %q does not contain the identifier of the server", stdout.String())
+	}
+}
+
+func TestRun_StatusNotReady(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testServer := httptest.NewServer(server.NewManagementHandler(&server.MembershipServiceContext{Context: ctx}))
+	defer testServer.Close()
+
+	var stdout, stderr bytes.Buffer
+	if got := run([]string{"status", "-address", testServer.URL}, &stdout, &stderr); got != ExitError {
+		t.Errorf("status of a server that is shutting down exited with %v, want %v", got, ExitError)
+	}
+	if !strings.Contains(stdout.String(), "shutting down") {
+		t.Errorf("status output %q does not say why the server is not ready", stdout.String())
+	}
+}
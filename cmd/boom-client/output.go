@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"text/tabwriter"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// printer writes results in the output format the user asked for
+type printer struct {
+	format string
+	out    io.Writer
+}
+
+func newPrinter(format string, out io.Writer) (*printer, error) {
+	switch format {
+	case OutputTable, OutputJSON, OutputYAML:
+		return &printer{format: format, out: out}, nil
+	default:
+		return nil, usageErrorf("unknown output format %q, use one of %s, %s or %s", format, OutputTable, OutputJSON, OutputYAML)
+	}
+}
+
+// print writes the value as JSON or YAML, or calls writeTable to write it as a table
+func (p *printer) print(value interface{}, writeTable func(table io.Writer)) error {
+	switch p.format {
+	case OutputJSON:
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OutputYAML:
+		return p.printYAML(value)
+	default:
+		table := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+		writeTable(table)
+		return table.Flush()
+	}
+}
+
+// printStream writes a value that is part of a stream, JSON as one line per value and YAML as one document per value
+func (p *printer) printStream(value interface{}, writeLine func(out io.Writer)) error {
+	switch p.format {
+	case OutputJSON:
+		return json.NewEncoder(p.out).Encode(value)
+	case OutputYAML:
+		fmt.Fprintln(p.out, "---")
+		return p.printYAML(value)
+	default:
+		writeLine(p.out)
+		return nil
+	}
+}
+
+// printYAML goes via JSON, so the YAML uses the same field names and order as the management API
+func (p *printer) printYAML(value interface{}) error {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(encodedValue, &document); err != nil {
+		return err
+	}
+	resetStyle(&document)
+	encoder := yaml.NewEncoder(p.out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// resetStyle turns the flow style YAML that we get from parsing JSON into block style YAML
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
@@ -2,91 +2,101 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
 	"github.com/joostvdg/boom/internal/server"
-	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/sdk/resource"
-	"log"
+	"github.com/joostvdg/boom/internal/telemetry"
 	"net"
 	"os"
 	"os/signal"
-	"reflect"
-	"runtime"
-	"sync"
 	"syscall"
+	"time"
 
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/metric/global"
 
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 )
 
-// TODO make env variables
-const (
-	service     = "boom-server"
-	environment = "local"
-)
-
-// tracerProvider returns an OpenTelemetry TracerProvider configured to use
-// the Jaeger exporter that will send spans to the provided url. The returned
-// TracerProvider will also use a Resource configured with all the information
-// about the application.
-func tracerProvider(url string, name string) (*tracesdk.TracerProvider, error) {
-	// Create the Jaeger exporter
-	exp, err := jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(url)))
-	if err != nil {
-		return nil, err
-	}
-	tp := tracesdk.NewTracerProvider(
-		// Always be sure to batch in production.
-		tracesdk.WithBatcher(exp),
-		// Record information about this application in a Resource.
-		tracesdk.WithResource(resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String(service),
-			attribute.String("environment", environment),
-			attribute.String("name", name),
-		)),
-	)
-	return tp, nil
-}
+const service = "boom-server"
 
 func main() {
-	helloPortOverride := flag.String("helloPort", api.HelloPort, fmt.Sprintf("PortSelf number for listening for Hello messages, default %s", api.HelloPort))
-	helloName := flag.String("helloName", "MySelf", "Name of this Boom server")
-	// TDOO: add tracing config support
-	tracingEnabled := flag.Bool("tracing", false, "Set if tracing is enabled")
-	flag.Parse()
+	serverConfig, err := config.Load(service, os.Args[1:], os.LookupEnv)
+	var usageError *config.UsageError
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case errors.As(err, &usageError):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if serverConfig.PrintConfig {
+		fmt.Print(serverConfig.YAML())
+		os.Exit(0)
+	}
+	helloName := &serverConfig.Name
+	helloPortOverride := &serverConfig.Port
+	tracingEnabled := &serverConfig.Tracing.Enabled
+	logger := newLogger(serverConfig)
 
 	// TODO: if it does not respond, do not start the tracer
-	// TODO: tracer does not respond to graceful shutdown
 	var tp *tracesdk.TracerProvider
 	if *tracingEnabled {
 		var err error
-		tp, err = tracerProvider("http://localhost:14268/api/traces", *helloName)
+		tp, err = telemetry.NewTracerProvider(context.Background(), serverConfig.Tracing, telemetry.Resource(service, *helloName, serverConfig.Environment))
 		if err != nil {
-			log.Fatal(err)
+			logger.Error("Could not create the tracer", "exporter", serverConfig.Tracing.Exporter, "error", err)
+			os.Exit(1)
 		}
 		// Register our TracerProvider as the global so any imported
 		// instrumentation in the future will default to using it.
 		otel.SetTracerProvider(tp)
 	}
+	var meterProvider *controller.Controller
+	if serverConfig.Metrics.Enabled {
+		var err error
+		meterProvider, err = telemetry.NewMeterProvider(context.Background(), serverConfig.Metrics, telemetry.Resource(service, *helloName, serverConfig.Environment))
+		if err != nil {
+			logger.Error("Could not create the meter", "exporter", serverConfig.Metrics.Exporter, "error", err)
+			os.Exit(1)
+		}
+		global.SetMeterProvider(meterProvider)
+	}
 
-	myAddress := determineAddress()
-	helloMessage := api.ConstructHelloMessage(*helloName, myAddress.String(), *helloPortOverride)
-	goodbyeMessage := api.ConstructGoodbyeMessage(*helloName, myAddress.String(), *helloPortOverride)
-	heartbeatRequestMessage := api.ConstructHeartbeatRequestMessage(*helloName, myAddress.String(), *helloPortOverride)
-	heartbeatResponseMessage := api.ConstructHeartbeatResponseMessage(*helloName, myAddress.String(), *helloPortOverride)
-	myself := createMyself(*helloName, myAddress)
+	myAddress, err := determineAddress()
+	if err != nil {
+		logger.Error("Could not determine our address", "error", err)
+		os.Exit(1)
+	}
+	nodeID, err := server.LoadNodeID(serverConfig.DataDir)
+	if err != nil {
+		logger.Error("Could not load our node ID", "dataDir", serverConfig.DataDir, "error", err)
+		os.Exit(1)
+	}
+	myself := createMyself(nodeID, *helloName, myAddress, *helloPortOverride, serverConfig.Membership)
 	myIdentity := myself.Identifier()
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	logger.Info("Starting", "nodeID", myIdentity, "name", myself.Name(), "segment", myself.Segment, "gateway", myself.Gateway)
+	helloMessage := api.HelloMessage.CreateMemberMessage(&myself)
+	goodbyeMessage := api.GoodbyeMessage.CreateMemberMessage(&myself)
+	goodbyeAckMessage := api.GoodbyeAckMessage.CreateMemberMessage(&myself)
+	heartbeatRequestMessage := api.HeartbeatRequestMessage.CreateMemberMessage(&myself)
+	heartbeatResponseMessage := api.HeartbeatResponseMessage.CreateMemberMessage(&myself)
+	// a signal, or a request to leave, first lets the cluster know we leave, and then stops the services
+	leaveRequested, requestLeave := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer requestLeave()
+	ctx, stop := context.WithCancel(context.Background())
 	defer stop()
 
 	membershipServiceContext := &server.MembershipServiceContext{
 		Context:           ctx,
+		Config:            serverConfig,
 		TracingEnabled:    *tracingEnabled,
 		TracerProvider:    tp,
 		SelfAddress:       myAddress,
@@ -94,45 +104,114 @@ func main() {
 		Identity:          myIdentity,
 		HelloMessage:      helloMessage,
 		GoodbyeMessage:    goodbyeMessage,
+		GoodbyeAck:        goodbyeAckMessage,
 		HeartbeatRequest:  heartbeatRequestMessage,
 		HeartbeatResponse: heartbeatResponseMessage,
 		ServerPort:        *helloPortOverride,
+		ManagementAddress: serverConfig.API.Address,
+		Shutdown:          requestLeave,
+		Logger:            logger,
+	}
+	if err := membershipServiceContext.SetTags(serverConfig.Tags); err != nil {
+		logger.Error("Could not set our tags", "error", err)
+		os.Exit(1)
+	}
+	var wanPool *server.MembershipServiceContext
+	if serverConfig.WAN.Enabled {
+		wanPool = server.NewWANPool(membershipServiceContext)
+		membershipServiceContext.WAN = wanPool
+		logger.Info("Joining the WAN pool", "datacenter", serverConfig.Datacenter, "port", serverConfig.WAN.Port)
 	}
 
+	// the services are stopped in reverse order: first those that receive and send messages,
+	// then those that handle them, and the management API last, so it can answer probes while we stop
 	membershipServices := []server.MembershipService{
-		server.ListenForMulticast,
-		server.MulticastExistence,
-		server.StartMembershipServer,
+		server.StartManagementServer,
+		server.HandleClockUpdates,
 		server.HandleMember,
 		server.CleanupMembers,
-		server.HandleClockUpdates,
 		server.HeartbeatCloseMembers,
+		server.MulticastExistence,
+		server.StartMembershipServer,
+		server.ListenForMulticast,
+	}
+	if wanPool != nil {
+		membershipServices = append(membershipServices, server.ServeForwardedQueries)
 	}
 
-	var wg sync.WaitGroup
-	for _, membershipService := range membershipServices {
-		wg.Add(1)
-		go func(service server.MembershipService, waitGroup *sync.WaitGroup) {
-			service(membershipServiceContext)
-			serviceName := runtime.FuncForPC(reflect.ValueOf(service).Pointer()).Name()
-			// TODO change this to debug logging
-			fmt.Printf("Service %v has closed\n", serviceName)
-			waitGroup.Done()
-		}(membershipService, &wg)
+	if meterProvider != nil {
+		if err := server.ObserveMembership(membershipServiceContext); err != nil {
+			logger.Warn("Could not observe the membership for the metrics", "error", err)
+		}
+	}
+	go reloadOnHangup(membershipServiceContext)
+	go func() {
+		select {
+		case <-leaveRequested.Done():
+			// we say goodbye to the WAN pool and our cluster at once, so we only drain once
+			leftWANPool := make(chan struct{})
+			go func() {
+				defer close(leftWANPool)
+				if wanPool != nil {
+					server.Leave(wanPool)
+				}
+			}()
+			server.Leave(membershipServiceContext)
+			<-leftWANPool
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+
+	// the WAN pool runs beside our cluster, a service of it that keeps failing shuts the node down as well
+	wanStopped := make(chan error, 1)
+	if wanPool != nil {
+		go func() {
+			wanStopped <- server.Supervise(wanPool, server.WANPoolServices)
+		}()
+	} else {
+		wanStopped <- nil
+	}
+	supervisorErr := server.Supervise(membershipServiceContext, membershipServices)
+	stop()
+	wanErr := <-wanStopped
+	if supervisorErr == nil {
+		supervisorErr = wanErr
 	}
-	wg.Wait()
 
-	fmt.Printf("Shutting down!\n")
-	server.CloseChannels()
-	server.NotifyMembersOfLeaving(goodbyeMessage)
+	logger.Info("Shutting down")
+	membershipServiceContext.CloseChannels()
+	if wanPool != nil {
+		wanPool.CloseChannels()
+	}
+	if supervisorErr != nil {
+		// nobody is left to receive the acknowledgements, but the members should not have to detect we failed
+		membershipServiceContext.NotifyMembersOfLeaving(logger, goodbyeMessage)
+		if wanPool != nil {
+			wanPool.NotifyMembersOfLeaving(logger, wanPool.GoodbyeMessage)
+		}
+	}
 
-	// TODO: this does not seem to work
-	if *tracingEnabled {
-		tp.ForceFlush(ctx)
+	// the context is done by now, so flushing gets a context of its own
+	shutdownContext, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+	defer cancel()
+	if tp := membershipServiceContext.UpdateTracerProvider(nil); tp != nil {
+		if err := tp.Shutdown(shutdownContext); err != nil {
+			logger.Warn("Could not flush the spans", "error", err)
+		}
+	}
+	if meterProvider != nil {
+		if err := meterProvider.Stop(shutdownContext); err != nil {
+			logger.Warn("Could not flush the metrics", "error", err)
+		}
+	}
+	if supervisorErr != nil {
+		cancel()
+		os.Exit(1)
 	}
 }
 
-func createMyself(name string, address net.Addr) api.Member {
+func createMyself(nodeID api.NodeID, name string, address net.Addr, port string, membershipConfig config.MembershipConfig) api.Member {
 	hostname, err := os.Hostname()
 	if err != nil {
 		panic(err)
@@ -140,35 +219,34 @@ func createMyself(name string, address net.Addr) api.Member {
 	ip, _ := api.NewIP4Address(address.String())
 
 	member := api.Member{
+		ID:         nodeID,
 		MemberName: name,
 		Hostname:   hostname,
 		IPSelf:     &ip,
+		PortSelf:   port,
 		Clock:      0,
+		Started:    time.Now(),
+		Segment:    membershipConfig.Segment,
+		Gateway:    membershipConfig.Gateway,
 	}
 	return member
 }
 
-func determineAddress() net.Addr {
+func determineAddress() (net.Addr, error) {
 	connection, err := net.ListenUDP("udp4", nil)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
 	defer connection.Close()
 	address := connection.LocalAddr()
-	return address
+	return address, nil
 }
 
-// newResource returns a resource describing this application.
-func newResource() *resource.Resource {
-	r, _ := resource.Merge(
-		resource.Default(),
-		resource.NewWithAttributes(
-			semconv.SchemaURL,
-			semconv.ServiceNameKey.String("boom-server"),
-			semconv.ServiceVersionKey.String("v0.1.0"),
-			attribute.String("environment", "demo"),
-		),
-	)
-	return r
+// newLogger creates the logger for the server, with the level and format from the configuration
+func newLogger(serverConfig *config.Config) *logging.Logger {
+	// the configuration has been validated, so these parse
+	level, _ := logging.ParseLevel(serverConfig.Log.Level)
+	format, _ := logging.ParseFormat(serverConfig.Log.Format)
+	return logging.New(os.Stdout, format, level).With("node", serverConfig.Name)
 }
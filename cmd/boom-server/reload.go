@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/joostvdg/boom/internal/server"
+	"github.com/joostvdg/boom/internal/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// telemetryShutdownTimeout is how long we wait for the spans and metrics to be flushed
+const telemetryShutdownTimeout = 5 * time.Second
+
+// reloadOnHangup reloads the configuration every time we receive a SIGHUP, until the services are done
+func reloadOnHangup(serviceContext *server.MembershipServiceContext) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-hangup:
+			reloadConfig(serviceContext)
+		case <-serviceContext.Done():
+			return
+		}
+	}
+}
+
+// reloadConfig loads the configuration again, the same way as at start, and applies the changes that are safe at runtime
+func reloadConfig(serviceContext *server.MembershipServiceContext) {
+	logger := serviceContext.Log()
+	logger.Info("Received SIGHUP, reloading the configuration")
+	updated, err := config.Load(service, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		logger.Error("Keeping the current configuration, as the new one is invalid", "error", err)
+		return
+	}
+
+	current := serviceContext.CurrentConfig()
+	next, changed, restartRequired := current.WithChanges(updated)
+	for _, key := range restartRequired {
+		logger.Warn("Setting has changed, but requires a restart to take effect", "setting", key)
+	}
+	if len(changed) == 0 {
+		logger.Info("No settings have changed that can be applied at runtime")
+		return
+	}
+
+	if tracingChanged(changed) {
+		err = reloadTracing(serviceContext, next)
+		if err != nil {
+			logger.Error("Keeping the current tracing configuration, could not apply the new one", "error", err)
+			next.Environment = current.Environment
+			next.Tracing = current.Tracing
+		}
+	}
+	if tagsChanged(changed) {
+		err = serviceContext.SetTags(next.Tags)
+		if err != nil {
+			logger.Error("Keeping the current tags, could not apply the new ones", "error", err)
+			next.Tags = current.Tags
+		}
+	}
+	if level, err := logging.ParseLevel(next.Log.Level); err == nil {
+		logger.SetLevel(level)
+	}
+	serviceContext.UpdateConfig(next)
+	if serviceContext.WAN != nil {
+		serviceContext.WAN.UpdateConfig(next.WANPool())
+	}
+	logger.Info("Applied the configuration changes", "settings", strings.Join(changed, ","))
+}
+
+func tagsChanged(changed []string) bool {
+	for _, key := range changed {
+		if key == "tags" {
+			return true
+		}
+	}
+	return false
+}
+
+func tracingChanged(changed []string) bool {
+	for _, key := range changed {
+		if key == "environment" || strings.HasPrefix(key, "tracing.") {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadTracing replaces the TracerProvider with one for the new configuration, and flushes the previous one
+func reloadTracing(serviceContext *server.MembershipServiceContext, next *config.Config) error {
+	var tp *tracesdk.TracerProvider
+	if next.Tracing.Enabled {
+		var err error
+		tp, err = telemetry.NewTracerProvider(context.Background(), next.Tracing, telemetry.Resource(service, next.Name, next.Environment))
+		if err != nil {
+			return err
+		}
+		otel.SetTracerProvider(tp)
+	} else {
+		// the global provider would otherwise keep handing out tracers of the one we shut down below
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	}
+
+	previous := serviceContext.UpdateTracerProvider(tp)
+	if serviceContext.WAN != nil {
+		serviceContext.WAN.UpdateTracerProvider(tp)
+	}
+	if previous != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), telemetryShutdownTimeout)
+		defer cancel()
+		if err := previous.Shutdown(ctx); err != nil {
+			serviceContext.Log().Warn("Could not flush the spans of the previous tracer", "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/joostvdg/boom/internal/simulation"
+	"io"
+	"os"
+)
+
+const service = "boom-sim"
+
+// Exit codes, so scripts can tell why a run failed
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// Output formats of the report
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet(service, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	scenarioFile := flags.String("scenario", "", "Scenario file, YAML, the default scenario forms a cluster of 5 nodes")
+	nodes := flags.Int("nodes", 0, "Number of nodes, overrides the scenario")
+	seed := flags.Int64("seed", 0, "Seed of the simulated network, overrides the scenario")
+	format := flags.String("format", FormatJSON, "Format of the report: json or csv")
+	output := flags.String("output", "", "File to write the report to, stdout if not set")
+	logLevel := flags.String("logLevel", "error", "Lowest level of the log lines of the nodes: debug, info, warn or error")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return ExitOK
+		}
+		return ExitUsage
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", flags.Args())
+		return ExitUsage
+	}
+	if *format != FormatJSON && *format != FormatCSV {
+		fmt.Fprintf(stderr, "format must be json or csv, got %q\n", *format)
+		return ExitUsage
+	}
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(stderr, "logLevel must be debug, info, warn or error, got %q\n", *logLevel)
+		return ExitUsage
+	}
+
+	scenario := simulation.DefaultScenario()
+	if *scenarioFile != "" {
+		scenario, err = simulation.LoadScenario(*scenarioFile)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitUsage
+		}
+	}
+	if *nodes != 0 {
+		scenario.Nodes = *nodes
+	}
+	if *seed != 0 {
+		scenario.Seed = *seed
+	}
+	if err := scenario.Validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitUsage
+	}
+
+	logger := logging.New(stderr, logging.FormatText, level)
+	report, err := simulation.Run(scenario, logger)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+
+	out := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitError
+		}
+		defer file.Close()
+		out = file
+	}
+	if *format == FormatCSV {
+		err = report.WriteCSV(out)
+	} else {
+		err = report.WriteJSON(out)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitError
+	}
+	return ExitOK
+}
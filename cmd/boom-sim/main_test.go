@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/joostvdg/boom/internal/simulation"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRun_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{name: "UnknownFlag", args: []string{"-explode"}, want: ExitUsage},
+		{name: "UnknownFormat", args: []string{"-format", "xml"}, want: ExitUsage},
+		{name: "UnknownLogLevel", args: []string{"-logLevel", "loud"}, want: ExitUsage},
+		{name: "MissingScenario", args: []string{"-scenario", filepath.Join(t.TempDir(), "missing.yaml")}, want: ExitUsage},
+		{name: "TooManyNodes", args: []string{"-nodes", "300"}, want: ExitUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := run(tt.args, &stdout, &stderr); got != tt.want {
+				t.Errorf("run(%v) = %v, want %v, stderr: %s", tt.args, got, tt.want, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_Scenario(t *testing.T) {
+	scenarioFile := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(scenarioFile, []byte("nodes: 3\nduration: 40s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if got := run([]string{"-scenario", scenarioFile, "-nodes", "2"}, &stdout, &stderr); got != ExitOK {
+		t.Fatalf("run() = %v, want %v, stderr: %s", got, ExitOK, stderr.String())
+	}
+	var report simulation.Report
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("could not decode the report %q: %v", stdout.String(), err)
+	}
+	if report.Nodes != 2 || report.Duration != 40 || len(report.Convergence) != 1 || !report.Convergence[0].Converged {
+		t.Errorf("report = %+v, want 2 nodes that converged within 40s", report)
+	}
+}
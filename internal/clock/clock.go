@@ -0,0 +1,237 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time, and wakes up those that wait for it
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the channel, once the duration has passed
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f, once the duration has passed
+	AfterFunc(d time.Duration, f func()) Timer
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer sends the time on C once, unless it is stopped
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Ticker sends the time on C at every interval, dropping ticks for a slow receiver
+type Ticker interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+// Real is the clock of the machine
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ timer *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+type realTicker struct{ ticker *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.ticker.C }
+func (t realTicker) Reset(d time.Duration) { t.ticker.Reset(d) }
+func (t realTicker) Stop()                 { t.ticker.Stop() }
+
+// Fake is a clock that only moves when it is advanced, so a simulation decides how fast time goes
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	// sequence keeps waiters that are due at the same time in the order they were created, after the timers and the tickers
+	// with a shorter interval, as goroutines that start together can create their tickers in any order
+	sequence uint64
+}
+
+// NewFake creates a fake clock that starts at the given time
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	sequence uint64
+	// interval is set for tickers, which are rescheduled every time they fire
+	interval time.Duration
+	channel  chan time.Time
+	f        func()
+	stopped  bool
+}
+
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.schedule(d, 0, fn)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.schedule(d, 0, nil)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.schedule(d, d, nil)}
+}
+
+func (f *Fake) schedule(d time.Duration, interval time.Duration, fn func()) *fakeWaiter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sequence++
+	waiter := &fakeWaiter{clock: f, deadline: f.now.Add(d), sequence: f.sequence, interval: interval, f: fn}
+	if fn == nil {
+		waiter.channel = make(chan time.Time, 1)
+	}
+	f.waiters = append(f.waiters, waiter)
+	return waiter
+}
+
+// Advance moves the clock forward, firing every timer and ticker that is due on the way, in order
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	target := f.now.Add(d)
+	f.lock.Unlock()
+	for f.Next(target) {
+	}
+}
+
+// Next moves the clock to the first timer or ticker that is due by the target and fires it, or to the target if none is.
+// It reports whether one fired, so a simulation can let it be handled before the next one fires.
+func (f *Fake) Next(target time.Time) bool {
+	f.lock.Lock()
+	waiter := f.nextDue(target)
+	if waiter == nil {
+		if target.After(f.now) {
+			f.now = target
+		}
+		f.lock.Unlock()
+		return false
+	}
+	f.now = waiter.deadline
+	if waiter.interval > 0 {
+		waiter.deadline = waiter.deadline.Add(waiter.interval)
+	} else {
+		waiter.stopped = true
+		f.remove(waiter)
+	}
+	now := f.now
+	f.lock.Unlock()
+
+	// the callbacks can use the clock themselves, so they run without the lock
+	if waiter.f != nil {
+		waiter.f()
+	} else {
+		select {
+		case waiter.channel <- now:
+		default:
+		}
+	}
+	return true
+}
+
+// Pending returns how many timers and tickers are waiting, so a simulation can wait for its services to be scheduled
+func (f *Fake) Pending() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+// Unread returns how many tickers fired without their tick being received yet, so a simulation can wait for its services
+// to handle them
+func (f *Fake) Unread() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	unread := 0
+	for _, waiter := range f.waiters {
+		if waiter.interval > 0 && len(waiter.channel) > 0 {
+			unread++
+		}
+	}
+	return unread
+}
+
+func (f *Fake) nextDue(target time.Time) *fakeWaiter {
+	sort.Slice(f.waiters, func(i, j int) bool {
+		if f.waiters[i].deadline.Equal(f.waiters[j].deadline) {
+			if f.waiters[i].interval != f.waiters[j].interval {
+				return f.waiters[i].interval < f.waiters[j].interval
+			}
+			return f.waiters[i].sequence < f.waiters[j].sequence
+		}
+		return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+	})
+	if len(f.waiters) == 0 || f.waiters[0].deadline.After(target) {
+		return nil
+	}
+	return f.waiters[0]
+}
+
+func (f *Fake) remove(waiter *fakeWaiter) {
+	for i, scheduled := range f.waiters {
+		if scheduled == waiter {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.channel
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	wasActive := !w.stopped
+	w.stopped = true
+	w.clock.remove(w)
+	return wasActive
+}
+
+// fakeTicker is a waiter that is rescheduled every time it fires
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.fakeWaiter.Stop() }
+
+func (w *fakeWaiter) Reset(d time.Duration) {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	w.clock.remove(w)
+	w.clock.sequence++
+	w.sequence = w.clock.sequence
+	w.deadline = w.clock.now.Add(d)
+	w.interval = d
+	w.stopped = false
+	w.clock.waiters = append(w.clock.waiters, w)
+}
@@ -0,0 +1,120 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2022, 9, 13, 23, 25, 38, 0, time.UTC)
+
+func TestFake_Advance(t *testing.T) {
+	fake := NewFake(start)
+	var fired []string
+	fake.AfterFunc(3*time.Second, func() { fired = append(fired, "3s") })
+	fake.AfterFunc(time.Second, func() {
+		fired = append(fired, "1s")
+		// a callback that schedules a timer which is due before the target, sees it fire in the same advance
+		fake.AfterFunc(time.Second, func() { fired = append(fired, "1s+1s") })
+	})
+	stopped := fake.AfterFunc(2*time.Second, func() { fired = append(fired, "stopped") })
+	if !stopped.Stop() {
+		t.Errorf("Stop() = false for a timer that did not fire yet")
+	}
+
+	fake.Advance(5 * time.Second)
+	want := []string{"1s", "1s+1s", "3s"}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("fired %v, want %v", fired, want)
+		}
+	}
+	if now := fake.Now(); !now.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("Now() = %v, want %v", now, start.Add(5*time.Second))
+	}
+	if fake.Pending() != 0 {
+		t.Errorf("Pending() = %v after every timer fired, want 0", fake.Pending())
+	}
+}
+
+func TestFake_Ticker(t *testing.T) {
+	tests := []struct {
+		name      string
+		advance   []time.Duration
+		reset     time.Duration
+		wantTicks int
+	}{
+		{name: "OneTick", advance: []time.Duration{time.Second}, wantTicks: 1},
+		{name: "BeforeFirstTick", advance: []time.Duration{999 * time.Millisecond}, wantTicks: 0},
+		{name: "SlowReceiverDropsTicks", advance: []time.Duration{10 * time.Second}, wantTicks: 1},
+		{name: "TickPerAdvance", advance: []time.Duration{time.Second, time.Second, time.Second}, wantTicks: 3},
+		{name: "Reset", advance: []time.Duration{time.Second, time.Second}, reset: 5 * time.Second, wantTicks: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := NewFake(start)
+			ticker := fake.NewTicker(time.Second)
+			if tt.reset > 0 {
+				ticker.Reset(tt.reset)
+			}
+			ticks := 0
+			for _, d := range tt.advance {
+				fake.Advance(d)
+				select {
+				case <-ticker.C():
+					ticks++
+				default:
+				}
+			}
+			if ticks != tt.wantTicks {
+				t.Errorf("received %v ticks, want %v", ticks, tt.wantTicks)
+			}
+			ticker.Stop()
+			if fake.Pending() != 0 {
+				t.Errorf("Pending() = %v after Stop, want 0", fake.Pending())
+			}
+		})
+	}
+}
+
+func TestFake_Next(t *testing.T) {
+	fake := NewFake(start)
+	slow := fake.NewTicker(2 * time.Second)
+	fast := fake.NewTicker(time.Second)
+	var fired []string
+	fake.AfterFunc(2*time.Second, func() { fired = append(fired, "timer") })
+
+	// at 2s the timer fires first, then the tickers from the shortest interval up, whichever was created first
+	target := start.Add(2 * time.Second)
+	for fake.Next(target) {
+		select {
+		case <-slow.C():
+			fired = append(fired, "slow")
+		case <-fast.C():
+			fired = append(fired, "fast")
+		default:
+		}
+		if fake.Unread() != 0 {
+			t.Errorf("Unread() = %v after the tick was received, want 0", fake.Unread())
+		}
+	}
+	want := []string{"fast", "timer", "fast", "slow"}
+	if len(fired) != len(want) {
+		t.Fatalf("fired %v, want %v", fired, want)
+	}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Errorf("fired %v, want %v", fired, want)
+		}
+	}
+	if now := fake.Now(); !now.Equal(target) {
+		t.Errorf("Now() = %v, want %v", now, target)
+	}
+
+	fake.Advance(time.Second)
+	if fake.Unread() != 1 {
+		t.Errorf("Unread() = %v with a tick nobody received, want 1", fake.Unread())
+	}
+}
@@ -0,0 +1,634 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const EnvironmentPrefix = "BOOM_"
+
+const (
+	DefaultName               = "MySelf"
+	DefaultEnvironment        = "local"
+	DefaultTracingExporter    = ExporterJaeger
+	DefaultTracingSampler     = SamplerAlways
+	DefaultMetricsExporter    = ExporterOTLPGRPC
+	DefaultMetricsInterval    = 30 * time.Second
+	DefaultLogLevel           = "info"
+	DefaultLogFormat          = "text"
+	DefaultMulticastInterval  = 30 * time.Second
+	DefaultHeartbeatInterval  = 5 * time.Second
+	DefaultProbeTimeout       = 500 * time.Millisecond
+	DefaultCleanupInterval    = 10 * time.Second
+	DefaultCleanupTimeout     = 40 * time.Second
+	DefaultMaxShortListSize   = 3
+	DefaultPhiThreshold       = 8.0
+	DefaultPhiWindowSize      = 100
+	DefaultMaxLocalHealth     = 8
+	DefaultLeaveTimeout       = 5 * time.Second
+	DefaultDrainWindow        = 5 * time.Second
+	DefaultNameConflictPolicy = NameConflictOldestWins
+	DefaultNameConflictAction = NameConflictRename
+	DefaultDatacenter         = "dc1"
+	DefaultWANPort            = "7778"
+	// the WAN pool probes less often, and is more patient, as the round trips between datacenters take longer
+	DefaultWANHeartbeatInterval = 15 * time.Second
+	DefaultWANProbeTimeout      = 3 * time.Second
+	DefaultWANCleanupTimeout    = 2 * time.Minute
+	// DefaultReadyMinMembers keeps a node that has not joined a cluster yet from reporting it is ready
+	DefaultReadyMinMembers = 1
+)
+
+// Exporters send traces and metrics to where they are collected
+const (
+	ExporterJaeger   = "jaeger"
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterStdout   = "stdout"
+)
+
+// Samplers decide which traces are recorded, besides these a sampler can be a ratio between 0 and 1
+const (
+	SamplerAlways = "always"
+	SamplerNever  = "never"
+)
+
+// Name conflict policies decide which of two nodes that claim the same name keeps it
+const (
+	// NameConflictRejectNewcomer keeps the member that was there first, the members that know it do not accept the other
+	NameConflictRejectNewcomer = "reject-newcomer"
+	// NameConflictOldestWins keeps the member that started first
+	NameConflictOldestWins = "oldest-wins"
+	// NameConflictMajority keeps the member that most members knew first
+	NameConflictMajority = "majority"
+)
+
+// Name conflict actions are what a node does when it loses its name to another node
+const (
+	NameConflictRename   = "rename"
+	NameConflictShutdown = "shutdown"
+)
+
+var NameConflictPolicies = []string{NameConflictRejectNewcomer, NameConflictOldestWins, NameConflictMajority}
+var NameConflictActions = []string{NameConflictRename, NameConflictShutdown}
+
+var TracingExporters = []string{ExporterJaeger, ExporterOTLPGRPC, ExporterOTLPHTTP, ExporterStdout}
+var MetricsExporters = []string{ExporterOTLPGRPC, ExporterOTLPHTTP, ExporterStdout}
+
+// Config holds all the settings of a boom-server
+type Config struct {
+	Name        string           `yaml:"name" toml:"name"`
+	Port        string           `yaml:"port" toml:"port"`
+	Environment string           `yaml:"environment" toml:"environment"`
+	DataDir     string           `yaml:"dataDir" toml:"dataDir"`
+	Datacenter  string           `yaml:"datacenter" toml:"datacenter"`
+	Tags        TagsConfig       `yaml:"tags" toml:"tags"`
+	API         APIConfig        `yaml:"api" toml:"api"`
+	Membership  MembershipConfig `yaml:"membership" toml:"membership"`
+	WAN         WANConfig        `yaml:"wan" toml:"wan"`
+	Tracing     TracingConfig    `yaml:"tracing" toml:"tracing"`
+	Metrics     MetricsConfig    `yaml:"metrics" toml:"metrics"`
+	Log         LogConfig        `yaml:"log" toml:"log"`
+
+	// File is the configuration file the settings were loaded from, if any
+	File string `yaml:"-" toml:"-"`
+	// PrintConfig asks to print the configuration instead of running the server
+	PrintConfig bool `yaml:"-" toml:"-"`
+}
+
+// TagsConfig are the key/value pairs this server describes itself with to the other members, such as its zone or role
+type TagsConfig map[string]string
+
+type APIConfig struct {
+	Address string `yaml:"address" toml:"address"`
+	// ReadyMinMembers is how many healthy members we need to know before /readyz reports we are ready
+	ReadyMinMembers int `yaml:"readyMinMembers" toml:"readyMinMembers"`
+}
+
+type MembershipConfig struct {
+	MulticastGroup    string        `yaml:"multicastGroup" toml:"multicastGroup"`
+	MulticastInterval time.Duration `yaml:"multicastInterval" toml:"multicastInterval"`
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval" toml:"heartbeatInterval"`
+	// ProbeTimeout is the least time we wait for a heartbeat response, we wait longer for members with longer round trips
+	ProbeTimeout     time.Duration `yaml:"probeTimeout" toml:"probeTimeout"`
+	CleanupInterval  time.Duration `yaml:"cleanupInterval" toml:"cleanupInterval"`
+	CleanupTimeout   time.Duration `yaml:"cleanupTimeout" toml:"cleanupTimeout"`
+	MaxShortListSize int           `yaml:"maxShortListSize" toml:"maxShortListSize"`
+	// PhiThreshold is how suspicious a member that does not respond has to get before we consider it failed,
+	// a phi of 8 means there is a chance of 1 in 10^8 that its response is just late
+	PhiThreshold float64 `yaml:"phiThreshold" toml:"phiThreshold"`
+	// PhiWindowSize is how many of the latest intervals between heartbeat responses the suspicion is based on
+	PhiWindowSize int `yaml:"phiWindowSize" toml:"phiWindowSize"`
+	// MaxLocalHealth caps how unhealthy we consider ourselves, at a local health of n we probe n+1 times slower
+	// and are n+1 times more patient before we consider a member failed, 0 turns local health awareness off
+	MaxLocalHealth int      `yaml:"maxLocalHealth" toml:"maxLocalHealth"`
+	Seeds          []string `yaml:"seeds" toml:"seeds"`
+	// LeaveTimeout is how long we wait for a quorum of members to acknowledge our Goodbye
+	LeaveTimeout time.Duration `yaml:"leaveTimeout" toml:"leaveTimeout"`
+	// DrainWindow is how long we keep answering heartbeats after our Goodbye, before we stop
+	DrainWindow time.Duration `yaml:"drainWindow" toml:"drainWindow"`
+	// NameConflictPolicy decides which of two nodes that claim the same name keeps it, see NameConflictPolicies
+	NameConflictPolicy string `yaml:"nameConflictPolicy" toml:"nameConflictPolicy"`
+	// NameConflictAction is what we do when we lose our name to another node, see NameConflictActions
+	NameConflictAction string `yaml:"nameConflictAction" toml:"nameConflictAction"`
+	// Segment is the network area this server is in, we only keep track of the members in our segment,
+	// empty for the default segment
+	Segment string `yaml:"segment" toml:"segment"`
+	// Gateway servers keep track of the gateways of the other segments as well, and exchange state with them
+	Gateway bool `yaml:"gateway" toml:"gateway"`
+}
+
+// WANConfig is the WAN pool, a second gossip ring that the servers of every datacenter that enable it join,
+// so queries can be forwarded to another datacenter, see Config.WANPool
+type WANConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Port is the port we listen on for the membership messages of the WAN pool, and for queries forwarded to our datacenter
+	Port              string        `yaml:"port" toml:"port"`
+	Seeds             []string      `yaml:"seeds" toml:"seeds"`
+	HeartbeatInterval time.Duration `yaml:"heartbeatInterval" toml:"heartbeatInterval"`
+	ProbeTimeout      time.Duration `yaml:"probeTimeout" toml:"probeTimeout"`
+	CleanupTimeout    time.Duration `yaml:"cleanupTimeout" toml:"cleanupTimeout"`
+}
+
+type TracingConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the URL of the collector, empty means the default of the exporter
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	Sampler  string `yaml:"sampler" toml:"sampler"`
+}
+
+type MetricsConfig struct {
+	Enabled  bool   `yaml:"enabled" toml:"enabled"`
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the URL of the collector, empty means the default of the exporter
+	Endpoint string        `yaml:"endpoint" toml:"endpoint"`
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+// UsageError is returned when the command line cannot be parsed, the usage has already been printed by then
+type UsageError struct {
+	err error
+}
+
+func (e *UsageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *UsageError) Unwrap() error {
+	return e.err
+}
+
+// setting binds a configuration value to its environment variable and command line flag
+type setting struct {
+	Key   string
+	Env   string
+	Flag  string
+	Usage string
+	Value flag.Value
+	// Reloadable settings can change while the server runs, the others require a restart
+	Reloadable bool
+}
+
+// Default returns the configuration a boom-server runs with, when nothing is configured
+func Default() *Config {
+	return &Config{
+		Name:        DefaultName,
+		Port:        api.HelloPort,
+		Environment: DefaultEnvironment,
+		Datacenter:  DefaultDatacenter,
+		Tags:        TagsConfig{},
+		API: APIConfig{
+			Address:         api.ManagementAddress,
+			ReadyMinMembers: DefaultReadyMinMembers,
+		},
+		Membership: MembershipConfig{
+			MulticastGroup:     api.MembershipGroupAddress,
+			MulticastInterval:  DefaultMulticastInterval,
+			HeartbeatInterval:  DefaultHeartbeatInterval,
+			ProbeTimeout:       DefaultProbeTimeout,
+			CleanupInterval:    DefaultCleanupInterval,
+			CleanupTimeout:     DefaultCleanupTimeout,
+			MaxShortListSize:   DefaultMaxShortListSize,
+			PhiThreshold:       DefaultPhiThreshold,
+			PhiWindowSize:      DefaultPhiWindowSize,
+			MaxLocalHealth:     DefaultMaxLocalHealth,
+			Seeds:              []string{},
+			LeaveTimeout:       DefaultLeaveTimeout,
+			DrainWindow:        DefaultDrainWindow,
+			NameConflictPolicy: DefaultNameConflictPolicy,
+			NameConflictAction: DefaultNameConflictAction,
+		},
+		WAN: WANConfig{
+			Port:              DefaultWANPort,
+			Seeds:             []string{},
+			HeartbeatInterval: DefaultWANHeartbeatInterval,
+			ProbeTimeout:      DefaultWANProbeTimeout,
+			CleanupTimeout:    DefaultWANCleanupTimeout,
+		},
+		Tracing: TracingConfig{
+			Enabled:  false,
+			Exporter: DefaultTracingExporter,
+			Sampler:  DefaultTracingSampler,
+		},
+		Metrics: MetricsConfig{
+			Enabled:  false,
+			Exporter: DefaultMetricsExporter,
+			Interval: DefaultMetricsInterval,
+		},
+		Log: LogConfig{
+			Level:  DefaultLogLevel,
+			Format: DefaultLogFormat,
+		},
+	}
+}
+
+// settings lists every setting that can be set via the environment and the command line
+func (c *Config) settings() []setting {
+	return []setting{
+		{Key: "name", Env: "NAME", Flag: "helloName", Usage: "Name of this Boom server", Value: (*stringValue)(&c.Name)},
+		{Key: "port", Env: "PORT", Flag: "helloPort", Usage: "Port for listening to membership messages", Value: (*stringValue)(&c.Port)},
+		{Key: "environment", Env: "ENVIRONMENT", Flag: "environment", Usage: "Name of the environment this server runs in", Value: (*stringValue)(&c.Environment), Reloadable: true},
+		{Key: "dataDir", Env: "DATA_DIR", Flag: "dataDir", Usage: "Directory to keep our node ID in across restarts, empty for a new node ID at every start", Value: (*stringValue)(&c.DataDir)},
+		{Key: "datacenter", Env: "DATACENTER", Flag: "datacenter", Usage: "Name of the datacenter this server runs in", Value: (*stringValue)(&c.Datacenter)},
+		{Key: "tags", Env: "TAGS", Flag: "tags", Usage: "Comma separated key=value tags this server describes itself with", Value: (*tagsValue)(&c.Tags), Reloadable: true},
+		{Key: "api.address", Env: "API_ADDRESS", Flag: "apiAddress", Usage: "Address the management API listens on", Value: (*stringValue)(&c.API.Address)},
+		{Key: "api.readyMinMembers", Env: "READY_MIN_MEMBERS", Flag: "readyMinMembers", Usage: "How many healthy members we need to know before we are ready", Value: (*intValue)(&c.API.ReadyMinMembers), Reloadable: true},
+		{Key: "membership.multicastGroup", Env: "MULTICAST_GROUP", Flag: "multicastGroup", Usage: "Multicast group to announce ourselves on", Value: (*stringValue)(&c.Membership.MulticastGroup)},
+		{Key: "membership.segment", Env: "SEGMENT", Flag: "segment", Usage: "Network area this server is in, empty for the default segment", Value: (*stringValue)(&c.Membership.Segment)},
+		{Key: "membership.gateway", Env: "GATEWAY", Flag: "gateway", Usage: "Set if this server exchanges state with the gateways of the other segments", Value: (*boolValue)(&c.Membership.Gateway)},
+		{Key: "membership.multicastInterval", Env: "MULTICAST_INTERVAL", Flag: "multicastInterval", Usage: "How often we announce ourselves", Value: (*durationValue)(&c.Membership.MulticastInterval), Reloadable: true},
+		{Key: "membership.heartbeatInterval", Env: "HEARTBEAT_INTERVAL", Flag: "heartbeatInterval", Usage: "How often we send heartbeat requests", Value: (*durationValue)(&c.Membership.HeartbeatInterval), Reloadable: true},
+		{Key: "membership.probeTimeout", Env: "PROBE_TIMEOUT", Flag: "probeTimeout", Usage: "The least time we wait for a heartbeat response", Value: (*durationValue)(&c.Membership.ProbeTimeout), Reloadable: true},
+		{Key: "membership.cleanupInterval", Env: "CLEANUP_INTERVAL", Flag: "cleanupInterval", Usage: "How often we look for members to remove", Value: (*durationValue)(&c.Membership.CleanupInterval), Reloadable: true},
+		{Key: "membership.cleanupTimeout", Env: "CLEANUP_TIMEOUT", Flag: "cleanupTimeout", Usage: "How long a member can go unseen before we remove it", Value: (*durationValue)(&c.Membership.CleanupTimeout), Reloadable: true},
+		{Key: "membership.maxShortListSize", Env: "MAX_SHORT_LIST_SIZE", Flag: "maxShortListSize", Usage: "How many members we send a heartbeat request to every heartbeat interval", Value: (*intValue)(&c.Membership.MaxShortListSize), Reloadable: true},
+		{Key: "membership.phiThreshold", Env: "PHI_THRESHOLD", Flag: "phiThreshold", Usage: "How suspicious a member that does not respond has to get before we consider it failed", Value: (*floatValue)(&c.Membership.PhiThreshold), Reloadable: true},
+		{Key: "membership.phiWindowSize", Env: "PHI_WINDOW_SIZE", Flag: "phiWindowSize", Usage: "How many intervals between heartbeat responses the suspicion is based on", Value: (*intValue)(&c.Membership.PhiWindowSize), Reloadable: true},
+		{Key: "membership.maxLocalHealth", Env: "MAX_LOCAL_HEALTH", Flag: "maxLocalHealth", Usage: "How far we slow down probing when we are unhealthy ourselves, 0 turns it off", Value: (*intValue)(&c.Membership.MaxLocalHealth), Reloadable: true},
+		{Key: "membership.seeds", Env: "SEEDS", Flag: "seeds", Usage: "Comma separated ip:port addresses of members to announce ourselves to", Value: (*stringListValue)(&c.Membership.Seeds), Reloadable: true},
+		{Key: "membership.leaveTimeout", Env: "LEAVE_TIMEOUT", Flag: "leaveTimeout", Usage: "How long we wait for members to acknowledge our goodbye", Value: (*durationValue)(&c.Membership.LeaveTimeout), Reloadable: true},
+		{Key: "membership.drainWindow", Env: "DRAIN_WINDOW", Flag: "drainWindow", Usage: "How long we keep answering heartbeats after our goodbye", Value: (*durationValue)(&c.Membership.DrainWindow), Reloadable: true},
+		{Key: "membership.nameConflictPolicy", Env: "NAME_CONFLICT_POLICY", Flag: "nameConflictPolicy", Usage: "Which of two nodes that claim the same name keeps it: " + strings.Join(NameConflictPolicies, ", "), Value: (*stringValue)(&c.Membership.NameConflictPolicy), Reloadable: true},
+		{Key: "membership.nameConflictAction", Env: "NAME_CONFLICT_ACTION", Flag: "nameConflictAction", Usage: "What we do when we lose our name to another node: " + strings.Join(NameConflictActions, ", "), Value: (*stringValue)(&c.Membership.NameConflictAction), Reloadable: true},
+		{Key: "wan.enabled", Env: "WAN_ENABLED", Flag: "wan", Usage: "Set if this server joins the WAN pool, so queries can be forwarded between datacenters", Value: (*boolValue)(&c.WAN.Enabled)},
+		{Key: "wan.port", Env: "WAN_PORT", Flag: "wanPort", Usage: "Port for listening to the WAN pool, and to queries forwarded from other datacenters", Value: (*stringValue)(&c.WAN.Port)},
+		{Key: "wan.seeds", Env: "WAN_SEEDS", Flag: "wanSeeds", Usage: "Comma separated ip:port addresses of WAN pool servers in other datacenters", Value: (*stringListValue)(&c.WAN.Seeds), Reloadable: true},
+		{Key: "wan.heartbeatInterval", Env: "WAN_HEARTBEAT_INTERVAL", Flag: "wanHeartbeatInterval", Usage: "How often we send heartbeat requests to the WAN pool", Value: (*durationValue)(&c.WAN.HeartbeatInterval), Reloadable: true},
+		{Key: "wan.probeTimeout", Env: "WAN_PROBE_TIMEOUT", Flag: "wanProbeTimeout", Usage: "The least time we wait for a heartbeat response from the WAN pool", Value: (*durationValue)(&c.WAN.ProbeTimeout), Reloadable: true},
+		{Key: "wan.cleanupTimeout", Env: "WAN_CLEANUP_TIMEOUT", Flag: "wanCleanupTimeout", Usage: "How long a member of the WAN pool can go unseen before we remove it", Value: (*durationValue)(&c.WAN.CleanupTimeout), Reloadable: true},
+		{Key: "tracing.enabled", Env: "TRACING_ENABLED", Flag: "tracing", Usage: "Set if tracing is enabled", Value: (*boolValue)(&c.Tracing.Enabled), Reloadable: true},
+		{Key: "tracing.exporter", Env: "TRACING_EXPORTER", Flag: "tracingExporter", Usage: "Where to send spans: " + strings.Join(TracingExporters, ", "), Value: (*stringValue)(&c.Tracing.Exporter), Reloadable: true},
+		{Key: "tracing.endpoint", Env: "TRACING_ENDPOINT", Flag: "tracingEndpoint", Usage: "URL of the collector to send spans to, empty for the default of the exporter", Value: (*stringValue)(&c.Tracing.Endpoint), Reloadable: true},
+		{Key: "tracing.sampler", Env: "TRACING_SAMPLER", Flag: "tracingSampler", Usage: "Which traces to record: always, never, or a ratio like 0.1", Value: (*stringValue)(&c.Tracing.Sampler), Reloadable: true},
+		{Key: "metrics.enabled", Env: "METRICS_ENABLED", Flag: "metrics", Usage: "Set if OpenTelemetry metrics are exported", Value: (*boolValue)(&c.Metrics.Enabled)},
+		{Key: "metrics.exporter", Env: "METRICS_EXPORTER", Flag: "metricsExporter", Usage: "Where to send metrics: " + strings.Join(MetricsExporters, ", "), Value: (*stringValue)(&c.Metrics.Exporter)},
+		{Key: "metrics.endpoint", Env: "METRICS_ENDPOINT", Flag: "metricsEndpoint", Usage: "URL of the collector to send metrics to, empty for the default of the exporter", Value: (*stringValue)(&c.Metrics.Endpoint)},
+		{Key: "metrics.interval", Env: "METRICS_INTERVAL", Flag: "metricsInterval", Usage: "How often metrics are exported", Value: (*durationValue)(&c.Metrics.Interval)},
+		{Key: "log.level", Env: "LOG_LEVEL", Flag: "logLevel", Usage: "Lowest level of the log lines to write: debug, info, warn or error", Value: (*stringValue)(&c.Log.Level), Reloadable: true},
+		{Key: "log.format", Env: "LOG_FORMAT", Flag: "logFormat", Usage: "Format of the log lines: text or json", Value: (*stringValue)(&c.Log.Format)},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence:
+// the defaults, the configuration file, BOOM_* environment variables and the command line flags.
+// The configuration file is set with -config or BOOM_CONFIG, and can be YAML or TOML.
+func Load(programName string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	// parse the flags before anything else, but only apply them at the end, as they take precedence
+	flags := flag.NewFlagSet(programName, flag.ContinueOnError)
+	configFile := flags.String("config", "", fmt.Sprintf("Configuration file, YAML or TOML, can be set with %sCONFIG", EnvironmentPrefix))
+	printConfig := flags.Bool("print-config", false, "Print the configuration, and exit")
+	flagValues := make(map[string]string)
+	for _, s := range config.settings() {
+		usage := fmt.Sprintf("%s (%s, %s%s)", s.Usage, s.Key, EnvironmentPrefix, s.Env)
+		_, isBool := s.Value.(*boolValue)
+		flags.Var(&recordingValue{name: s.Flag, recorded: flagValues, defaultValue: s.Value.String(), isBool: isBool}, s.Flag, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, &UsageError{err: err}
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(EnvironmentPrefix + "CONFIG")
+	}
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range config.settings() {
+		if value, ok := lookupEnv(EnvironmentPrefix + s.Env); ok {
+			if err := s.Value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s%s: %w", value, EnvironmentPrefix, s.Env, err)
+			}
+		}
+	}
+	for _, s := range config.settings() {
+		if value, ok := flagValues[s.Flag]; ok {
+			if err := s.Value.Set(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for -%s: %w", value, s.Flag, err)
+			}
+		}
+	}
+
+	config.File = *configFile
+	config.PrintConfig = *printConfig
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// loadFile reads the settings in the file over the current ones, TOML if the extension is .toml, YAML otherwise
+func (c *Config) loadFile(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read configuration file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		metadata, err := toml.Decode(string(content), c)
+		if err != nil {
+			return fmt.Errorf("could not parse configuration file %s: %w", file, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unknown setting %q in configuration file %s", undecoded[0].String(), file)
+		}
+		return nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("could not parse configuration file %s: %w", file, err)
+	}
+	return nil
+}
+
+// Validate checks every setting, and reports all the problems it finds at once
+func (c *Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if c.Name == "" {
+		addProblem("name must be set")
+	} else if len(c.Name) > api.MemberNameField.Size {
+		addProblem("name %q is longer than %d bytes, other members would only see %q", c.Name, api.MemberNameField.Size, c.Name[0:api.MemberNameField.Size])
+	}
+	if err := api.ValidateTags(c.Tags); err != nil {
+		addProblem("tags are not valid: %s", err)
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		addProblem("port must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if _, _, err := net.SplitHostPort(c.API.Address); err != nil {
+		addProblem("api.address must be host:port, got %q", c.API.Address)
+	}
+	if c.API.ReadyMinMembers < 0 {
+		addProblem("api.readyMinMembers must not be negative, got %d", c.API.ReadyMinMembers)
+	}
+
+	membership := c.Membership
+	if group, err := net.ResolveUDPAddr(api.MembershipNetwork, membership.MulticastGroup); err != nil || !group.IP.IsMulticast() {
+		addProblem("membership.multicastGroup must be a multicast ip:port, like %s, got %q", api.MembershipGroupAddress, membership.MulticastGroup)
+	}
+	durations := []struct {
+		key   string
+		value time.Duration
+	}{
+		{"membership.multicastInterval", membership.MulticastInterval},
+		{"membership.heartbeatInterval", membership.HeartbeatInterval},
+		{"membership.probeTimeout", membership.ProbeTimeout},
+		{"membership.cleanupInterval", membership.CleanupInterval},
+		{"membership.cleanupTimeout", membership.CleanupTimeout},
+		{"membership.leaveTimeout", membership.LeaveTimeout},
+	}
+	for _, duration := range durations {
+		if duration.value <= 0 {
+			addProblem("%s must be a positive duration, like 5s, got %v", duration.key, duration.value)
+		}
+	}
+	if membership.ProbeTimeout > membership.HeartbeatInterval {
+		addProblem("membership.probeTimeout (%v) must not be longer than membership.heartbeatInterval (%v), when we send the next request",
+			membership.ProbeTimeout, membership.HeartbeatInterval)
+	}
+	if membership.CleanupTimeout <= membership.MulticastInterval {
+		addProblem("membership.cleanupTimeout (%v) must be longer than membership.multicastInterval (%v), or members are removed between two announcements",
+			membership.CleanupTimeout, membership.MulticastInterval)
+	}
+	if membership.DrainWindow < 0 {
+		addProblem("membership.drainWindow must not be negative, got %v", membership.DrainWindow)
+	}
+	if membership.MaxShortListSize < 1 {
+		addProblem("membership.maxShortListSize must be at least 1, got %d", membership.MaxShortListSize)
+	}
+	if membership.PhiThreshold <= 0 {
+		addProblem("membership.phiThreshold must be positive, like 8, got %v", membership.PhiThreshold)
+	}
+	if membership.PhiWindowSize < 1 {
+		addProblem("membership.phiWindowSize must be at least 1, got %d", membership.PhiWindowSize)
+	}
+	if membership.MaxLocalHealth < 0 {
+		addProblem("membership.maxLocalHealth must not be negative, got %d", membership.MaxLocalHealth)
+	}
+	if !contains(NameConflictPolicies, membership.NameConflictPolicy) {
+		addProblem("membership.nameConflictPolicy must be one of %s, got %q", strings.Join(NameConflictPolicies, ", "), membership.NameConflictPolicy)
+	}
+	if !contains(NameConflictActions, membership.NameConflictAction) {
+		addProblem("membership.nameConflictAction must be one of %s, got %q", strings.Join(NameConflictActions, ", "), membership.NameConflictAction)
+	}
+	if err := api.ValidateSegment(membership.Segment); err != nil {
+		addProblem("membership.segment is not valid: %s", err)
+	}
+	for _, seed := range membership.Seeds {
+		if _, err := net.ResolveUDPAddr(api.MembershipNetwork, seed); err != nil {
+			addProblem("membership.seeds must be ip:port addresses, got %q", seed)
+		}
+	}
+
+	if c.Datacenter == "" {
+		addProblem("datacenter must be set")
+	} else if err := api.ValidateDatacenter(c.Datacenter); err != nil {
+		addProblem("datacenter is not valid: %s", err)
+	}
+	if c.WAN.Enabled {
+		c.validateWAN(addProblem)
+	}
+
+	if !contains(TracingExporters, c.Tracing.Exporter) {
+		addProblem("tracing.exporter must be one of %s, got %q", strings.Join(TracingExporters, ", "), c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" && !isURL(c.Tracing.Endpoint) {
+		addProblem("tracing.endpoint must be a URL, like http://localhost:4317, got %q", c.Tracing.Endpoint)
+	}
+	if _, err := SamplerRatio(c.Tracing.Sampler); err != nil {
+		addProblem("tracing.sampler must be always, never or a ratio between 0 and 1, got %q", c.Tracing.Sampler)
+	}
+	if !contains(MetricsExporters, c.Metrics.Exporter) {
+		addProblem("metrics.exporter must be one of %s, got %q", strings.Join(MetricsExporters, ", "), c.Metrics.Exporter)
+	}
+	if c.Metrics.Endpoint != "" && !isURL(c.Metrics.Endpoint) {
+		addProblem("metrics.endpoint must be a URL, like http://localhost:4317, got %q", c.Metrics.Endpoint)
+	}
+	if c.Metrics.Interval <= 0 {
+		addProblem("metrics.interval must be a positive duration, like 30s, got %v", c.Metrics.Interval)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		addProblem("log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if _, err := logging.ParseFormat(c.Log.Format); err != nil {
+		addProblem("log.format must be text or json, got %q", c.Log.Format)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// validateWAN checks the settings of the WAN pool, which only matter when we join it
+func (c *Config) validateWAN(addProblem func(format string, a ...interface{})) {
+	wan := c.WAN
+	if port, err := strconv.Atoi(wan.Port); err != nil || port < 1 || port > 65535 {
+		addProblem("wan.port must be a number between 1 and 65535, got %q", wan.Port)
+	} else if wan.Port == c.Port {
+		addProblem("wan.port must differ from port %s, the WAN pool is a gossip ring of its own", c.Port)
+	}
+	if wan.HeartbeatInterval <= 0 {
+		addProblem("wan.heartbeatInterval must be a positive duration, like 15s, got %v", wan.HeartbeatInterval)
+	}
+	if wan.ProbeTimeout <= 0 {
+		addProblem("wan.probeTimeout must be a positive duration, like 3s, got %v", wan.ProbeTimeout)
+	} else if wan.ProbeTimeout > wan.HeartbeatInterval {
+		addProblem("wan.probeTimeout (%v) must not be longer than wan.heartbeatInterval (%v), when we send the next request",
+			wan.ProbeTimeout, wan.HeartbeatInterval)
+	}
+	// the servers of the WAN pool announce themselves to the seeds every multicast interval
+	if wan.CleanupTimeout <= c.Membership.MulticastInterval {
+		addProblem("wan.cleanupTimeout (%v) must be longer than membership.multicastInterval (%v), or members are removed between two announcements",
+			wan.CleanupTimeout, c.Membership.MulticastInterval)
+	}
+	for _, seed := range wan.Seeds {
+		if _, err := net.ResolveUDPAddr(api.MembershipNetwork, seed); err != nil {
+			addProblem("wan.seeds must be ip:port addresses, got %q", seed)
+		}
+	}
+}
+
+// WANPool returns the configuration of the WAN pool: this configuration, with the membership settings of the WAN.
+// The servers of the WAN pool find each other through its seeds, not by multicast, and it has no segments.
+func (c *Config) WANPool() *Config {
+	pool := *c
+	pool.Port = c.WAN.Port
+	pool.Membership.MulticastGroup = ""
+	pool.Membership.Seeds = c.WAN.Seeds
+	pool.Membership.HeartbeatInterval = c.WAN.HeartbeatInterval
+	pool.Membership.ProbeTimeout = c.WAN.ProbeTimeout
+	pool.Membership.CleanupTimeout = c.WAN.CleanupTimeout
+	pool.Membership.Segment = ""
+	pool.Membership.Gateway = false
+	return &pool
+}
+
+// SamplerRatio returns the ratio of the traces the sampler records, always is 1 and never is 0
+func SamplerRatio(sampler string) (float64, error) {
+	switch sampler {
+	case SamplerAlways:
+		return 1, nil
+	case SamplerNever:
+		return 0, nil
+	}
+	ratio, err := strconv.ParseFloat(sampler, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ratio < 0 || ratio > 1 {
+		return 0, fmt.Errorf("ratio %v is not between 0 and 1", ratio)
+	}
+	return ratio, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
+
+// WithChanges returns a copy of this configuration with the reloadable settings of the updated configuration,
+// and lists the keys of the settings that changed, and of those that require a restart to change
+func (c *Config) WithChanges(updated *Config) (*Config, []string, []string) {
+	result := *c
+	result.Membership.Seeds = append([]string{}, c.Membership.Seeds...)
+	result.WAN.Seeds = append([]string{}, c.WAN.Seeds...)
+	result.Tags = make(TagsConfig, len(c.Tags))
+	for key, value := range c.Tags {
+		result.Tags[key] = value
+	}
+	result.File = updated.File
+
+	var changed, restartRequired []string
+	resultSettings := result.settings()
+	updatedSettings := updated.settings()
+	for i, current := range c.settings() {
+		updatedValue := updatedSettings[i].Value.String()
+		if current.Value.String() == updatedValue {
+			continue
+		}
+		if !current.Reloadable {
+			restartRequired = append(restartRequired, current.Key)
+			continue
+		}
+		// the value comes from a valid configuration, so it can be set
+		resultSettings[i].Value.Set(updatedValue)
+		changed = append(changed, current.Key)
+	}
+	return &result, changed, restartRequired
+}
+
+// YAML returns the configuration as it would be written in a YAML configuration file
+func (c *Config) YAML() string {
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return fmt.Sprintf("# could not print the configuration: %s\n", err)
+	}
+	encoder.Close()
+	return content.String()
+}
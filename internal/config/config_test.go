@@ -0,0 +1,248 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func environment(variables map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := variables[key]
+		return value, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDefault_IsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("Default().Validate() = %v, want no error", err)
+	}
+}
+
+func TestLoad_Files(t *testing.T) {
+	yamlFile := writeConfigFile(t, "boom.yaml", `
+name: Alan
+tags:
+  zone: eu-west-1a
+membership:
+  heartbeatInterval: 2s
+  seeds:
+    - 10.0.0.1:7777
+`)
+	tomlFile := writeConfigFile(t, "boom.toml", `
+name = "Alan"
+
+[tags]
+zone = "eu-west-1a"
+
+[membership]
+heartbeatInterval = "2s"
+seeds = ["10.0.0.1:7777"]
+`)
+	for _, file := range []string{yamlFile, tomlFile} {
+		t.Run(filepath.Ext(file), func(t *testing.T) {
+			config, err := Load("test", []string{"-config", file}, environment(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Name != "Alan" || config.Membership.HeartbeatInterval != 2*time.Second ||
+				!reflect.DeepEqual(config.Membership.Seeds, []string{"10.0.0.1:7777"}) ||
+				!reflect.DeepEqual(config.Tags, TagsConfig{"zone": "eu-west-1a"}) {
+				t.Errorf("Load() = %+v, want the settings from %s", config, file)
+			}
+			if config.Membership.MulticastInterval != DefaultMulticastInterval {
+				t.Errorf("Load() multicastInterval = %v, want the default %v", config.Membership.MulticastInterval, DefaultMulticastInterval)
+			}
+		})
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeConfigFile(t, "boom.yaml", `
+name: FromFile
+port: "7780"
+environment: file
+`)
+	env := environment(map[string]string{
+		"BOOM_CONFIG":      file,
+		"BOOM_PORT":        "7781",
+		"BOOM_ENVIRONMENT": "env",
+		"BOOM_TAGS":        "zone=eu-west-1a,role=db",
+		"BOOM_DATA_DIR":    "/var/lib/boom",
+	})
+	config, err := Load("test", []string{"-environment", "flag"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Tags, TagsConfig{"zone": "eu-west-1a", "role": "db"}) {
+		t.Errorf("Load() tags = %v, want those of BOOM_TAGS", config.Tags)
+	}
+	if config.DataDir != "/var/lib/boom" {
+		t.Errorf("Load() data directory = %v, want that of BOOM_DATA_DIR", config.DataDir)
+	}
+	if config.Name != "FromFile" || config.Port != "7781" || config.Environment != "flag" {
+		t.Errorf("Load() = name %v, port %v, environment %v, want FromFile, 7781 and flag", config.Name, config.Port, config.Environment)
+	}
+	if config.File != file {
+		t.Errorf("Load() file = %v, want %v", config.File, file)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	unknownSetting := writeConfigFile(t, "boom.yaml", "membership:\n  heartbeatInterva: 2s\n")
+	unknownTomlSetting := writeConfigFile(t, "boom.toml", "[membership]\nheartbeatInterva = \"2s\"\n")
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "MissingFile", args: []string{"-config", "/does/not/exist.yaml"}, wantErr: "could not read configuration file"},
+		{name: "UnknownYAMLSetting", args: []string{"-config", unknownSetting}, wantErr: "heartbeatInterva"},
+		{name: "UnknownTOMLSetting", args: []string{"-config", unknownTomlSetting}, wantErr: "heartbeatInterva"},
+		{name: "InvalidEnvironmentVariable", env: map[string]string{"BOOM_MAX_SHORT_LIST_SIZE": "many"}, wantErr: "BOOM_MAX_SHORT_LIST_SIZE"},
+		{name: "InvalidFlag", args: []string{"-heartbeatInterval", "often"}, wantErr: "-heartbeatInterval"},
+		{name: "UnknownFlag", args: []string{"-unknown"}, wantErr: "-unknown"},
+		{name: "InvalidPort", args: []string{"-helloPort", "99999"}, wantErr: "port must be a number"},
+		{name: "NotMulticast", args: []string{"-multicastGroup", "10.0.0.1:7791"}, wantErr: "membership.multicastGroup"},
+		{name: "InvalidSeed", args: []string{"-seeds", "10.0.0.1"}, wantErr: "membership.seeds"},
+		{name: "CleanupTooSoon", args: []string{"-cleanupTimeout", "10s"}, wantErr: "membership.cleanupTimeout"},
+		{name: "NameTooLong", args: []string{"-helloName", "AVeryLongServerName"}, wantErr: "longer than 12 bytes"},
+		{name: "UnknownLogLevel", env: map[string]string{"BOOM_LOG_LEVEL": "verbose"}, wantErr: "log.level"},
+		{name: "UnknownLogFormat", args: []string{"-logFormat", "xml"}, wantErr: "log.format"},
+		{name: "UnknownTracingExporter", args: []string{"-tracingExporter", "zipkin"}, wantErr: "tracing.exporter"},
+		{name: "InvalidMetricsEndpoint", env: map[string]string{"BOOM_METRICS_ENDPOINT": "localhost:4317"}, wantErr: "metrics.endpoint"},
+		{name: "NegativeReadyMinMembers", args: []string{"-readyMinMembers", "-1"}, wantErr: "api.readyMinMembers"},
+		{name: "NegativeDrainWindow", env: map[string]string{"BOOM_DRAIN_WINDOW": "-1s"}, wantErr: "membership.drainWindow"},
+		{name: "ProbeTimeoutTooLong", args: []string{"-probeTimeout", "10s"}, wantErr: "membership.probeTimeout"},
+		{name: "NegativeMaxLocalHealth", args: []string{"-maxLocalHealth", "-1"}, wantErr: "membership.maxLocalHealth"},
+		{name: "UnknownNameConflictPolicy", args: []string{"-nameConflictPolicy", "youngest-wins"}, wantErr: "membership.nameConflictPolicy"},
+		{name: "UnknownNameConflictAction", env: map[string]string{"BOOM_NAME_CONFLICT_ACTION": "ignore"}, wantErr: "membership.nameConflictAction"},
+		{name: "InvalidSegment", args: []string{"-segment", "eu west"}, wantErr: "membership.segment"},
+		{name: "EmptyDatacenter", args: []string{"-datacenter", ""}, wantErr: "datacenter"},
+		{name: "InvalidDatacenter", env: map[string]string{"BOOM_DATACENTER": "eu/west"}, wantErr: "datacenter"},
+		{name: "WANPortIsPort", args: []string{"-wan", "-wanPort", "7777"}, wantErr: "wan.port"},
+		{name: "WANProbeTimeoutTooLong", args: []string{"-wan", "-wanProbeTimeout", "20s"}, wantErr: "wan.probeTimeout"},
+		{name: "InvalidWANSeed", args: []string{"-wan", "-wanSeeds", "eu-west"}, wantErr: "wan.seeds"},
+		{name: "TagWithoutValue", args: []string{"-tags", "zone"}, wantErr: "-tags"},
+		{name: "TagsTooLarge", env: map[string]string{"BOOM_TAGS": "key=" + strings.Repeat("x", 300)}, wantErr: "BOOM_TAGS"},
+		{name: "InvalidTagInFile", args: []string{"-config", writeConfigFile(t, "tags.yaml", "tags:\n  zone: eu,west\n")}, wantErr: "tags are not valid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load("test", tt.args, environment(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want an error about %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_YAMLRoundTrip(t *testing.T) {
+	config := Default()
+	config.Membership.Seeds = []string{"10.0.0.1:7777", "10.0.0.2:7777"}
+	config.Tags = TagsConfig{"zone": "eu-west-1a"}
+	file := writeConfigFile(t, "boom.yaml", config.YAML())
+
+	loaded, err := Load("test", []string{"-config", file}, environment(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.File = ""
+	if !reflect.DeepEqual(loaded, config) {
+		t.Errorf("Load() of the printed configuration = %+v, want %+v", loaded, config)
+	}
+}
+
+func TestConfig_WithChanges(t *testing.T) {
+	current := Default()
+	updated := Default()
+	updated.Name = "Renamed"
+	updated.API.Address = "0.0.0.0:7788"
+	updated.Membership.HeartbeatInterval = time.Second
+	updated.Membership.Seeds = []string{"10.0.0.1:7777"}
+	updated.Tracing.Enabled = true
+	updated.Tags = TagsConfig{"zone": "eu-west-1a"}
+
+	next, changed, restartRequired := current.WithChanges(updated)
+
+	wantChanged := []string{"tags", "membership.heartbeatInterval", "membership.seeds", "tracing.enabled"}
+	wantRestartRequired := []string{"name", "api.address"}
+	if !reflect.DeepEqual(changed, wantChanged) {
+		t.Errorf("WithChanges() changed = %v, want %v", changed, wantChanged)
+	}
+	if !reflect.DeepEqual(restartRequired, wantRestartRequired) {
+		t.Errorf("WithChanges() restartRequired = %v, want %v", restartRequired, wantRestartRequired)
+	}
+	if next.Name != current.Name || next.API.Address != current.API.Address {
+		t.Errorf("WithChanges() applied settings that require a restart: %+v", next)
+	}
+	if next.Membership.HeartbeatInterval != time.Second || !reflect.DeepEqual(next.Membership.Seeds, updated.Membership.Seeds) || !next.Tracing.Enabled ||
+		!reflect.DeepEqual(next.Tags, updated.Tags) {
+		t.Errorf("WithChanges() did not apply the reloadable settings: %+v", next)
+	}
+	if current.Membership.HeartbeatInterval != DefaultHeartbeatInterval || len(current.Membership.Seeds) != 0 || len(current.Tags) != 0 {
+		t.Errorf("WithChanges() modified the current configuration: %+v", current)
+	}
+}
+
+func TestConfig_WANPool(t *testing.T) {
+	config := Default()
+	config.Membership.Segment = "eu-west"
+	config.Membership.Gateway = true
+	config.Membership.Seeds = []string{"10.0.0.1:7777"}
+	config.WAN.Seeds = []string{"10.1.0.1:7778"}
+
+	pool := config.WANPool()
+
+	if pool.Port != DefaultWANPort || pool.Membership.MulticastGroup != "" || !reflect.DeepEqual(pool.Membership.Seeds, config.WAN.Seeds) {
+		t.Errorf("WANPool() = %+v, want the port and seeds of the WAN pool, without a multicast group", pool)
+	}
+	if pool.Membership.HeartbeatInterval != DefaultWANHeartbeatInterval || pool.Membership.ProbeTimeout != DefaultWANProbeTimeout ||
+		pool.Membership.CleanupTimeout != DefaultWANCleanupTimeout {
+		t.Errorf("WANPool() = %+v, want the timeouts of the WAN pool", pool.Membership)
+	}
+	if pool.Membership.Segment != "" || pool.Membership.Gateway {
+		t.Errorf("WANPool() is in segment %q (gateway %v), want the default segment", pool.Membership.Segment, pool.Membership.Gateway)
+	}
+	if config.Port != Default().Port || config.Membership.MulticastGroup != Default().Membership.MulticastGroup {
+		t.Errorf("WANPool() modified the configuration: %+v", config)
+	}
+}
+
+func TestSamplerRatio(t *testing.T) {
+	tests := []struct {
+		sampler string
+		want    float64
+		wantErr bool
+	}{
+		{sampler: SamplerAlways, want: 1},
+		{sampler: SamplerNever, want: 0},
+		{sampler: "0.25", want: 0.25},
+		{sampler: "1.5", wantErr: true},
+		{sampler: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sampler, func(t *testing.T) {
+			got, err := SamplerRatio(tt.sampler)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SamplerRatio() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("SamplerRatio() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
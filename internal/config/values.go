@@ -0,0 +1,143 @@
+package config
+
+import (
+	"github.com/joostvdg/boom/api"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The values below implement flag.Value for the fields of the Config,
+// so environment variables and flags are parsed the same way
+
+type stringValue string
+
+func (v *stringValue) Set(value string) error {
+	*v = stringValue(value)
+	return nil
+}
+
+func (v *stringValue) String() string {
+	return string(*v)
+}
+
+type intValue int
+
+func (v *intValue) Set(value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*v = intValue(parsed)
+	return nil
+}
+
+func (v *intValue) String() string {
+	return strconv.Itoa(int(*v))
+}
+
+type floatValue float64
+
+func (v *floatValue) Set(value string) error {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	*v = floatValue(parsed)
+	return nil
+}
+
+func (v *floatValue) String() string {
+	return strconv.FormatFloat(float64(*v), 'g', -1, 64)
+}
+
+type boolValue bool
+
+func (v *boolValue) Set(value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*v = boolValue(parsed)
+	return nil
+}
+
+func (v *boolValue) String() string {
+	return strconv.FormatBool(bool(*v))
+}
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*v = durationValue(parsed)
+	return nil
+}
+
+func (v *durationValue) String() string {
+	return time.Duration(*v).String()
+}
+
+// stringListValue is a comma separated list, an empty value clears the list
+type stringListValue []string
+
+func (v *stringListValue) Set(value string) error {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
+
+func (v *stringListValue) String() string {
+	return strings.Join(*v, ",")
+}
+
+// tagsValue is a comma separated list of key=value tags, an empty value clears the tags
+type tagsValue map[string]string
+
+func (v *tagsValue) Set(value string) error {
+	tags, err := api.ParseTags(value)
+	if err != nil {
+		return err
+	}
+	*v = tags
+	return nil
+}
+
+func (v *tagsValue) String() string {
+	return api.FormatTags(*v)
+}
+
+// recordingValue remembers the value a flag is set to, so it can be applied after the file and environment
+type recordingValue struct {
+	name         string
+	recorded     map[string]string
+	defaultValue string
+	isBool       bool
+}
+
+func (v *recordingValue) Set(value string) error {
+	v.recorded[v.name] = value
+	return nil
+}
+
+func (v *recordingValue) String() string {
+	if v == nil || v.recorded == nil {
+		return ""
+	}
+	if value, ok := v.recorded[v.name]; ok {
+		return value
+	}
+	return v.defaultValue
+}
+
+func (v *recordingValue) IsBoolFlag() bool {
+	return v.isBool
+}
@@ -0,0 +1,232 @@
+// Package coordinate keeps the Vivaldi network coordinate of a node, from the round trip times to other members.
+// It follows the algorithm as Serf uses it: "Vivaldi: A Decentralized Network Coordinate System" by Dabek et al.,
+// with the height of "Network Coordinates in the Wild" by Ledlie et al., a latency filter, an adjustment term,
+// and a gravity that keeps the coordinates from drifting away from the origin.
+package coordinate
+
+import (
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// zeroThreshold is the distance in seconds below which two coordinates are considered on top of each other
+const zeroThreshold = 1.0e-6
+
+// maxRoundTrip is the longest round trip time we take in, anything longer is likely a measurement error
+const maxRoundTrip = 10 * time.Second
+
+// Config holds the tuning of the Vivaldi algorithm, the defaults are those of Serf
+type Config struct {
+	Dimensionality int
+	// ErrorMax is the error of a new coordinate, and the most it can become
+	ErrorMax float64
+	// CE is how much a sample changes the error estimate
+	CE float64
+	// CC is how much a sample moves the coordinate
+	CC float64
+	// AdjustmentWindowSize is how many samples the adjustment term averages, 0 turns it off
+	AdjustmentWindowSize int
+	// HeightMin is the lowest height, in seconds
+	HeightMin float64
+	// LatencyFilterSize is how many samples per member the median is taken of, to ignore outliers
+	LatencyFilterSize int
+	// GravityRho is how far from the origin, in seconds, the gravity is as strong as the samples
+	GravityRho float64
+	// Seed seeds the random direction coordinates on top of each other are pushed apart in, 0 picks one from the time
+	Seed int64
+}
+
+// DefaultConfig returns the configuration Serf found to work well, up to a few thousand members
+func DefaultConfig() Config {
+	return Config{
+		Dimensionality:       api.CoordinateDimensionality,
+		ErrorMax:             1.5,
+		CE:                   0.25,
+		CC:                   0.25,
+		AdjustmentWindowSize: 20,
+		HeightMin:            10.0e-6,
+		LatencyFilterSize:    3,
+		GravityRho:           150.0,
+	}
+}
+
+// Client is the coordinate of this node, which it updates with every round trip time to another member
+type Client struct {
+	config            Config
+	coordinate        *api.Coordinate
+	origin            *api.Coordinate
+	adjustmentIndex   int
+	adjustmentSamples []float64
+	latencyFilters    map[string][]float64
+	random            *rand.Rand
+	lock              sync.Mutex
+}
+
+// NewClient starts at the origin, with the largest error, as we know nothing about the network yet
+func NewClient(config Config) *Client {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Client{
+		config:            config,
+		coordinate:        newCoordinate(config),
+		origin:            newCoordinate(config),
+		adjustmentSamples: make([]float64, config.AdjustmentWindowSize),
+		latencyFilters:    make(map[string][]float64),
+		random:            rand.New(rand.NewSource(seed)),
+	}
+}
+
+func newCoordinate(config Config) *api.Coordinate {
+	return &api.Coordinate{
+		Vec:    make([]float64, config.Dimensionality),
+		Error:  config.ErrorMax,
+		Height: config.HeightMin,
+	}
+}
+
+// Coordinate returns a copy of the current coordinate
+func (c *Client) Coordinate() *api.Coordinate {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.coordinate.Clone()
+}
+
+// Forget drops what we measured of a member, once it is gone
+func (c *Client) Forget(member string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.latencyFilters, member)
+}
+
+// Update moves our coordinate by a round trip time to the member with the other coordinate, and returns the new one
+func (c *Client) Update(member string, other *api.Coordinate, roundTrip time.Duration) (*api.Coordinate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !other.IsValid() || !c.coordinate.IsCompatibleWith(other) {
+		return nil, fmt.Errorf("coordinate of %s has %d dimensions or is not valid, want %d", member, len(other.Vec), len(c.coordinate.Vec))
+	}
+	if roundTrip < 0 || roundTrip > maxRoundTrip {
+		return nil, fmt.Errorf("round trip time %v to %s is not between 0 and %v", roundTrip, member, maxRoundTrip)
+	}
+	roundTripSeconds := c.latencyFilter(member, roundTrip.Seconds())
+	c.updateVivaldi(other, roundTripSeconds)
+	c.updateAdjustment(other, roundTripSeconds)
+	c.updateGravity()
+	if !c.coordinate.IsValid() {
+		// better to start over than to keep a coordinate that spreads its garbage to the others
+		c.coordinate = newCoordinate(c.config)
+		return nil, fmt.Errorf("coordinate became invalid after the round trip time %v to %s, reset it", roundTrip, member)
+	}
+	return c.coordinate.Clone(), nil
+}
+
+// latencyFilter returns the median of the latest round trip times to the member, which ignores the odd outlier
+func (c *Client) latencyFilter(member string, roundTripSeconds float64) float64 {
+	samples := append(c.latencyFilters[member], roundTripSeconds)
+	if len(samples) > c.config.LatencyFilterSize {
+		samples = samples[1:]
+	}
+	c.latencyFilters[member] = samples
+	sorted := append([]float64{}, samples...)
+	sort.Float64s(sorted)
+	return sorted[len(sorted)/2]
+}
+
+// updateVivaldi moves the coordinate towards, or away from, the other, by how wrong their distance is,
+// weighed by how confident both are in their coordinates
+func (c *Client) updateVivaldi(other *api.Coordinate, roundTripSeconds float64) {
+	if roundTripSeconds < zeroThreshold {
+		roundTripSeconds = zeroThreshold
+	}
+	distance := c.coordinate.DistanceTo(other).Seconds()
+	wrongness := math.Abs(distance-roundTripSeconds) / roundTripSeconds
+
+	totalError := c.coordinate.Error + other.Error
+	if totalError < zeroThreshold {
+		totalError = zeroThreshold
+	}
+	weight := c.coordinate.Error / totalError
+	c.coordinate.Error = c.config.CE*weight*wrongness + c.coordinate.Error*(1.0-c.config.CE*weight)
+	if c.coordinate.Error > c.config.ErrorMax {
+		c.coordinate.Error = c.config.ErrorMax
+	}
+
+	force := c.config.CC * weight * (roundTripSeconds - distance)
+	c.applyForce(force, other)
+}
+
+// updateAdjustment averages how far off the raw distances are, half of it is ours, half that of the others
+func (c *Client) updateAdjustment(other *api.Coordinate, roundTripSeconds float64) {
+	if c.config.AdjustmentWindowSize == 0 {
+		return
+	}
+	c.adjustmentSamples[c.adjustmentIndex] = roundTripSeconds - c.coordinate.RawDistanceTo(other)
+	c.adjustmentIndex = (c.adjustmentIndex + 1) % c.config.AdjustmentWindowSize
+	sum := 0.0
+	for _, sample := range c.adjustmentSamples {
+		sum += sample
+	}
+	c.coordinate.Adjustment = sum / (2.0 * float64(c.config.AdjustmentWindowSize))
+}
+
+// updateGravity pulls the coordinate towards the origin, harder the further it is
+func (c *Client) updateGravity() {
+	distance := c.origin.DistanceTo(c.coordinate).Seconds()
+	force := -1.0 * math.Pow(distance/c.config.GravityRho, 2.0)
+	c.applyForce(force, c.origin)
+}
+
+// applyForce moves the coordinate away from the other by the force, towards it if the force is negative
+func (c *Client) applyForce(force float64, other *api.Coordinate) {
+	unit, magnitude := c.unitVectorAt(c.coordinate.Vec, other.Vec)
+	for i := range c.coordinate.Vec {
+		c.coordinate.Vec[i] += unit[i] * force
+	}
+	if magnitude > zeroThreshold {
+		c.coordinate.Height = (c.coordinate.Height+other.Height)*force/magnitude + c.coordinate.Height
+		c.coordinate.Height = math.Max(c.coordinate.Height, c.config.HeightMin)
+	}
+}
+
+// unitVectorAt returns the unit vector from the second to the first vector, and the distance between them.
+// Two vectors on top of each other are pushed apart in a random direction.
+func (c *Client) unitVectorAt(vec1 []float64, vec2 []float64) ([]float64, float64) {
+	difference := make([]float64, len(vec1))
+	for i := range difference {
+		difference[i] = vec1[i] - vec2[i]
+	}
+	if magnitude := vectorMagnitude(difference); magnitude > zeroThreshold {
+		return scale(difference, 1.0/magnitude), magnitude
+	}
+	for i := range difference {
+		difference[i] = c.random.Float64() - 0.5
+	}
+	if magnitude := vectorMagnitude(difference); magnitude > zeroThreshold {
+		return scale(difference, 1.0/magnitude), 0.0
+	}
+	unit := make([]float64, len(vec1))
+	unit[0] = 1.0
+	return unit, 0.0
+}
+
+func vectorMagnitude(vec []float64) float64 {
+	sum := 0.0
+	for _, value := range vec {
+		sum += value * value
+	}
+	return math.Sqrt(sum)
+}
+
+func scale(vec []float64, factor float64) []float64 {
+	for i := range vec {
+		vec[i] *= factor
+	}
+	return vec
+}
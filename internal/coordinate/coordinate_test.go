@@ -0,0 +1,110 @@
+package coordinate
+
+import (
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestClient_Update(t *testing.T) {
+	other := newCoordinate(DefaultConfig())
+	tests := []struct {
+		name      string
+		other     *api.Coordinate
+		roundTrip time.Duration
+		wantErr   bool
+	}{
+		{"RoundTrip", other, 10 * time.Millisecond, false},
+		{"ZeroRoundTrip", other, 0, false},
+		{"NegativeRoundTrip", other, -time.Millisecond, true},
+		{"LongRoundTrip", other, time.Minute, true},
+		{"OtherDimensionality", &api.Coordinate{Vec: make([]float64, 2)}, 10 * time.Millisecond, true},
+		{"Invalid", &api.Coordinate{Vec: make([]float64, api.CoordinateDimensionality), Error: math.NaN()}, 10 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(DefaultConfig())
+			coordinate, err := client.Update("Alan@Boreas", tt.other, tt.roundTrip)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !coordinate.IsValid() {
+				t.Errorf("Update() = %+v, which is not valid", coordinate)
+			}
+		})
+	}
+}
+
+func TestClient_LatencyFilter(t *testing.T) {
+	client := NewClient(DefaultConfig())
+	tests := []struct {
+		sample float64
+		want   float64
+	}{
+		{0.010, 0.010},
+		{0.020, 0.020},
+		// an outlier is ignored, it is not the median
+		{1.000, 0.020},
+		{0.030, 0.030},
+		// the first sample is forgotten
+		{0.015, 0.030},
+	}
+	for i, tt := range tests {
+		if got := client.latencyFilter("Alan@Boreas", tt.sample); got != tt.want {
+			t.Errorf("sample %d: latencyFilter(%v) = %v, want %v", i, tt.sample, got, tt.want)
+		}
+	}
+	client.Forget("Alan@Boreas")
+	if got := client.latencyFilter("Alan@Boreas", 0.050); got != 0.050 {
+		t.Errorf("latencyFilter() after Forget = %v, want the new sample 0.05", got)
+	}
+}
+
+// TestClient_Converges places members on a grid, with round trip times by their distance, and lets them
+// measure each other at random, until the coordinates estimate the round trips
+func TestClient_Converges(t *testing.T) {
+	const side = 3
+	const spacing = 10 * time.Millisecond
+	random := rand.New(rand.NewSource(1))
+	clients := make([]*Client, side*side)
+	for i := range clients {
+		clients[i] = NewClient(DefaultConfig())
+		clients[i].random = rand.New(rand.NewSource(int64(i)))
+	}
+	roundTrip := func(i int, j int) time.Duration {
+		dx, dy := float64(i%side-j%side), float64(i/side-j/side)
+		return time.Duration(math.Sqrt(dx*dx+dy*dy) * float64(spacing))
+	}
+
+	for round := 0; round < 1000; round++ {
+		for i, client := range clients {
+			j := random.Intn(len(clients))
+			if j == i {
+				continue
+			}
+			if _, err := client.Update(fmt.Sprint(j), clients[j].Coordinate(), roundTrip(i, j)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i := range clients {
+		for j := range clients {
+			if i == j {
+				continue
+			}
+			estimate := clients[i].Coordinate().DistanceTo(clients[j].Coordinate())
+			if difference := estimate - roundTrip(i, j); difference < -spacing/2 || difference > spacing/2 {
+				t.Errorf("estimated round trip from %d to %d is %v, want about %v", i, j, estimate, roundTrip(i, j))
+			}
+		}
+	}
+	for i, client := range clients {
+		if coordinate := client.Coordinate(); coordinate.Error > 0.5 {
+			t.Errorf("error of %d is %v after converging, want it lower", i, coordinate.Error)
+		}
+	}
+}
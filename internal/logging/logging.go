@@ -0,0 +1,323 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Level is the severity of a log line, lines below the level of the Logger are dropped
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Format is how log lines are written
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// maxLimitedLines bounds how many distinct rate limited lines we remember
+const maxLimitedLines = 1024
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+}
+
+// ParseLevel returns the Level with the name, as used in the configuration
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+}
+
+// ParseFormat returns the Format with the name, as used in the configuration
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case FormatText, FormatJSON:
+		return format, nil
+	default:
+		return FormatText, fmt.Errorf("unknown log format %q, use text or json", name)
+	}
+}
+
+// output is shared by a Logger and all the Loggers derived from it
+type output struct {
+	lock   sync.Mutex
+	out    io.Writer
+	format Format
+	level  int32
+	now    func() time.Time
+
+	// limited tracks the rate limited lines, by their level, message and the fields that identify them
+	limitedLock sync.Mutex
+	limited     map[string]*limitedLine
+}
+
+type limitedLine struct {
+	lastWritten time.Time
+	suppressed  int
+}
+
+// Logger writes leveled log lines with key/value fields
+type Logger struct {
+	output *output
+	fields []interface{}
+	// every is the rate limit, lines with the same message and fields are written at most once per interval
+	every time.Duration
+	// everyKeys are the fields that tell rate limited lines apart, all of them when there are none
+	everyKeys []string
+}
+
+// New returns a Logger that writes lines of at least the level to out, in the format
+func New(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{output: &output{
+		out:     out,
+		format:  format,
+		level:   int32(level),
+		now:     time.Now,
+		limited: make(map[string]*limitedLine),
+	}}
+}
+
+// Nop returns a Logger that writes nothing
+func Nop() *Logger {
+	return New(io.Discard, FormatText, LevelError+1)
+}
+
+// SetLevel changes the level of this Logger and all the Loggers derived from it
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.output.level, int32(level))
+}
+
+// Level returns the level below which lines are dropped
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.output.level))
+}
+
+// Enabled reports whether lines of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// With returns a Logger that adds the key/value pairs to every line
+func (l *Logger) With(keyValues ...interface{}) *Logger {
+	derived := *l
+	derived.fields = append(append(make([]interface{}, 0, len(l.fields)+len(keyValues)), l.fields...), keyValues...)
+	return &derived
+}
+
+// WithSpan returns a Logger that adds the trace and span id to every line, if the span context is valid
+func (l *Logger) WithSpan(spanContext trace.SpanContext) *Logger {
+	if !spanContext.IsValid() {
+		return l
+	}
+	return l.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+}
+
+// Every returns a Logger that writes a line at most once per interval, when the same message repeats with the same
+// values for the keys, or with the same fields when no keys are given, so fields that change every time, like a
+// measurement or a span id, do not defeat the limit; the next line written reports how many were suppressed in between
+func (l *Logger) Every(interval time.Duration, keys ...string) *Logger {
+	derived := *l
+	derived.every = interval
+	derived.everyKeys = keys
+	return &derived
+}
+
+func (l *Logger) Debug(message string, keyValues ...interface{}) {
+	l.log(LevelDebug, message, keyValues)
+}
+
+func (l *Logger) Info(message string, keyValues ...interface{}) {
+	l.log(LevelInfo, message, keyValues)
+}
+
+func (l *Logger) Warn(message string, keyValues ...interface{}) {
+	l.log(LevelWarn, message, keyValues)
+}
+
+func (l *Logger) Error(message string, keyValues ...interface{}) {
+	l.log(LevelError, message, keyValues)
+}
+
+func (l *Logger) log(level Level, message string, keyValues []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := l.output.now()
+	fields := append(append(make([]interface{}, 0, len(l.fields)+len(keyValues)+2), l.fields...), keyValues...)
+	if l.every > 0 {
+		suppressed, write := l.output.limit(level, message, limitFields(fields, l.everyKeys), l.every, now)
+		if !write {
+			return
+		}
+		if suppressed > 0 {
+			fields = append(fields, "suppressed", suppressed)
+		}
+	}
+
+	var line bytes.Buffer
+	if l.output.format == FormatJSON {
+		writeJSON(&line, now, level, message, fields)
+	} else {
+		writeText(&line, now, level, message, fields)
+	}
+	l.output.lock.Lock()
+	defer l.output.lock.Unlock()
+	l.output.out.Write(line.Bytes())
+}
+
+// limit decides if a rate limited line is written now, and how many of the same lines were suppressed before it
+func (o *output) limit(level Level, message string, fields []interface{}, every time.Duration, now time.Time) (int, bool) {
+	var key bytes.Buffer
+	writeText(&key, time.Time{}, level, message, fields)
+
+	o.limitedLock.Lock()
+	defer o.limitedLock.Unlock()
+	line, seen := o.limited[key.String()]
+	if seen && now.Sub(line.lastWritten) < every {
+		line.suppressed++
+		return 0, false
+	}
+	if !seen {
+		if len(o.limited) >= maxLimitedLines {
+			for limitedKey, limited := range o.limited {
+				if now.Sub(limited.lastWritten) >= every {
+					delete(o.limited, limitedKey)
+				}
+			}
+		}
+		line = &limitedLine{}
+		o.limited[key.String()] = line
+	}
+	suppressed := line.suppressed
+	line.lastWritten = now
+	line.suppressed = 0
+	return suppressed, true
+}
+
+// limitFields returns the fields with one of the keys, or all fields when there are no keys
+func limitFields(fields []interface{}, keys []string) []interface{} {
+	if len(keys) == 0 {
+		return fields
+	}
+	identifying := make([]interface{}, 0, 2*len(keys))
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		for _, limitKey := range keys {
+			if key == limitKey {
+				identifying = append(identifying, key, value)
+				break
+			}
+		}
+	}
+	return identifying
+}
+
+// writeText writes the line as logfmt: time=... level=info msg="..." key=value
+func writeText(line *bytes.Buffer, now time.Time, level Level, message string, fields []interface{}) {
+	if !now.IsZero() {
+		line.WriteString("time=")
+		line.WriteString(now.Format(timeFormat))
+		line.WriteByte(' ')
+	}
+	line.WriteString("level=")
+	line.WriteString(level.String())
+	line.WriteString(" msg=")
+	writeTextValue(line, message)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		line.WriteByte(' ')
+		line.WriteString(key)
+		line.WriteByte('=')
+		writeTextValue(line, fmt.Sprint(value))
+	}
+	line.WriteByte('\n')
+}
+
+func writeTextValue(line *bytes.Buffer, value string) {
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		line.WriteString(strconv.Quote(value))
+		return
+	}
+	line.WriteString(value)
+}
+
+// writeJSON writes the line as a single JSON object
+func writeJSON(line *bytes.Buffer, now time.Time, level Level, message string, fields []interface{}) {
+	line.WriteString(`{"time":`)
+	writeJSONValue(line, now.Format(timeFormat))
+	line.WriteString(`,"level":`)
+	writeJSONValue(line, level.String())
+	line.WriteString(`,"msg":`)
+	writeJSONValue(line, message)
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		line.WriteByte(',')
+		writeJSONValue(line, key)
+		line.WriteByte(':')
+		writeJSONValue(line, value)
+	}
+	line.WriteString("}\n")
+}
+
+func writeJSONValue(line *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case fmt.Stringer:
+		value = v.String()
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(encoded)
+}
+
+// field returns the key/value pair at index i, a value without a key is reported under !BADKEY
+func field(fields []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(fields[i])
+	if i+1 >= len(fields) {
+		return "!BADKEY", key
+	}
+	return key, fields[i+1]
+}
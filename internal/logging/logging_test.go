@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var testTime = time.Date(2022, 9, 13, 23, 25, 38, 841000000, time.UTC)
+
+func newTestLogger(format Format, level Level) (*Logger, *bytes.Buffer, *time.Time) {
+	var out bytes.Buffer
+	now := testTime
+	logger := New(&out, format, level)
+	logger.output.now = func() time.Time { return now }
+	return logger, &out, &now
+}
+
+func TestLogger_Text(t *testing.T) {
+	tests := []struct {
+		name      string
+		log       func(logger *Logger)
+		wantLines string
+	}{
+		{
+			name:      "Fields",
+			log:       func(logger *Logger) { logger.Info("Received hello", "member", "Alan@Boreas", "clock", 3) },
+			wantLines: "time=2022-09-13T23:25:38.841Z level=info msg=\"Received hello\" member=Alan@Boreas clock=3\n",
+		},
+		{
+			name: "QuotedValues",
+			log: func(logger *Logger) {
+				logger.Error("Send failed", "error", errors.New("no route to host"), "empty", "")
+			},
+			wantLines: "time=2022-09-13T23:25:38.841Z level=error msg=\"Send failed\" error=\"no route to host\" empty=\"\"\n",
+		},
+		{
+			name:      "With",
+			log:       func(logger *Logger) { logger.With("service", "HandleMember").Warn("Gone", "member", "Bas@Boreas") },
+			wantLines: "time=2022-09-13T23:25:38.841Z level=warn msg=Gone service=HandleMember member=Bas@Boreas\n",
+		},
+		{
+			name:      "BelowLevel",
+			log:       func(logger *Logger) { logger.Debug("Received message") },
+			wantLines: "",
+		},
+		{
+			name:      "MissingValue",
+			log:       func(logger *Logger) { logger.Info("Odd", "lonely") },
+			wantLines: "time=2022-09-13T23:25:38.841Z level=info msg=Odd !BADKEY=lonely\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, out, _ := newTestLogger(FormatText, LevelInfo)
+			tt.log(logger)
+			if got := out.String(); got != tt.wantLines {
+				t.Errorf("got %q, want %q", got, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestLogger_JSON(t *testing.T) {
+	logger, out, _ := newTestLogger(FormatJSON, LevelDebug)
+	logger.Debug("Received message", "remote", "10.0.0.1:7780", "bytes", 43, "error", errors.New("boom"))
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("line %q is not JSON: %v", out.String(), err)
+	}
+	want := map[string]interface{}{
+		"time":   "2022-09-13T23:25:38.841Z",
+		"level":  "debug",
+		"msg":    "Received message",
+		"remote": "10.0.0.1:7780",
+		"bytes":  float64(43),
+		"error":  "boom",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %v", key, line[key], value)
+		}
+	}
+}
+
+func TestLogger_SetLevel(t *testing.T) {
+	logger, out, _ := newTestLogger(FormatText, LevelInfo)
+	derived := logger.With("service", "HandleMember")
+	logger.SetLevel(LevelError)
+	derived.Warn("Dropped")
+	if out.Len() != 0 {
+		t.Errorf("derived logger wrote %q below the level", out.String())
+	}
+	logger.SetLevel(LevelDebug)
+	derived.Debug("Written")
+	if !strings.Contains(out.String(), "msg=Written") {
+		t.Errorf("derived logger did not write at the new level: %q", out.String())
+	}
+}
+
+func TestLogger_WithSpan(t *testing.T) {
+	logger, out, _ := newTestLogger(FormatText, LevelInfo)
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	})
+	logger.WithSpan(spanContext).Info("Traced")
+	logger.WithSpan(trace.SpanContext{}).Info("Untraced")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !strings.HasSuffix(lines[0], "trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7") {
+		t.Errorf("traced line %q does not have the trace and span id", lines[0])
+	}
+	if strings.Contains(lines[1], "trace_id") {
+		t.Errorf("untraced line %q has a trace id", lines[1])
+	}
+}
+
+func TestLogger_Every(t *testing.T) {
+	logger, out, now := newTestLogger(FormatText, LevelInfo)
+	limited := logger.Every(time.Minute)
+
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas")
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas")
+	limited.Warn("Initiating failure propagation", "member", "Bas@Boreas")
+	*now = now.Add(30 * time.Second)
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas")
+	*now = now.Add(31 * time.Second)
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"member=Alan@Boreas",
+		"member=Bas@Boreas",
+		"member=Alan@Boreas suppressed=2",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %d lines, want %d: %q", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want it to end with %q", i, line, want[i])
+		}
+	}
+}
+
+func TestLogger_EveryKeys(t *testing.T) {
+	logger, out, now := newTestLogger(FormatText, LevelInfo)
+	limited := logger.With("span_id", "00f067aa0ba902b7").Every(time.Minute, "member")
+
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas", "phi", 8.3)
+	limited.With("span_id", "53995c3f42cd8ad8").Warn("Initiating failure propagation", "member", "Alan@Boreas", "phi", 9.1)
+	limited.Warn("Initiating failure propagation", "member", "Bas@Boreas", "phi", 8.7)
+	*now = now.Add(61 * time.Second)
+	limited.Warn("Initiating failure propagation", "member", "Alan@Boreas", "phi", 12.4)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"member=Alan@Boreas phi=8.3",
+		"member=Bas@Boreas phi=8.7",
+		"member=Alan@Boreas phi=12.4 suppressed=1",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("wrote %d lines, want %d: %q", len(lines), len(want), out.String())
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want it to end with %q", i, line, want[i])
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug", want: LevelDebug},
+		{name: "INFO", want: LevelInfo},
+		{name: "warning", want: LevelWarn},
+		{name: "error", want: LevelError},
+		{name: "verbose", want: LevelInfo, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
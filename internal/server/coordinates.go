@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
+	"time"
+)
+
+// Members send their network coordinate along with heartbeat requests and responses.
+// Every response in time moves our coordinate by its round trip time, and with the coordinates of the others
+// we can estimate the round trip time to any member, without probing it.
+
+// coordinate returns our network coordinate, as we send it to the others
+func (s *MembershipServiceContext) coordinate() *api.Coordinate {
+	return s.cluster().coordinates.Coordinate()
+}
+
+// recordCoordinate remembers the latest coordinate a member sent us
+func (s *MembershipServiceContext) recordCoordinate(identifier string, memberCoordinate *api.Coordinate) {
+	cluster := s.cluster()
+	cluster.memberCoordinatesLock <- struct{}{}
+	cluster.memberCoordinates[identifier] = memberCoordinate
+	<-cluster.memberCoordinatesLock
+}
+
+// updateCoordinate moves our coordinate by the round trip time to the member, which sent its coordinate with the response
+func (s *MembershipServiceContext) updateCoordinate(logger *logging.Logger, identifier string, memberCoordinate *api.Coordinate, roundTrip time.Duration) {
+	s.recordCoordinate(identifier, memberCoordinate)
+	if _, err := s.cluster().coordinates.Update(identifier, memberCoordinate, roundTrip); err != nil {
+		logger.Debug("Could not update our coordinate", "member", identifier, "roundTrip", roundTrip, "error", err)
+	}
+}
+
+// memberCoordinate returns the latest coordinate of the member, nil if it did not send us one
+func (s *MembershipServiceContext) memberCoordinate(identifier string) *api.Coordinate {
+	cluster := s.cluster()
+	cluster.memberCoordinatesLock <- struct{}{}
+	memberCoordinate := cluster.memberCoordinates[identifier]
+	<-cluster.memberCoordinatesLock
+	return memberCoordinate
+}
+
+// estimatedRoundTrips estimates the round trip time to every member that sent us a coordinate
+func (s *MembershipServiceContext) estimatedRoundTrips() map[string]time.Duration {
+	cluster := s.cluster()
+	selfCoordinate := s.coordinate()
+	cluster.memberCoordinatesLock <- struct{}{}
+	roundTrips := make(map[string]time.Duration, len(cluster.memberCoordinates))
+	for identifier, memberCoordinate := range cluster.memberCoordinates {
+		if selfCoordinate.IsCompatibleWith(memberCoordinate) {
+			roundTrips[identifier] = selfCoordinate.DistanceTo(memberCoordinate)
+		}
+	}
+	<-cluster.memberCoordinatesLock
+	return roundTrips
+}
+
+// forgetCoordinate drops what we know of the coordinate of a member that is gone
+func (s *MembershipServiceContext) forgetCoordinate(identifier string) {
+	cluster := s.cluster()
+	cluster.memberCoordinatesLock <- struct{}{}
+	delete(cluster.memberCoordinates, identifier)
+	<-cluster.memberCoordinatesLock
+	cluster.coordinates.Forget(identifier)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"testing"
+	"time"
+)
+
+func TestMembershipServiceContext_CoordinateFollowsResponses(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default(), Clock: fakeClock}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	cluster.members[alan.Identifier()] = alan
+	alanCoordinate := &api.Coordinate{Vec: make([]float64, api.CoordinateDimensionality), Error: 0.2, Height: 0.001}
+	alanCoordinate.Vec[0] = 0.01
+
+	for i := 0; i < 10; i++ {
+		sequence := serviceContext.HandleHeartbeatResponseTracking(context.Background(), logging.Nop(), alan)
+		fakeClock.Advance(20 * time.Millisecond)
+		serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), alan, sequence, true, alanCoordinate)
+	}
+
+	if got := serviceContext.memberCoordinate(alan.Identifier()); got != alanCoordinate {
+		t.Errorf("memberCoordinate() = %+v, want the coordinate Alan sent", got)
+	}
+	if got := serviceContext.coordinate(); got.Error >= 1.5 {
+		t.Errorf("coordinate() error = %v, want the responses to have lowered it", got.Error)
+	}
+	memberInfos := serviceContext.MemberInfoSnapshot()
+	if len(memberInfos) != 1 || memberInfos[0].Coordinate == nil || memberInfos[0].EstimatedRoundTrip <= 0 {
+		t.Fatalf("MemberInfoSnapshot() = %+v, want Alan with its coordinate and an estimated round trip", memberInfos)
+	}
+	if estimate := time.Duration(memberInfos[0].EstimatedRoundTrip * float64(time.Second)); estimate < 10*time.Millisecond || estimate > 30*time.Millisecond {
+		t.Errorf("estimated round trip to Alan is %v, want it near the 20ms measured", estimate)
+	}
+
+	serviceContext.forgetCoordinate(alan.Identifier())
+	if got := serviceContext.memberCoordinate(alan.Identifier()); got != nil {
+		t.Errorf("memberCoordinate() after forgetCoordinate = %+v, want nil", got)
+	}
+}
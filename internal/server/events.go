@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/api"
+)
+
+// eventSubscriberBuffer is how many events a subscriber may lag behind, before it starts missing events
+const eventSubscriberBuffer = 64
+
+// SubscribeToEvents returns a channel that receives every membership event from now on
+func (s *MembershipServiceContext) SubscribeToEvents() chan api.MemberEvent {
+	cluster := s.cluster()
+	subscription := make(chan api.MemberEvent, eventSubscriberBuffer)
+	cluster.eventSubscribersLock <- struct{}{} // acquire token
+	cluster.eventSubscribers[subscription] = struct{}{}
+	<-cluster.eventSubscribersLock // release token
+	return subscription
+}
+
+// UnsubscribeFromEvents stops sending events to the subscription, and closes it
+func (s *MembershipServiceContext) UnsubscribeFromEvents(subscription chan api.MemberEvent) {
+	cluster := s.cluster()
+	cluster.eventSubscribersLock <- struct{}{} // acquire token
+	delete(cluster.eventSubscribers, subscription)
+	<-cluster.eventSubscribersLock // release token
+	close(subscription)
+}
+
+// publishEvent lets every subscriber know something happened to a member, we never block on slow subscribers
+func (s *MembershipServiceContext) publishEvent(eventType api.MemberEventType, member *api.Member, state api.MemberState) {
+	s.deliverEvent(api.MemberEvent{
+		Type:   eventType,
+		Time:   s.clock().Now(),
+		Member: api.NewMemberInfo(member, state),
+	})
+}
+
+// publishConflict lets every subscriber know the member claims the same name as the other member
+func (s *MembershipServiceContext) publishConflict(member *api.Member, other *api.Member) {
+	s.deliverEvent(api.MemberEvent{
+		Type:          api.MemberEventConflict,
+		Time:          s.clock().Now(),
+		Member:        api.NewMemberInfo(member, api.MemberStateAlive),
+		ConflictsWith: other.Identifier(),
+	})
+}
+
+func (s *MembershipServiceContext) deliverEvent(event api.MemberEvent) {
+	cluster := s.cluster()
+	cluster.eventSubscribersLock <- struct{}{} // acquire token
+	for subscription := range cluster.eventSubscribers {
+		select {
+		case subscription <- event:
+		default:
+		}
+	}
+	<-cluster.eventSubscribersLock // release token
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ServiceName is the name of the function of a MembershipService, like HandleMember
+func ServiceName(service MembershipService) string {
+	serviceName := runtime.FuncForPC(reflect.ValueOf(service).Pointer()).Name()
+	return serviceName[strings.LastIndex(serviceName, ".")+1:]
+}
+
+func (s *MembershipServiceContext) setServiceState(serviceName string, state api.ServiceState, err error) {
+	s = s.root()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.services == nil {
+		s.services = make(map[string]*api.ServiceStatus)
+	}
+	status := s.services[serviceName]
+	if status == nil {
+		status = &api.ServiceStatus{}
+		s.services[serviceName] = status
+	}
+	if state == api.ServiceStateRunning && status.State == api.ServiceStateRestarting {
+		status.Restarts++
+	}
+	status.State = state
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// Health reports the node is healthy as long as its services are running, or restarting after a failure
+func (s *MembershipServiceContext) Health() api.HealthStatus {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	health := api.HealthStatus{Healthy: true, Services: make(map[string]api.ServiceStatus)}
+	for serviceName, status := range s.services {
+		health.Services[serviceName] = *status
+		if status.State != api.ServiceStateRunning && status.State != api.ServiceStateRestarting {
+			health.Healthy = false
+		}
+	}
+	return health
+}
+
+// Readiness reports the node is ready when all its services are running, it is not shutting down or leaving, and knows enough healthy members
+func (s *MembershipServiceContext) Readiness() api.ReadinessStatus {
+	minMembers := config.DefaultReadyMinMembers
+	if currentConfig := s.CurrentConfig(); currentConfig != nil {
+		minMembers = currentConfig.API.ReadyMinMembers
+	}
+	membershipConfig := s.membershipConfig()
+	health := s.Health()
+	readiness := api.ReadinessStatus{
+		Healthy:        health.Healthy,
+		HealthyMembers: s.healthyMemberCount(membershipConfig.PhiThreshold, s.memberProbeInterval(membershipConfig)),
+		MinMembers:     minMembers,
+		Reasons:        make([]string, 0),
+	}
+	serviceNames := make([]string, 0, len(health.Services))
+	for serviceName := range health.Services {
+		serviceNames = append(serviceNames, serviceName)
+	}
+	sort.Strings(serviceNames)
+	for _, serviceName := range serviceNames {
+		if state := health.Services[serviceName].State; state != api.ServiceStateRunning {
+			readiness.Reasons = append(readiness.Reasons, fmt.Sprintf("service %s is %s", serviceName, state))
+		}
+	}
+	if root := s.root(); root.Context != nil && root.Err() != nil {
+		readiness.Reasons = append(readiness.Reasons, "shutting down")
+	}
+	if s.Leaving() {
+		readiness.Reasons = append(readiness.Reasons, "leaving the cluster")
+	}
+	if readiness.HealthyMembers < minMembers {
+		readiness.Reasons = append(readiness.Reasons,
+			fmt.Sprintf("knows %d healthy members, needs at least %d", readiness.HealthyMembers, minMembers))
+	}
+	readiness.Ready = len(readiness.Reasons) == 0
+	return readiness
+}
+
+// healthyMemberCount counts the alive members, except those we are suspicious enough of to consider failed
+func (s *MembershipServiceContext) healthyMemberCount(phiThreshold float64, probeInterval time.Duration) int {
+	cluster := s.cluster()
+	healthyMembers := 0
+	localHealthMultiplier := s.localHealthMultiplier()
+	cluster.membersLock <- struct{}{} //acquire token
+	cluster.heartbeatResponsesLock <- struct{}{}
+	now := s.clock().Now()
+	for identifier := range cluster.members {
+		tracker := cluster.heartbeatResponses[identifier]
+		if tracker == nil || tracker.Phi(now, probeInterval, localHealthMultiplier) < phiThreshold {
+			healthyMembers++
+		}
+	}
+	<-cluster.heartbeatResponsesLock
+	<-cluster.membersLock //release token
+	return healthyMembers
+}
+
+// getHealth serves /healthz, for liveness probes
+func (m *managementAPI) getHealth(w http.ResponseWriter, r *http.Request) {
+	health := m.serviceContext.Health()
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// getReadiness serves /readyz, for readiness probes
+func (m *managementAPI) getReadiness(w http.ResponseWriter, r *http.Request) {
+	readiness := m.serviceContext.Readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness)
+}
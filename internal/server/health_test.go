@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getStatus(t *testing.T, handler http.Handler, path string) (int, map[string]interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var document map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatalf("GET %s returned %q, which is not JSON: %v", path, recorder.Body.String(), err)
+	}
+	return recorder.Code, document
+}
+
+func TestManagementAPI_Health(t *testing.T) {
+	tests := []struct {
+		state       api.ServiceState
+		wantHealthy bool
+		wantReady   bool
+	}{
+		{state: api.ServiceStateRunning, wantHealthy: true, wantReady: true},
+		{state: api.ServiceStateRestarting, wantHealthy: true, wantReady: false},
+		{state: api.ServiceStateFailed, wantHealthy: false, wantReady: false},
+		{state: api.ServiceStateStopped, wantHealthy: false, wantReady: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			// readiness only depends on the services here, not on the members we know
+			serverConfig := config.Default()
+			serverConfig.API.ReadyMinMembers = 0
+			serviceContext := &MembershipServiceContext{Context: context.Background(), Config: serverConfig}
+			handler := NewManagementHandler(serviceContext)
+			serviceContext.setServiceState(ServiceName(HandleMember), api.ServiceStateRunning, nil)
+			serviceContext.setServiceState(ServiceName(HandleClockUpdates), tt.state, nil)
+
+			status, document := getStatus(t, handler, "/healthz")
+			requireSchemaFields(t, "health.json", document)
+			if document["healthy"] != tt.wantHealthy || (status == http.StatusOK) != tt.wantHealthy {
+				t.Errorf("GET /healthz = %v %v, want healthy %v", status, document, tt.wantHealthy)
+			}
+			services := document["services"].(map[string]interface{})
+			if clockUpdates := services["HandleClockUpdates"].(map[string]interface{}); clockUpdates["state"] != string(tt.state) {
+				t.Errorf("HandleClockUpdates = %v, want %v", clockUpdates, tt.state)
+			}
+
+			status, document = getStatus(t, handler, "/readyz")
+			if document["ready"] != tt.wantReady || (status == http.StatusOK) != tt.wantReady {
+				t.Errorf("GET /readyz = %v %v, want ready %v", status, document, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_Readiness(t *testing.T) {
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	tests := []struct {
+		name       string
+		minMembers int
+		shutdown   bool
+		wantStatus int
+	}{
+		{name: "NoMembersNeeded", minMembers: 0, wantStatus: http.StatusOK},
+		{name: "EnoughMembers", minMembers: 1, wantStatus: http.StatusOK},
+		{name: "NotEnoughHealthyMembers", minMembers: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "ShuttingDown", minMembers: 0, shutdown: true, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := config.Default()
+			serverConfig.API.ReadyMinMembers = tt.minMembers
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.shutdown {
+				cancel()
+			}
+			serviceContext := &MembershipServiceContext{Context: ctx, Config: serverConfig}
+			cluster := serviceContext.cluster()
+			cluster.members[alan.Identifier()] = alan
+			cluster.members[bas.Identifier()] = bas
+			cluster.heartbeatResponses[bas.Identifier()] = &heartbeatResponseTracker{LastResponse: time.Now().Add(-time.Hour)}
+			handler := NewManagementHandler(serviceContext)
+
+			status, document := getStatus(t, handler, "/readyz")
+			requireSchemaFields(t, "readiness.json", document)
+			if status != tt.wantStatus {
+				t.Errorf("GET /readyz = %v %v, want %v", status, document, tt.wantStatus)
+			}
+			if document["healthyMembers"] != float64(1) {
+				t.Errorf("healthyMembers = %v, want 1, as Bas has not responded for an hour", document["healthyMembers"])
+			}
+		})
+	}
+}
+
+func TestManagementAPI_ReadinessNotJoined(t *testing.T) {
+	handler := NewManagementHandler(&MembershipServiceContext{Context: context.Background(), Config: config.Default()})
+
+	status, document := getStatus(t, handler, "/readyz")
+	if status != http.StatusServiceUnavailable || document["healthyMembers"] != float64(0) {
+		t.Errorf("GET /readyz = %v %v, want %v, as we have not heard from any member", status, document, http.StatusServiceUnavailable)
+	}
+}
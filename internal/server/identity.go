@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
+)
+
+// We know members by their node ID, their name is for people. Two node IDs with the same name at the same address
+// are a node that restarted without keeping its node ID, so the previous one is gone for good.
+// Two node IDs with the same name at different addresses are two nodes that claim the same name, see name_conflict.go.
+
+// checkName looks for other members with the name of a member we did not know yet,
+// it returns false when we do not accept the member, as it lost its name to a member that was there first
+func (s *MembershipServiceContext) checkName(ctx context.Context, logger *logging.Logger, member *api.Member) bool {
+	if member.ID.IsZero() {
+		return true
+	}
+	accepted := true
+	for _, other := range s.membersNamed(member.Name()) {
+		if other.Identifier() == member.Identifier() {
+			continue
+		}
+		if memberAddress(other) == memberAddress(member) {
+			logger.Info("Member restarted as a new node, forgetting the previous one", "member", member.Identifier(),
+				"name", member.Name(), "previous", other.Identifier())
+			s.forgetPreviousIncarnation(other)
+			continue
+		}
+		logger.Every(repeatedLogInterval).Warn("Two nodes claim the same name", "name", member.Name(),
+			"member", member.Identifier(), "remote", memberAddress(member), "other", other.Identifier(), "otherRemote", memberAddress(other))
+		s.publishConflict(member, other)
+		// a member without a node ID cannot be told it lost its name
+		if !other.ID.IsZero() && !s.resolveNameConflict(ctx, logger, other, member) {
+			accepted = false
+		}
+	}
+	if s.Self == nil {
+		return accepted
+	}
+	if self := s.selfMember(); member.Name() == self.Name() {
+		logger.Every(repeatedLogInterval).Warn("Another node claims our name", "name", member.Name(),
+			"member", member.Identifier(), "remote", memberAddress(member))
+		s.publishConflict(member, self)
+		if !self.ID.IsZero() && !s.resolveNameConflict(ctx, logger, self, member) {
+			accepted = false
+		}
+	}
+	return accepted
+}
+
+// membersNamed returns the alive and failed members with the name
+func (s *MembershipServiceContext) membersNamed(name string) []*api.Member {
+	cluster := s.cluster()
+	named := make([]*api.Member, 0)
+	alive := make(map[string]bool)
+	cluster.membersLock <- struct{}{} //acquire token
+	for identifier, member := range cluster.members {
+		if member.Name() == name {
+			named = append(named, member)
+			alive[identifier] = true
+		}
+	}
+	<-cluster.membersLock //release token
+
+	cluster.memberFailListLock <- struct{}{}
+	for identifier, member := range cluster.memberFailList {
+		if member.Name() == name && !alive[identifier] {
+			named = append(named, member)
+		}
+	}
+	<-cluster.memberFailListLock
+	return named
+}
+
+// forgetPreviousIncarnation lets the node ID of a node that restarted leave, as it will never come back
+func (s *MembershipServiceContext) forgetPreviousIncarnation(previous *api.Member) {
+	leftMember, newlyLeft, known := s.markLeft(previous)
+	s.forgetCoordinate(previous.Identifier())
+	if newlyLeft && known {
+		s.publishEvent(api.MemberEventLeave, leftMember, api.MemberStateLeft)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"testing"
+)
+
+func TestMembershipServiceContext_CheckName(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	self.ID = api.NodeID{0x09}
+	previous := newTestMember("Alan", "Boreas", "127.0.0.1", "7780")
+	previous.ID = api.NodeID{0x01}
+	tests := []struct {
+		name         string
+		member       *api.Member
+		wantPrevious api.MemberState
+	}{
+		{"Restarted", newTestMember("Alan", "Boreas", "127.0.0.1", "7780"), api.MemberStateLeft},
+		{"SameNameElsewhere", newTestMember("Alan", "Boreas", "127.0.0.2", "7780"), api.MemberStateAlive},
+		{"OtherName", newTestMember("Bas", "Boreas", "127.0.0.1", "7780"), api.MemberStateAlive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default(), Self: self, Identity: self.Identifier()}
+			cluster := serviceContext.cluster()
+			cluster.members[previous.Identifier()] = previous
+			tt.member.ID = api.NodeID{0x02}
+			cluster.members[tt.member.Identifier()] = tt.member
+
+			serviceContext.checkName(context.Background(), logging.Nop(), tt.member)
+			states := make(map[string]api.MemberState)
+			for _, memberInfo := range serviceContext.MemberInfoSnapshot() {
+				states[memberInfo.ID] = memberInfo.State
+			}
+			if states[previous.Identifier()] != tt.wantPrevious || states[tt.member.Identifier()] != api.MemberStateAlive {
+				t.Errorf("members are %v after %s said hello, want the previous node ID %s", states, tt.member.Name(), tt.wantPrevious)
+			}
+		})
+	}
+}
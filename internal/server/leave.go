@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"time"
+)
+
+// goodbyeRetransmitInterval is how long we wait for acknowledgements, before we send our Goodbye again
+const goodbyeRetransmitInterval = time.Second
+
+// Leaving reports whether this node is leaving the cluster
+func (s *MembershipServiceContext) Leaving() bool {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.leaving
+}
+
+func (s *MembershipServiceContext) setLeaving() {
+	s = s.root()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.leaving = true
+}
+
+// Leave lets the cluster know we are leaving: it sends our Goodbye until a quorum of the members acknowledged it,
+// or the leave timeout passed, and then keeps answering heartbeats during the drain window.
+// The services keep running, stopping them is up to the caller.
+func Leave(serviceContext *MembershipServiceContext) {
+	membershipConfig := serviceContext.CurrentConfig().Membership
+	logger := serviceContext.Log().With("service", "Leave")
+	cluster := serviceContext.cluster()
+	serviceContext.setLeaving()
+	// acknowledgements from an earlier force-leave or leave are not for this Goodbye
+	for len(cluster.leaveAcks) > 0 {
+		<-cluster.leaveAcks
+	}
+
+	recipients := make(map[string]*api.Member)
+	cluster.membersLock <- struct{}{} //acquire token
+	for identifier, member := range cluster.members {
+		recipients[identifier] = member
+	}
+	<-cluster.membersLock //release token
+	quorum := len(recipients)/2 + 1
+	if len(recipients) == 0 {
+		quorum = 0
+	}
+
+	tracer, _ := serviceContext.Tracer()
+	ctx, span := startSpan(context.Background(), tracer, "Leave")
+	defer span.End()
+	logger.Info("Leaving the cluster", "members", len(recipients), "quorum", quorum)
+
+	acknowledged := make(map[string]bool)
+	timeout := serviceContext.clock().NewTimer(membershipConfig.LeaveTimeout)
+	retransmit := serviceContext.clock().NewTicker(goodbyeRetransmitInterval)
+	sendGoodbye := func() {
+		for identifier, member := range recipients {
+			if acknowledged[identifier] {
+				continue
+			}
+			err := serviceContext.sendMessageToMember(ctx, logger, member, serviceContext.ownMessage(api.GoodbyeMessage), "goodbye")
+			if err != nil {
+				logger.Warn("Could not send goodbye", "member", identifier, "error", err)
+			}
+		}
+	}
+	sendGoodbye()
+	for len(acknowledged) < quorum {
+		select {
+		case identifier := <-cluster.leaveAcks:
+			if recipients[identifier] != nil && !acknowledged[identifier] {
+				acknowledged[identifier] = true
+				logger.Debug("Member acknowledged our goodbye", "member", identifier)
+			}
+		case <-retransmit.C():
+			sendGoodbye()
+		case <-timeout.C():
+			logger.Warn("Not enough members acknowledged our goodbye, leaving anyway",
+				"acknowledged", len(acknowledged), "quorum", quorum, "timeout", membershipConfig.LeaveTimeout)
+			quorum = len(acknowledged)
+		}
+	}
+	// the clock has no business waking us up during the drain window
+	timeout.Stop()
+	retransmit.Stop()
+	logger.Info("Left the cluster, draining", "acknowledged", len(acknowledged), "drainWindow", membershipConfig.DrainWindow)
+	<-serviceContext.clock().After(membershipConfig.DrainWindow)
+}
+
+// markLeft moves the member to the left list, and returns the member as we knew it, so we can still reach it.
+// newlyLeft is false when we already knew it left, known is false when we never heard of it before.
+func (s *MembershipServiceContext) markLeft(member *api.Member) (leftMember *api.Member, newlyLeft bool, known bool) {
+	cluster := s.cluster()
+	identifier := member.Identifier()
+	cluster.memberLeftListLock <- struct{}{}
+	leftMember, alreadyLeft := cluster.memberLeftList[identifier]
+	<-cluster.memberLeftListLock
+	if alreadyLeft {
+		return leftMember, false, true
+	}
+
+	removed := s.RemoveMember(identifier)
+	known = removed != nil
+	// the services can still hold the member we knew, so we keep a copy of our own
+	var left api.Member
+	if known {
+		left = *removed
+	} else {
+		// a goodbye we hear from a member that gossips it comes from that member, not from the one that left
+		left = *member
+		left.IP = left.IPSelf
+	}
+	left.LastSeen = s.clock().Now()
+	leftMember = &left
+	cluster.memberLeftListLock <- struct{}{}
+	cluster.memberLeftList[identifier] = leftMember
+	<-cluster.memberLeftListLock
+	return leftMember, true, known
+}
+
+// hasLeft reports whether the member left the cluster
+func (s *MembershipServiceContext) hasLeft(identifier string) bool {
+	cluster := s.cluster()
+	cluster.memberLeftListLock <- struct{}{}
+	defer func() { <-cluster.memberLeftListLock }()
+	_, left := cluster.memberLeftList[identifier]
+	return left
+}
+
+// rejoined removes the member from the left list, as it said hello again
+func (s *MembershipServiceContext) rejoined(identifier string) {
+	cluster := s.cluster()
+	cluster.memberLeftListLock <- struct{}{}
+	delete(cluster.memberLeftList, identifier)
+	<-cluster.memberLeftListLock
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newListeningMember creates a member that can be messaged, on a port of the loopback address
+func newListeningMember(t *testing.T, name string) (*api.Member, *net.UDPConn) {
+	t.Helper()
+	connection, err := net.ListenUDP(api.MembershipNetwork, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { connection.Close() })
+	port := strconv.Itoa(connection.LocalAddr().(*net.UDPAddr).Port)
+	return newTestMember(name, "Boreas", "127.0.0.1", port), connection
+}
+
+func requireMessageType(t *testing.T, receiver *net.UDPConn, want api.MessageType) *api.Member {
+	t.Helper()
+	member, messageType, err := api.ReadMemberMessage(receiveDatagram(t, receiver), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messageType.Prefix != want.Prefix {
+		t.Fatalf("received a %s message, want a %s message", messageType.Name, want.Name)
+	}
+	return member
+}
+
+func TestHandleMember_Goodbye(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	ctx, cancel := context.WithCancel(context.Background())
+	serviceContext := &MembershipServiceContext{
+		Context:    ctx,
+		Config:     config.Default(),
+		Self:       self,
+		Identity:   self.Identifier(),
+		GoodbyeAck: api.GoodbyeAckMessage.CreateMemberMessage(self),
+	}
+	cluster := serviceContext.cluster()
+	alan, alanConnection := newListeningMember(t, "Alan")
+	bas, basConnection := newListeningMember(t, "Bas")
+	cluster.members[alan.Identifier()] = alan
+	cluster.members[bas.Identifier()] = bas
+	cluster.memberShortList[alan.Identifier()] = alan
+	cluster.memberShortList[bas.Identifier()] = bas
+	cluster.heartbeatResponses[alan.Identifier()] = &heartbeatResponseTracker{}
+	alanLastSeen := alan.LastSeen
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		HandleMember(serviceContext)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// the goodbye can reach us from another member, so the address of the message is not that of Alan
+	goodbye := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	cluster.memberGoodbye <- memberMessage{ctx: context.Background(), member: goodbye}
+	if acknowledgedBy := requireMessageType(t, alanConnection, api.GoodbyeAckMessage); acknowledgedBy.Identifier() != self.Identifier() {
+		t.Errorf("goodbye acknowledged by %v, want %v", acknowledgedBy.Identifier(), self.Identifier())
+	}
+	if forwarded := requireMessageType(t, basConnection, api.GoodbyeMessage); forwarded.Identifier() != alan.Identifier() {
+		t.Errorf("forwarded the goodbye of %v, want %v", forwarded.Identifier(), alan.Identifier())
+	}
+
+	// a retransmitted goodbye is acknowledged again
+	cluster.memberGoodbye <- memberMessage{ctx: context.Background(), member: goodbye}
+	requireMessageType(t, alanConnection, api.GoodbyeAckMessage)
+
+	// whoever missed the goodbye might think Alan failed, which we ignore
+	cluster.memberNotResponding <- memberMessage{ctx: context.Background(), member: alan}
+	// HandleMember handles one message at a time, once it takes the next, it is done with this one
+	cluster.memberHeartbeatResponse <- memberMessage{ctx: context.Background(), member: self}
+
+	states := make(map[string]api.MemberState)
+	for _, memberInfo := range serviceContext.MemberInfoSnapshot() {
+		states[memberInfo.ID] = memberInfo.State
+	}
+	if states[alan.Identifier()] != api.MemberStateLeft || states[bas.Identifier()] != api.MemberStateAlive {
+		t.Errorf("member states = %v, want Alan left and Bas alive", states)
+	}
+	if cluster.memberShortList[alan.Identifier()] != nil || cluster.heartbeatResponses[alan.Identifier()] != nil {
+		t.Errorf("still tracking Alan after it left")
+	}
+	if !alan.LastSeen.Equal(alanLastSeen) {
+		t.Errorf("the member we knew was modified when it left, last seen %v, want %v", alan.LastSeen, alanLastSeen)
+	}
+}
+
+func TestHandleMember_GossipedGoodbyeOfUnknownMember(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	ctx, cancel := context.WithCancel(context.Background())
+	serviceContext := &MembershipServiceContext{
+		Context:    ctx,
+		Config:     config.Default(),
+		Self:       self,
+		Identity:   self.Identifier(),
+		GoodbyeAck: api.GoodbyeAckMessage.CreateMemberMessage(self),
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		HandleMember(serviceContext)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	// Bas forwards the goodbye of Alan, whom we never heard of, so the message comes from the address of Bas
+	alan, alanConnection := newListeningMember(t, "Alan")
+	basIP, _ := api.NewIP4Address("10.0.0.2")
+	goodbye := *alan
+	goodbye.IP = &basIP
+	serviceContext.cluster().memberGoodbye <- memberMessage{ctx: context.Background(), member: &goodbye}
+
+	if acknowledgedBy := requireMessageType(t, alanConnection, api.GoodbyeAckMessage); acknowledgedBy.Identifier() != self.Identifier() {
+		t.Errorf("goodbye acknowledged by %v, want %v", acknowledgedBy.Identifier(), self.Identifier())
+	}
+	if !serviceContext.hasLeft(alan.Identifier()) {
+		t.Errorf("Alan has not left")
+	}
+}
+
+func TestLeave(t *testing.T) {
+	tests := []struct {
+		name             string
+		acknowledgements int
+		wantTimeout      bool
+	}{
+		{name: "Quorum", acknowledgements: 2},
+		{name: "NoQuorum", acknowledgements: 1, wantTimeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+			serverConfig := config.Default()
+			serverConfig.Membership.LeaveTimeout = 500 * time.Millisecond
+			serverConfig.Membership.DrainWindow = 0
+			serviceContext := &MembershipServiceContext{
+				Context:        context.Background(),
+				Config:         serverConfig,
+				Self:           self,
+				Identity:       self.Identifier(),
+				GoodbyeMessage: api.GoodbyeMessage.CreateMemberMessage(self),
+			}
+			cluster := serviceContext.cluster()
+			connections := make([]*net.UDPConn, 0)
+			for _, name := range []string{"Alan", "Bas", "Corne"} {
+				member, connection := newListeningMember(t, name)
+				cluster.members[member.Identifier()] = member
+				connections = append(connections, connection)
+			}
+
+			start := time.Now()
+			left := make(chan struct{})
+			go func() {
+				defer close(left)
+				Leave(serviceContext)
+			}()
+			for i, connection := range connections {
+				requireMessageType(t, connection, api.GoodbyeMessage)
+				if i < tt.acknowledgements {
+					cluster.leaveAcks <- newTestMember([]string{"Alan", "Bas", "Corne"}[i], "Boreas", "127.0.0.1", "0").Identifier()
+				}
+			}
+			<-left
+
+			if timedOut := time.Since(start) >= serverConfig.Membership.LeaveTimeout; timedOut != tt.wantTimeout {
+				t.Errorf("Leave took %v with %d of 3 acknowledgements, want timeout %v", time.Since(start), tt.acknowledgements, tt.wantTimeout)
+			}
+			if !serviceContext.Leaving() {
+				t.Errorf("Leaving() = false after Leave")
+			}
+			if readiness := serviceContext.Readiness(); readiness.Ready {
+				t.Errorf("Readiness() = %+v, want not ready while leaving", readiness)
+			}
+		})
+	}
+}
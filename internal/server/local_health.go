@@ -0,0 +1,35 @@
+package server
+
+// The local health of Lifeguard: when our own probes go unanswered, or a member thinks we failed, the problem is more
+// likely with us than with the others, for example as we are starved of CPU or our network is flaky.
+// So the less healthy we are, the slower we probe, and the more patient we are before we consider a member failed.
+
+// localHealth returns how unhealthy we consider ourselves, 0 is healthy
+func (s *MembershipServiceContext) localHealth() int {
+	cluster := s.cluster()
+	cluster.localHealthLock <- struct{}{} // acquire token
+	localHealth := cluster.localHealth
+	<-cluster.localHealthLock // release token
+	return localHealth
+}
+
+// localHealthMultiplier is how much slower we probe, and how much longer we wait for a member, than when we are healthy
+func (s *MembershipServiceContext) localHealthMultiplier() int {
+	return s.localHealth() + 1
+}
+
+// adjustLocalHealth adds delta to our local health, which stays between 0 and membership.maxLocalHealth
+func (s *MembershipServiceContext) adjustLocalHealth(delta int) {
+	maxLocalHealth := s.membershipConfig().MaxLocalHealth
+	cluster := s.cluster()
+	cluster.localHealthLock <- struct{}{} // acquire token
+	localHealth := cluster.localHealth + delta
+	if localHealth > maxLocalHealth {
+		localHealth = maxLocalHealth
+	}
+	if localHealth < 0 {
+		localHealth = 0
+	}
+	cluster.localHealth = localHealth
+	<-cluster.localHealthLock // release token
+}
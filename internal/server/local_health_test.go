@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"testing"
+	"time"
+)
+
+func TestMembershipServiceContext_AdjustLocalHealth(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxLocalHealth int
+		adjustments    []int
+		want           int
+	}{
+		{"Healthy", 8, nil, 0},
+		{"Degraded", 8, []int{1, 1, 1, -1}, 2},
+		{"NotBelowHealthy", 8, []int{1, -1, -1}, 0},
+		{"NotAboveMax", 2, []int{1, 1, 1, 1}, 2},
+		{"Off", 0, []int{1, 1}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig := config.Default()
+			serverConfig.Membership.MaxLocalHealth = tt.maxLocalHealth
+			serviceContext := &MembershipServiceContext{Context: context.Background(), Config: serverConfig}
+			for _, delta := range tt.adjustments {
+				serviceContext.adjustLocalHealth(delta)
+			}
+			if got := serviceContext.localHealth(); got != tt.want {
+				t.Errorf("localHealth() = %d, want %d", got, tt.want)
+			}
+			if got := serviceContext.localHealthMultiplier(); got != tt.want+1 {
+				t.Errorf("localHealthMultiplier() = %d, want %d", got, tt.want+1)
+			}
+		})
+	}
+}
+
+func TestMembershipServiceContext_LocalHealthFollowsProbes(t *testing.T) {
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default()}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	now := time.Now()
+	tracker := &heartbeatResponseTracker{LastResponse: now.Add(-6 * time.Second)}
+	tracker.request(now.Add(-5 * time.Second))
+	cluster.heartbeatResponses[alan.Identifier()] = tracker
+
+	sequence := serviceContext.HandleHeartbeatResponseTracking(context.Background(), logging.Nop(), alan)
+	if got := serviceContext.localHealth(); got != 1 {
+		t.Errorf("localHealth() = %d after a probe went unanswered, want 1", got)
+	}
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), alan, sequence, true, nil)
+	if got := serviceContext.localHealth(); got != 0 {
+		t.Errorf("localHealth() = %d after a probe was answered, want 0", got)
+	}
+	serviceContext.HandleHeartbeatResponseTracking(context.Background(), logging.Nop(), alan)
+	if got := serviceContext.localHealth(); got != 0 {
+		t.Errorf("localHealth() = %d after the previous probe was answered, want 0", got)
+	}
+}
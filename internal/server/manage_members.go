@@ -1,137 +1,273 @@
 package server
 
 import (
-	"fmt"
-	"time"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
 )
 
-func HandleMember(serviceContext *MembershipServiceContext) {
+func HandleMember(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
 	myIdentity := serviceContext.Identity
+	logger := serviceContext.Log().With("service", "HandleMember")
+	cluster := serviceContext.cluster()
 	for {
 		select {
 		case <-ctx.Done(): // Activated when ctx.Done() closes
-			fmt.Println("Closing HandleMember")
-			return
-		case member := <-memberHello:
+			logger.Debug("Closing")
+			return nil
+		case received := <-cluster.memberHello:
+			member := received.member
 			// ignore myself
-			if member.Identifier() == myIdentity {
-				return
+			if member.Identifier() == myIdentity || serviceContext.otherSegment(logger, member) {
+				continue
 			}
 
-			member.LastSeen = time.Now()
-			if members[member.Identifier()] == nil {
-				fmt.Printf("Received Hello from new Member: %+v\n", member)
+			member.LastSeen = serviceContext.clock().Now()
+			serviceContext.rejoined(member.Identifier())
+			cluster.membersLock <- struct{}{} //acquire token
+			lastSeenInfo := cluster.members[member.Identifier()]
+			cluster.members[member.Identifier()] = member
+			<-cluster.membersLock //release token
+			if lastSeenInfo == nil {
+				logger.Info("Received hello from new member", "member", member.Identifier(), "name", member.Name(), "remote", memberAddress(member), "clock", member.Clock)
+				if !serviceContext.checkName(received.ctx, logger, member) {
+					serviceContext.recordMessageRejected(rejectedNameConflict)
+					serviceContext.RemoveMember(member.Identifier())
+					continue
+				}
+				serviceContext.publishEvent(api.MemberEventJoin, member, api.MemberStateAlive)
+				// a new member might have joined via us, so it does not know about us yet, unless we are leaving
+				if !serviceContext.Leaving() {
+					err := serviceContext.sendMessageToMember(received.ctx, logger, member, serviceContext.helloMessage(), "hello")
+					if err != nil {
+						logger.Warn("Could not send hello", "member", member.Identifier(), "error", err)
+					}
+				}
 			} else {
-				lastSeenInfo := members[member.Identifier()]
 				durationSinceLastSeen := member.LastSeen.Sub(lastSeenInfo.LastSeen)
-				fmt.Printf("Received Hello from known Member: %s, first message since: %v\n", member.Identifier(), durationSinceLastSeen)
-			}
-			membersLock <- struct{}{} //acquire token
-			members[member.Identifier()] = member
-			<-membersLock //release token
-			fmt.Printf("Updated member %s's last seen", member.MemberName)
-		case member := <-memberGoodbye:
-			// ignore myself or any member we didn't know anyway
-			if member.Identifier() == myIdentity || members[member.Identifier()] == nil {
-				return
-			}
-			fmt.Printf("Received Goodbye from known Member: %s (%v), removing from Membership\n", member.Identifier(), member.IP.String())
-			membersLock <- struct{}{} //acquire token
-			delete(members, member.Identifier())
-			<-membersLock //release token
-			if memberShortList[member.Identifier()] != nil {
-				memberShortListLock <- struct{}{} //acquire token
-				delete(memberShortList, member.Identifier())
-				<-memberShortListLock //release token
-			}
-			fmt.Printf("Member %s removed\ngo", member.MemberName)
-		case member := <-memberHeartbeatRequest:
+				logger.Debug("Received hello from known member", "member", member.Identifier(), "sinceLastSeen", durationSinceLastSeen)
+				serviceContext.memberUpdated(logger, lastSeenInfo, member)
+				// a member that still claims the name of another member is told again, the NameConflict could have been lost
+				serviceContext.checkName(received.ctx, logger, member)
+			}
+		case received := <-cluster.memberGoodbye:
+			member := received.member
+			// ignore myself
+			if member.Identifier() == myIdentity {
+				continue
+			}
+			leftMember, newlyLeft, known := serviceContext.markLeft(member)
+			// acknowledge every copy, the member that is leaving retransmits until enough of us did
+			err := serviceContext.sendMessageToMember(received.ctx, logger, leftMember, serviceContext.ownMessage(api.GoodbyeAckMessage), "goodbyeAck")
+			if err != nil {
+				logger.Warn("Could not acknowledge goodbye", "member", member.Identifier(), "error", err)
+			}
+			if !newlyLeft {
+				continue
+			}
+			logger.Info("Received goodbye, member left", "member", member.Identifier(), "remote", memberAddress(leftMember), "known", known)
+			if known {
+				serviceContext.publishEvent(api.MemberEventLeave, leftMember, api.MemberStateLeft)
+			}
+			// the members we gossip to do not keep track of the members of the other segments either
+			if !known && !serviceContext.admits(leftMember) {
+				continue
+			}
+			// gossip the goodbye, so those the member could not reach hear about it as well
+			goodbye := api.GoodbyeMessage.CreateMemberMessage(leftMember)
+			for _, shortListMember := range serviceContext.shortListSnapshot() {
+				if shortListMember.Identifier() == member.Identifier() {
+					continue
+				}
+				err := serviceContext.sendMessageToMember(received.ctx, logger, shortListMember, goodbye, "goodbye")
+				if err != nil {
+					logger.Warn("Could not forward goodbye", "member", shortListMember.Identifier(), "left", member.Identifier(), "error", err)
+				}
+			}
+		case received := <-cluster.memberGoodbyeAck:
+			member := received.member
+			if !serviceContext.Leaving() {
+				continue
+			}
+			select {
+			case cluster.leaveAcks <- member.Identifier():
+			default:
+				logger.Debug("Dropped goodbye acknowledgement", "member", member.Identifier())
+			}
+		case received := <-cluster.memberHeartbeatRequest:
+			member := received.member
 			// ignore myself
 			if member.Identifier() == myIdentity {
 				continue
 			}
 			// when we get a request, answer it with a response
-			fmt.Printf("Received heartbeat request from member %v\n", member)
-			clockUpdate <- 1
+			logger.Debug("Received heartbeat request", "member", member.Identifier(), "remote", memberAddress(member), "clock", member.Clock)
+			cluster.clockUpdate <- 1
 
-			// if we have not filled our shortlist yet, we can probably fill it with those that are talking to us
-			// TODO: this might be counter productive, and perhaps we should reset this list overtime?
-			memberShortListLock <- struct{}{}
-			if len(memberShortList) < MaxShortListSize && memberShortList[member.Identifier()] == nil {
-				memberShortList[member.Identifier()] = member
+			// echo the sequence number, so the member knows which of its requests we answer
+			response := serviceContext.ownMessage(api.HeartbeatResponseMessage)
+			if received.hasSequence {
+				response = api.WithSequence(response, received.sequence)
 			}
-			<-memberShortListLock
-
-			err := sendMessageToMember(member, serviceContext.HeartbeatResponse, "heartbeatResponse")
+			response = api.WithCoordinate(response, serviceContext.coordinate())
+			if received.coordinate != nil {
+				serviceContext.recordCoordinate(member.Identifier(), received.coordinate)
+			}
+			err := serviceContext.sendMessageToMember(received.ctx, logger, member, response, "heartbeatResponse")
 			if err != nil {
-				fmt.Printf("Could not send heartbeat response to %v: %v", member, err)
+				logger.Warn("Could not send heartbeat response", "member", member.Identifier(), "error", err)
 			}
-		case member := <-memberHeartbeatResponse:
+		case received := <-cluster.memberHeartbeatResponse:
+			member := received.member
 			// ignore myself
 			if member.Identifier() == myIdentity {
 				continue
 			}
-			fmt.Printf("Received heartbeat response from member %v\n", member)
-			go HandleHeartbeatResponseTrackingUpdate(member)
-		case member := <-memberNotResponding:
+			logger.Debug("Received heartbeat response", "member", member.Identifier(), "clock", member.Clock, "sequence", received.sequence)
+			go serviceContext.HandleHeartbeatResponseTrackingUpdate(logger, member, received.sequence, received.hasSequence, received.coordinate)
+		case received := <-cluster.memberNotResponding:
+			member := received.member
+			// a member that thinks we failed, likely could not reach us in time, which is a sign we are not healthy
 			if member.Identifier() == myIdentity {
+				logger.Every(repeatedLogInterval).Warn("Heard a member considers us no longer alive")
+				serviceContext.adjustLocalHealth(1)
 				continue
-			fmt.Printf("We heard member %v is no longer alive, lets scrap him \n", member)
-			HandleMemberNotResponding(member, serviceContext.HeartbeatRequest)}
+			}
+			// a member that left is not failed, whoever thinks so missed its goodbye
+			if serviceContext.hasLeft(member.Identifier()) {
+				logger.Debug("Heard a member that left is no longer alive", "member", member.Identifier())
+				continue
+			}
+			// the report comes from another member, so the origin is that of the reporter, not of the member it is about
+			cluster.membersLock <- struct{}{} //acquire token
+			known := cluster.members[member.Identifier()]
+			<-cluster.membersLock //release token
+			if known != nil {
+				member = known
+			} else if !serviceContext.admits(member) {
+				serviceContext.recordMessageRejected(rejectedOtherSegment)
+				logger.Debug("Heard a member of another segment is no longer alive", "member", member.Identifier(), "segment", member.Segment)
+				continue
+			} else {
+				member.IP = member.IPSelf
+			}
+			logger.Info("Heard a member is no longer alive", "member", member.Identifier(), "remote", memberAddress(member))
+			serviceContext.HandleMemberNotResponding(received.ctx, logger, member, serviceContext.ownMessage(api.HeartbeatRequestMessage))
 			// TODO: when we've tried to reach a Member for X tries, and we do not get a response, let the others know
 			// TODO: we should probably verify this ourselves, before scrapping the poor sod
 			// TODO: limit how many times we send a failure propagation
-		case member := <-memberHelloMulticast:
+		case received := <-cluster.memberNameConflict:
+			serviceContext.lostName(logger, received)
+		case received := <-cluster.memberHelloMulticast:
+			member := received.member
 			// ignore myself
-			if member.Identifier() == myIdentity {
+			if member.Identifier() == myIdentity || serviceContext.otherSegment(logger, member) {
 				continue
 			}
-			member.LastSeen = time.Now()
-			membersLock <- struct{}{} //acquire token
-			members[member.Identifier()] = member
-			<-membersLock //release token
-			fmt.Printf("Received Multicast from Member: %s @%v(%v:%v / %v)\n", member.MemberName, member.Hostname, member.IP, member.PortSelf, member.IPSelf)
+			member.LastSeen = serviceContext.clock().Now()
+			serviceContext.rejoined(member.Identifier())
+			cluster.membersLock <- struct{}{} //acquire token
+			lastSeenInfo := cluster.members[member.Identifier()]
+			cluster.members[member.Identifier()] = member
+			<-cluster.membersLock //release token
+			if lastSeenInfo == nil {
+				if !serviceContext.checkName(received.ctx, logger, member) {
+					serviceContext.recordMessageRejected(rejectedNameConflict)
+					serviceContext.RemoveMember(member.Identifier())
+					continue
+				}
+				serviceContext.publishEvent(api.MemberEventJoin, member, api.MemberStateAlive)
+			} else {
+				serviceContext.memberUpdated(logger, lastSeenInfo, member)
+				serviceContext.checkName(received.ctx, logger, member)
+			}
+			logger.Debug("Received multicast", "member", member.Identifier(), "remote", memberAddress(member), "ipSelf", member.IPSelf)
 		}
 	}
 }
 
 
-func CleanupMembers(serviceContext *MembershipServiceContext) {
+func CleanupMembers(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
-	clock := time.NewTicker(10 * time.Second)
+	membershipConfig := serviceContext.CurrentConfig().Membership
+	logger := serviceContext.Log().With("service", "CleanupMembers")
+	cluster := serviceContext.cluster()
+	clock := serviceContext.clock().NewTicker(membershipConfig.CleanupInterval)
+	defer clock.Stop()
 	for {
 		select {
+		case <-serviceContext.ConfigChanged():
+			membershipConfig = serviceContext.CurrentConfig().Membership
+			clock.Reset(membershipConfig.CleanupInterval)
 		case <-ctx.Done(): // Activated when ctx.Done() closes
-			fmt.Println("Closing CleanupMembers")
-			return
-		case <-clock.C:
-			for _, member := range members {
-				durationSinceLastSeen := time.Now().Sub(member.LastSeen)
-				if durationSinceLastSeen > (time.Second * 40) {
-					fmt.Printf("Removing member %v because they did not check in recently\n", member)
-					membersLock <- struct{}{} //acquire token
-					delete(members, member.Identifier())
-					<-membersLock //release tokenc
+			logger.Debug("Closing")
+			return nil
+		case <-clock.C():
+			cluster.membersLock <- struct{}{} //acquire token
+			var reaped []*api.Member
+			for _, member := range cluster.members {
+				durationSinceLastSeen := serviceContext.clock().Now().Sub(member.LastSeen)
+				if durationSinceLastSeen > membershipConfig.CleanupTimeout {
+					delete(cluster.members, member.Identifier())
+					reaped = append(reaped, member)
+				}
+			}
+			<-cluster.membersLock //release token
+			for _, member := range reaped {
+				logger.Info("Removing member because it did not check in recently", "member", member.Identifier(), "lastSeen", member.LastSeen)
+				cluster.heartbeatResponsesLock <- struct{}{}
+				delete(cluster.heartbeatResponses, member.Identifier())
+				<-cluster.heartbeatResponsesLock
+				serviceContext.forgetCoordinate(member.Identifier())
+				serviceContext.publishEvent(api.MemberEventReap, member, api.MemberStateFailed)
+			}
+
+			cluster.memberLeftListLock <- struct{}{}
+			for identifier, member := range cluster.memberLeftList {
+				if serviceContext.clock().Now().Sub(member.LastSeen) > membershipConfig.CleanupTimeout {
+					logger.Debug("Forgetting member that left", "member", identifier)
+					delete(cluster.memberLeftList, identifier)
 				}
 			}
+			<-cluster.memberLeftListLock
 
-			// TODO: we should also cleanup failing members that have not responded to our heartbeat request
-			for _, member := range memberFailList {
-				tracker := heartbeatResponses[member.Identifier()]
-				if tracker.LastResponse.After(time.Unix(0,0)) {
-					// TODO: OMG, it is resurrected from the Dead, what to do?
-					fmt.Printf("We heard from our long lost brother: %v\n", member)
-				} else {
-					memberFailListLock <- struct{}{}
-					delete(memberFailList, member.Identifier())
-					<-memberShortListLock
+			// failed members are forgotten once they did not respond for as long as a member that does not check in
+			cluster.memberFailListLock <- struct{}{}
+			failed := make([]*api.Member, 0, len(cluster.memberFailList))
+			for _, member := range cluster.memberFailList {
+				failed = append(failed, member)
+			}
+			<-cluster.memberFailListLock
+			for _, member := range failed {
+				cluster.heartbeatResponsesLock <- struct{}{}
+				tracker := cluster.heartbeatResponses[member.Identifier()]
+				respondedRecently := tracker != nil && serviceContext.clock().Now().Sub(tracker.LastResponse) <= membershipConfig.CleanupTimeout
+				<-cluster.heartbeatResponsesLock
+				if !respondedRecently {
+					logger.Debug("Forgetting member that failed", "member", member.Identifier())
+					cluster.memberFailListLock <- struct{}{}
+					delete(cluster.memberFailList, member.Identifier())
+					<-cluster.memberFailListLock
 
-					heartbeatResponsesLock <- struct{}{}
-					delete(heartbeatResponses, member.Identifier())
-					<- heartbeatResponsesLock
+					cluster.heartbeatResponsesLock <- struct{}{}
+					delete(cluster.heartbeatResponses, member.Identifier())
+					<-cluster.heartbeatResponsesLock
+					serviceContext.forgetCoordinate(member.Identifier())
 				}
 			}
 		}
 	}
 }
+
+// memberUpdated lets the subscribers know when a Hello of a known member changed its name or tags
+func (s *MembershipServiceContext) memberUpdated(logger *logging.Logger, lastSeenInfo *api.Member, member *api.Member) {
+	switch {
+	case lastSeenInfo.Name() != member.Name():
+		logger.Info("Member changed its name", "member", member.Identifier(), "name", member.Name(), "previous", lastSeenInfo.Name())
+	case !sameTags(lastSeenInfo.Tags, member.Tags):
+		logger.Info("Member changed its tags", "member", member.Identifier(), "tags", api.FormatTags(member.Tags))
+	default:
+		return
+	}
+	s.publishEvent(api.MemberEventUpdate, member, api.MemberStateAlive)
+}
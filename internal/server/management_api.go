@@ -0,0 +1,398 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"io/fs"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const managementPathPrefix = "/" + api.ManagementAPIVersion
+const managementShutdownTimeout = 5 * time.Second
+
+// managementAPI serves the HTTP/JSON management API for a single node
+type managementAPI struct {
+	serviceContext *MembershipServiceContext
+}
+
+// StartManagementServer serves the HTTP/JSON management API, which lets operators query and steer this node
+func StartManagementServer(serviceContext *MembershipServiceContext) error {
+	ctx := serviceContext.Context
+	logger := serviceContext.Log().With("service", "StartManagementServer")
+	httpServer := &http.Server{
+		Addr:    serviceContext.ManagementAddress,
+		Handler: NewManagementHandler(serviceContext),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownContext, cancel := context.WithTimeout(context.Background(), managementShutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownContext)
+	}()
+
+	logger.Info("Serving the management API", "address", serviceContext.ManagementAddress)
+	err := httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("could not serve the management API on %s: %w", serviceContext.ManagementAddress, err)
+	}
+	logger.Debug("Closing")
+	return nil
+}
+
+// NewManagementHandler creates the http.Handler with all the routes of the management API
+func NewManagementHandler(serviceContext *MembershipServiceContext) http.Handler {
+	managementApi := &managementAPI{serviceContext: serviceContext}
+	routes := map[string]http.HandlerFunc{
+		"/members":     allowMethods(managementApi.inDatacenter(managementApi.listMembers), http.MethodGet),
+		"/members/":    allowMethods(managementApi.inDatacenter(managementApi.member), http.MethodGet, http.MethodPost),
+		"/wan/members": allowMethods(managementApi.listWANMembers, http.MethodGet),
+		"/self":        allowMethods(managementApi.getSelf, http.MethodGet),
+		"/self/tags":   allowMethods(managementApi.tags, http.MethodGet, http.MethodPut),
+		"/info":        allowMethods(managementApi.getInfo, http.MethodGet),
+		"/events":      allowMethods(managementApi.streamEvents, http.MethodGet),
+		"/join":        allowMethods(managementApi.join, http.MethodPost),
+		"/leave":       allowMethods(managementApi.leave, http.MethodPost),
+		"/schemas/":    allowMethods(managementApi.getSchema, http.MethodGet),
+	}
+
+	mux := http.NewServeMux()
+	for path, handler := range routes {
+		mux.HandleFunc(managementPathPrefix+path, handler)
+	}
+	mux.Handle("/metrics", newMetricsHandler(serviceContext))
+	mux.HandleFunc("/healthz", allowMethods(managementApi.getHealth, http.MethodGet))
+	mux.HandleFunc("/readyz", allowMethods(managementApi.getReadiness, http.MethodGet))
+	return mux
+}
+
+// listMembers handles GET /members, with ?tag=key=value to list only the members with all those tags,
+// and ?segment=name to list only the members in the segment
+func (m *managementAPI) listMembers(w http.ResponseWriter, r *http.Request) {
+	tags := make(map[string]string)
+	for _, tagQuery := range r.URL.Query()["tag"] {
+		queryTags, err := api.ParseTags(tagQuery)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid tag %q: %s", tagQuery, err))
+			return
+		}
+		for key, value := range queryTags {
+			tags[key] = value
+		}
+	}
+	// an empty segment is the default segment, so only a segment that is not given at all matches every segment
+	segments, filterSegment := r.URL.Query()["segment"]
+	memberInfos := make([]api.MemberInfo, 0)
+	for _, memberInfo := range m.serviceContext.MemberInfoSnapshot() {
+		if filterSegment && memberInfo.Segment != segments[0] {
+			continue
+		}
+		if memberInfo.MatchesTags(tags) {
+			memberInfos = append(memberInfos, memberInfo)
+		}
+	}
+	writeJSON(w, http.StatusOK, memberInfos)
+}
+
+// member handles GET /members/{id} and POST /members/{id}/force-leave, the id can also be the name of the member
+func (m *managementAPI) member(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, managementPathPrefix+"/members/")
+	forceLeave := strings.HasSuffix(id, "/force-leave")
+	id = strings.TrimSuffix(id, "/force-leave")
+	if forceLeave != (r.Method == http.MethodPost) {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+		return
+	}
+
+	memberInfos := findMember(m.serviceContext.MemberInfoSnapshot(), id)
+	switch {
+	case len(memberInfos) == 0:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no member with id %q", id))
+	case len(memberInfos) > 1:
+		ids := make([]string, 0, len(memberInfos))
+		for _, memberInfo := range memberInfos {
+			ids = append(ids, memberInfo.ID)
+		}
+		writeError(w, http.StatusConflict, fmt.Sprintf("more than one member is named %q, use one of their ids: %s", id, strings.Join(ids, ", ")))
+	case forceLeave:
+		m.forceLeave(w, memberInfos[0].ID)
+	default:
+		writeJSON(w, http.StatusOK, memberInfos[0])
+	}
+}
+
+// findMember returns the member with the id, or else those with it as their name, which can be more than one
+func findMember(memberInfos []api.MemberInfo, id string) []api.MemberInfo {
+	named := make([]api.MemberInfo, 0)
+	for _, memberInfo := range memberInfos {
+		if memberInfo.ID == id {
+			return []api.MemberInfo{memberInfo}
+		}
+		if memberInfo.Name == id {
+			named = append(named, memberInfo)
+		}
+	}
+	return named
+}
+
+// forceLeave removes the member, and sends a Goodbye on its behalf to all members, so they remove it as well
+func (m *managementAPI) forceLeave(w http.ResponseWriter, id string) {
+	member := m.serviceContext.RemoveMember(id)
+	if member == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no member with id %q", id))
+		return
+	}
+	m.serviceContext.markLeft(member)
+	m.serviceContext.publishEvent(api.MemberEventForceLeave, member, api.MemberStateLeft)
+	logger := m.serviceContext.Log().With("service", "StartManagementServer")
+	logger.Info("Forcing a member to leave", "member", member.Identifier())
+	m.serviceContext.NotifyMembersOfLeaving(logger, api.GoodbyeMessage.CreateMemberMessage(member))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *managementAPI) getSelf(w http.ResponseWriter, r *http.Request) {
+	self := *m.serviceContext.selfMember()
+	self.LastSeen = m.serviceContext.clock().Now()
+	self.Tags = m.serviceContext.Tags()
+	selfInfo := api.NewMemberInfo(&self, api.MemberStateAlive)
+	selfInfo.Coordinate = m.serviceContext.coordinate()
+	writeJSON(w, http.StatusOK, selfInfo)
+}
+
+// tags handles GET /self/tags, and PUT /self/tags which replaces our tags and lets the members know
+func (m *managementAPI) tags(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var tags map[string]string
+		err := json.NewDecoder(r.Body).Decode(&tags)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read tags: %s", err))
+			return
+		}
+		err = m.serviceContext.SetTags(tags)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	tags := m.serviceContext.Tags()
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	writeJSON(w, http.StatusOK, tags)
+}
+
+func (m *managementAPI) getInfo(w http.ResponseWriter, r *http.Request) {
+	cluster := m.serviceContext.cluster()
+	self := *m.serviceContext.selfMember()
+	self.LastSeen = m.serviceContext.clock().Now()
+	self.Tags = m.serviceContext.Tags()
+
+	info := api.NodeInfo{
+		Self:        api.NewMemberInfo(&self, api.MemberStateAlive),
+		ServerPort:  m.serviceContext.ServerPort,
+		Members:     map[api.MemberState]int{api.MemberStateAlive: 0, api.MemberStateFailed: 0, api.MemberStateLeft: 0},
+		ShortList:   make([]string, 0),
+		LocalHealth: m.serviceContext.localHealth(),
+		Datacenter:  m.serviceContext.datacenter(),
+	}
+	if pool := m.serviceContext.WAN; pool != nil {
+		info.WAN = pool.wanInfo()
+	}
+	info.Self.Coordinate = m.serviceContext.coordinate()
+	for _, memberInfo := range m.serviceContext.MemberInfoSnapshot() {
+		info.Members[memberInfo.State]++
+	}
+	cluster.memberShortListLock <- struct{}{}
+	for identifier := range cluster.memberShortList {
+		info.ShortList = append(info.ShortList, identifier)
+	}
+	<-cluster.memberShortListLock
+	sort.Strings(info.ShortList)
+	writeJSON(w, http.StatusOK, info)
+}
+
+// streamEvents writes every membership event as a line of JSON, until the client goes away or we shut down
+func (m *managementAPI) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "streaming is not supported")
+		return
+	}
+	subscription := m.serviceContext.SubscribeToEvents()
+	defer m.serviceContext.UnsubscribeFromEvents(subscription)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event := <-subscription:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-m.serviceContext.Done():
+			return
+		}
+	}
+}
+
+// join announces this node to the member listening on the requested address, which answers with its own Hello
+func (m *managementAPI) join(w http.ResponseWriter, r *http.Request) {
+	var joinRequest api.JoinRequest
+	err := json.NewDecoder(r.Body).Decode(&joinRequest)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read join request: %s", err))
+		return
+	}
+	address, err := net.ResolveUDPAddr(api.MembershipNetwork, joinRequest.Address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid address %q: %s", joinRequest.Address, err))
+		return
+	}
+	err = m.serviceContext.sendMessageToAddress(r.Context(), address, m.serviceContext.helloMessage(), "hello")
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("could not send hello to %s: %s", address, err))
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// leave shuts this node down, which first lets the other members know we are leaving
+func (m *managementAPI) leave(w http.ResponseWriter, r *http.Request) {
+	if m.serviceContext.Shutdown == nil {
+		writeError(w, http.StatusNotImplemented, "this node cannot be asked to leave")
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	go m.serviceContext.Shutdown()
+}
+
+func (m *managementAPI) getSchema(w http.ResponseWriter, r *http.Request) {
+	schemaName := strings.TrimPrefix(r.URL.Path, managementPathPrefix+"/schemas/")
+	if schemaName == "" {
+		schemaFiles, _ := fs.Glob(api.Schemas, "schemas/*.json")
+		schemaNames := make([]string, 0, len(schemaFiles))
+		for _, schemaFile := range schemaFiles {
+			schemaNames = append(schemaNames, strings.TrimPrefix(schemaFile, "schemas/"))
+		}
+		writeJSON(w, http.StatusOK, schemaNames)
+		return
+	}
+
+	schema, err := fs.ReadFile(api.Schemas, "schemas/"+schemaName)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no schema named %q", schemaName))
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(schema)
+}
+
+// MemberInfoSnapshot collects every member we know of, alive, failed or left, sorted by their identifier
+func (s *MembershipServiceContext) MemberInfoSnapshot() []api.MemberInfo {
+	cluster := s.cluster()
+	memberInfos := make([]api.MemberInfo, 0)
+	alive := make(map[string]bool)
+	cluster.membersLock <- struct{}{} //acquire token
+	for identifier, member := range cluster.members {
+		memberInfos = append(memberInfos, api.NewMemberInfo(member, api.MemberStateAlive))
+		alive[identifier] = true
+	}
+	<-cluster.membersLock //release token
+
+	cluster.memberFailListLock <- struct{}{}
+	for identifier, member := range cluster.memberFailList {
+		if !alive[identifier] {
+			memberInfos = append(memberInfos, api.NewMemberInfo(member, api.MemberStateFailed))
+		}
+	}
+	<-cluster.memberFailListLock
+
+	cluster.memberLeftListLock <- struct{}{}
+	for identifier, member := range cluster.memberLeftList {
+		if !alive[identifier] {
+			memberInfos = append(memberInfos, api.NewMemberInfo(member, api.MemberStateLeft))
+		}
+	}
+	<-cluster.memberLeftListLock
+
+	membershipConfig := s.membershipConfig()
+	probeInterval := s.memberProbeInterval(membershipConfig)
+	now := s.clock().Now()
+	localHealthMultiplier := s.localHealthMultiplier()
+	cluster.heartbeatResponsesLock <- struct{}{} // acquire token
+	for i := range memberInfos {
+		tracker := cluster.heartbeatResponses[memberInfos[i].ID]
+		if tracker != nil {
+			memberInfos[i].Heartbeat = &api.HeartbeatInfo{
+				MissedResponses:   tracker.MissedResponsesCounter,
+				LastResponse:      tracker.LastResponse,
+				LastResponseClock: tracker.LastResponseClock,
+				Phi:               tracker.Phi(now, probeInterval, localHealthMultiplier),
+				LateResponses:     tracker.LateResponses,
+			}
+			if samples := len(tracker.roundTrips.samples); samples > 0 {
+				min, avg, p99 := tracker.roundTrips.statistics()
+				memberInfos[i].Heartbeat.RoundTrip = &api.RoundTripInfo{
+					Samples: samples,
+					Min:     min.Seconds(),
+					Avg:     avg.Seconds(),
+					P99:     p99.Seconds(),
+					Timeout: tracker.roundTrips.probeTimeout(membershipConfig, localHealthMultiplier).Seconds(),
+				}
+			}
+		}
+	}
+	<-cluster.heartbeatResponsesLock
+
+	selfCoordinate := s.coordinate()
+	cluster.memberCoordinatesLock <- struct{}{}
+	for i := range memberInfos {
+		if memberCoordinate := cluster.memberCoordinates[memberInfos[i].ID]; memberCoordinate != nil {
+			memberInfos[i].Coordinate = memberCoordinate
+			if selfCoordinate.IsCompatibleWith(memberCoordinate) {
+				memberInfos[i].EstimatedRoundTrip = selfCoordinate.DistanceTo(memberCoordinate).Seconds()
+			}
+		}
+	}
+	<-cluster.memberCoordinatesLock
+
+	sort.Slice(memberInfos, func(i, j int) bool {
+		return memberInfos[i].ID < memberInfos[j].ID
+	})
+	return memberInfos
+}
+
+// allowMethods rejects any request that does not use one of the given HTTP methods
+func allowMethods(handler http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				handler(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// an error here means the client went away, there is nobody left to tell
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, api.ErrorResponse{Error: message})
+}
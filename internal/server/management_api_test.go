@@ -0,0 +1,511 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestMember(name string, host string, ip string, port string) *api.Member {
+	ip4Address, _ := api.NewIP4Address(ip)
+	return &api.Member{
+		MemberName: name,
+		Hostname:   host,
+		IP:         &ip4Address,
+		IPSelf:     &ip4Address,
+		PortSelf:   port,
+		LastSeen:   time.Now(),
+	}
+}
+
+func newTestManagementServer(t *testing.T, shutdown context.CancelFunc) (*httptest.Server, *MembershipServiceContext) {
+	t.Helper()
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	serviceContext := &MembershipServiceContext{
+		Context:      context.Background(),
+		Self:         self,
+		Identity:     self.Identifier(),
+		HelloMessage: api.HelloMessage.CreateMemberMessage(self),
+		ServerPort:   self.PortSelf,
+		Shutdown:     shutdown,
+	}
+	testServer := httptest.NewServer(NewManagementHandler(serviceContext))
+	t.Cleanup(testServer.Close)
+	return testServer, serviceContext
+}
+
+// requireSchemaFields verifies the document has all the properties its JSON schema requires
+func requireSchemaFields(t *testing.T, schemaName string, document map[string]interface{}) {
+	t.Helper()
+	rawSchema, err := fs.ReadFile(api.Schemas, "schemas/"+schemaName)
+	if err != nil {
+		t.Fatalf("could not read schema %s: %v", schemaName, err)
+	}
+	var schema struct {
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(rawSchema, &schema); err != nil {
+		t.Fatalf("could not parse schema %s: %v", schemaName, err)
+	}
+	for _, property := range schema.Required {
+		if _, ok := document[property]; !ok {
+			t.Errorf("document %v is missing property %q required by %s", document, property, schemaName)
+		}
+	}
+}
+
+func TestManagementAPI_ListMembers(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	cluster.members[alan.Identifier()] = alan
+	cluster.memberFailList[bas.Identifier()] = bas
+	cluster.heartbeatResponses[alan.Identifier()] = &heartbeatResponseTracker{MissedResponsesCounter: 2, LastResponse: NoResponseTime}
+	cluster.heartbeatResponses[alan.Identifier()].roundTrips.add(20 * time.Millisecond)
+
+	response, err := http.Get(testServer.URL + "/v1/members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /v1/members status = %v, want %v", response.StatusCode, http.StatusOK)
+	}
+
+	var documents []map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&documents); err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 2 {
+		t.Fatalf("GET /v1/members returned %d members, want 2", len(documents))
+	}
+	for _, document := range documents {
+		requireSchemaFields(t, "member.json", document)
+	}
+	heartbeat := documents[0]["heartbeat"].(map[string]interface{})
+	requireSchemaFields(t, "heartbeat.json", heartbeat)
+	if roundTrip, ok := heartbeat["roundTrip"].(map[string]interface{}); !ok || roundTrip["samples"] != 1.0 || roundTrip["p99"] != 0.02 {
+		t.Errorf("heartbeat of %v has round trip %v, want 1 sample of 0.02 seconds", alan.Identifier(), heartbeat["roundTrip"])
+	}
+
+	wantStates := map[string]string{alan.Identifier(): "alive", bas.Identifier(): "failed"}
+	for _, document := range documents {
+		if got := document["state"]; got != wantStates[document["id"].(string)] {
+			t.Errorf("member %v has state %v, want %v", document["id"], got, wantStates[document["id"].(string)])
+		}
+	}
+}
+
+func TestManagementAPI_GetMember(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	cluster.members[alan.Identifier()] = alan
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantSchema string
+	}{
+		{name: "KnownMember", id: alan.Identifier(), wantStatus: http.StatusOK, wantSchema: "member.json"},
+		{name: "UnknownMember", id: "Nobody@Nowhere", wantStatus: http.StatusNotFound, wantSchema: "error.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := http.Get(testServer.URL + "/v1/members/" + tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("GET /v1/members/%s status = %v, want %v", tt.id, response.StatusCode, tt.wantStatus)
+			}
+			var document map[string]interface{}
+			if err := json.NewDecoder(response.Body).Decode(&document); err != nil {
+				t.Fatal(err)
+			}
+			requireSchemaFields(t, tt.wantSchema, document)
+		})
+	}
+}
+
+func TestManagementAPI_GetSelf(t *testing.T) {
+	testServer, _ := newTestManagementServer(t, nil)
+
+	response, err := http.Get(testServer.URL + "/v1/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var self api.MemberInfo
+	if err := json.NewDecoder(response.Body).Decode(&self); err != nil {
+		t.Fatal(err)
+	}
+	if self.ID != "Self@localhost" || self.State != api.MemberStateAlive {
+		t.Errorf("GET /v1/self = %+v, want alive member Self@localhost", self)
+	}
+}
+
+func TestManagementAPI_Join(t *testing.T) {
+	testServer, _ := newTestManagementServer(t, nil)
+	seed, err := net.ListenUDP(api.MembershipNetwork, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seed.Close()
+
+	joinRequest, _ := json.Marshal(api.JoinRequest{Address: seed.LocalAddr().String()})
+	response, err := http.Post(testServer.URL+"/v1/join", "application/json", bytes.NewReader(joinRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /v1/join status = %v, want %v", response.StatusCode, http.StatusAccepted)
+	}
+
+	buffer := make([]byte, 1024)
+	seed.SetReadDeadline(time.Now().Add(time.Second))
+	numberOfBytes, address, err := seed.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("seed did not receive a hello: %v", err)
+	}
+	member, messageType, err := api.ReadMemberMessage(buffer[0:numberOfBytes], address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(messageType, api.HelloMessage) || member.Identifier() != "Self@localhost" {
+		t.Errorf("seed received %v from %v, want a hello from Self@localhost", messageType, member.Identifier())
+	}
+
+	response, err = http.Post(testServer.URL+"/v1/join", "application/json", bytes.NewBufferString(`{"address": "not-an-address"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("POST /v1/join with an invalid address status = %v, want %v", response.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestManagementAPI_Leave(t *testing.T) {
+	left := make(chan struct{})
+	testServer, _ := newTestManagementServer(t, func() { close(left) })
+
+	response, err := http.Post(testServer.URL+"/v1/leave", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST /v1/leave status = %v, want %v", response.StatusCode, http.StatusAccepted)
+	}
+	select {
+	case <-left:
+	case <-time.After(time.Second):
+		t.Error("POST /v1/leave did not shut the node down")
+	}
+}
+
+func TestManagementAPI_MethodNotAllowed(t *testing.T) {
+	testServer, _ := newTestManagementServer(t, nil)
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/v1/members"},
+		{method: http.MethodDelete, path: "/v1/self"},
+		{method: http.MethodGet, path: "/v1/join"},
+		{method: http.MethodGet, path: "/v1/leave"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.method, tt.path), func(t *testing.T) {
+			request, _ := http.NewRequest(tt.method, testServer.URL+tt.path, nil)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("status = %v, want %v", response.StatusCode, http.StatusMethodNotAllowed)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_Schemas(t *testing.T) {
+	testServer, _ := newTestManagementServer(t, nil)
+	response, err := http.Get(testServer.URL + "/v1/schemas/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var schemaNames []string
+	if err := json.NewDecoder(response.Body).Decode(&schemaNames); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, schemaName := range schemaNames {
+		response, err := http.Get(testServer.URL + "/v1/schemas/" + schemaName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema map[string]interface{}
+		err = json.NewDecoder(response.Body).Decode(&schema)
+		response.Body.Close()
+		if err != nil {
+			t.Errorf("schema %s is not valid JSON: %v", schemaName, err)
+		}
+	}
+	if len(schemaNames) == 0 {
+		t.Error("GET /v1/schemas/ did not list any schemas")
+	}
+}
+
+func TestManagementAPI_ForceLeave(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	cluster.memberFailList[alan.Identifier()] = alan
+	cluster.heartbeatResponses[alan.Identifier()] = &heartbeatResponseTracker{MissedResponsesCounter: 5, LastResponse: NoResponseTime}
+
+	response, err := http.Post(testServer.URL+"/v1/members/"+alan.Identifier()+"/force-leave", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST force-leave status = %v, want %v", response.StatusCode, http.StatusAccepted)
+	}
+	if cluster.memberFailList[alan.Identifier()] != nil || cluster.heartbeatResponses[alan.Identifier()] != nil {
+		t.Errorf("force-leave did not remove %v", alan.Identifier())
+	}
+
+	response, err = http.Post(testServer.URL+"/v1/members/"+alan.Identifier()+"/force-leave", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("POST force-leave of an unknown member status = %v, want %v", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestManagementAPI_Events(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, testServer.URL+"/v1/events", nil)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	serviceContext.publishEvent(api.MemberEventJoin, alan, api.MemberStateAlive)
+
+	var event map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&event); err != nil {
+		t.Fatal(err)
+	}
+	requireSchemaFields(t, "member-event.json", event)
+	if event["type"] != string(api.MemberEventJoin) {
+		t.Errorf("event type = %v, want %v", event["type"], api.MemberEventJoin)
+	}
+}
+
+func TestManagementAPI_ListMembersByTag(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	alan.Tags = map[string]string{"zone": "eu-west-1a", "role": "db"}
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	bas.Tags = map[string]string{"zone": "eu-west-1b"}
+	cluster.members[alan.Identifier()] = alan
+	cluster.members[bas.Identifier()] = bas
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"NoTag", "", http.StatusOK, []string{alan.Identifier(), bas.Identifier()}},
+		{"OneTag", "?tag=zone=eu-west-1a", http.StatusOK, []string{alan.Identifier()}},
+		{"AllTags", "?tag=zone=eu-west-1a&tag=role=db", http.StatusOK, []string{alan.Identifier()}},
+		{"CommaSeparated", "?tag=zone=eu-west-1b,role=db", http.StatusOK, []string{}},
+		{"InvalidTag", "?tag=zone", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := http.Get(testServer.URL + "/v1/members" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /v1/members%s status = %v, want %v", tt.query, response.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var memberInfos []api.MemberInfo
+			if err := json.NewDecoder(response.Body).Decode(&memberInfos); err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0, len(memberInfos))
+			for _, memberInfo := range memberInfos {
+				ids = append(ids, memberInfo.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GET /v1/members%s = %v, want %v", tt.query, ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_ListMembersBySegment(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	bas.Segment = "eu-west"
+	bas.Gateway = true
+	cluster.members[alan.Identifier()] = alan
+	cluster.members[bas.Identifier()] = bas
+
+	tests := []struct {
+		name    string
+		query   string
+		wantIDs []string
+	}{
+		{"AnySegment", "", []string{alan.Identifier(), bas.Identifier()}},
+		{"Segment", "?segment=eu-west", []string{bas.Identifier()}},
+		{"DefaultSegment", "?segment=", []string{alan.Identifier()}},
+		{"UnknownSegment", "?segment=us-east", []string{}},
+		{"SegmentAndTag", "?segment=eu-west&tag=zone=eu-west-1a", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := http.Get(testServer.URL + "/v1/members" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			var memberInfos []api.MemberInfo
+			if err := json.NewDecoder(response.Body).Decode(&memberInfos); err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0, len(memberInfos))
+			for _, memberInfo := range memberInfos {
+				ids = append(ids, memberInfo.ID)
+				if memberInfo.ID == bas.Identifier() && (memberInfo.Segment != "eu-west" || !memberInfo.Gateway) {
+					t.Errorf("GET /v1/members%s shows %s in segment %q (gateway %v), want eu-west as a gateway", tt.query, memberInfo.ID, memberInfo.Segment, memberInfo.Gateway)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("GET /v1/members%s = %v, want %v", tt.query, ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_Tags(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	serviceContext.Config = config.Default()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantTags   map[string]string
+	}{
+		{"SetTags", `{"zone": "eu-west-1a"}`, http.StatusOK, map[string]string{"zone": "eu-west-1a"}},
+		{"InvalidTags", `{"zone,role": "db"}`, http.StatusBadRequest, map[string]string{"zone": "eu-west-1a"}},
+		{"NotAnObject", `["zone"]`, http.StatusBadRequest, map[string]string{"zone": "eu-west-1a"}},
+		{"RemoveTags", `{}`, http.StatusOK, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodPut, testServer.URL+"/v1/self/tags", bytes.NewBufferString(tt.body))
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("PUT /v1/self/tags %s status = %v, want %v", tt.body, response.StatusCode, tt.wantStatus)
+			}
+
+			response, err = http.Get(testServer.URL + "/v1/self")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			var self api.MemberInfo
+			if err := json.NewDecoder(response.Body).Decode(&self); err != nil {
+				t.Fatal(err)
+			}
+			if len(self.Tags) != len(tt.wantTags) || !self.MatchesTags(tt.wantTags) {
+				t.Errorf("GET /v1/self has tags %v, want %v", self.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_GetMemberByName(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	alan.ID = api.NodeID{0x01}
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	bas.ID = api.NodeID{0x02}
+	otherBas := newTestMember("Bas", "Boreas", "10.0.0.3", "7781")
+	otherBas.ID = api.NodeID{0x03}
+	for _, member := range []*api.Member{alan, bas, otherBas} {
+		cluster.members[member.Identifier()] = member
+	}
+
+	tests := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantID     string
+	}{
+		{"NodeID", bas.Identifier(), http.StatusOK, bas.Identifier()},
+		{"UniqueName", "Alan@Boreas", http.StatusOK, alan.Identifier()},
+		{"SharedName", "Bas@Boreas", http.StatusConflict, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := http.Get(testServer.URL + "/v1/members/" + tt.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("GET /v1/members/%s status = %v, want %v", tt.id, response.StatusCode, tt.wantStatus)
+			}
+			var memberInfo api.MemberInfo
+			if err := json.NewDecoder(response.Body).Decode(&memberInfo); err != nil {
+				t.Fatal(err)
+			}
+			if memberInfo.ID != tt.wantID {
+				t.Errorf("GET /v1/members/%s = %v, want %v", tt.id, memberInfo.ID, tt.wantID)
+			}
+		})
+	}
+}
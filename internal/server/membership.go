@@ -4,45 +4,120 @@ import (
 	"context"
 	"fmt"
 	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/coordinate"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/joostvdg/boom/internal/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
 	"time"
 )
 
-var members map[string]*api.Member
-var membersLock = make(chan struct{}, 1)
-var memberShortList map[string]*api.Member
-var memberShortListLock = make(chan struct{}, 1)
-var memberFailList map[string]*api.Member
-var memberFailListLock = make(chan struct{}, 1)
-var heartbeatResponses map[string]*heartbeatResponseTracker
-var heartbeatResponsesLock = make(chan struct{}, 1)
-var clockUpdate = make(chan int64)
-var clockLock = make(chan struct{}, 1)
-var memberHeartbeatRequest = make(chan *api.Member)
-var memberHeartbeatResponse = make(chan *api.Member)
-var memberNotResponding = make(chan *api.Member)
-var memberHello = make(chan *api.Member)
-var memberGoodbye = make(chan *api.Member)
-var memberHelloMulticast = make(chan *api.Member)
+// clusterState is what a node knows about the members of the cluster, and the channels its services hand messages over
+type clusterState struct {
+	members                 map[string]*api.Member
+	membersLock             chan struct{}
+	// memberShortList are the members we probe this protocol period, and tell about failures and goodbyes
+	memberShortList         map[string]*api.Member
+	memberShortListLock     chan struct{}
+	// probeOrder is the shuffled list of members we go through to pick the short list, see nextProbeTargets,
+	// it is guarded by the memberShortListLock as well
+	probeOrder              []string
+	probeIndex              int
+	probeRandom             *rand.Rand
+	memberFailList          map[string]*api.Member
+	memberFailListLock      chan struct{}
+	memberLeftList          map[string]*api.Member
+	memberLeftListLock      chan struct{}
+	heartbeatResponses      map[string]*heartbeatResponseTracker
+	heartbeatResponsesLock  chan struct{}
+	// localHealth is how unhealthy we consider ourselves, see adjustLocalHealth
+	localHealth             int
+	localHealthLock         chan struct{}
+	// coordinates keeps our network coordinate, memberCoordinates are those the members sent us, see coordinates.go
+	coordinates             *coordinate.Client
+	memberCoordinates       map[string]*api.Coordinate
+	memberCoordinatesLock   chan struct{}
+	clockUpdate             chan int64
+	clockLock               chan struct{}
+	memberHeartbeatRequest  chan memberMessage
+	memberHeartbeatResponse chan memberMessage
+	memberNotResponding     chan memberMessage
+	memberHello             chan memberMessage
+	memberGoodbye           chan memberMessage
+	memberGoodbyeAck        chan memberMessage
+	// leaveAcks receives the identifiers of the members that acknowledged our Goodbye, while we are leaving
+	leaveAcks               chan string
+	memberHelloMulticast    chan memberMessage
+	memberNameConflict      chan memberMessage
+	// nameConflictVotes are the members that told us we lost our name, see NameConflictMajority
+	nameConflictVotes       map[string]bool
+	nameConflictVotesLock   chan struct{}
+	eventSubscribers        map[chan api.MemberEvent]struct{}
+	eventSubscribersLock    chan struct{}
+}
+
+func newClusterState(seed int64) *clusterState {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	coordinateConfig := coordinate.DefaultConfig()
+	coordinateConfig.Seed = seed
+	return &clusterState{
+		members:                 make(map[string]*api.Member),
+		membersLock:             make(chan struct{}, 1),
+		memberShortList:         make(map[string]*api.Member),
+		memberShortListLock:     make(chan struct{}, 1),
+		probeOrder:              make([]string, 0),
+		probeRandom:             rand.New(rand.NewSource(seed)),
+		memberFailList:          make(map[string]*api.Member),
+		memberFailListLock:      make(chan struct{}, 1),
+		memberLeftList:          make(map[string]*api.Member),
+		memberLeftListLock:      make(chan struct{}, 1),
+		heartbeatResponses:      make(map[string]*heartbeatResponseTracker),
+		heartbeatResponsesLock:  make(chan struct{}, 1),
+		localHealthLock:         make(chan struct{}, 1),
+		coordinates:             coordinate.NewClient(coordinateConfig),
+		memberCoordinates:       make(map[string]*api.Coordinate),
+		memberCoordinatesLock:   make(chan struct{}, 1),
+		clockUpdate:             make(chan int64),
+		clockLock:               make(chan struct{}, 1),
+		memberHeartbeatRequest:  make(chan memberMessage),
+		memberHeartbeatResponse: make(chan memberMessage),
+		memberNotResponding:     make(chan memberMessage),
+		memberHello:             make(chan memberMessage),
+		memberGoodbye:           make(chan memberMessage),
+		memberGoodbyeAck:        make(chan memberMessage),
+		leaveAcks:               make(chan string, 64),
+		memberHelloMulticast:    make(chan memberMessage),
+		memberNameConflict:      make(chan memberMessage),
+		nameConflictVotes:       make(map[string]bool),
+		nameConflictVotesLock:   make(chan struct{}, 1),
+		eventSubscribers:        make(map[chan api.MemberEvent]struct{}),
+		eventSubscribersLock:    make(chan struct{}, 1),
+	}
+}
 
 var NoResponseTime time.Time //time.Date(1970, 1, 1, 0, 0,0, 0, nil)
-const MaxShortListSize = 3
 
-type MembershipService func(*MembershipServiceContext)
+// MembershipService does its work until the context is done, an error means it failed and can be restarted
+type MembershipService func(*MembershipServiceContext) error
 
 func init() {
-	members = make(map[string]*api.Member)
-	memberShortList = make(map[string]*api.Member)
-	memberFailList = make(map[string]*api.Member)
-	heartbeatResponses = make (map[string]*heartbeatResponseTracker)
 	NoResponseTime = time.Unix(0, 0)
 }
 
 type MembershipServiceContext struct {
 	context.Context
+	// Config and the tracing fields can change while the services run, use CurrentConfig and Tracer to read them
+	Config            *config.Config
 	TracingEnabled    bool
 	TracerProvider    *tracesdk.TracerProvider
 	SelfAddress       net.Addr
@@ -50,71 +125,336 @@ type MembershipServiceContext struct {
 	Identity          string
 	HelloMessage      []byte
 	GoodbyeMessage    []byte
+	GoodbyeAck        []byte
 	HeartbeatRequest  []byte
 	HeartbeatResponse []byte
 	ServerPort        string
+	ManagementAddress string
+	Shutdown          context.CancelFunc
+	Logger            *logging.Logger
+	// Clock and Network are those of the machine, unless a simulation replaces them
+	Clock             clock.Clock
+	Network           transport.Network
+	// WAN is the context of the WAN pool, if we are one of the servers of our datacenter that joined it, see NewWANPool
+	WAN               *MembershipServiceContext
+	// Pool is the gossip ring the context is part of, PoolWAN for the WAN pool, empty for our cluster
+	Pool              string
+	// Seed seeds the random choices of the services, such as the order we probe members in, 0 picks one from the time
+	Seed              int64
+
+	// lock guards the Config and tracing fields, our tags and name, which can change while the services run, and the service statuses
+	lock          sync.RWMutex
+	configChanged chan struct{}
+	services      map[string]*api.ServiceStatus
+	leaving       bool
+	tags          map[string]string
+	// renamedTo is the MemberName we go by since we lost ours to another node, see selfMember
+	renamedTo     string
+	state         *clusterState
+	stateOnce     sync.Once
+	// parent is the context this one was derived from for a single service, it holds the state that can change
+	parent *MembershipServiceContext
+}
+
+// root returns the context that holds the state the services share
+func (s *MembershipServiceContext) root() *MembershipServiceContext {
+	if s.parent != nil {
+		return s.parent
+	}
+	return s
+}
+
+// withContext derives the context of a single service, so it can be stopped on its own
+func (s *MembershipServiceContext) withContext(ctx context.Context) *MembershipServiceContext {
+	root := s.root()
+	return &MembershipServiceContext{
+		Context:           ctx,
+		SelfAddress:       root.SelfAddress,
+		Self:              root.Self,
+		Identity:          root.Identity,
+		HelloMessage:      root.HelloMessage,
+		GoodbyeMessage:    root.GoodbyeMessage,
+		GoodbyeAck:        root.GoodbyeAck,
+		HeartbeatRequest:  root.HeartbeatRequest,
+		HeartbeatResponse: root.HeartbeatResponse,
+		ServerPort:        root.ServerPort,
+		ManagementAddress: root.ManagementAddress,
+		Shutdown:          root.Shutdown,
+		Logger:            root.Logger,
+		Clock:             root.Clock,
+		Network:           root.Network,
+		WAN:               root.WAN,
+		Pool:              root.Pool,
+		Seed:              root.Seed,
+		parent:            root,
+	}
+}
+
+// cluster returns what this node knows about the cluster, which its services share
+func (s *MembershipServiceContext) cluster() *clusterState {
+	s = s.root()
+	s.stateOnce.Do(func() {
+		s.state = newClusterState(s.Seed)
+	})
+	return s.state
+}
+
+// clock returns the clock the services tell the time with
+func (s *MembershipServiceContext) clock() clock.Clock {
+	if s.Clock == nil {
+		return clock.Real
+	}
+	return s.Clock
+}
+
+// network returns the network the services send and receive their messages over
+func (s *MembershipServiceContext) network() transport.Network {
+	if s.Network == nil {
+		return transport.UDP
+	}
+	return s.Network
+}
+
+// CurrentConfig returns the configuration the services should use right now
+func (s *MembershipServiceContext) CurrentConfig() *config.Config {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.Config
+}
+
+// membershipConfig returns the current membership settings, or the defaults when there is no configuration
+func (s *MembershipServiceContext) membershipConfig() config.MembershipConfig {
+	if currentConfig := s.CurrentConfig(); currentConfig != nil {
+		return currentConfig.Membership
+	}
+	return config.Default().Membership
+}
+
+// ConfigChanged returns a channel that is closed when the configuration changes
+func (s *MembershipServiceContext) ConfigChanged() <-chan struct{} {
+	s = s.root()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.configChanged == nil {
+		s.configChanged = make(chan struct{})
+	}
+	return s.configChanged
+}
+
+// UpdateConfig replaces the configuration, and lets the services waiting on ConfigChanged know
+func (s *MembershipServiceContext) UpdateConfig(updated *config.Config) {
+	s = s.root()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Config = updated
+	if s.configChanged != nil {
+		close(s.configChanged)
+		s.configChanged = nil
+	}
+}
+
+// Log returns the logger of this node, one that writes nothing if there is none
+func (s *MembershipServiceContext) Log() *logging.Logger {
+	if s.Logger == nil {
+		return logging.Nop()
+	}
+	return s.Logger
+}
+
+// shutdown stops the node, when a service cannot do without something it failed to set up
+func (s *MembershipServiceContext) shutdown() {
+	if s.Shutdown != nil {
+		s.Shutdown()
+	}
+}
+
+// Tracer returns the tracer to create spans with, and whether tracing is enabled at all
+func (s *MembershipServiceContext) Tracer() (trace.Tracer, bool) {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if !s.TracingEnabled || s.TracerProvider == nil {
+		return nil, false
+	}
+	return s.TracerProvider.Tracer(name), true
+}
+
+// UpdateTracerProvider replaces the TracerProvider, nil disables tracing, it returns the previous TracerProvider
+func (s *MembershipServiceContext) UpdateTracerProvider(tracerProvider *tracesdk.TracerProvider) *tracesdk.TracerProvider {
+	s = s.root()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	previous := s.TracerProvider
+	s.TracerProvider = tracerProvider
+	s.TracingEnabled = tracerProvider != nil
+	return previous
 }
 
-// TODO test this and refine
 // heartbeatResponseTracker is a way to track if the members we send a heartbeat too, are responding
 type heartbeatResponseTracker struct {
+	// MissedResponsesCounter is how many requests we sent since the last response, it does not decide anything
 	MissedResponsesCounter int
 	LastResponse           time.Time
 	LastResponseClock      int64
+	// LastRequest is when we last sent a heartbeat request, to measure the round trip
+	LastRequest time.Time
+	// TrackingSince is when we sent our first request, until the member responds we measure from there
+	TrackingSince time.Time
+	// FailureDetected is set once we have let the others know the member does not respond
+	FailureDetected bool
+	// LateResponses is how many responses arrived after their request timed out, or answered no request we sent
+	LateResponses int
+	// Sequence is the sequence number of the latest request, which the member echoes in its response
+	Sequence   uint32
+	pending    map[uint32]time.Time
+	roundTrips roundTrips
+	arrivals   arrivalWindow
 }
 
-// SetReadDeadlineOnCancel sets the deadline for connections to "now", once the context is finished
-// else the sockets stay open, and do not let us close gracefully, see link below:
-// https://github.com/golang/go/issues/20280#issuecomment-655588450
-func SetReadDeadlineOnCancel(ctx context.Context, connection *net.UDPConn) {
+// recordResponse remembers when the member responded, and how long after its previous response,
+// as if we probed at the heartbeat interval rather than slower by the local health multiplier
+func (t *heartbeatResponseTracker) recordResponse(now time.Time, windowSize int, localHealthMultiplier int) {
+	if t.LastResponse.After(NoResponseTime) {
+		t.arrivals.add(now.Sub(t.LastResponse)/time.Duration(localHealthMultiplier), windowSize)
+	}
+	t.LastResponse = now
+}
+
+// Phi is how suspicious it is that the member has not responded since its last response, or since we started tracking it.
+// The probe interval is how often we probe the member, see memberProbeInterval.
+// While we probe slower, by the local health multiplier, we are as much more patient.
+func (t *heartbeatResponseTracker) Phi(now time.Time, probeInterval time.Duration, localHealthMultiplier int) float64 {
+	since := t.LastResponse
+	if !since.After(NoResponseTime) {
+		since = t.TrackingSince
+	}
+	mean, stdDeviation := t.arrivals.distribution(probeInterval)
+	return phi(now.Sub(since)/time.Duration(localHealthMultiplier), mean, stdDeviation)
+}
+
+// CloseOnCancel closes the transport once the context is finished, which ends a Receive that is waiting
+func CloseOnCancel(ctx context.Context, connection transport.Transport) {
 	go func() {
 		<-ctx.Done()
-		connection.SetReadDeadline(time.Now())
+		connection.Close()
 	}()
 }
 
-func NotifyMembersOfLeaving(goodbyeMessage []byte) {
-	fmt.Printf("Notifying Members Of Leaving...\n")
+func (s *MembershipServiceContext) NotifyMembersOfLeaving(logger *logging.Logger, goodbyeMessage []byte) {
+	members := s.aliveMembersSnapshot()
+	logger.Info("Notifying members of leaving", "members", len(members))
 	var wg sync.WaitGroup
 	for _, member := range members {
 		wg.Add(1)
 		go func(memberToMessage *api.Member) {
 			defer wg.Done()
-			err := sendMessageToMember(memberToMessage, goodbyeMessage, "leave")
+			err := s.sendMessageToMember(context.Background(), logger, memberToMessage, goodbyeMessage, "leave")
 			if err != nil {
-				fmt.Printf("Could not send leave message to %v: %v\n", memberToMessage, err)
+				logger.Warn("Could not send leave message", "member", memberToMessage.Identifier(), "error", err)
 			}
 		}(member)
 	}
 	wg.Wait()
 }
 
-func sendMessageToMember(memberToMessage *api.Member, message []byte, messageType string) error {
-	serverAddress := memberToMessage.IP.String() + ":" + memberToMessage.PortSelf
-	fmt.Printf("Sending %v message to %v @%v\n", messageType, memberToMessage.MemberName, serverAddress)
+func (s *MembershipServiceContext) sendMessageToMember(ctx context.Context, logger *logging.Logger, memberToMessage *api.Member, message []byte, messageType string) error {
+	serverAddress := memberAddress(memberToMessage)
+	logger.Debug("Sending message", "type", messageType, "member", memberToMessage.Identifier(), "remote", serverAddress)
 	remotePort, err := strconv.Atoi(memberToMessage.PortSelf)
 	if err != nil {
-		fmt.Printf("Encountered an error when parsing remote port: %s\n", err)
+		logger.Warn("Could not parse the port of a member", "member", memberToMessage.Identifier(), "port", memberToMessage.PortSelf, "error", err)
 		return nil
 	}
 	udpServer := net.UDPAddr{IP: net.ParseIP(memberToMessage.IP.String()), Port: remotePort}
+	return s.sendMessageToAddress(ctx, &udpServer, message, messageType)
+}
 
-	connection, err := net.ListenUDP(api.MembershipNetwork, nil)
+// memberAddress is the ip:port we reach the member on
+func memberAddress(member *api.Member) string {
+	if member.IP == nil {
+		return ":" + member.PortSelf
+	}
+	return member.IP.String() + ":" + member.PortSelf
+}
+
+// sendMessageToAddress sends the message in a span of the trace of the context, which the message carries along
+func (s *MembershipServiceContext) sendMessageToAddress(ctx context.Context, udpServer *net.UDPAddr, message []byte, messageType string) error {
+	_, span := startSpan(ctx, nil, "Send", attribute.String("type", messageType), attribute.String("remote", udpServer.String()))
+	defer span.End()
+	message = withTraceContext(message, span.SpanContext())
+
+	connection, err := s.network().Listen(nil)
 	if err != nil {
-		fmt.Printf("Encountered an error when creating the local connection: %s\n", err)
-		return nil
+		err = fmt.Errorf("could not create the local connection: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
 	}
 
 	defer connection.Close()
-	_, err = connection.WriteToUDP(message, &udpServer)
+	err = connection.Send(message, udpServer)
 	if err != nil {
-		fmt.Printf("Encountered an error when sending the %v message: %s\n", messageType, err)
+		err = fmt.Errorf("could not send the %v message: %w", messageType, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
+	s.recordMessageSent(message)
 	return nil
 }
 
-func CloseChannels() {
-	close(memberHelloMulticast)
-	close(memberHello)
+// RemoveMember forgets everything we know about a member, it returns the member if we knew about it
+func (s *MembershipServiceContext) RemoveMember(identifier string) *api.Member {
+	cluster := s.cluster()
+	cluster.membersLock <- struct{}{} //acquire token
+	member := cluster.members[identifier]
+	delete(cluster.members, identifier)
+	<-cluster.membersLock //release token
+
+	cluster.memberShortListLock <- struct{}{}
+	delete(cluster.memberShortList, identifier)
+	<-cluster.memberShortListLock
+
+	cluster.memberFailListLock <- struct{}{}
+	if member == nil {
+		member = cluster.memberFailList[identifier]
+	}
+	delete(cluster.memberFailList, identifier)
+	<-cluster.memberFailListLock
+
+	cluster.heartbeatResponsesLock <- struct{}{}
+	delete(cluster.heartbeatResponses, identifier)
+	<-cluster.heartbeatResponsesLock
+	return member
+}
+
+// shortListSnapshot copies the short list, so we can message its members without holding the lock
+func (s *MembershipServiceContext) shortListSnapshot() []*api.Member {
+	cluster := s.cluster()
+	cluster.memberShortListLock <- struct{}{}
+	shortList := make([]*api.Member, 0, len(cluster.memberShortList))
+	for _, member := range cluster.memberShortList {
+		shortList = append(shortList, member)
+	}
+	<-cluster.memberShortListLock
+	return shortList
+}
+
+// aliveMembersSnapshot copies the members we consider alive, so we can message them without holding the lock
+func (s *MembershipServiceContext) aliveMembersSnapshot() []*api.Member {
+	cluster := s.cluster()
+	cluster.membersLock <- struct{}{} //acquire token
+	members := make([]*api.Member, 0, len(cluster.members))
+	for _, member := range cluster.members {
+		members = append(members, member)
+	}
+	<-cluster.membersLock //release token
+	return members
+}
+
+func (s *MembershipServiceContext) CloseChannels() {
+	cluster := s.cluster()
+	close(cluster.memberHelloMulticast)
+	close(cluster.memberHello)
 }
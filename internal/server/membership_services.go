@@ -1,12 +1,12 @@
 package server
 
 import (
+	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/joostvdg/boom/api"
-	"go.opentelemetry.io/otel/trace"
-	"log"
+	"github.com/joostvdg/boom/internal/logging"
 	"net"
-	"strconv"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -14,291 +14,432 @@ import (
 
 const name = "boom-server"
 
+// repeatedLogInterval is how often we log lines that would otherwise repeat at every heartbeat
+const repeatedLogInterval = time.Minute
+
 // StartMembershipServer starts the server that listens to all kinds of Membership messages
-func StartMembershipServer(serviceContext *MembershipServiceContext) {
+func StartMembershipServer(serviceContext *MembershipServiceContext) error {
 	port := serviceContext.ServerPort
 	ctx := serviceContext.Context
 	listenAddress := serviceContext.Self.IPSelf.String()
+	serviceLogger := serviceContext.Log().With("service", "StartMembershipServer")
+	cluster := serviceContext.cluster()
 	s, err := net.ResolveUDPAddr(api.MembershipNetwork, listenAddress+":"+port)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("could not resolve the listen address %s: %w", listenAddress+":"+port, err)
 	}
 
-	connection, err := net.ListenUDP(api.MembershipNetwork, s)
+	connection, err := serviceContext.network().Listen(s)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("could not listen for membership messages on %s: %w", s, err)
 	}
 	defer connection.Close()
-	defer fmt.Println("Closing StartMembershipServer")
-	SetReadDeadlineOnCancel(ctx, connection)
+	defer serviceLogger.Debug("Closing")
+	CloseOnCancel(ctx, connection)
 
 	buffer := make([]byte, 1024)
 
-	fmt.Printf("Listening on port %s for Hello & Goodbye messages...\n", port)
+	serviceLogger.Info("Listening for membership messages", "address", s)
 	for {
-		var span trace.Span
-		if serviceContext.TracingEnabled {
-			_, span = serviceContext.TracerProvider.Tracer(name).Start(ctx, "Membership")
-		}
-		numberOfBytes, address, err := connection.ReadFromUDP(buffer)
-		// TODO find a way to abstract away these steps
-		if serviceContext.TracingEnabled {
-			span.SetAttributes(attribute.Int("bytes", numberOfBytes))
-			span.SetAttributes(attribute.String("origin", address.String()))
-		}
+		numberOfBytes, address, err := connection.Receive(buffer)
 		if err != nil {
-			fmt.Printf("Encountered an error reading from UDP connection: %s\n", err)
-			return
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("could not read from the connection: %w", err)
 		}
-		fmt.Printf("Received message %s from %s\n", string(buffer[0:numberOfBytes]), address.String())
-		member, messageType, err := api.ReadMemberMessage(buffer[0:numberOfBytes], address)
+		rawMessage := buffer[0:numberOfBytes]
+		// continue the trace of the member that sent the message, or start one
+		tracer, _ := serviceContext.Tracer()
+		messageContext, span := startSpan(contextFromMessage(ctx, rawMessage), tracer, "Membership",
+			attribute.Int("bytes", numberOfBytes), attribute.String("origin", address.String()))
+		logger := serviceLogger.WithSpan(span.SpanContext())
+		logger.Debug("Received message", "remote", address, "bytes", numberOfBytes, "message", hexBytes(rawMessage))
+		member, messageType, err := api.ReadMemberMessage(rawMessage, address)
 		helloMessageType := "unknown"
 		if err != nil {
-			fmt.Printf("Encountered an error determining message type: %s\n", err)
+			serviceContext.recordMessageDecodeError()
+			logger.Warn("Could not read message", "remote", address, "error", err)
 		} else {
+			serviceContext.recordMessageReceived(messageType)
+			logger.Debug("Read message", "type", messageType.Name, "member", member.Identifier(), "remote", address)
+			received := memberMessage{ctx: messageContext, member: member}
+			received.sequence, received.hasSequence = api.ReadSequence(rawMessage)
+			received.coordinate, _ = api.ReadCoordinate(rawMessage)
+			received.nameConflict, received.hasNameConflict = api.ReadNameConflict(rawMessage)
 			switch messageType.Prefix {
 			case api.HelloPrefix:
 				helloMessageType = "hello"
-				memberHello <- member
+				cluster.memberHello <- received
 			case api.GoodbyePrefix:
 				helloMessageType = "goodbye"
-				memberGoodbye <- member
+				cluster.memberGoodbye <- received
+			case api.GoodbyeAckPrefix:
+				helloMessageType = "GoodbyeAck"
+				cluster.memberGoodbyeAck <- received
 			case api.HeartbeatRequestPrefix:
 				helloMessageType = "HeartbeatRequest"
-				memberHeartbeatRequest <- member
+				cluster.memberHeartbeatRequest <- received
 			case api.HeartbeatResponsePrefix:
 				helloMessageType = "HeartbeatResponse"
-				memberHeartbeatResponse <- member
+				cluster.memberHeartbeatResponse <- received
 			case api.MemberFailureDetectedPrefix:
 				helloMessageType = "MemberFailureDetected"
-				memberNotResponding <- member
+				cluster.memberNotResponding <- received
+			case api.NameConflictPrefix:
+				helloMessageType = "NameConflict"
+				cluster.memberNameConflict <- received
 			default:
-				fmt.Println("Ran into an error, unknown message type")
+				logger.Warn("Received a message of unknown type", "remote", address, "prefix", messageType.Prefix)
 			}
 		}
-		if serviceContext.TracingEnabled {
-			span.SetAttributes(attribute.String("type", helloMessageType))
-			span.End()
-		}
+		span.SetAttributes(attribute.String("type", helloMessageType))
+		span.End()
 	}
 }
 
 // ListenForMulticast listens for BOOM servers annoucning themselves via UDP multicast
-func ListenForMulticast(serviceContext *MembershipServiceContext) {
+func ListenForMulticast(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
-	addr, err := net.ResolveUDPAddr(api.MembershipNetwork, api.MembershipGroupAddress)
+	serviceLogger := serviceContext.Log().With("service", "ListenForMulticast")
+	cluster := serviceContext.cluster()
+	addr, err := net.ResolveUDPAddr(api.MembershipNetwork, serviceContext.CurrentConfig().Membership.MulticastGroup)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not resolve the multicast group: %w", err)
 	}
 
 	// Open up a connection
-	connection, err := net.ListenMulticastUDP(api.MembershipNetwork, nil, addr)
+	connection, err := serviceContext.network().ListenMulticast(addr)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not listen on the multicast group %s: %w", addr, err)
 	}
 	defer connection.Close()
-	defer fmt.Println("Closing ListenForMulticast")
-	SetReadDeadlineOnCancel(ctx, connection)
+	defer serviceLogger.Debug("Closing")
+	CloseOnCancel(ctx, connection)
 
 	buffer := make([]byte, 1024)
 	for {
-		var span trace.Span
-		if serviceContext.TracingEnabled {
-			_, span = serviceContext.TracerProvider.Tracer(name).Start(ctx, "Membership-Broadcast")
-		}
-		numberOfBytes, originAddress, err := connection.ReadFromUDP(buffer)
-		if serviceContext.TracingEnabled {
-			span.SetAttributes(attribute.Int("bytes", numberOfBytes))
-			span.SetAttributes(attribute.String("origin", originAddress.String()))
-		}
+		numberOfBytes, originAddress, err := connection.Receive(buffer)
 		if err != nil {
-			fmt.Printf("Received an error: %s\n", err)
-			if serviceContext.TracingEnabled {
-				span.End()
+			if ctx.Err() != nil {
+				return nil
 			}
-			return
+			return fmt.Errorf("could not read from the multicast group: %w", err)
 		}
+		rawMessage := buffer[0:numberOfBytes]
+		tracer, _ := serviceContext.Tracer()
+		messageContext, span := startSpan(contextFromMessage(ctx, rawMessage), tracer, "Membership-Broadcast",
+			attribute.Int("bytes", numberOfBytes), attribute.String("origin", originAddress.String()))
+		logger := serviceLogger.WithSpan(span.SpanContext())
 
 		// TODO: should we treat this type of message differently?
-		member, _, err := api.ReadMemberMessage(buffer[0:numberOfBytes], originAddress)
+		member, messageType, err := api.ReadMemberMessage(rawMessage, originAddress)
 		if err != nil {
-			fmt.Printf("Ran into an error: %s\n", err)
+			serviceContext.recordMessageDecodeError()
+			logger.Warn("Could not read multicast message", "remote", originAddress, "error", err)
 		} else {
-			memberHelloMulticast <- member
-		}
-		if serviceContext.TracingEnabled {
-			span.End()
+			serviceContext.recordMessageReceived(messageType)
+			cluster.memberHelloMulticast <- memberMessage{ctx: messageContext, member: member}
 		}
+		span.End()
 	}
 }
 
-func MulticastExistence(serviceContext *MembershipServiceContext) {
+// MulticastExistence announces us on the multicast group, and to the seeds, at start and at every multicast interval
+func MulticastExistence(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
-	message := serviceContext.HelloMessage
-	clock := time.NewTicker(30 * time.Second)
+	membershipConfig := serviceContext.CurrentConfig().Membership
+	logger := serviceContext.Log().With("service", "MulticastExistence")
+	serviceContext.announceToSeeds(logger, membershipConfig.Seeds, serviceContext.helloMessage())
+	clock := serviceContext.clock().NewTicker(membershipConfig.MulticastInterval)
+	defer clock.Stop()
 	for {
 		select {
-		case <-clock.C:
-			serverAddress := api.MembershipGroupAddress
-			udpServer, err := net.ResolveUDPAddr(api.MembershipNetwork, serverAddress)
-			connection, err := net.ListenUDP(api.MembershipNetwork, nil)
-			if err != nil {
-				fmt.Printf("Received an error: %s\n", err)
-				return
+		case <-serviceContext.ConfigChanged():
+			membershipConfig = serviceContext.CurrentConfig().Membership
+			clock.Reset(membershipConfig.MulticastInterval)
+		case <-clock.C():
+			// once we said goodbye, a hello would make the others think we are back
+			if serviceContext.Leaving() {
+				continue
 			}
-			_, err = connection.WriteToUDP(message, udpServer)
-			if err != nil {
-				fmt.Printf("Received an error: %s", err)
-				return
+			// our tags can change in between
+			message := serviceContext.helloMessage()
+			// without a multicast group, such as in the WAN pool, we only announce ourselves to the seeds
+			if serverAddress := membershipConfig.MulticastGroup; serverAddress != "" {
+				udpServer, err := net.ResolveUDPAddr(api.MembershipNetwork, serverAddress)
+				if err != nil {
+					return fmt.Errorf("could not resolve the multicast group %s: %w", serverAddress, err)
+				}
+				connection, err := serviceContext.network().Listen(nil)
+				if err != nil {
+					return fmt.Errorf("could not create the local connection: %w", err)
+				}
+				err = connection.Send(message, udpServer)
+				connection.Close() // not using defer as we're in a loop
+				if err != nil {
+					return fmt.Errorf("could not announce ourselves on %s: %w", serverAddress, err)
+				}
+				serviceContext.recordMessageSent(message)
 			}
-			connection.Close() // not using defer as we're in a loop
+			serviceContext.announceToSeeds(logger, membershipConfig.Seeds, message)
 		case <-ctx.Done(): // Activated when ctx.Done() closes
-			fmt.Println("Closing MulticastExistence")
-			return
+			logger.Debug("Closing")
+			return nil
+		}
+	}
+}
+
+// announceToSeeds sends our Hello to every seed, so we can join a cluster where multicast does not reach
+func (s *MembershipServiceContext) announceToSeeds(logger *logging.Logger, seeds []string, helloMessage []byte) {
+	for _, seed := range seeds {
+		seedAddress, err := net.ResolveUDPAddr(api.MembershipNetwork, seed)
+		if err != nil {
+			logger.Every(repeatedLogInterval, "seed").Warn("Could not resolve seed", "seed", seed, "error", err)
+			continue
+		}
+		err = s.sendMessageToAddress(context.Background(), seedAddress, helloMessage, "hello")
+		if err != nil {
+			logger.Every(repeatedLogInterval, "seed").Warn("Could not send hello to seed", "seed", seed, "error", err)
 		}
 	}
 }
 
-func HeartbeatCloseMembers(serviceContext *MembershipServiceContext) {
+func HeartbeatCloseMembers(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
-	message := serviceContext.HeartbeatRequest
-	clock := time.NewTicker(5 * time.Second)
+	membershipConfig := serviceContext.CurrentConfig().Membership
+	logger := serviceContext.Log().With("service", "HeartbeatCloseMembers")
+	cluster := serviceContext.cluster()
+	probeInterval := membershipConfig.HeartbeatInterval
+	clock := serviceContext.clock().NewTicker(probeInterval)
+	defer clock.Stop()
 	for {
 		select {
-		case <-clock.C:
-			clockUpdate <- 1
-			// TODO verify if this is a good idea, at least at some point we will have populated this map
-			// TODO: maybe we should be able to provide a "starter list" as a possible override in the init
-
-			// As long as we do not have our max in the short list, we should add more
-			memberShortListLock <- struct{}{}
-			if len(memberShortList) < MaxShortListSize && len(members) > 0 {
-				sizeCounter := 0
-				for _, member := range members {
-					if sizeCounter >= MaxShortListSize {
-						return
-					}
-					memberShortList[member.Identifier()] = member
-					sizeCounter++
-				}
+		case <-serviceContext.ConfigChanged():
+			membershipConfig = serviceContext.CurrentConfig().Membership
+			probeInterval = membershipConfig.HeartbeatInterval * time.Duration(serviceContext.localHealthMultiplier())
+			clock.Reset(probeInterval)
+		case <-clock.C():
+			// the less healthy we are ourselves, the slower we probe, so we do not accuse members of our own problems
+			if interval := membershipConfig.HeartbeatInterval * time.Duration(serviceContext.localHealthMultiplier()); interval != probeInterval {
+				logger.Info("Probing at a different interval, as our local health changed", "interval", interval, "localHealth", serviceContext.localHealth())
+				probeInterval = interval
+				clock.Reset(probeInterval)
+			}
+			// the members are no longer tracking us once we said goodbye, so we stop tracking them
+			if serviceContext.Leaving() {
+				continue
 			}
-			<-memberShortListLock
-			for _, member := range memberShortList {
-				go sendHeartbeatRequest(member, message)
+			cluster.clockUpdate <- 1
+			tracer, _ := serviceContext.Tracer()
+			message := serviceContext.ownMessage(api.HeartbeatRequestMessage)
+			for _, member := range serviceContext.nextProbeTargets(membershipConfig.MaxShortListSize) {
+				// every heartbeat starts a trace, which the response and any failure propagation are part of
+				go func(memberToMessage *api.Member) {
+					heartbeatContext, span := startSpan(ctx, tracer, "Heartbeat", attribute.String("member", memberToMessage.Identifier()))
+					defer span.End()
+					serviceContext.sendHeartbeatRequest(heartbeatContext, logger, memberToMessage, message)
+				}(member)
 			}
 		case <-ctx.Done(): // Activated when ctx.Done() closes
-			fmt.Println("Closing HeartbeatCloseMembers")
-			return
+			logger.Debug("Closing")
+			return nil
 		}
 	}
 }
 
-func sendHeartbeatRequest(memberToMessage *api.Member, message []byte) {
-	serverAddress := memberToMessage.IP.String() + ":" + memberToMessage.PortSelf
-	fmt.Printf("Sending heartbeat request message to %v @%v\n",
-		memberToMessage.MemberName, serverAddress)
-	remotePort, err := strconv.Atoi(memberToMessage.PortSelf)
+func (s *MembershipServiceContext) sendHeartbeatRequest(ctx context.Context, logger *logging.Logger, memberToMessage *api.Member, message []byte) {
+	// we track the request before we send it, or the response could arrive before we know the sequence number it echoes
+	sequence := s.HandleHeartbeatResponseTracking(ctx, logger, memberToMessage)
+	message = api.WithCoordinate(api.WithSequence(message, sequence), s.coordinate())
+	err := s.sendMessageToMember(ctx, logger, memberToMessage, message, "heartbeatRequest")
 	if err != nil {
-		fmt.Printf("Encountered an error when parsing remote port: %s\n", err)
-		return
-	}
-	udpServer := net.UDPAddr{IP: net.ParseIP(memberToMessage.IP.String()), Port: remotePort}
-
-	connection, err := net.ListenUDP(api.MembershipNetwork, nil)
-	if err != nil {
-		fmt.Printf("Encountered an error when creating the local connection: %s\n", err)
-		return
+		logger.Warn("Could not send heartbeat request", "member", memberToMessage.Identifier(), "remote", memberAddress(memberToMessage), "error", err)
 	}
-
-	defer connection.Close()
-	_, err = connection.WriteToUDP(message, &udpServer)
-	if err != nil {
-		fmt.Printf("Encountered an error when sending the heartbeat request message: %s\n", err)
-		return
-	}
-	HandleHeartbeatResponseTracking(memberToMessage)
 }
 
-func HandleClockUpdates(serviceContext *MembershipServiceContext) {
+func HandleClockUpdates(serviceContext *MembershipServiceContext) error {
 	ctx := serviceContext.Context
+	logger := serviceContext.Log().With("service", "HandleClockUpdates")
+	cluster := serviceContext.cluster()
 	for {
 		select {
-		case update := <-clockUpdate:
-			clockLock <- struct{}{} // acquire token
+		case update := <-cluster.clockUpdate:
+			cluster.clockLock <- struct{}{} // acquire token
 			serviceContext.Self.Clock += update
-			<-clockLock // release token
+			<-cluster.clockLock // release token
 		case <-ctx.Done(): // Activated when ctx.Done() closes
-			fmt.Println("Closing HandleClockUpdates")
-			return
+			logger.Debug("Closing")
+			return nil
 		}
 	}
 }
 
-func HandleHeartbeatResponseTrackingUpdate(memberResponded *api.Member) {
-	if heartbeatResponses[memberResponded.Identifier()] == nil {
-		fmt.Printf("Received a response from a member we are no longer tracking: %v\n", memberResponded)
+// HandleHeartbeatResponseTrackingUpdate matches a heartbeat response to the request with the sequence number it echoes.
+// Only a response in time counts, a late one could be to a request from long ago, and counts as seeing the member.
+// The round trip time of a response in time moves our network coordinate, if the member sent its coordinate.
+func (s *MembershipServiceContext) HandleHeartbeatResponseTrackingUpdate(logger *logging.Logger, memberResponded *api.Member, sequence uint32, hasSequence bool, memberCoordinate *api.Coordinate) {
+	cluster := s.cluster()
+	membershipConfig := s.membershipConfig()
+	localHealthMultiplier := s.localHealthMultiplier()
+	now := s.clock().Now()
+	cluster.heartbeatResponsesLock <- struct{}{} // acquire token
+	memberTracker := cluster.heartbeatResponses[memberResponded.Identifier()]
+	if memberTracker == nil {
+		<-cluster.heartbeatResponsesLock
+		logger.Debug("Received a response from a member we are no longer tracking", "member", memberResponded.Identifier())
+		return
+	}
+	// a member that does not echo sequence numbers, answers our latest request
+	if !hasSequence {
+		sequence = memberTracker.Sequence
+	}
+	roundTrip, answered := memberTracker.answer(now, sequence, memberTracker.roundTrips.probeTimeout(membershipConfig, localHealthMultiplier))
+	if !answered {
+		memberTracker.LateResponses++
+		<-cluster.heartbeatResponsesLock
+		s.recordLateHeartbeatResponse()
+		logger.Debug("Received a late heartbeat response", "member", memberResponded.Identifier(), "sequence", sequence)
 		return
 	}
-	heartbeatResponsesLock <- struct{}{} // acquire token
-	memberTracker := heartbeatResponses[memberResponded.Identifier()]
 	memberTracker.LastResponseClock = memberResponded.Clock
-	memberTracker.LastResponse = time.Now()
+	memberTracker.recordResponse(now, membershipConfig.PhiWindowSize, localHealthMultiplier)
 	memberTracker.MissedResponsesCounter = 0
-	<-heartbeatResponsesLock
+	s.recordHeartbeatRoundTrip(roundTrip)
+	failureDetected := memberTracker.FailureDetected
+	memberTracker.FailureDetected = false
+	<-cluster.heartbeatResponsesLock
+	if memberCoordinate != nil {
+		s.updateCoordinate(logger, memberResponded.Identifier(), memberCoordinate, roundTrip)
+	}
+	// a probe that is answered is a sign we are healthy
+	s.adjustLocalHealth(-1)
+
+	cluster.memberFailListLock <- struct{}{}
+	failedMember, failed := cluster.memberFailList[memberResponded.Identifier()]
+	delete(cluster.memberFailList, memberResponded.Identifier())
+	<-cluster.memberFailListLock
+	if failureDetected || failed {
+		s.recordFalsePositiveRecovery()
+		logger.Info("Member we considered failed responded again", "member", memberResponded.Identifier())
+		memberResponded.LastSeen = s.clock().Now()
+		cluster.membersLock <- struct{}{} //acquire token
+		// a heartbeat response does not carry tags, the member keeps those we knew
+		if known := cluster.members[memberResponded.Identifier()]; known != nil {
+			memberResponded.Tags = known.Tags
+		} else if failed {
+			memberResponded.Tags = failedMember.Tags
+		}
+		cluster.members[memberResponded.Identifier()] = memberResponded
+		<-cluster.membersLock //release token
+		s.publishEvent(api.MemberEventJoin, memberResponded, api.MemberStateAlive)
+		return
+	}
+	// so CleanupMembers only reaps members that stopped responding, not those we hear from through heartbeats alone
+	cluster.membersLock <- struct{}{} //acquire token
+	if known := cluster.members[memberResponded.Identifier()]; known != nil {
+		seen := *known
+		seen.LastSeen = now
+		cluster.members[memberResponded.Identifier()] = &seen
+	}
+	<-cluster.membersLock //release token
 }
 
-func HandleHeartbeatResponseTracking(memberToTrack *api.Member) {
-	var memberTracker *heartbeatResponseTracker
-	if heartbeatResponses[memberToTrack.Identifier()] == nil {
-		fmt.Printf("Requesting a response from a new Member: %+v\n", memberToTrack)
+// HandleHeartbeatResponseTracking tracks the heartbeat request we are about to send, and returns its sequence number.
+// Requests that were not answered within the probe timeout are given up on, and once the member
+// did not respond for too long, we consider it failed and let the others know.
+func (s *MembershipServiceContext) HandleHeartbeatResponseTracking(ctx context.Context, logger *logging.Logger, memberToTrack *api.Member) uint32 {
+	cluster := s.cluster()
+	membershipConfig := s.membershipConfig()
+	probeInterval := s.memberProbeInterval(membershipConfig)
+	localHealthMultiplier := s.localHealthMultiplier()
+	now := s.clock().Now()
+	cluster.heartbeatResponsesLock <- struct{}{} // acquire token
+	memberTracker := cluster.heartbeatResponses[memberToTrack.Identifier()]
+	if memberTracker == nil {
+		logger.Debug("Requesting a response from a new member", "member", memberToTrack.Identifier())
 		memberTracker = &heartbeatResponseTracker{
 			MissedResponsesCounter: 1,
 			LastResponse:           NoResponseTime,
 			LastResponseClock:      0,
+			TrackingSince:          now,
 		}
-		heartbeatResponsesLock <- struct{}{} // acquire token
-		heartbeatResponses[memberToTrack.Identifier()] = memberTracker
-		<-heartbeatResponsesLock
-	} else {
-		memberTracker = heartbeatResponses[memberToTrack.Identifier()]
-		if memberTracker.MissedResponsesCounter >= 5 {
-			fmt.Printf("We are not able to reach %v for 5 times, initiating failure propagation\n", memberToTrack)
-			// TODO: review this
-			for _, member := range memberShortList {
-				message := api.ConstructMemberFailureDetectedMessage(memberToTrack)
-				if member.Identifier() != memberToTrack.Identifier() {
-					err := sendMessageToMember(member, message, "failureDetected")
-					if err != nil {
-						fmt.Printf("Could not send MemberFailureDetected to %v: %v\n", member, err)
-					}
+		cluster.heartbeatResponses[memberToTrack.Identifier()] = memberTracker
+		sequence := memberTracker.request(now)
+		<-cluster.heartbeatResponsesLock
+		return sequence
+	}
+	// a probe that timed out went unanswered, though a member we already consider failed says nothing about us
+	timedOut := memberTracker.expire(now, memberTracker.roundTrips.probeTimeout(membershipConfig, localHealthMultiplier))
+	missedProbe := timedOut > 0 && !memberTracker.FailureDetected
+	sequence := memberTracker.request(now)
+	phi := memberTracker.Phi(now, probeInterval, localHealthMultiplier)
+	if phi >= membershipConfig.PhiThreshold {
+		newlyFailed := !memberTracker.FailureDetected
+		memberTracker.FailureDetected = true
+		<-cluster.heartbeatResponsesLock
+		if missedProbe {
+			s.adjustLocalHealth(1)
+		}
+		// we let the others know once, or those that hear it and verify it themselves would keep telling each other
+		if !newlyFailed {
+			return sequence
+		}
+		logger.Every(repeatedLogInterval, "member").Warn("Member did not respond, initiating failure propagation",
+			"member", memberToTrack.Identifier(), "phi", phi)
+		// a member that left while we probed it is not failed
+		if s.hasLeft(memberToTrack.Identifier()) {
+			return sequence
+		}
+		// HandleMemberNotResponding counts the detection, and gives the member one more request to answer
+		s.HandleMemberNotResponding(ctx, logger, memberToTrack, s.ownMessage(api.HeartbeatRequestMessage))
+		for _, member := range s.shortListSnapshot() {
+			message := api.ConstructMemberFailureDetectedMessage(memberToTrack)
+			if member.Identifier() != memberToTrack.Identifier() {
+				err := s.sendMessageToMember(ctx, logger, member, message, "failureDetected")
+				if err != nil {
+					logger.Warn("Could not send member failure detected", "member", member.Identifier(), "failed", memberToTrack.Identifier(), "error", err)
 				}
 			}
-		} else {
-			heartbeatResponsesLock <- struct{}{} // acquire token
-			memberTracker.MissedResponsesCounter++
-			<-heartbeatResponsesLock
+		}
+	} else {
+		memberTracker.MissedResponsesCounter++
+		<-cluster.heartbeatResponsesLock
+		if missedProbe {
+			s.adjustLocalHealth(1)
 		}
 	}
+	return sequence
 }
 
 
-func HandleMemberNotResponding(member *api.Member, message []byte) {
+func (s *MembershipServiceContext) HandleMemberNotResponding(ctx context.Context, logger *logging.Logger, member *api.Member, message []byte) {
+	cluster := s.cluster()
 	// TODO: remove from MembersList and MemberShortList
 	// TODO: add to - or update - member in MemberFailList
 	// TODO: request a heartbeat response
-	membersLock <- struct{}{} //acquire token
-	delete(members, member.Identifier())
-	<-membersLock //release token
+	cluster.membersLock <- struct{}{} //acquire token
+	delete(cluster.members, member.Identifier())
+	<-cluster.membersLock //release token
+
+	cluster.memberShortListLock <- struct{}{}
+	delete(cluster.memberShortList, member.Identifier())
+	<-cluster.memberShortListLock
+
+	cluster.memberFailListLock <- struct{}{}
+	if _, failed := cluster.memberFailList[member.Identifier()]; !failed {
+		s.recordFailureDetection()
+	}
+	cluster.memberFailList[member.Identifier()] = member
+	<-cluster.memberFailListLock
+	s.publishEvent(api.MemberEventFailed, member, api.MemberStateFailed)
 
-	memberShortListLock <- struct{}{}
-	delete(memberShortList, member.Identifier())
-	<-memberShortListLock
+	go s.sendHeartbeatRequest(ctx, logger, member, message)
+}
 
-	memberFailListLock <- struct{}{}
-	memberFailList[member.Identifier()] = member
-	<- memberFailListLock
+// hexBytes formats bytes as hex when they are logged
+type hexBytes []byte
 
-	go sendHeartbeatRequest(member, message)
-}
\ No newline at end of file
+func (h hexBytes) String() string {
+	return hex.EncodeToString(h)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"testing"
+	"time"
+)
+
+func TestMembershipServiceContext_UpdateConfig(t *testing.T) {
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default()}
+	changed := serviceContext.ConfigChanged()
+
+	updated := config.Default()
+	updated.Membership.HeartbeatInterval = time.Second
+	serviceContext.UpdateConfig(updated)
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("UpdateConfig() did not signal the configuration changed")
+	}
+	if got := serviceContext.CurrentConfig().Membership.HeartbeatInterval; got != time.Second {
+		t.Errorf("CurrentConfig() heartbeatInterval = %v, want %v", got, time.Second)
+	}
+	select {
+	case <-serviceContext.ConfigChanged():
+		t.Error("ConfigChanged() is closed before the next change")
+	default:
+	}
+}
+
+func TestMembershipServiceContext_UpdateTracerProvider(t *testing.T) {
+	serviceContext := &MembershipServiceContext{Context: context.Background()}
+	if _, enabled := serviceContext.Tracer(); enabled {
+		t.Error("Tracer() is enabled without a TracerProvider")
+	}
+	if previous := serviceContext.UpdateTracerProvider(nil); previous != nil {
+		t.Errorf("UpdateTracerProvider() = %v, want no previous TracerProvider", previous)
+	}
+}
+
+func TestHandleHeartbeatResponseTracking_PhiMarksFailed(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	serviceContext := &MembershipServiceContext{
+		Context:          context.Background(),
+		Config:           config.Default(),
+		Self:             self,
+		Identity:         self.Identifier(),
+		HeartbeatRequest: api.HeartbeatRequestMessage.CreateMemberMessage(self),
+	}
+	cluster := serviceContext.cluster()
+	alan, alanConnection := newListeningMember(t, "Alan")
+	bas, basConnection := newListeningMember(t, "Bas")
+	cluster.members[alan.Identifier()] = alan
+	cluster.members[bas.Identifier()] = bas
+	cluster.memberShortList[alan.Identifier()] = alan
+	cluster.memberShortList[bas.Identifier()] = bas
+	cluster.heartbeatResponses[alan.Identifier()] = &heartbeatResponseTracker{LastResponse: time.Now().Add(-time.Minute)}
+	events := serviceContext.SubscribeToEvents()
+
+	serviceContext.HandleHeartbeatResponseTracking(context.Background(), logging.Nop(), alan)
+	if cluster.members[alan.Identifier()] != nil || cluster.memberFailList[alan.Identifier()] == nil {
+		t.Errorf("Alan is a member = %v, failed = %v, want it failed", cluster.members[alan.Identifier()] != nil, cluster.memberFailList[alan.Identifier()] != nil)
+	}
+	select {
+	case event := <-events:
+		if event.Type != api.MemberEventFailed || event.Member.ID != alan.Identifier() {
+			t.Errorf("published %v about %v, want Alan failed", event.Type, event.Member.ID)
+		}
+	default:
+		t.Error("published no event, want Alan failed")
+	}
+	// Bas hears about it, Alan gets one more request to answer
+	if failed := requireMessageType(t, basConnection, api.MemberFailureDetected); failed.Identifier() != alan.Identifier() {
+		t.Errorf("Bas heard %v failed, want Alan", failed.Identifier())
+	}
+	requireMessageType(t, alanConnection, api.HeartbeatRequestMessage)
+}
+
+func TestHandleHeartbeatResponseTrackingUpdate_RefreshesLastSeen(t *testing.T) {
+	start := time.Date(2022, 9, 13, 23, 25, 38, 0, time.UTC)
+	fakeClock := clock.NewFake(start)
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default(), Clock: fakeClock}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	alan.LastSeen = start
+	cluster.members[alan.Identifier()] = alan
+	tracker := &heartbeatResponseTracker{LastResponse: start}
+	cluster.heartbeatResponses[alan.Identifier()] = tracker
+
+	fakeClock.Advance(time.Minute)
+	sequence := tracker.request(fakeClock.Now())
+	fakeClock.Advance(10 * time.Millisecond)
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), newTestMember("Alan", "Boreas", "10.0.0.1", "7780"), sequence, true, nil)
+	if seen := cluster.members[alan.Identifier()].LastSeen; !seen.Equal(fakeClock.Now()) {
+		t.Errorf("LastSeen = %v after Alan answered, want %v", seen, fakeClock.Now())
+	}
+	if !alan.LastSeen.Equal(start) {
+		t.Errorf("LastSeen of the member we knew changed to %v, want it left as is", alan.LastSeen)
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/global"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncint64"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	"net/http"
+	"time"
+)
+
+const metricsNamespace = "boom"
+
+const unknownMessageType = "unknown"
+
+var (
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "Membership messages sent, by pool and message type.",
+	}, []string{"pool", "type"})
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Membership messages received, by pool and message type.",
+	}, []string{"pool", "type"})
+	messageDecodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "message_decode_errors_total",
+		Help:      "Datagrams received that could not be read as a membership message, by pool.",
+	}, []string{"pool"})
+	messagesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_rejected_total",
+		Help:      "Datagrams received that we did not act on, by pool and reason.",
+	}, []string{"pool", "reason"})
+	failureDetections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "failure_detections_total",
+		Help:      "Times a member was considered failed, by us or by a member that let us know, by pool.",
+	}, []string{"pool"})
+	falsePositiveRecoveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "false_positive_recoveries_total",
+		Help:      "Times a member we considered failed responded again, by pool.",
+	}, []string{"pool"})
+	heartbeatRoundTrip = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeat_rtt_seconds",
+		Help:      "Time between sending a heartbeat request and receiving the response, by pool.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"pool"})
+	lateHeartbeatResponses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeat_late_responses_total",
+		Help:      "Heartbeat responses that arrived after their request timed out, or answered no request we sent, by pool.",
+	}, []string{"pool"})
+
+	membersDescription = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "members"),
+		"Members we know about, by pool and state.", []string{"pool", "state"}, nil)
+	shortListSizeDescription = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "short_list_size"),
+		"Members we send heartbeat requests to, by pool.", []string{"pool"}, nil)
+	clockDescription = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "clock"),
+		"Our local clock, by pool.", []string{"pool"}, nil)
+	localHealthDescription = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "local_health"),
+		"How unhealthy we consider ourselves, 0 is healthy, we probe this many times slower plus one, by pool.", []string{"pool"}, nil)
+)
+
+// the reasons a message is rejected, a message that cannot be read at all is a decode error instead
+const (
+	// rejectedOtherSegment is a message about a member of a segment we do not keep track of
+	rejectedOtherSegment = "other-segment"
+	// rejectedNameConflict is a hello of a newcomer that lost its name to a member that was there first
+	rejectedNameConflict = "name-conflict"
+)
+
+// the same metrics as OpenTelemetry instruments, they record nothing until a MeterProvider is set
+var (
+	otelMessagesSent            syncint64.Counter
+	otelMessagesReceived        syncint64.Counter
+	otelMessageDecodeErrors     syncint64.Counter
+	otelMessagesRejected        syncint64.Counter
+	otelFailureDetections       syncint64.Counter
+	otelFalsePositiveRecoveries syncint64.Counter
+	otelLateHeartbeatResponses  syncint64.Counter
+	otelHeartbeatRoundTrip      syncfloat64.Histogram
+)
+
+func init() {
+	for _, pool := range []string{PoolLAN, PoolWAN} {
+		for _, messageType := range api.MessageTypes {
+			messagesSent.WithLabelValues(pool, messageType.Name)
+			messagesReceived.WithLabelValues(pool, messageType.Name)
+		}
+		for _, reason := range []string{rejectedOtherSegment, rejectedNameConflict} {
+			messagesRejected.WithLabelValues(pool, reason)
+		}
+		messageDecodeErrors.WithLabelValues(pool)
+		failureDetections.WithLabelValues(pool)
+		falsePositiveRecoveries.WithLabelValues(pool)
+		heartbeatRoundTrip.WithLabelValues(pool)
+		lateHeartbeatResponses.WithLabelValues(pool)
+	}
+
+	var err error
+	meter := global.Meter(name)
+	counters := []struct {
+		counter     *syncint64.Counter
+		name        string
+		description string
+	}{
+		{&otelMessagesSent, "boom.messages.sent", "Membership messages sent, by pool and message type."},
+		{&otelMessagesReceived, "boom.messages.received", "Membership messages received, by pool and message type."},
+		{&otelMessageDecodeErrors, "boom.message.decode_errors", "Datagrams received that could not be read as a membership message, by pool."},
+		{&otelMessagesRejected, "boom.messages.rejected", "Datagrams received that we did not act on, by pool and reason."},
+		{&otelFailureDetections, "boom.failure_detections", "Times a member was considered failed, by us or by a member that let us know, by pool."},
+		{&otelFalsePositiveRecoveries, "boom.false_positive_recoveries", "Times a member we considered failed responded again, by pool."},
+		{&otelLateHeartbeatResponses, "boom.heartbeat.late_responses", "Heartbeat responses that arrived after their request timed out, or answered no request we sent, by pool."},
+	}
+	for _, c := range counters {
+		*c.counter, err = meter.SyncInt64().Counter(c.name, instrument.WithDescription(c.description))
+		if err != nil {
+			panic(err)
+		}
+	}
+	otelHeartbeatRoundTrip, err = meter.SyncFloat64().Histogram("boom.heartbeat.rtt",
+		instrument.WithDescription("Time between sending a heartbeat request and receiving the response, by pool."), instrument.WithUnit("s"))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ObserveMembership registers the OpenTelemetry gauges of the membership, and of the WAN pool if we joined it,
+// which are observed every time metrics are collected
+func ObserveMembership(serviceContext *MembershipServiceContext) error {
+	meter := global.Meter(name)
+	membersGauge, err := meter.AsyncInt64().Gauge("boom.members", instrument.WithDescription("Members we know about, by pool and state."))
+	if err != nil {
+		return err
+	}
+	shortListSizeGauge, err := meter.AsyncInt64().Gauge("boom.short_list_size", instrument.WithDescription("Members we send heartbeat requests to, by pool."))
+	if err != nil {
+		return err
+	}
+	clockGauge, err := meter.AsyncInt64().Gauge("boom.clock", instrument.WithDescription("Our local clock, by pool."), instrument.WithUnit(unit.Dimensionless))
+	if err != nil {
+		return err
+	}
+	localHealthGauge, err := meter.AsyncInt64().Gauge("boom.local_health",
+		instrument.WithDescription("How unhealthy we consider ourselves, 0 is healthy, we probe this many times slower plus one, by pool."), instrument.WithUnit(unit.Dimensionless))
+	if err != nil {
+		return err
+	}
+	gauges := []instrument.Asynchronous{membersGauge, shortListSizeGauge, clockGauge, localHealthGauge}
+	return meter.RegisterCallback(gauges, func(ctx context.Context) {
+		for _, pool := range serviceContext.pools() {
+			observeMembership(ctx, pool, membersGauge, shortListSizeGauge, clockGauge, localHealthGauge)
+		}
+	})
+}
+
+func observeMembership(ctx context.Context, serviceContext *MembershipServiceContext, membersGauge asyncint64.Gauge, shortListSizeGauge asyncint64.Gauge, clockGauge asyncint64.Gauge, localHealthGauge asyncint64.Gauge) {
+	state := currentMembershipState(serviceContext)
+	pool := attribute.String("pool", serviceContext.pool())
+	for memberState, count := range state.membersPerState {
+		membersGauge.Observe(ctx, int64(count), pool, attribute.String("state", string(memberState)))
+	}
+	shortListSizeGauge.Observe(ctx, int64(state.shortListSize), pool)
+	clockGauge.Observe(ctx, state.clock, pool)
+	localHealthGauge.Observe(ctx, int64(state.localHealth), pool)
+}
+
+// membershipState is what the membership gauges report
+type membershipState struct {
+	membersPerState map[api.MemberState]int
+	shortListSize   int
+	clock           int64
+	localHealth     int
+}
+
+func currentMembershipState(serviceContext *MembershipServiceContext) membershipState {
+	state := membershipState{membersPerState: map[api.MemberState]int{api.MemberStateAlive: 0, api.MemberStateFailed: 0, api.MemberStateLeft: 0}}
+	cluster := serviceContext.cluster()
+	for _, memberInfo := range serviceContext.MemberInfoSnapshot() {
+		state.membersPerState[memberInfo.State]++
+	}
+
+	cluster.memberShortListLock <- struct{}{}
+	state.shortListSize = len(cluster.memberShortList)
+	<-cluster.memberShortListLock
+
+	cluster.clockLock <- struct{}{} // acquire token
+	state.clock = serviceContext.Self.Clock
+	<-cluster.clockLock // release token
+	state.localHealth = serviceContext.localHealth()
+	return state
+}
+
+// membershipCollector reports the state of the membership, and of the WAN pool if we joined it, at the time it is scraped
+type membershipCollector struct {
+	serviceContext *MembershipServiceContext
+}
+
+func (c *membershipCollector) Describe(descriptions chan<- *prometheus.Desc) {
+	descriptions <- membersDescription
+	descriptions <- shortListSizeDescription
+	descriptions <- clockDescription
+	descriptions <- localHealthDescription
+}
+
+func (c *membershipCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, serviceContext := range c.serviceContext.pools() {
+		state := currentMembershipState(serviceContext)
+		pool := serviceContext.pool()
+		for memberState, count := range state.membersPerState {
+			metrics <- prometheus.MustNewConstMetric(membersDescription, prometheus.GaugeValue, float64(count), pool, string(memberState))
+		}
+		metrics <- prometheus.MustNewConstMetric(shortListSizeDescription, prometheus.GaugeValue, float64(state.shortListSize), pool)
+		metrics <- prometheus.MustNewConstMetric(clockDescription, prometheus.GaugeValue, float64(state.clock), pool)
+		metrics <- prometheus.MustNewConstMetric(localHealthDescription, prometheus.GaugeValue, float64(state.localHealth), pool)
+	}
+}
+
+// newMetricsHandler serves the membership and protocol metrics, and those of the Go runtime, in the Prometheus format
+func newMetricsHandler(serviceContext *MembershipServiceContext) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		messagesSent,
+		messagesReceived,
+		messageDecodeErrors,
+		messagesRejected,
+		failureDetections,
+		falsePositiveRecoveries,
+		heartbeatRoundTrip,
+		lateHeartbeatResponses,
+		&membershipCollector{serviceContext: serviceContext},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// messageTypeName is the name of the type of the message, for labelling metrics
+func messageTypeName(message []byte) string {
+	if len(message) == 0 {
+		return unknownMessageType
+	}
+	messageType, ok := api.MessageTypeByPrefix(message[0])
+	if !ok {
+		return unknownMessageType
+	}
+	return messageType.Name
+}
+
+func (s *MembershipServiceContext) recordMessageSent(message []byte) {
+	messageType := messageTypeName(message)
+	messagesSent.WithLabelValues(s.pool(), messageType).Inc()
+	otelMessagesSent.Add(context.Background(), 1, attribute.String("pool", s.pool()), attribute.String("type", messageType))
+}
+
+func (s *MembershipServiceContext) recordMessageReceived(messageType api.MessageType) {
+	messagesReceived.WithLabelValues(s.pool(), messageType.Name).Inc()
+	otelMessagesReceived.Add(context.Background(), 1, attribute.String("pool", s.pool()), attribute.String("type", messageType.Name))
+}
+
+func (s *MembershipServiceContext) recordMessageDecodeError() {
+	messageDecodeErrors.WithLabelValues(s.pool()).Inc()
+	otelMessageDecodeErrors.Add(context.Background(), 1, attribute.String("pool", s.pool()))
+}
+
+func (s *MembershipServiceContext) recordMessageRejected(reason string) {
+	messagesRejected.WithLabelValues(s.pool(), reason).Inc()
+	otelMessagesRejected.Add(context.Background(), 1, attribute.String("pool", s.pool()), attribute.String("reason", reason))
+}
+
+func (s *MembershipServiceContext) recordFailureDetection() {
+	failureDetections.WithLabelValues(s.pool()).Inc()
+	otelFailureDetections.Add(context.Background(), 1, attribute.String("pool", s.pool()))
+}
+
+func (s *MembershipServiceContext) recordFalsePositiveRecovery() {
+	falsePositiveRecoveries.WithLabelValues(s.pool()).Inc()
+	otelFalsePositiveRecoveries.Add(context.Background(), 1, attribute.String("pool", s.pool()))
+}
+
+func (s *MembershipServiceContext) recordHeartbeatRoundTrip(roundTrip time.Duration) {
+	heartbeatRoundTrip.WithLabelValues(s.pool()).Observe(roundTrip.Seconds())
+	otelHeartbeatRoundTrip.Record(context.Background(), roundTrip.Seconds(), attribute.String("pool", s.pool()))
+}
+
+func (s *MembershipServiceContext) recordLateHeartbeatResponse() {
+	lateHeartbeatResponses.WithLabelValues(s.pool()).Inc()
+	otelLateHeartbeatResponses.Add(context.Background(), 1, attribute.String("pool", s.pool()))
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Endpoint(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	bas := newTestMember("Bas", "Boreas", "10.0.0.2", "7781")
+	cluster.members[alan.Identifier()] = alan
+	cluster.memberShortList[alan.Identifier()] = alan
+	cluster.memberFailList[bas.Identifier()] = bas
+	serviceContext.recordMessageSent(api.HelloMessage.CreateMemberMessage(alan))
+
+	response, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics status = %v, want %v", response.StatusCode, http.StatusOK)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantLines := []string{
+		`boom_members{pool="lan",state="alive"} 1`,
+		`boom_members{pool="lan",state="failed"} 1`,
+		`boom_short_list_size{pool="lan"} 1`,
+		`boom_clock{pool="lan"} 0`,
+		`boom_local_health{pool="lan"} 0`,
+		`boom_messages_received_total{pool="lan",type="HeartbeatRequest"} `,
+		`boom_message_decode_errors_total{pool="lan"} `,
+		`boom_messages_rejected_total{pool="lan",reason="other-segment"} `,
+		`boom_failure_detections_total{pool="lan"} `,
+		`boom_false_positive_recoveries_total{pool="lan"} `,
+		`boom_heartbeat_rtt_seconds_count{pool="lan"} `,
+		`go_goroutines `,
+	}
+	for _, wantLine := range wantLines {
+		if !strings.Contains(string(body), wantLine) {
+			t.Errorf("GET /metrics does not contain %q", wantLine)
+		}
+	}
+	if sent := testutil.ToFloat64(messagesSent.WithLabelValues(PoolLAN, "Hello")); sent < 1 {
+		t.Errorf("messages sent of type Hello = %v, want at least 1", sent)
+	}
+}
+
+func TestMetrics_FalsePositiveRecovery(t *testing.T) {
+	serviceContext := &MembershipServiceContext{}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	cluster.memberFailList[alan.Identifier()] = alan
+	tracker := &heartbeatResponseTracker{
+		MissedResponsesCounter: 5,
+		LastResponse:           NoResponseTime,
+		FailureDetected:        true,
+	}
+	sequence := tracker.request(time.Now().Add(-10 * time.Millisecond))
+	cluster.heartbeatResponses[alan.Identifier()] = tracker
+	recoveriesBefore := testutil.ToFloat64(falsePositiveRecoveries.WithLabelValues(PoolLAN))
+	roundTripsBefore := heartbeatRoundTripCount(t)
+
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), alan, sequence, true, nil)
+
+	if recoveries := testutil.ToFloat64(falsePositiveRecoveries.WithLabelValues(PoolLAN)) - recoveriesBefore; recoveries != 1 {
+		t.Errorf("false positive recoveries increased by %v, want 1", recoveries)
+	}
+	if roundTrips := heartbeatRoundTripCount(t) - roundTripsBefore; roundTrips != 1 {
+		t.Errorf("heartbeat round trips observed = %d, want 1", roundTrips)
+	}
+	if cluster.members[alan.Identifier()] == nil || cluster.memberFailList[alan.Identifier()] != nil {
+		t.Errorf("recovered member %v is not alive again", alan.Identifier())
+	}
+}
+
+func TestMetrics_Pools(t *testing.T) {
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	serviceContext.Config = config.Default()
+	pool := NewWANPool(serviceContext)
+	serviceContext.WAN = pool
+	bas := newTestMember("Bas", "eu", "10.1.0.2", "7778")
+	pool.cluster().members[bas.Identifier()] = bas
+	otherSegment := newTestMember("Cas", "Boreas", "10.0.0.3", "7777")
+	otherSegment.Segment = "eu-west"
+	lanRejectedBefore := testutil.ToFloat64(messagesRejected.WithLabelValues(PoolLAN, rejectedOtherSegment))
+	wanSentBefore := testutil.ToFloat64(messagesSent.WithLabelValues(PoolWAN, "Hello"))
+	lanSentBefore := testutil.ToFloat64(messagesSent.WithLabelValues(PoolLAN, "Hello"))
+
+	serviceContext.otherSegment(logging.Nop(), otherSegment)
+	pool.recordMessageSent(api.HelloMessage.CreateMemberMessage(bas))
+
+	if rejected := testutil.ToFloat64(messagesRejected.WithLabelValues(PoolLAN, rejectedOtherSegment)) - lanRejectedBefore; rejected != 1 {
+		t.Errorf("messages rejected for their segment increased by %v, want 1", rejected)
+	}
+	if sent := testutil.ToFloat64(messagesSent.WithLabelValues(PoolWAN, "Hello")) - wanSentBefore; sent != 1 {
+		t.Errorf("hellos sent in the WAN pool increased by %v, want 1", sent)
+	}
+	if sent := testutil.ToFloat64(messagesSent.WithLabelValues(PoolLAN, "Hello")) - lanSentBefore; sent != 0 {
+		t.Errorf("hellos sent in our cluster increased by %v, want 0", sent)
+	}
+
+	response, err := http.Get(testServer.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wantLine := range []string{`boom_members{pool="lan",state="alive"} 0`, `boom_members{pool="wan",state="alive"} 1`, `boom_short_list_size{pool="wan"} 0`} {
+		if !strings.Contains(string(body), wantLine) {
+			t.Errorf("GET /metrics does not contain %q", wantLine)
+		}
+	}
+}
+
+func heartbeatRoundTripCount(t *testing.T) uint64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := heartbeatRoundTrip.WithLabelValues(PoolLAN).(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
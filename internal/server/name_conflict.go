@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"unicode/utf8"
+)
+
+// When two node IDs claim the same name, the members decide which keeps it by the membership.nameConflictPolicy,
+// and send the other a NameConflict message. The node that loses its name renames itself, or leaves the cluster.
+
+// resolveNameConflict decides which of a member we knew and a member we did not know yet keeps their name,
+// and lets the other know, it returns false when we do not accept the new member
+func (s *MembershipServiceContext) resolveNameConflict(ctx context.Context, logger *logging.Logger, known *api.Member, newcomer *api.Member) bool {
+	policy := s.membershipConfig().NameConflictPolicy
+	// we do not vote about our own name, the other members decide
+	if policy == config.NameConflictMajority && known.Identifier() == s.Identity {
+		return true
+	}
+	// when we claim the name ourselves the policy applies as well, so we come to the same decision as the others
+	winner, loser := known, newcomer
+	if policy == config.NameConflictOldestWins {
+		if api.OlderMember(known, newcomer) == newcomer {
+			winner, loser = newcomer, known
+		}
+	}
+	if loser.Identifier() == s.Identity {
+		s.yieldName(logger, winner.Identifier())
+		return true
+	}
+
+	logger.Every(repeatedLogInterval).Info("Letting a member know it lost its name", "member", loser.Identifier(), "name", loser.Name(),
+		"winner", winner.Identifier(), "policy", policy)
+	conflict := api.NameConflict{Winner: winner.ID, Voter: s.selfMember().ID}
+	message := api.WithNameConflict(api.NameConflictMessage.CreateMemberMessage(loser), conflict)
+	if err := s.sendMessageToMember(ctx, logger, loser, message, "nameConflict"); err != nil {
+		logger.Warn("Could not send name conflict", "member", loser.Identifier(), "error", err)
+	}
+	return policy != config.NameConflictRejectNewcomer || loser != newcomer
+}
+
+// lostName handles a NameConflict message, which tells us another node keeps our name
+func (s *MembershipServiceContext) lostName(logger *logging.Logger, received memberMessage) {
+	member := received.member
+	self := s.selfMember()
+	// after a rename, the members that did not hear of it yet can still tell us we lost our previous name
+	if !received.hasNameConflict || member.Identifier() != s.Identity || member.Name() != self.Name() {
+		logger.Debug("Ignoring name conflict that is not about us", "member", member.Identifier(), "name", member.Name())
+		return
+	}
+	if s.membershipConfig().NameConflictPolicy == config.NameConflictMajority {
+		votes, voters := s.recordNameConflictVote(received.nameConflict.Voter.String(), self.Name())
+		if votes*2 <= voters {
+			logger.Info("Member votes we lose our name", "voter", received.nameConflict.Voter, "votes", votes, "voters", voters)
+			return
+		}
+	}
+	s.yieldName(logger, received.nameConflict.Winner.String())
+}
+
+// recordNameConflictVote remembers the member told us we lost our name, it returns how many members did,
+// and how many members can vote, which are those that do not claim the name themselves
+func (s *MembershipServiceContext) recordNameConflictVote(voter string, name string) (int, int) {
+	cluster := s.cluster()
+	cluster.nameConflictVotesLock <- struct{}{}
+	cluster.nameConflictVotes[voter] = true
+	votes := len(cluster.nameConflictVotes)
+	<-cluster.nameConflictVotesLock
+
+	voters := 0
+	for _, member := range s.aliveMembersSnapshot() {
+		if member.Name() != name {
+			voters++
+		}
+	}
+	return votes, voters
+}
+
+// yieldName gives up our name to the winner, as the membership.nameConflictAction says
+func (s *MembershipServiceContext) yieldName(logger *logging.Logger, winner string) {
+	if s.Leaving() {
+		return
+	}
+	action := s.membershipConfig().NameConflictAction
+	self := s.selfMember()
+	logger.Warn("Lost our name to another node", "name", self.Name(), "winner", winner, "action", action)
+	if action == config.NameConflictShutdown {
+		s.shutdown()
+		return
+	}
+	s.rename(logger, renamedName(self.MemberName, self.ID))
+}
+
+// rename makes us go by another MemberName, and sends our Hello to every member, so they know us by it
+func (s *MembershipServiceContext) rename(logger *logging.Logger, memberName string) {
+	root := s.root()
+	root.lock.Lock()
+	root.renamedTo = memberName
+	root.lock.Unlock()
+
+	cluster := s.cluster()
+	cluster.nameConflictVotesLock <- struct{}{}
+	cluster.nameConflictVotes = make(map[string]bool)
+	<-cluster.nameConflictVotesLock
+	logger.Warn("Renamed ourselves", "name", s.selfMember().Name())
+	s.sendHelloToMembers(logger)
+}
+
+// renamedName adds the start of the node ID to the name, cut off so it fits the MemberName field
+func renamedName(memberName string, id api.NodeID) string {
+	suffix := "-" + id.String()[:4]
+	for len(memberName)+len(suffix) > api.MemberNameField.Size {
+		_, size := utf8.DecodeLastRuneInString(memberName)
+		memberName = memberName[:len(memberName)-size]
+	}
+	return memberName + suffix
+}
+
+// selfMember returns a copy of the member we are, with the name we go by now
+func (s *MembershipServiceContext) selfMember() *api.Member {
+	root := s.root()
+	root.lock.RLock()
+	renamedTo := root.renamedTo
+	root.lock.RUnlock()
+
+	cluster := s.cluster()
+	cluster.clockLock <- struct{}{} // acquire token
+	self := *root.Self
+	<-cluster.clockLock // release token
+	if renamedTo != "" {
+		self.MemberName = renamedTo
+	}
+	return &self
+}
+
+// ownMessage returns our message of the type, once we renamed ourselves it is created again with our new name
+func (s *MembershipServiceContext) ownMessage(messageType api.MessageType) []byte {
+	root := s.root()
+	root.lock.RLock()
+	renamed := root.renamedTo != ""
+	root.lock.RUnlock()
+	if renamed {
+		return messageType.CreateMemberMessage(s.selfMember())
+	}
+	switch messageType.Prefix {
+	case api.HelloPrefix:
+		return s.HelloMessage
+	case api.GoodbyePrefix:
+		return s.GoodbyeMessage
+	case api.GoodbyeAckPrefix:
+		return s.GoodbyeAck
+	case api.HeartbeatRequestPrefix:
+		return s.HeartbeatRequest
+	case api.HeartbeatResponsePrefix:
+		return s.HeartbeatResponse
+	}
+	return messageType.CreateMemberMessage(s.selfMember())
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"net"
+	"testing"
+	"time"
+)
+
+func newNameConflictTestContext(policy string, action string) *MembershipServiceContext {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	self.ID = api.NodeID{0x09}
+	serviceConfig := config.Default()
+	serviceConfig.Membership.NameConflictPolicy = policy
+	serviceConfig.Membership.NameConflictAction = action
+	return &MembershipServiceContext{
+		Context:      context.Background(),
+		Config:       serviceConfig,
+		Self:         self,
+		Identity:     self.Identifier(),
+		HelloMessage: api.HelloMessage.CreateMemberMessage(self),
+		Logger:       logging.Nop(),
+	}
+}
+
+// requireNameConflict fails unless the member receives a NameConflict that tells it the winner keeps its name
+func requireNameConflict(t *testing.T, receiver *net.UDPConn, loser *api.Member, winner *api.Member, voter *api.Member) {
+	t.Helper()
+	datagram := receiveDatagram(t, receiver)
+	member, messageType, err := api.ReadMemberMessage(datagram, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict, ok := api.ReadNameConflict(datagram)
+	if messageType.Prefix != api.NameConflictPrefix || member.ID != loser.ID || !ok || conflict.Winner != winner.ID || conflict.Voter != voter.ID {
+		t.Errorf("received %s about %v with %+v, want a name conflict about %v, won by %v", messageType.Name, member.ID, conflict, loser.ID, winner.ID)
+	}
+}
+
+func requireNoMessage(t *testing.T, receiver *net.UDPConn) {
+	t.Helper()
+	receiver.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := receiver.ReadFromUDP(make([]byte, 1024)); err == nil {
+		t.Error("received a message, want none")
+	}
+}
+
+func TestMembershipServiceContext_ResolveNameConflict(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		policy         string
+		newcomerOlder  bool
+		wantKnownLoses bool
+		wantAccepted   bool
+	}{
+		{"OldestWinsKnownOlder", config.NameConflictOldestWins, false, false, true},
+		{"OldestWinsNewcomerOlder", config.NameConflictOldestWins, true, true, true},
+		{"RejectNewcomer", config.NameConflictRejectNewcomer, false, false, false},
+		{"RejectNewcomerNewcomerOlder", config.NameConflictRejectNewcomer, true, false, false},
+		{"Majority", config.NameConflictMajority, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := newNameConflictTestContext(tt.policy, config.NameConflictRename)
+			known, knownConnection := newListeningMember(t, "Alan")
+			known.ID = api.NodeID{0x01}
+			known.Started = now.Add(-time.Minute)
+			newcomer, newcomerConnection := newListeningMember(t, "Alan")
+			newcomer.ID = api.NodeID{0x02}
+			newcomer.Started = now
+			if tt.newcomerOlder {
+				newcomer.Started = now.Add(-time.Hour)
+			}
+
+			accepted := serviceContext.resolveNameConflict(context.Background(), logging.Nop(), known, newcomer)
+			if accepted != tt.wantAccepted {
+				t.Errorf("resolveNameConflict() = %v, want %v", accepted, tt.wantAccepted)
+			}
+			if tt.wantKnownLoses {
+				requireNameConflict(t, knownConnection, known, newcomer, serviceContext.Self)
+				requireNoMessage(t, newcomerConnection)
+			} else {
+				requireNameConflict(t, newcomerConnection, newcomer, known, serviceContext.Self)
+				requireNoMessage(t, knownConnection)
+			}
+		})
+	}
+}
+
+func TestMembershipServiceContext_CheckOurName(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		wantRenamed  bool
+		wantAccepted bool
+	}{
+		// the newcomer started before us
+		{"OldestWins", config.NameConflictOldestWins, true, true},
+		// we hold the name, so we keep it although the newcomer started before us, as the others decide the same
+		{"RejectNewcomerNewcomerOlder", config.NameConflictRejectNewcomer, false, false},
+		// we do not vote about our own name
+		{"Majority", config.NameConflictMajority, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := newNameConflictTestContext(tt.policy, config.NameConflictRename)
+			serviceContext.Self.Started = time.Now()
+			subscription := serviceContext.SubscribeToEvents()
+			bas, basConnection := newListeningMember(t, "Bas")
+			serviceContext.cluster().members[bas.Identifier()] = bas
+			newcomer, newcomerConnection := newListeningMember(t, "Self")
+			newcomer.Hostname = "localhost"
+			newcomer.ID = api.NodeID{0x02}
+			newcomer.Started = time.Now().Add(-time.Hour)
+
+			accepted := serviceContext.checkName(context.Background(), logging.Nop(), newcomer)
+			if accepted != tt.wantAccepted {
+				t.Errorf("checkName() = %v, want %v", accepted, tt.wantAccepted)
+			}
+			select {
+			case event := <-subscription:
+				if event.Type != api.MemberEventConflict || event.Member.ID != newcomer.Identifier() || event.ConflictsWith != serviceContext.Identity {
+					t.Errorf("received event %+v, want a conflict of the newcomer with us", event)
+				}
+			default:
+				t.Error("received no conflict event")
+			}
+			if tt.wantAccepted {
+				requireNoMessage(t, newcomerConnection)
+			} else {
+				requireNameConflict(t, newcomerConnection, newcomer, serviceContext.Self, serviceContext.Self)
+			}
+			if !tt.wantRenamed {
+				requireNoMessage(t, basConnection)
+				return
+			}
+			if got := serviceContext.selfMember().Name(); got != "Self-0900@localhost" {
+				t.Errorf("renamed ourselves to %s, want Self-0900@localhost", got)
+			}
+			if hello := requireMessageType(t, basConnection, api.HelloMessage); hello.Name() != "Self-0900@localhost" || hello.ID != serviceContext.Self.ID {
+				t.Errorf("member received a hello of %s (%v), want our new name", hello.Name(), hello.ID)
+			}
+		})
+	}
+}
+
+func TestHandleMember_NameConflict(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		action       string
+		votes        int
+		wantRenamed  bool
+		wantShutdown bool
+	}{
+		{"Rename", config.NameConflictOldestWins, config.NameConflictRename, 1, true, false},
+		{"Shutdown", config.NameConflictOldestWins, config.NameConflictShutdown, 1, false, true},
+		{"MinorityVote", config.NameConflictMajority, config.NameConflictRename, 1, false, false},
+		{"MajorityVote", config.NameConflictMajority, config.NameConflictRename, 2, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := newNameConflictTestContext(tt.policy, tt.action)
+			ctx, cancel := context.WithCancel(context.Background())
+			serviceContext.Context = ctx
+			shutdown := false
+			serviceContext.Shutdown = func() { shutdown = true }
+			cluster := serviceContext.cluster()
+			bas, basConnection := newListeningMember(t, "Bas")
+			bas.ID = api.NodeID{0x02}
+			cas, _ := newListeningMember(t, "Cas")
+			cas.ID = api.NodeID{0x03}
+			cluster.members[bas.Identifier()] = bas
+			cluster.members[cas.Identifier()] = cas
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				HandleMember(serviceContext)
+			}()
+
+			winner := api.NodeID{0x01}
+			for _, voter := range []*api.Member{bas, cas}[:tt.votes] {
+				conflict := api.NameConflict{Winner: winner, Voter: voter.ID}
+				cluster.memberNameConflict <- memberMessage{ctx: context.Background(), member: serviceContext.selfMember(), nameConflict: conflict, hasNameConflict: true}
+			}
+			// HandleMember handles one message at a time, once it takes the next, it is done with this one
+			cluster.memberHeartbeatResponse <- memberMessage{ctx: context.Background(), member: serviceContext.Self}
+			cancel()
+			<-stopped
+
+			if renamed := serviceContext.selfMember().Name() != serviceContext.Self.Name(); renamed != tt.wantRenamed {
+				t.Errorf("renamed ourselves to %s: %v, want %v", serviceContext.selfMember().Name(), renamed, tt.wantRenamed)
+			}
+			if shutdown != tt.wantShutdown {
+				t.Errorf("shut down: %v, want %v", shutdown, tt.wantShutdown)
+			}
+			if tt.wantRenamed {
+				requireMessageType(t, basConnection, api.HelloMessage)
+			}
+
+			// once we renamed ourselves, the name conflict is no longer about us
+			serviceContext.lostName(logging.Nop(), memberMessage{member: serviceContext.Self, nameConflict: api.NameConflict{Winner: winner, Voter: bas.ID}, hasNameConflict: true})
+			if tt.wantRenamed && serviceContext.selfMember().MemberName != "Self-0900" {
+				t.Errorf("renamed ourselves again to %s", serviceContext.selfMember().Name())
+			}
+		})
+	}
+}
+
+func TestRenamedName(t *testing.T) {
+	id := api.NodeID{0x6b, 0xa7, 0xb8, 0x10}
+	tests := []struct {
+		memberName string
+		want       string
+	}{
+		{"MySelf", "MySelf-6ba7"},
+		{"", "-6ba7"},
+		{"ServerNumber", "ServerN-6ba7"},
+		{"Zoëëëëë", "Zoëë-6ba7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.memberName, func(t *testing.T) {
+			got := renamedName(tt.memberName, id)
+			if got != tt.want {
+				t.Errorf("renamedName(%q) = %q, want %q", tt.memberName, got, tt.want)
+			}
+			if len(got) > api.MemberNameField.Size {
+				t.Errorf("renamedName(%q) = %q does not fit the MemberName field", tt.memberName, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// nodeIDFile is the file in the data directory that holds our node ID
+const nodeIDFile = "node-id"
+
+// LoadNodeID returns the node ID kept in the data directory, and creates it when there is none yet.
+// Without a data directory, every start is a new node, with a new node ID.
+func LoadNodeID(dataDir string) (api.NodeID, error) {
+	if dataDir == "" {
+		return api.NewNodeID()
+	}
+	file := filepath.Join(dataDir, nodeIDFile)
+	content, err := os.ReadFile(file)
+	if err == nil {
+		id, err := api.ParseNodeID(string(content))
+		if err != nil {
+			return id, fmt.Errorf("could not read the node ID in %s: %w", file, err)
+		}
+		return id, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return api.NodeID{}, fmt.Errorf("could not read the node ID: %w", err)
+	}
+
+	id, err := api.NewNodeID()
+	if err != nil {
+		return id, err
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return id, fmt.Errorf("could not create the data directory: %w", err)
+	}
+	// write it under another name first, so a crash never leaves half a node ID behind
+	temporaryFile := file + ".tmp"
+	if err := os.WriteFile(temporaryFile, []byte(id.String()+"\n"), 0600); err != nil {
+		return id, fmt.Errorf("could not write the node ID: %w", err)
+	}
+	if err := os.Rename(temporaryFile, file); err != nil {
+		return id, fmt.Errorf("could not write the node ID: %w", err)
+	}
+	return id, nil
+}
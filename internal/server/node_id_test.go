@@ -0,0 +1,37 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNodeID(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "boom")
+	id, err := LoadNodeID(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadNodeID(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != id {
+		t.Errorf("LoadNodeID() = %v after a restart, want the node ID it kept, %v", again, id)
+	}
+
+	withoutDataDir, err := LoadNodeID("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutDataDir.IsZero() || withoutDataDir == id {
+		t.Errorf("LoadNodeID() without a data directory = %v, want a new node ID", withoutDataDir)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataDir, nodeIDFile), []byte("not a node ID"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadNodeID(dataDir); err == nil {
+		t.Error("LoadNodeID() with a corrupt node ID succeeded, want an error")
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+// minStdDeviationRatio is the least standard deviation of the intervals, relative to the heartbeat interval.
+// On a link that is perfectly regular, a single late response would otherwise make the member look failed.
+const minStdDeviationRatio = 0.5
+
+// arrivalWindow holds the latest intervals between the heartbeat responses of a member
+type arrivalWindow struct {
+	intervals []time.Duration
+}
+
+// add records an interval, and forgets the oldest ones so no more than size are kept
+func (w *arrivalWindow) add(interval time.Duration, size int) {
+	w.intervals = append(w.intervals, interval)
+	if len(w.intervals) > size {
+		w.intervals = append(w.intervals[:0], w.intervals[len(w.intervals)-size:]...)
+	}
+}
+
+// distribution returns the mean and standard deviation of the intervals.
+// Until there are any, the heartbeat interval is what we expect, with a quarter of it as deviation.
+func (w *arrivalWindow) distribution(heartbeatInterval time.Duration) (mean float64, stdDeviation float64) {
+	if len(w.intervals) == 0 {
+		mean = float64(heartbeatInterval)
+		stdDeviation = mean / 4
+	} else {
+		for _, interval := range w.intervals {
+			mean += float64(interval)
+		}
+		mean /= float64(len(w.intervals))
+		for _, interval := range w.intervals {
+			stdDeviation += (float64(interval) - mean) * (float64(interval) - mean)
+		}
+		stdDeviation = math.Sqrt(stdDeviation / float64(len(w.intervals)))
+	}
+	return mean, math.Max(stdDeviation, minStdDeviationRatio*float64(heartbeatInterval))
+}
+
+// phi is the suspicion level of the phi accrual failure detector: the chance that a response is just late,
+// when it has been elapsed since the last one, as -log10. So a phi of 1 is a chance of 10%, and 3 a chance of 0.1%.
+// It uses the logistic approximation of the normal distribution, as Akka does, written so it stays finite.
+func phi(elapsed time.Duration, mean float64, stdDeviation float64) float64 {
+	y := (float64(elapsed) - mean) / stdDeviation
+	z := y * (1.5976 + 0.070566*y*y)
+	if z > 0 {
+		return z/math.Ln10 + math.Log10(1+math.Exp(-z))
+	}
+	return math.Log10(1 + math.Exp(z))
+}
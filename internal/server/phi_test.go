@@ -0,0 +1,111 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPhi(t *testing.T) {
+	mean := float64(5 * time.Second)
+	stdDeviation := float64(2500 * time.Millisecond)
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		want    float64
+	}{
+		{"Early", 0, 0.0099988},
+		{"OnTime", 5 * time.Second, 0.3010300},
+		{"OneMissed", 10 * time.Second, 1.6428279},
+		{"TwoMissed", 15 * time.Second, 4.7366946},
+		{"ThreeMissed", 20 * time.Second, 10.7826009},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := phi(tt.elapsed, mean, stdDeviation); math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("phi(%v) = %v, want %v", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+	t.Run("Finite", func(t *testing.T) {
+		if got := phi(24*time.Hour, mean, stdDeviation); math.IsInf(got, 0) || math.IsNaN(got) {
+			t.Errorf("phi(24h) = %v, want a finite suspicion", got)
+		}
+	})
+}
+
+func TestArrivalWindow(t *testing.T) {
+	heartbeatInterval := 5 * time.Second
+	tests := []struct {
+		name             string
+		intervals        []time.Duration
+		size             int
+		wantIntervals    int
+		wantMean         time.Duration
+		wantStdDeviation time.Duration
+	}{
+		{"Empty", nil, 10, 0, 5 * time.Second, 2500 * time.Millisecond},
+		{"Regular", []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}, 10, 3, 5 * time.Second, 2500 * time.Millisecond},
+		{"Irregular", []time.Duration{2 * time.Second, 8 * time.Second, 2 * time.Second, 8 * time.Second}, 10, 4, 5 * time.Second, 3 * time.Second},
+		{"KeepsTheLatest", []time.Duration{time.Hour, 5 * time.Second, 5 * time.Second}, 2, 2, 5 * time.Second, 2500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := arrivalWindow{}
+			for _, interval := range tt.intervals {
+				window.add(interval, tt.size)
+			}
+			if len(window.intervals) != tt.wantIntervals {
+				t.Errorf("add() kept %d intervals, want %d", len(window.intervals), tt.wantIntervals)
+			}
+			mean, stdDeviation := window.distribution(heartbeatInterval)
+			if time.Duration(mean) != tt.wantMean || time.Duration(stdDeviation) != tt.wantStdDeviation {
+				t.Errorf("distribution() = %v, %v, want %v, %v", time.Duration(mean), time.Duration(stdDeviation), tt.wantMean, tt.wantStdDeviation)
+			}
+		})
+	}
+}
+
+func TestHeartbeatResponseTracker_Phi(t *testing.T) {
+	heartbeatInterval := 5 * time.Second
+	start := time.Date(2022, 9, 13, 23, 25, 38, 0, time.UTC)
+
+	t.Run("NeverResponded", func(t *testing.T) {
+		tracker := &heartbeatResponseTracker{LastResponse: NoResponseTime, TrackingSince: start}
+		if got := tracker.Phi(start.Add(5*time.Second), heartbeatInterval, 1); got > 1 {
+			t.Errorf("Phi() = %v right after we started tracking, want it low", got)
+		}
+		if got := tracker.Phi(start.Add(time.Minute), heartbeatInterval, 1); got < 8 {
+			t.Errorf("Phi() = %v a minute after we started tracking, want at least 8", got)
+		}
+	})
+	t.Run("SlowMemberGetsSlack", func(t *testing.T) {
+		regular := &heartbeatResponseTracker{LastResponse: NoResponseTime}
+		slow := &heartbeatResponseTracker{LastResponse: NoResponseTime}
+		now := start
+		for i := 0; i < 10; i++ {
+			now = now.Add(5 * time.Second)
+			regular.recordResponse(now, 100, 1)
+			slow.recordResponse(now.Add(time.Duration(i%2)*10*time.Second), 100, 1)
+		}
+		if len(regular.arrivals.intervals) != 9 {
+			t.Errorf("recordResponse() kept %d intervals, want 9 as the first response has no interval", len(regular.arrivals.intervals))
+		}
+		silence := 20 * time.Second
+		regularPhi := regular.Phi(regular.LastResponse.Add(silence), heartbeatInterval, 1)
+		slowPhi := slow.Phi(slow.LastResponse.Add(silence), heartbeatInterval, 1)
+		if slowPhi >= regularPhi {
+			t.Errorf("Phi() after %v of silence = %v for a slow member, want less than %v for a regular one", silence, slowPhi, regularPhi)
+		}
+	})
+	t.Run("UnhealthyIsMorePatient", func(t *testing.T) {
+		tracker := &heartbeatResponseTracker{LastResponse: start}
+		silence := 20 * time.Second
+		if got := tracker.Phi(start.Add(silence), heartbeatInterval, 1); got < 8 {
+			t.Errorf("Phi() after %v of silence = %v when we are healthy, want at least 8", silence, got)
+		}
+		if got := tracker.Phi(start.Add(silence), heartbeatInterval, 4); got > 1 {
+			t.Errorf("Phi() after %v of silence = %v when we probe 4 times slower, want it low", silence, got)
+		}
+	})
+}
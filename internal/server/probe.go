@@ -0,0 +1,76 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"sort"
+	"time"
+)
+
+// nextProbeTargets picks the members to probe this protocol period, and makes them the short list.
+// Like SWIM, we go round-robin through a shuffled list of all members, which is shuffled again after every full pass,
+// so every member is probed once per pass. A member that joins during a pass is probed from the next one on.
+// The members of a period are probed nearest first by their estimated round trip time,
+// those that did not send us a coordinate follow in the order of the pass.
+func (s *MembershipServiceContext) nextProbeTargets(count int) []*api.Member {
+	cluster := s.cluster()
+	roundTrips := s.estimatedRoundTrips()
+	cluster.memberShortListLock <- struct{}{}
+	cluster.membersLock <- struct{}{} //acquire token
+	targets := make([]*api.Member, 0, count)
+	picked := make(map[string]bool)
+	for len(targets) < count && len(picked) < len(cluster.members) {
+		if cluster.probeIndex >= len(cluster.probeOrder) {
+			cluster.probeOrder = cluster.probeOrder[:0]
+			for identifier := range cluster.members {
+				cluster.probeOrder = append(cluster.probeOrder, identifier)
+			}
+			// the order of a map is not random enough to shuffle, but sorted the shuffle only depends on the source
+			sort.Strings(cluster.probeOrder)
+			cluster.probeRandom.Shuffle(len(cluster.probeOrder), func(i, j int) {
+				cluster.probeOrder[i], cluster.probeOrder[j] = cluster.probeOrder[j], cluster.probeOrder[i]
+			})
+			// those we already probe this period go last, so the new pass does not skip them
+			sort.SliceStable(cluster.probeOrder, func(i, j int) bool {
+				return !picked[cluster.probeOrder[i]] && picked[cluster.probeOrder[j]]
+			})
+			cluster.probeIndex = 0
+		}
+		identifier := cluster.probeOrder[cluster.probeIndex]
+		cluster.probeIndex++
+		// members that failed or left since the shuffle are skipped
+		if member := cluster.members[identifier]; member != nil && !picked[identifier] {
+			picked[identifier] = true
+			targets = append(targets, member)
+		}
+	}
+	<-cluster.membersLock //release token
+	// a distant member is still probed once per pass, only later within its period
+	sort.SliceStable(targets, func(i, j int) bool {
+		roundTripI, knownI := roundTrips[targets[i].Identifier()]
+		roundTripJ, knownJ := roundTrips[targets[j].Identifier()]
+		if knownI && knownJ {
+			return roundTripI < roundTripJ
+		}
+		return knownI && !knownJ
+	})
+	cluster.memberShortList = make(map[string]*api.Member, len(targets))
+	for _, member := range targets {
+		cluster.memberShortList[member.Identifier()] = member
+	}
+	<-cluster.memberShortListLock
+	return targets
+}
+
+// memberProbeInterval is how often we expect to probe a single member: once every pass through all members
+func (s *MembershipServiceContext) memberProbeInterval(membershipConfig config.MembershipConfig) time.Duration {
+	cluster := s.cluster()
+	cluster.membersLock <- struct{}{} //acquire token
+	members := len(cluster.members)
+	<-cluster.membersLock //release token
+	periods := (members + membershipConfig.MaxShortListSize - 1) / membershipConfig.MaxShortListSize
+	if periods < 1 {
+		periods = 1
+	}
+	return membershipConfig.HeartbeatInterval * time.Duration(periods)
+}
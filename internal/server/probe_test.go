@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"testing"
+	"time"
+)
+
+func newProbeTestContext(members int) *MembershipServiceContext {
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default()}
+	cluster := serviceContext.cluster()
+	for i := 0; i < members; i++ {
+		member := newTestMember(fmt.Sprintf("Node%d", i), "Boreas", fmt.Sprintf("10.0.0.%d", i+1), "7777")
+		cluster.members[member.Identifier()] = member
+	}
+	return serviceContext
+}
+
+func TestMembershipServiceContext_NextProbeTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		members int
+		count   int
+		periods int
+		// wantProbes is how often every member is probed over the periods, at least and at most
+		wantMin int
+		wantMax int
+	}{
+		{"NoMembers", 0, 3, 4, 0, 0},
+		{"FewerMembersThanCount", 2, 3, 4, 4, 4},
+		{"OnePerPeriod", 5, 1, 10, 2, 2},
+		{"SeveralPerPeriod", 9, 3, 9, 3, 3},
+		// a pass of 7 members takes 2 periods and a bit, the periods end halfway a pass
+		{"PassesAcrossPeriods", 7, 3, 7, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := newProbeTestContext(tt.members)
+			probes := make(map[string]int)
+			for period := 0; period < tt.periods; period++ {
+				targets := serviceContext.nextProbeTargets(tt.count)
+				picked := make(map[string]bool)
+				for _, target := range targets {
+					if picked[target.Identifier()] {
+						t.Errorf("period %d probes %s more than once", period, target.Identifier())
+					}
+					picked[target.Identifier()] = true
+					probes[target.Identifier()]++
+				}
+				wantTargets := tt.count
+				if tt.members < wantTargets {
+					wantTargets = tt.members
+				}
+				if len(targets) != wantTargets {
+					t.Errorf("period %d probes %d members, want %d", period, len(targets), wantTargets)
+				}
+				if shortList := serviceContext.shortListSnapshot(); len(shortList) != len(targets) {
+					t.Errorf("period %d short list has %d members, want the %d targets", period, len(shortList), len(targets))
+				}
+			}
+			for identifier := range serviceContext.cluster().members {
+				if probes[identifier] < tt.wantMin || probes[identifier] > tt.wantMax {
+					t.Errorf("%s is probed %d times, want %d to %d", identifier, probes[identifier], tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func TestMembershipServiceContext_NextProbeTargetsSkipsRemovedMembers(t *testing.T) {
+	serviceContext := newProbeTestContext(4)
+	first := serviceContext.nextProbeTargets(1)[0]
+	serviceContext.RemoveMember(first.Identifier())
+	for period := 0; period < 6; period++ {
+		for _, target := range serviceContext.nextProbeTargets(1) {
+			if target.Identifier() == first.Identifier() {
+				t.Fatalf("period %d probes %s, which was removed", period, target.Identifier())
+			}
+		}
+	}
+}
+
+func TestMembershipServiceContext_NextProbeTargetsNearestFirst(t *testing.T) {
+	serviceContext := newProbeTestContext(4)
+	// the others are 30ms, 10ms and 20ms away, Node3 did not send us a coordinate so it goes after them
+	roundTrips := map[string]float64{"Node0@Boreas": 0.03, "Node1@Boreas": 0.01, "Node2@Boreas": 0.02, "Node3@Boreas": 1}
+	for identifier, distance := range roundTrips {
+		if identifier == "Node3@Boreas" {
+			continue
+		}
+		memberCoordinate := &api.Coordinate{Vec: make([]float64, api.CoordinateDimensionality)}
+		memberCoordinate.Vec[0] = distance
+		serviceContext.recordCoordinate(identifier, memberCoordinate)
+	}
+	// the pass itself stays shuffled, so the farthest members are not always probed last
+	firstPeriods := make(map[string]bool)
+	for pass := 0; pass < 10; pass++ {
+		probed := make(map[string]int)
+		for period := 0; period < 2; period++ {
+			targets := serviceContext.nextProbeTargets(2)
+			if len(targets) != 2 {
+				t.Fatalf("pass %d period %d probes %v, want 2 members", pass, period, targets)
+			}
+			if roundTrips[targets[0].Identifier()] > roundTrips[targets[1].Identifier()] {
+				t.Errorf("pass %d period %d probes %v, want the nearest first", pass, period, targets)
+			}
+			if period == 0 {
+				firstPeriods[targets[0].Identifier()+","+targets[1].Identifier()] = true
+			}
+			for _, target := range targets {
+				probed[target.Identifier()]++
+			}
+		}
+		for identifier := range roundTrips {
+			if probed[identifier] != 1 {
+				t.Errorf("pass %d probes %s %d times, want once", pass, identifier, probed[identifier])
+			}
+		}
+	}
+	if len(firstPeriods) < 2 {
+		t.Errorf("every pass starts with %v, want a shuffled order", firstPeriods)
+	}
+}
+
+func TestMembershipServiceContext_MemberProbeInterval(t *testing.T) {
+	tests := []struct {
+		name    string
+		members int
+		want    time.Duration
+	}{
+		{"NoMembers", 0, config.DefaultHeartbeatInterval},
+		{"OnePass", 3, config.DefaultHeartbeatInterval},
+		{"TwoPasses", 4, 2 * config.DefaultHeartbeatInterval},
+		{"ManyMembers", 30, 10 * config.DefaultHeartbeatInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceContext := newProbeTestContext(tt.members)
+			if got := serviceContext.memberProbeInterval(serviceContext.membershipConfig()); got != tt.want {
+				t.Errorf("memberProbeInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
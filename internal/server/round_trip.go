@@ -0,0 +1,96 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/internal/config"
+	"sort"
+	"time"
+)
+
+// roundTripWindowSize is how many of the latest round trip times of a member we keep
+const roundTripWindowSize = 100
+
+// probeTimeoutRoundTrips is how many times the 99th percentile of its round trips we wait for the response of a member
+const probeTimeoutRoundTrips = 3
+
+// roundTrips holds the latest round trip times of the heartbeats of a member
+type roundTrips struct {
+	samples []time.Duration
+}
+
+// add records a round trip time, and forgets the oldest ones so no more than roundTripWindowSize are kept
+func (r *roundTrips) add(roundTrip time.Duration) {
+	r.samples = append(r.samples, roundTrip)
+	if len(r.samples) > roundTripWindowSize {
+		r.samples = append(r.samples[:0], r.samples[len(r.samples)-roundTripWindowSize:]...)
+	}
+}
+
+// statistics returns the shortest, average and 99th percentile round trip time, which are 0 without samples
+func (r *roundTrips) statistics() (min time.Duration, avg time.Duration, p99 time.Duration) {
+	if len(r.samples) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]time.Duration{}, r.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+	// the nearest rank, the smallest sample that at least 99% of the samples are not larger than
+	rank := (99*len(sorted) + 99) / 100
+	return sorted[0], total / time.Duration(len(sorted)), sorted[rank-1]
+}
+
+// probeTimeout is how long we wait for the response to a heartbeat request: a few times the round trips we see,
+// at least membership.probeTimeout, and no longer than the heartbeat interval, when we send the next request.
+// While we probe slower, by the local health multiplier, we wait as much longer.
+func (r *roundTrips) probeTimeout(membershipConfig config.MembershipConfig, localHealthMultiplier int) time.Duration {
+	timeout := membershipConfig.ProbeTimeout
+	if _, _, p99 := r.statistics(); probeTimeoutRoundTrips*p99 > timeout {
+		timeout = probeTimeoutRoundTrips * p99
+	}
+	if timeout > membershipConfig.HeartbeatInterval {
+		timeout = membershipConfig.HeartbeatInterval
+	}
+	return timeout * time.Duration(localHealthMultiplier)
+}
+
+// request numbers a heartbeat request we are about to send, so we can tell which request a response answers
+func (t *heartbeatResponseTracker) request(now time.Time) uint32 {
+	if t.pending == nil {
+		t.pending = make(map[uint32]time.Time)
+	}
+	t.Sequence++
+	t.pending[t.Sequence] = now
+	t.LastRequest = now
+	return t.Sequence
+}
+
+// expire forgets the requests that were not answered within the timeout, and returns how many there were
+func (t *heartbeatResponseTracker) expire(now time.Time, timeout time.Duration) int {
+	expired := 0
+	for sequence, sent := range t.pending {
+		if now.Sub(sent) > timeout {
+			delete(t.pending, sequence)
+			expired++
+		}
+	}
+	return expired
+}
+
+// answer matches a response to the request with the sequence number, and returns how long the round trip took.
+// It is false for a late response, to a request that timed out, or for one that answers no request we sent,
+// which says nothing about whether the member still responds in time.
+func (t *heartbeatResponseTracker) answer(now time.Time, sequence uint32, timeout time.Duration) (time.Duration, bool) {
+	sent, ok := t.pending[sequence]
+	if !ok {
+		return 0, false
+	}
+	delete(t.pending, sequence)
+	roundTrip := now.Sub(sent)
+	if roundTrip > timeout {
+		return 0, false
+	}
+	t.roundTrips.add(roundTrip)
+	return roundTrip, true
+}
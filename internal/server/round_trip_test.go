@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"testing"
+	"time"
+)
+
+func TestRoundTrips_Statistics(t *testing.T) {
+	milliseconds := func(values ...int) []time.Duration {
+		durations := make([]time.Duration, len(values))
+		for i, value := range values {
+			durations[i] = time.Duration(value) * time.Millisecond
+		}
+		return durations
+	}
+	hundredAndOne := make([]int, 101)
+	for i := range hundredAndOne {
+		hundredAndOne[i] = i + 1
+	}
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		wantMin time.Duration
+		wantAvg time.Duration
+		wantP99 time.Duration
+	}{
+		{"NoSamples", nil, 0, 0, 0},
+		{"OneSample", milliseconds(20), 20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond},
+		{"Unsorted", milliseconds(30, 10, 20), 10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond},
+		// the first sample is forgotten, so of 2 to 101 the 99th percentile is the 99th sample
+		{"FullWindow", milliseconds(hundredAndOne...), 2 * time.Millisecond, 51500 * time.Microsecond, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roundTrips roundTrips
+			for _, sample := range tt.samples {
+				roundTrips.add(sample)
+			}
+			min, avg, p99 := roundTrips.statistics()
+			if min != tt.wantMin || avg != tt.wantAvg || p99 != tt.wantP99 {
+				t.Errorf("statistics() = %v, %v, %v, want %v, %v, %v", min, avg, p99, tt.wantMin, tt.wantAvg, tt.wantP99)
+			}
+		})
+	}
+}
+
+func TestRoundTrips_ProbeTimeout(t *testing.T) {
+	membershipConfig := config.Default().Membership
+	tests := []struct {
+		name                  string
+		roundTrip             time.Duration
+		localHealthMultiplier int
+		want                  time.Duration
+	}{
+		{"NoSamples", 0, 1, config.DefaultProbeTimeout},
+		{"FastMember", 10 * time.Millisecond, 1, config.DefaultProbeTimeout},
+		{"SlowMember", 400 * time.Millisecond, 1, 1200 * time.Millisecond},
+		{"HeartbeatInterval", 3 * time.Second, 1, config.DefaultHeartbeatInterval},
+		{"Unhealthy", 400 * time.Millisecond, 3, 3600 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var roundTrips roundTrips
+			if tt.roundTrip > 0 {
+				roundTrips.add(tt.roundTrip)
+			}
+			if got := roundTrips.probeTimeout(membershipConfig, tt.localHealthMultiplier); got != tt.want {
+				t.Errorf("probeTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeartbeatResponseTracker_Answer(t *testing.T) {
+	sent := time.Now()
+	timeout := 2 * time.Second
+	tests := []struct {
+		name      string
+		sequence  uint32
+		after     time.Duration
+		answered  bool
+		roundTrip time.Duration
+	}{
+		{"InTime", 2, 100 * time.Millisecond, true, 100 * time.Millisecond},
+		{"PreviousRequest", 1, 100 * time.Millisecond, true, 1100 * time.Millisecond},
+		{"Late", 2, 3 * time.Second, false, 0},
+		{"NotSent", 3, 100 * time.Millisecond, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tracker heartbeatResponseTracker
+			tracker.request(sent.Add(-time.Second))
+			tracker.request(sent)
+			roundTrip, answered := tracker.answer(sent.Add(tt.after), tt.sequence, timeout)
+			if answered != tt.answered || roundTrip != tt.roundTrip {
+				t.Errorf("answer(%d) = %v, %v, want %v, %v", tt.sequence, roundTrip, answered, tt.roundTrip, tt.answered)
+			}
+			if _, again := tracker.answer(sent.Add(tt.after), tt.sequence, timeout); again {
+				t.Errorf("answer(%d) matched the same request twice", tt.sequence)
+			}
+		})
+	}
+}
+
+func TestHeartbeatResponseTracker_Expire(t *testing.T) {
+	sent := time.Now()
+	var tracker heartbeatResponseTracker
+	tracker.request(sent.Add(-2 * time.Second))
+	tracker.request(sent)
+	if expired := tracker.expire(sent.Add(500*time.Millisecond), time.Second); expired != 1 {
+		t.Errorf("expire() = %d, want the 1 request older than the timeout", expired)
+	}
+	if len(tracker.pending) != 1 {
+		t.Errorf("%d requests pending after expire, want 1", len(tracker.pending))
+	}
+}
+
+func TestMembershipServiceContext_LateResponse(t *testing.T) {
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default()}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	tracker := &heartbeatResponseTracker{MissedResponsesCounter: 3, LastResponse: NoResponseTime, TrackingSince: time.Now()}
+	// the request timed out long ago, only now its response arrives
+	sequence := tracker.request(time.Now().Add(-time.Minute))
+	cluster.heartbeatResponses[alan.Identifier()] = tracker
+
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), alan, sequence, true, nil)
+	if tracker.MissedResponsesCounter != 3 || tracker.LastResponse != NoResponseTime {
+		t.Errorf("late response reset the tracker to %d missed responses, last response %v", tracker.MissedResponsesCounter, tracker.LastResponse)
+	}
+	if tracker.LateResponses != 1 {
+		t.Errorf("LateResponses = %d, want 1", tracker.LateResponses)
+	}
+
+	sequence = serviceContext.HandleHeartbeatResponseTracking(context.Background(), logging.Nop(), alan)
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), alan, sequence, true, nil)
+	if tracker.MissedResponsesCounter != 0 || len(tracker.roundTrips.samples) != 1 {
+		t.Errorf("response in time left %d missed responses and %d round trips, want 0 and 1", tracker.MissedResponsesCounter, len(tracker.roundTrips.samples))
+	}
+}
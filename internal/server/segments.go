@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
+)
+
+// A member only keeps track of the members in its segment, so it only probes them, and only gossips to them.
+// Gateways keep track of the gateways of the other segments as well, so the traffic between segments is between gateways.
+
+// admits reports whether we keep track of the member: the members of our segment, and as a gateway, the gateways of every segment
+func (s *MembershipServiceContext) admits(member *api.Member) bool {
+	if s.Self == nil || member.Segment == s.Self.Segment {
+		return true
+	}
+	return s.Self.Gateway && member.Gateway
+}
+
+// otherSegment reports whether the member is in a segment we do not keep track of,
+// a member we did keep track of that moved there leaves, as far as we are concerned
+func (s *MembershipServiceContext) otherSegment(logger *logging.Logger, member *api.Member) bool {
+	if s.admits(member) {
+		return false
+	}
+	cluster := s.cluster()
+	cluster.membersLock <- struct{}{} //acquire token
+	known := cluster.members[member.Identifier()] != nil
+	<-cluster.membersLock //release token
+	if !known {
+		s.recordMessageRejected(rejectedOtherSegment)
+		logger.Every(repeatedLogInterval).Debug("Ignoring member of another segment", "member", member.Identifier(), "segment", member.Segment)
+		return true
+	}
+	logger.Info("Member moved to another segment", "member", member.Identifier(), "name", member.Name(), "segment", member.Segment)
+	leftMember, newlyLeft, _ := s.markLeft(member)
+	s.forgetCoordinate(member.Identifier())
+	if newlyLeft {
+		s.publishEvent(api.MemberEventLeave, leftMember, api.MemberStateLeft)
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+)
+
+func TestMembershipServiceContext_OtherSegment(t *testing.T) {
+	tests := []struct {
+		name          string
+		selfGateway   bool
+		segment       string
+		gateway       bool
+		known         bool
+		wantOther     bool
+		wantLeftEvent bool
+	}{
+		{"SameSegment", false, "east", false, false, false, false},
+		{"OtherSegment", false, "west", false, false, true, false},
+		{"OtherSegmentGateway", false, "west", true, false, true, false},
+		{"GatewayOtherSegment", true, "west", false, false, true, false},
+		{"GatewaysOfOtherSegments", true, "west", true, false, false, false},
+		{"DefaultSegment", false, "", false, false, true, false},
+		{"MovedToOtherSegment", false, "west", false, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+			self.Segment = "east"
+			self.Gateway = tt.selfGateway
+			serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default(), Self: self, Identity: self.Identifier()}
+			subscription := serviceContext.SubscribeToEvents()
+			member := newTestMember("Alan", "Boreas", "127.0.0.2", "7780")
+			if tt.known {
+				serviceContext.cluster().members[member.Identifier()] = member
+			}
+			member = newTestMember("Alan", "Boreas", "127.0.0.2", "7780")
+			member.Segment = tt.segment
+			member.Gateway = tt.gateway
+			rejectedBefore := testutil.ToFloat64(messagesRejected.WithLabelValues(PoolLAN, rejectedOtherSegment))
+
+			if got := serviceContext.otherSegment(logging.Nop(), member); got != tt.wantOther {
+				t.Errorf("otherSegment() = %v, want %v", got, tt.wantOther)
+			}
+			select {
+			case event := <-subscription:
+				if !tt.wantLeftEvent || event.Type != api.MemberEventLeave {
+					t.Errorf("received event %+v, want none", event)
+				}
+			default:
+				if tt.wantLeftEvent {
+					t.Error("received no leave event")
+				}
+			}
+			wantRejected := 0.0
+			if tt.wantOther && !tt.known {
+				wantRejected = 1
+			}
+			if rejected := testutil.ToFloat64(messagesRejected.WithLabelValues(PoolLAN, rejectedOtherSegment)) - rejectedBefore; rejected != wantRejected {
+				t.Errorf("messages rejected for their segment increased by %v, want %v", rejected, wantRejected)
+			}
+			if tt.known && tt.wantOther && !serviceContext.hasLeft(member.Identifier()) {
+				t.Errorf("member that moved to segment %q did not leave", tt.segment)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/transport"
+	"net"
+	"testing"
+	"time"
+)
+
+// simulationStep is how far the fake clock moves at a time, the services get to run in between
+const simulationStep = 100 * time.Millisecond
+
+// simulatedServices are the services of boom-server, without the management API
+var simulatedServices = []MembershipService{
+	HandleClockUpdates,
+	HandleMember,
+	CleanupMembers,
+	HeartbeatCloseMembers,
+	MulticastExistence,
+	StartMembershipServer,
+	ListenForMulticast,
+}
+
+// simulatedTickers is how many tickers the services of a single node start
+const simulatedTickers = 3
+
+type simulation struct {
+	t         *testing.T
+	clock     *clock.Fake
+	network   *transport.Memory
+	nodes     []*MembershipServiceContext
+	addresses []net.IP
+}
+
+func newSimulation(t *testing.T, size int) *simulation {
+	t.Helper()
+	return newSegmentedSimulation(t, make([]string, size), nil)
+}
+
+// newSegmentedSimulation simulates a node in each of the segments, the gateways are the nodes at those indexes
+func newSegmentedSimulation(t *testing.T, segments []string, gateways []int) *simulation {
+	t.Helper()
+	size := len(segments)
+	fakeClock := clock.NewFake(time.Date(2022, 9, 13, 23, 25, 38, 0, time.UTC))
+	s := &simulation{t: t, clock: fakeClock, network: transport.NewMemory(fakeClock, 1)}
+	for i := 0; i < size; i++ {
+		ip := net.IPv4(10, 0, 0, byte(i+1))
+		self := newTestMember(fmt.Sprintf("Node%d", i), "sim", ip.String(), "7777")
+		self.Segment = segments[i]
+		for _, gateway := range gateways {
+			self.Gateway = self.Gateway || gateway == i
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		node := &MembershipServiceContext{
+			Context:           ctx,
+			Config:            config.Default(),
+			Self:              self,
+			Identity:          self.Identifier(),
+			HelloMessage:      api.HelloMessage.CreateMemberMessage(self),
+			GoodbyeMessage:    api.GoodbyeMessage.CreateMemberMessage(self),
+			GoodbyeAck:        api.GoodbyeAckMessage.CreateMemberMessage(self),
+			HeartbeatRequest:  api.HeartbeatRequestMessage.CreateMemberMessage(self),
+			HeartbeatResponse: api.HeartbeatResponseMessage.CreateMemberMessage(self),
+			ServerPort:        self.PortSelf,
+			Shutdown:          cancel,
+			Clock:             fakeClock,
+			Network:           s.network.Host(ip),
+		}
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			Supervise(node, simulatedServices)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-stopped
+		})
+		s.nodes = append(s.nodes, node)
+		s.addresses = append(s.addresses, ip)
+	}
+	// the services have to start their tickers, before time can move
+	deadline := time.Now().Add(5 * time.Second)
+	for fakeClock.Pending() < size*simulatedTickers {
+		if time.Now().After(deadline) {
+			t.Fatalf("the services started %d tickers, want %d", fakeClock.Pending(), size*simulatedTickers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return s
+}
+
+// run moves the fake clock forward, a step at a time, so the services can keep up
+func (s *simulation) run(d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += simulationStep {
+		s.clock.Advance(simulationStep)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// states returns the state of every member the node knows, by the index of the node
+func (s *simulation) states(node int) map[int]api.MemberState {
+	states := make(map[int]api.MemberState)
+	for _, memberInfo := range s.nodes[node].MemberInfoSnapshot() {
+		for i, other := range s.nodes {
+			if other.Identity == memberInfo.ID {
+				states[i] = memberInfo.State
+			}
+		}
+	}
+	return states
+}
+
+// requireAlive verifies every node sees exactly the nodes it should see as alive
+func (s *simulation) requireAlive(wantAlive map[int][]int) {
+	s.t.Helper()
+	for node, alive := range wantAlive {
+		states := s.states(node)
+		aliveCount := 0
+		for _, state := range states {
+			if state == api.MemberStateAlive {
+				aliveCount++
+			}
+		}
+		for _, other := range alive {
+			if states[other] != api.MemberStateAlive {
+				s.t.Errorf("node %d sees node %d as %q, want alive, all states: %v", node, other, states[other], states)
+			}
+		}
+		if aliveCount != len(alive) {
+			s.t.Errorf("node %d sees %d members alive, want %v, all states: %v", node, aliveCount, alive, states)
+		}
+	}
+}
+
+func TestSimulation_JoinFailAndRecover(t *testing.T) {
+	s := newSimulation(t, 3)
+
+	s.run(config.DefaultMulticastInterval + time.Second)
+	s.requireAlive(map[int][]int{0: {1, 2}, 1: {0, 2}, 2: {0, 1}})
+
+	// node 2 cannot be reached, so the others stop seeing it as alive once it missed enough heartbeats
+	s.network.Partition([]net.IP{s.addresses[2]})
+	s.run(config.DefaultCleanupTimeout + config.DefaultCleanupInterval)
+	s.requireAlive(map[int][]int{0: {1}, 1: {0}, 2: {}})
+
+	// once it can be reached again, its next hello brings it back
+	s.network.Heal()
+	s.run(config.DefaultMulticastInterval + time.Second)
+	s.requireAlive(map[int][]int{0: {1, 2}, 1: {0, 2}, 2: {0, 1}})
+}
+
+func TestSimulation_LossyNetwork(t *testing.T) {
+	s := newSimulation(t, 3)
+	s.network.SetConditions(transport.Conditions{
+		Latency:     20 * time.Millisecond,
+		Jitter:      30 * time.Millisecond,
+		Loss:        0.05,
+		Duplication: 0.05,
+		Reordering:  0.1,
+	})
+
+	// a multicast hello can get lost, the next one makes up for it
+	s.run(2*config.DefaultMulticastInterval + time.Second)
+	s.requireAlive(map[int][]int{0: {1, 2}, 1: {0, 2}, 2: {0, 1}})
+	for i, ip := range s.addresses {
+		if stats := s.network.Stats(ip); stats.Sent == 0 || stats.Received == 0 {
+			t.Errorf("node %d sent %d and received %d datagrams, want both", i, stats.Sent, stats.Received)
+		}
+	}
+}
+
+func TestSimulation_Segments(t *testing.T) {
+	s := newSegmentedSimulation(t, []string{"east", "east", "west", "west"}, []int{0, 2})
+
+	// every node hears every multicast hello, but only keeps track of its own segment, and the gateways of each other
+	s.run(config.DefaultMulticastInterval + time.Second)
+	s.requireAlive(map[int][]int{0: {1, 2}, 1: {0}, 2: {0, 3}, 3: {2}})
+
+	// the other gateway gossips the failure of a gateway to its segment, whose members do not start keeping track of it
+	s.network.Partition([]net.IP{s.addresses[2]})
+	s.run(config.DefaultCleanupTimeout + config.DefaultCleanupInterval)
+	s.requireAlive(map[int][]int{0: {1}, 1: {0}})
+	if state, known := s.states(1)[2]; known {
+		t.Errorf("node 1 sees the gateway of another segment as %q, want it to not know it", state)
+	}
+}
+
+// joinWANPool lets the node join the WAN pool as the server of its datacenter, announcing itself to the seeds
+func (s *simulation) joinWANPool(node int, datacenter string, seeds ...int) *MembershipServiceContext {
+	s.t.Helper()
+	nodeConfig := *s.nodes[node].CurrentConfig()
+	nodeConfig.Datacenter = datacenter
+	nodeConfig.WAN.Enabled = true
+	nodeConfig.WAN.Seeds = nil
+	for _, seed := range seeds {
+		nodeConfig.WAN.Seeds = append(nodeConfig.WAN.Seeds, net.JoinHostPort(s.addresses[seed].String(), nodeConfig.WAN.Port))
+	}
+	s.nodes[node].UpdateConfig(&nodeConfig)
+	pool := NewWANPool(s.nodes[node])
+	pending := s.clock.Pending()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		Supervise(pool, WANPoolServices)
+	}()
+	s.t.Cleanup(func() {
+		s.nodes[node].Shutdown()
+		<-stopped
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for s.clock.Pending() < pending+simulatedTickers {
+		if time.Now().After(deadline) {
+			s.t.Fatalf("the services of the WAN pool started %d tickers, want %d", s.clock.Pending()-pending, simulatedTickers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return pool
+}
+
+func TestSimulation_WANPool(t *testing.T) {
+	// each segment stands in for the cluster of a datacenter, node 0 and 2 are their servers in the WAN pool
+	s := newSegmentedSimulation(t, []string{"east", "east", "west", "west"}, nil)
+	east := s.joinWANPool(0, "east", 2)
+	west := s.joinWANPool(2, "west", 0)
+
+	s.run(config.DefaultMulticastInterval + time.Second)
+	s.requireAlive(map[int][]int{0: {1}, 1: {0}, 2: {3}, 3: {2}})
+	for _, tt := range []struct {
+		pool *MembershipServiceContext
+		want string
+	}{{east, "west"}, {west, "east"}} {
+		servers := tt.pool.datacenterServers(tt.want)
+		if len(servers) != 1 || servers[0].Port != config.DefaultWANPort {
+			t.Errorf("the WAN pool of %s has servers %v in %s, want the other one", tt.pool.datacenter(), servers, tt.want)
+		}
+	}
+
+	// the WAN pool has its own timeouts, once they pass the datacenter that cannot be reached has no server left
+	s.network.Partition([]net.IP{s.addresses[2]})
+	s.run(config.DefaultWANCleanupTimeout + config.DefaultCleanupInterval)
+	if servers := east.datacenterServers("west"); len(servers) != 0 {
+		t.Errorf("the WAN pool of east has servers %v in west, want none", servers)
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"time"
+)
+
+// supervisorPolicy decides how the supervisor restarts services that fail
+type supervisorPolicy struct {
+	// restartBackoff is how long we wait before the first restart, it doubles with every failure in a row
+	restartBackoff    time.Duration
+	maxRestartBackoff time.Duration
+	// maxFailures in a row make the supervisor give up on the service, and shut the node down
+	maxFailures int
+	// stableAfter is how long a service has to run for its failures in a row to be forgotten
+	stableAfter time.Duration
+	// stopTimeout is how long we wait for a service to stop, before we stop the next one anyway
+	stopTimeout time.Duration
+}
+
+var defaultSupervisorPolicy = supervisorPolicy{
+	restartBackoff:    time.Second,
+	maxRestartBackoff: 30 * time.Second,
+	maxFailures:       5,
+	stableAfter:       time.Minute,
+	stopTimeout:       5 * time.Second,
+}
+
+// backoff is how long to wait before restarting a service that failed this many times in a row
+func (p supervisorPolicy) backoff(failures int) time.Duration {
+	backoff := p.restartBackoff
+	for i := 1; i < failures && backoff < p.maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.maxRestartBackoff {
+		return p.maxRestartBackoff
+	}
+	return backoff
+}
+
+// supervisedService is a service the supervisor runs in a context of its own, so it can be stopped on its own
+type supervisedService struct {
+	name   string
+	stop   context.CancelFunc
+	exited chan struct{}
+}
+
+// Supervise runs the services until the node shuts down, restarting those that fail.
+// When a service keeps failing, it shuts the node down and returns why.
+// The services are stopped one by one, in the reverse order they are listed,
+// so start those that consume what others produce first.
+func Supervise(serviceContext *MembershipServiceContext, services []MembershipService) error {
+	return supervise(serviceContext, services, defaultSupervisorPolicy)
+}
+
+func supervise(serviceContext *MembershipServiceContext, services []MembershipService, policy supervisorPolicy) error {
+	logger := serviceContext.Log().With("service", "Supervisor")
+	escalations := make(chan error, len(services))
+	supervisedServices := make([]supervisedService, 0, len(services))
+	for _, service := range services {
+		ctx, stop := context.WithCancel(context.Background())
+		supervised := supervisedService{name: ServiceName(service), stop: stop, exited: make(chan struct{})}
+		supervisedServices = append(supervisedServices, supervised)
+		go func(service MembershipService) {
+			defer close(supervised.exited)
+			superviseService(serviceContext.withContext(ctx), supervised.name, service, policy, escalations)
+		}(service)
+	}
+
+	var escalation error
+	select {
+	case <-serviceContext.Done():
+	case escalation = <-escalations:
+		logger.Error("Shutting down, as a service keeps failing", "error", escalation)
+		serviceContext.shutdown()
+	}
+
+	for i := len(supervisedServices) - 1; i >= 0; i-- {
+		supervised := supervisedServices[i]
+		supervised.stop()
+		stopTimeout := serviceContext.clock().NewTimer(policy.stopTimeout)
+		select {
+		case <-supervised.exited:
+			logger.Debug("Service has stopped", "name", supervised.name)
+		case <-stopTimeout.C():
+			logger.Warn("Service did not stop in time, stopping the next one", "name", supervised.name, "timeout", policy.stopTimeout)
+		}
+		stopTimeout.Stop()
+	}
+	return escalation
+}
+
+// superviseService runs the service until its context is done, restarting it when it fails
+func superviseService(serviceContext *MembershipServiceContext, serviceName string, service MembershipService, policy supervisorPolicy, escalations chan<- error) {
+	logger := serviceContext.Log().With("service", "Supervisor", "name", serviceName)
+	failures := 0
+	for {
+		serviceContext.setServiceState(serviceName, api.ServiceStateRunning, nil)
+		started := serviceContext.clock().Now()
+		err := service(serviceContext)
+		if serviceContext.Err() != nil {
+			if err != nil {
+				logger.Warn("Service failed while stopping", "error", err)
+			}
+			serviceContext.setServiceState(serviceName, api.ServiceStateStopped, err)
+			return
+		}
+		if err == nil {
+			err = errors.New("service returned before it was stopped")
+		}
+
+		if serviceContext.clock().Now().Sub(started) >= policy.stableAfter {
+			failures = 0
+		}
+		failures++
+		if failures >= policy.maxFailures {
+			serviceContext.setServiceState(serviceName, api.ServiceStateFailed, err)
+			escalations <- fmt.Errorf("service %s failed %d times in a row: %w", serviceName, failures, err)
+			return
+		}
+		backoff := policy.backoff(failures)
+		serviceContext.setServiceState(serviceName, api.ServiceStateRestarting, err)
+		logger.Warn("Service failed, restarting it", "failures", failures, "backoff", backoff, "error", err)
+		restart := serviceContext.clock().NewTimer(backoff)
+		select {
+		case <-restart.C():
+		case <-serviceContext.Done():
+			restart.Stop()
+			serviceContext.setServiceState(serviceName, api.ServiceStateStopped, nil)
+			return
+		}
+	}
+}
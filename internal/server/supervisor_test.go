@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testSupervisorPolicy = supervisorPolicy{
+	restartBackoff:    time.Millisecond,
+	maxRestartBackoff: 4 * time.Millisecond,
+	maxFailures:       3,
+	stableAfter:       time.Minute,
+	stopTimeout:       time.Second,
+}
+
+func TestSupervisorPolicy_Backoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 6, want: 30 * time.Second},
+		{failures: 100, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := defaultSupervisorPolicy.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func failingService(*MembershipServiceContext) error {
+	return errors.New("no route to host")
+}
+
+func TestSupervise_Escalates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceContext := &MembershipServiceContext{Context: ctx, Shutdown: cancel}
+	stopped := make(chan struct{})
+	waitingService := func(serviceContext *MembershipServiceContext) error {
+		<-serviceContext.Done()
+		close(stopped)
+		return nil
+	}
+
+	err := supervise(serviceContext, []MembershipService{waitingService, failingService}, testSupervisorPolicy)
+
+	if err == nil {
+		t.Fatal("supervise() of a service that keeps failing did not return an error")
+	}
+	if ctx.Err() == nil {
+		t.Error("supervise() did not shut the node down")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("supervise() returned before stopping the other services")
+	}
+	status := serviceContext.Health().Services["failingService"]
+	want := api.ServiceStatus{State: api.ServiceStateFailed, Restarts: testSupervisorPolicy.maxFailures - 1, LastError: "no route to host"}
+	if status != want {
+		t.Errorf("status of failingService = %+v, want %+v", status, want)
+	}
+}
+
+func TestSupervise_RestartsAndStopsInReverseOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	serviceContext := &MembershipServiceContext{Context: ctx, Shutdown: cancel}
+	var lock sync.Mutex
+	var stopOrder []string
+	failedOnce := false
+	restarted := make(chan struct{})
+	newService := func(serviceName string) MembershipService {
+		return func(serviceContext *MembershipServiceContext) error {
+			lock.Lock()
+			if serviceName == "handler" && !failedOnce {
+				failedOnce = true
+				lock.Unlock()
+				return errors.New("could not listen")
+			}
+			if serviceName == "handler" {
+				close(restarted)
+			}
+			lock.Unlock()
+
+			<-serviceContext.Done()
+			lock.Lock()
+			defer lock.Unlock()
+			stopOrder = append(stopOrder, serviceName)
+			return nil
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- supervise(serviceContext, []MembershipService{newService("api"), newService("handler"), newService("listener")}, testSupervisorPolicy)
+	}()
+	<-restarted
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("supervise() = %v, want no error as the node was shut down", err)
+	}
+
+	want := []string{"listener", "handler", "api"}
+	if len(stopOrder) != len(want) {
+		t.Fatalf("stopped %v, want %v", stopOrder, want)
+	}
+	for i := range want {
+		if stopOrder[i] != want[i] {
+			t.Errorf("stopped %v, want %v", stopOrder, want)
+			break
+		}
+	}
+}
+
+func TestSupervise_RestartsOnTheClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	serviceContext := &MembershipServiceContext{Context: ctx, Shutdown: cancel, Clock: fakeClock}
+	var calls int32
+	restarted := make(chan struct{})
+	service := func(serviceContext *MembershipServiceContext) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("could not listen")
+		}
+		close(restarted)
+		<-serviceContext.Done()
+		return nil
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- supervise(serviceContext, []MembershipService{service}, testSupervisorPolicy)
+	}()
+	for fakeClock.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-restarted:
+		t.Fatal("restarted the service before its backoff passed on the clock")
+	case <-time.After(10 * time.Millisecond):
+	}
+	fakeClock.Advance(testSupervisorPolicy.restartBackoff)
+	<-restarted
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("supervise() = %v, want no error as the node was shut down", err)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/logging"
+	"reflect"
+)
+
+// Our tags go along with every Hello we send. When they change, or our name does, we send our Hello to every member,
+// which replaces what they know of us, and the multicast carries them to those we do not know yet.
+
+// Tags returns a copy of the tags this node describes itself with
+func (s *MembershipServiceContext) Tags() map[string]string {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return copyTags(s.tags)
+}
+
+// SetTags replaces the tags of this node, and lets the members know, unless the tags are not valid
+func (s *MembershipServiceContext) SetTags(tags map[string]string) error {
+	if err := api.ValidateTags(tags); err != nil {
+		return err
+	}
+	root := s.root()
+	root.lock.Lock()
+	changed := !sameTags(root.tags, tags)
+	root.tags = copyTags(tags)
+	root.lock.Unlock()
+	if !changed {
+		return nil
+	}
+
+	logger := s.Log().With("service", "SetTags")
+	logger.Info("Tags changed, letting the members know", "tags", api.FormatTags(tags))
+	s.sendHelloToMembers(logger)
+	return nil
+}
+
+// sendHelloToMembers sends our Hello to every member, unless we are leaving
+func (s *MembershipServiceContext) sendHelloToMembers(logger *logging.Logger) {
+	if s.Leaving() {
+		return
+	}
+	helloMessage := s.helloMessage()
+	for _, member := range s.aliveMembersSnapshot() {
+		err := s.sendMessageToMember(context.Background(), logger, member, helloMessage, "hello")
+		if err != nil {
+			logger.Warn("Could not send hello", "member", member.Identifier(), "error", err)
+		}
+	}
+}
+
+// helloMessage returns our Hello, with our current tags
+func (s *MembershipServiceContext) helloMessage() []byte {
+	return api.WithTags(s.ownMessage(api.HelloMessage), s.Tags())
+}
+
+// sameTags treats no tags and empty tags alike
+func sameTags(tags map[string]string, other map[string]string) bool {
+	if len(tags) == 0 && len(other) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(tags, other)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for key, value := range tags {
+		copied[key] = value
+	}
+	return copied
+}
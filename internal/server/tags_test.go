@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMembershipServiceContext_SetTags(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	serviceContext := &MembershipServiceContext{
+		Context:      context.Background(),
+		Config:       config.Default(),
+		Self:         self,
+		Identity:     self.Identifier(),
+		HelloMessage: api.HelloMessage.CreateMemberMessage(self),
+		Logger:       logging.Nop(),
+	}
+	alan, alanConnection := newListeningMember(t, "Alan")
+	serviceContext.cluster().members[alan.Identifier()] = alan
+
+	tags := map[string]string{"zone": "eu-west-1a"}
+	if err := serviceContext.SetTags(tags); err != nil {
+		t.Fatal(err)
+	}
+	hello := requireMessageType(t, alanConnection, api.HelloMessage)
+	if !reflect.DeepEqual(hello.Tags, tags) {
+		t.Errorf("member received a hello with tags %v, want %v", hello.Tags, tags)
+	}
+
+	// changing the tags we were given does not change ours
+	tags["zone"] = "us-east-1a"
+	if got := serviceContext.Tags(); got["zone"] != "eu-west-1a" {
+		t.Errorf("Tags() = %v, want those we set", got)
+	}
+
+	if err := serviceContext.SetTags(map[string]string{"zone": "eu-west-1a"}); err != nil {
+		t.Fatal(err)
+	}
+	alanConnection.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := alanConnection.ReadFromUDP(make([]byte, 1024)); err == nil {
+		t.Error("member received a hello, but our tags did not change")
+	}
+
+	if err := serviceContext.SetTags(map[string]string{"zone=": "eu-west-1a"}); err == nil {
+		t.Error("SetTags() with an invalid key succeeded, want an error")
+	}
+	if got := serviceContext.Tags(); !reflect.DeepEqual(got, map[string]string{"zone": "eu-west-1a"}) {
+		t.Errorf("Tags() = %v after invalid tags, want the previous tags", got)
+	}
+}
+
+func TestHandleMember_TagsUpdated(t *testing.T) {
+	self := newTestMember("Self", "localhost", "127.0.0.1", "7777")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serviceContext := &MembershipServiceContext{
+		Context:  ctx,
+		Config:   config.Default(),
+		Self:     self,
+		Identity: self.Identifier(),
+		Logger:   logging.Nop(),
+	}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	alan.Tags = map[string]string{"zone": "eu-west-1a"}
+	cluster.members[alan.Identifier()] = alan
+	subscription := serviceContext.SubscribeToEvents()
+	go HandleMember(serviceContext)
+
+	tests := []struct {
+		name       string
+		tags       map[string]string
+		wantUpdate bool
+	}{
+		{"SameTags", map[string]string{"zone": "eu-west-1a"}, false},
+		{"OtherTags", map[string]string{"zone": "eu-west-1b"}, true},
+		{"NoTags", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hello := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+			hello.Tags = tt.tags
+			cluster.memberHello <- memberMessage{ctx: context.Background(), member: hello}
+			select {
+			case event := <-subscription:
+				if !tt.wantUpdate || event.Type != api.MemberEventUpdate || !reflect.DeepEqual(event.Member.Tags, tt.tags) {
+					t.Errorf("received event %s with tags %v, want an update: %v", event.Type, event.Member.Tags, tt.wantUpdate)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantUpdate {
+					t.Error("received no update event")
+				}
+			}
+		})
+	}
+}
+
+func TestHandleHeartbeatResponseTrackingUpdate_KeepsTags(t *testing.T) {
+	serviceContext := &MembershipServiceContext{Context: context.Background(), Config: config.Default()}
+	cluster := serviceContext.cluster()
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	alan.Tags = map[string]string{"zone": "eu-west-1a"}
+	cluster.memberFailList[alan.Identifier()] = alan
+	tracker := &heartbeatResponseTracker{LastResponse: NoResponseTime}
+	cluster.heartbeatResponses[alan.Identifier()] = tracker
+	sequence := tracker.request(time.Now())
+
+	response := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	serviceContext.HandleHeartbeatResponseTrackingUpdate(logging.Nop(), response, sequence, true, nil)
+	recovered := cluster.members[alan.Identifier()]
+	if recovered == nil || !reflect.DeepEqual(recovered.Tags, alan.Tags) {
+		t.Errorf("member recovered as %+v, want it to keep its tags %v", recovered, alan.Tags)
+	}
+}
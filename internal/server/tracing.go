@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// memberMessage is a member as read from a message, with the context it was received in,
+// so what we send because of it is part of the same trace
+type memberMessage struct {
+	ctx    context.Context
+	member *api.Member
+	// sequence is the sequence number of a heartbeat request, or the one its response echoes, if hasSequence
+	sequence    uint32
+	hasSequence bool
+	// coordinate is the network coordinate of the member that sent the message, if it sent one
+	coordinate *api.Coordinate
+	// nameConflict is who keeps the name of the member a NameConflict message is about, if hasNameConflict
+	nameConflict    api.NameConflict
+	hasNameConflict bool
+}
+
+// startSpan starts a span in the trace of the context, with a tracer a new trace is started if there is none
+func startSpan(ctx context.Context, tracer trace.Tracer, spanName string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tracer == nil {
+		tracer = trace.SpanFromContext(ctx).TracerProvider().Tracer(name)
+	}
+	return tracer.Start(ctx, spanName, trace.WithAttributes(attributes...))
+}
+
+// withTraceContext returns the message with the span as its trace context, if the span is part of a trace
+func withTraceContext(message []byte, spanContext trace.SpanContext) []byte {
+	if !spanContext.IsValid() {
+		return message
+	}
+	return api.WithTraceContext(message, api.TraceContext{
+		TraceID: spanContext.TraceID(),
+		SpanID:  spanContext.SpanID(),
+		Flags:   byte(spanContext.TraceFlags()),
+	})
+}
+
+// contextFromMessage returns the context with the span that sent the message as remote parent, if the message carries one
+func contextFromMessage(ctx context.Context, rawMessage []byte) context.Context {
+	traceContext, ok := api.ReadTraceContext(rawMessage)
+	if !ok {
+		return ctx
+	}
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceContext.TraceID,
+		SpanID:     traceContext.SpanID,
+		TraceFlags: trace.TraceFlags(traceContext.Flags),
+		Remote:     true,
+	})
+	if !spanContext.IsValid() {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, spanContext)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"github.com/joostvdg/boom/api"
+	"net"
+	"testing"
+	"time"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTestTracerProvider() (*tracesdk.TracerProvider, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	return tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)), recorder
+}
+
+func receiveDatagram(t *testing.T, receiver *net.UDPConn) []byte {
+	t.Helper()
+	if err := receiver.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 1024)
+	numberOfBytes, _, err := receiver.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer[0:numberOfBytes]
+}
+
+func TestSendMessageToAddress_TraceContext(t *testing.T) {
+	receiver, err := net.ListenUDP(api.MembershipNetwork, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer receiver.Close()
+	address := receiver.LocalAddr().(*net.UDPAddr)
+	message := api.HeartbeatRequestMessage.CreateMemberMessage(newTestMember("Alan", "Boreas", "10.0.0.1", "7780"))
+	tracerProvider, recorder := newTestTracerProvider()
+	serviceContext := &MembershipServiceContext{}
+
+	t.Run("Traced", func(t *testing.T) {
+		ctx, heartbeat := tracerProvider.Tracer("test").Start(context.Background(), "Heartbeat")
+		if err := serviceContext.sendMessageToAddress(ctx, address, message, "heartbeatRequest"); err != nil {
+			t.Fatal(err)
+		}
+		heartbeat.End()
+
+		traceContext, ok := api.ReadTraceContext(receiveDatagram(t, receiver))
+		if !ok {
+			t.Fatal("message sent in a trace does not carry a trace context")
+		}
+		spans := recorder.Ended()
+		send := spans[len(spans)-2]
+		if send.Name() != "Send" || send.Parent().SpanID() != heartbeat.SpanContext().SpanID() {
+			t.Fatalf("span %q with parent %v, want Send with parent %v", send.Name(), send.Parent().SpanID(), heartbeat.SpanContext().SpanID())
+		}
+		if traceContext.TraceID != heartbeat.SpanContext().TraceID() || traceContext.SpanID != send.SpanContext().SpanID() {
+			t.Errorf("trace context = %v, want the trace %v and the send span %v", traceContext, heartbeat.SpanContext().TraceID(), send.SpanContext().SpanID())
+		}
+	})
+	t.Run("Untraced", func(t *testing.T) {
+		if err := serviceContext.sendMessageToAddress(context.Background(), address, message, "heartbeatRequest"); err != nil {
+			t.Fatal(err)
+		}
+		if received := receiveDatagram(t, receiver); len(received) != len(message) {
+			t.Errorf("message sent outside a trace is %d bytes, want the %d bytes of the message", len(received), len(message))
+		}
+	})
+}
+
+func TestContextFromMessage(t *testing.T) {
+	tracerProvider, _ := newTestTracerProvider()
+	message := api.HeartbeatResponseMessage.CreateMemberMessage(newTestMember("Bas", "Boreas", "10.0.0.2", "7781"))
+	_, sender := tracerProvider.Tracer("test").Start(context.Background(), "Send")
+	sender.End()
+
+	ctx := contextFromMessage(context.Background(), withTraceContext(message, sender.SpanContext()))
+	remote := trace.SpanContextFromContext(ctx)
+	if !remote.IsRemote() || !remote.Equal(sender.SpanContext().WithRemote(true)) {
+		t.Fatalf("remote span context = %v, want the one of the sender", remote)
+	}
+	_, received := startSpan(ctx, tracerProvider.Tracer("test"), "Membership")
+	if received.SpanContext().TraceID() != sender.SpanContext().TraceID() {
+		t.Errorf("receiving span is in trace %v, want the trace of the sender %v", received.SpanContext().TraceID(), sender.SpanContext().TraceID())
+	}
+
+	if untraced := contextFromMessage(context.Background(), message); trace.SpanContextFromContext(untraced).IsValid() {
+		t.Errorf("message without a trace context has a remote span")
+	}
+}
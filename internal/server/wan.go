@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// The WAN pool is a second gossip ring, which the servers of every datacenter that set wan.enabled join.
+// It runs the same services as our cluster, with the timeouts of wan.*, in a context of its own, on wan.port.
+// Its members carry their datacenter, so a query with ?dc= is forwarded over HTTP to a server of that datacenter,
+// which answers it on its wan.port as well.
+
+// the gossip rings a node can be part of, to tell apart their metrics
+const (
+	PoolLAN = "lan"
+	PoolWAN = "wan"
+)
+
+// wanQueryTimeout is how long we wait for a server of another datacenter to answer a query we forward
+const wanQueryTimeout = 10 * time.Second
+
+// WANPoolServices are the services of the WAN pool, its servers find each other through the seeds, not by multicast
+var WANPoolServices = []MembershipService{
+	HandleClockUpdates,
+	HandleMember,
+	CleanupMembers,
+	HeartbeatCloseMembers,
+	MulticastExistence,
+	StartMembershipServer,
+}
+
+// NewWANPool creates the context of the WAN pool, in which we are the same node as in our cluster,
+// listening on the port of the WAN pool, in our datacenter
+func NewWANPool(serviceContext *MembershipServiceContext) *MembershipServiceContext {
+	currentConfig := serviceContext.CurrentConfig()
+	poolConfig := currentConfig.WANPool()
+	self := *serviceContext.Self
+	self.PortSelf = poolConfig.Port
+	self.Tags = nil
+	self.Segment = ""
+	self.Gateway = false
+	self.Datacenter = currentConfig.Datacenter
+	tracer, tracingEnabled := serviceContext.root().tracerProvider()
+	return &MembershipServiceContext{
+		Context:           serviceContext.Context,
+		Config:            poolConfig,
+		TracingEnabled:    tracingEnabled,
+		TracerProvider:    tracer,
+		SelfAddress:       serviceContext.SelfAddress,
+		Self:              &self,
+		Identity:          self.Identifier(),
+		HelloMessage:      api.HelloMessage.CreateMemberMessage(&self),
+		GoodbyeMessage:    api.GoodbyeMessage.CreateMemberMessage(&self),
+		GoodbyeAck:        api.GoodbyeAckMessage.CreateMemberMessage(&self),
+		HeartbeatRequest:  api.HeartbeatRequestMessage.CreateMemberMessage(&self),
+		HeartbeatResponse: api.HeartbeatResponseMessage.CreateMemberMessage(&self),
+		ServerPort:        poolConfig.Port,
+		Shutdown:          serviceContext.Shutdown,
+		Logger:            serviceContext.Log().With("pool", PoolWAN),
+		Clock:             serviceContext.Clock,
+		Network:           serviceContext.Network,
+		Pool:              PoolWAN,
+		Seed:              serviceContext.Seed,
+	}
+}
+
+// pool returns the gossip ring the context is part of, our cluster unless it is the WAN pool
+func (s *MembershipServiceContext) pool() string {
+	if s.Pool == "" {
+		return PoolLAN
+	}
+	return s.Pool
+}
+
+// pools returns the context of our cluster, and that of the WAN pool if we joined it
+func (s *MembershipServiceContext) pools() []*MembershipServiceContext {
+	if s.WAN == nil {
+		return []*MembershipServiceContext{s}
+	}
+	return []*MembershipServiceContext{s, s.WAN}
+}
+
+// tracerProvider returns the TracerProvider, and whether tracing is enabled
+func (s *MembershipServiceContext) tracerProvider() (*tracesdk.TracerProvider, bool) {
+	s = s.root()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.TracerProvider, s.TracingEnabled
+}
+
+// datacenter returns the datacenter we are in
+func (s *MembershipServiceContext) datacenter() string {
+	if currentConfig := s.CurrentConfig(); currentConfig != nil {
+		return currentConfig.Datacenter
+	}
+	return config.DefaultDatacenter
+}
+
+// datacenterServers returns the servers of the datacenter that are alive in the WAN pool,
+// those with the shortest estimated round trip first
+func (s *MembershipServiceContext) datacenterServers(datacenter string) []api.MemberInfo {
+	servers := make([]api.MemberInfo, 0)
+	for _, memberInfo := range s.MemberInfoSnapshot() {
+		if memberInfo.State == api.MemberStateAlive && memberInfo.Datacenter == datacenter {
+			servers = append(servers, memberInfo)
+		}
+	}
+	// servers we have no estimate for go last
+	sort.SliceStable(servers, func(i, j int) bool {
+		if servers[i].EstimatedRoundTrip == 0 || servers[j].EstimatedRoundTrip == 0 {
+			return servers[j].EstimatedRoundTrip == 0 && servers[i].EstimatedRoundTrip != 0
+		}
+		return servers[i].EstimatedRoundTrip < servers[j].EstimatedRoundTrip
+	})
+	return servers
+}
+
+// wanInfo describes the WAN pool, as we see it
+func (s *MembershipServiceContext) wanInfo() *api.WANInfo {
+	info := &api.WANInfo{
+		ServerPort: s.ServerPort,
+		Members:    map[api.MemberState]int{api.MemberStateAlive: 0, api.MemberStateFailed: 0, api.MemberStateLeft: 0},
+	}
+	datacenters := map[string]bool{s.datacenter(): true}
+	for _, memberInfo := range s.MemberInfoSnapshot() {
+		info.Members[memberInfo.State]++
+		if memberInfo.State == api.MemberStateAlive && memberInfo.Datacenter != "" {
+			datacenters[memberInfo.Datacenter] = true
+		}
+	}
+	info.Datacenters = make([]string, 0, len(datacenters))
+	for datacenter := range datacenters {
+		info.Datacenters = append(info.Datacenters, datacenter)
+	}
+	sort.Strings(info.Datacenters)
+	return info
+}
+
+// ServeForwardedQueries answers the queries the servers of other datacenters forward to ours, on the port of the WAN pool
+func ServeForwardedQueries(serviceContext *MembershipServiceContext) error {
+	ctx := serviceContext.Context
+	logger := serviceContext.Log().With("service", "ServeForwardedQueries")
+	pool := serviceContext.WAN
+	if pool == nil {
+		return fmt.Errorf("we are not in the WAN pool, so no queries are forwarded to us")
+	}
+	address := net.JoinHostPort(pool.Self.IPSelf.String(), pool.ServerPort)
+	httpServer := &http.Server{
+		Addr:    address,
+		Handler: newForwardedQueryHandler(serviceContext),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownContext, cancel := context.WithTimeout(context.Background(), managementShutdownTimeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownContext)
+	}()
+
+	logger.Info("Answering the queries of other datacenters", "address", address)
+	err := httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("could not answer the queries of other datacenters on %s: %w", address, err)
+	}
+	logger.Debug("Closing")
+	return nil
+}
+
+// newForwardedQueryHandler creates the http.Handler for the queries of other datacenters, which can only list our members
+func newForwardedQueryHandler(serviceContext *MembershipServiceContext) http.Handler {
+	managementApi := &managementAPI{serviceContext: serviceContext}
+	mux := http.NewServeMux()
+	mux.HandleFunc(managementPathPrefix+"/members", allowMethods(managementApi.listMembers, http.MethodGet))
+	mux.HandleFunc(managementPathPrefix+"/members/", allowMethods(managementApi.member, http.MethodGet))
+	return mux
+}
+
+// inDatacenter answers the request itself, unless ?dc= names another datacenter, whose servers answer it instead
+func (m *managementAPI) inDatacenter(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		datacenter := r.URL.Query().Get("dc")
+		if datacenter == "" || datacenter == m.serviceContext.datacenter() {
+			handler(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusBadRequest, "only queries can be forwarded to another datacenter")
+			return
+		}
+		m.forwardToDatacenter(w, r, datacenter)
+	}
+}
+
+// forwardToDatacenter forwards the query to the servers of the datacenter, nearest first, until one answers
+func (m *managementAPI) forwardToDatacenter(w http.ResponseWriter, r *http.Request, datacenter string) {
+	logger := m.serviceContext.Log().With("service", "StartManagementServer")
+	pool := m.serviceContext.WAN
+	if pool == nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("this server is not in the WAN pool, so it cannot forward queries to datacenter %q", datacenter))
+		return
+	}
+	servers := pool.datacenterServers(datacenter)
+	if len(servers) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no server of datacenter %q is alive in the WAN pool", datacenter))
+		return
+	}
+
+	query := r.URL.Query()
+	query.Del("dc")
+	for _, remote := range servers {
+		target := url.URL{Scheme: "http", Host: serverAddress(remote), Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: query.Encode()}
+		if err := forwardQuery(r.Context(), w, target.String()); err != nil {
+			logger.Warn("Could not forward query to another datacenter", "datacenter", datacenter, "server", remote.ID, "error", err)
+			continue
+		}
+		return
+	}
+	writeError(w, http.StatusBadGateway, fmt.Sprintf("no server of datacenter %q answered", datacenter))
+}
+
+// forwardQuery writes the answer to the GET request of the target, whatever its status,
+// it only fails when there is no answer, so the next server can be asked
+func forwardQuery(ctx context.Context, w http.ResponseWriter, target string) error {
+	ctx, cancel := context.WithTimeout(ctx, wanQueryTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	w.Header().Set("Content-Type", response.Header.Get("Content-Type"))
+	w.WriteHeader(response.StatusCode)
+	// once we started to answer, whoever asked has to make do with what they get
+	io.Copy(w, response.Body)
+	return nil
+}
+
+// serverAddress is where a server of the WAN pool answers forwarded queries, the address its messages come from
+func serverAddress(server api.MemberInfo) string {
+	ip := server.IP
+	if ip == "" {
+		ip = server.IPSelf
+	}
+	return net.JoinHostPort(ip, server.Port)
+}
+
+// listWANMembers handles GET /wan/members
+func (m *managementAPI) listWANMembers(w http.ResponseWriter, r *http.Request) {
+	pool := m.serviceContext.WAN
+	if pool == nil {
+		writeError(w, http.StatusNotFound, "this server is not in the WAN pool")
+		return
+	}
+	writeJSON(w, http.StatusOK, pool.MemberInfoSnapshot())
+}
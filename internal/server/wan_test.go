@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// newTestDatacenter answers the forwarded queries of a datacenter with a single member, Eve
+func newTestDatacenter(t *testing.T) (*httptest.Server, *api.Member) {
+	t.Helper()
+	self := newTestMember("Server", "eu", "127.0.0.1", "7777")
+	serviceContext := &MembershipServiceContext{
+		Context:  context.Background(),
+		Self:     self,
+		Identity: self.Identifier(),
+	}
+	eve := newTestMember("Eve", "eu", "10.1.0.1", "7777")
+	serviceContext.cluster().members[eve.Identifier()] = eve
+	testServer := httptest.NewServer(newForwardedQueryHandler(serviceContext))
+	t.Cleanup(testServer.Close)
+	return testServer, eve
+}
+
+// newTestWANServer is a WAN pool server of datacenter dc1, that knows a server of datacenter eu
+// which does not answer, and one that does at remote
+func newTestWANServer(t *testing.T, remote *httptest.Server) (*httptest.Server, *MembershipServiceContext) {
+	t.Helper()
+	testServer, serviceContext := newTestManagementServer(t, nil)
+	serviceContext.Config = config.Default()
+	pool := NewWANPool(serviceContext)
+	serviceContext.WAN = pool
+
+	// nobody listens on a port we just closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	remoteURL, _ := url.Parse(remote.URL)
+	_, remotePort, _ := net.SplitHostPort(remoteURL.Host)
+	for _, server := range []*api.Member{
+		newTestMember("Abe", "eu", "127.0.0.1", closedPort),
+		newTestMember("Bob", "eu", "127.0.0.1", remotePort),
+	} {
+		server.Datacenter = "eu"
+		pool.cluster().members[server.Identifier()] = server
+	}
+	return testServer, serviceContext
+}
+
+func TestManagementAPI_ForwardToDatacenter(t *testing.T) {
+	remote, eve := newTestDatacenter(t)
+	testServer, serviceContext := newTestWANServer(t, remote)
+	alan := newTestMember("Alan", "Boreas", "10.0.0.1", "7780")
+	serviceContext.cluster().members[alan.Identifier()] = alan
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"Members", http.MethodGet, "/v1/members?dc=eu", http.StatusOK, []string{eve.Identifier()}},
+		{"Member", http.MethodGet, "/v1/members/" + eve.Identifier() + "?dc=eu", http.StatusOK, []string{eve.Identifier()}},
+		{"UnknownMember", http.MethodGet, "/v1/members/" + alan.Identifier() + "?dc=eu", http.StatusNotFound, nil},
+		{"OwnDatacenter", http.MethodGet, "/v1/members?dc=dc1", http.StatusOK, []string{alan.Identifier()}},
+		{"UnknownDatacenter", http.MethodGet, "/v1/members?dc=us", http.StatusNotFound, nil},
+		{"NotAQuery", http.MethodPost, "/v1/members/" + eve.Identifier() + "/force-leave?dc=eu", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, _ := http.NewRequest(tt.method, testServer.URL+tt.path, nil)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, response.StatusCode, tt.wantStatus)
+			}
+			if tt.wantIDs == nil {
+				return
+			}
+			var body json.RawMessage
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			// a single member, or a list of them
+			var memberInfos []api.MemberInfo
+			if err := json.Unmarshal(body, &memberInfos); err != nil {
+				memberInfos = make([]api.MemberInfo, 1)
+				if err := json.Unmarshal(body, &memberInfos[0]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ids := make([]string, 0, len(memberInfos))
+			for _, memberInfo := range memberInfos {
+				ids = append(ids, memberInfo.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("%s %s = %v, want %v", tt.method, tt.path, ids, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_ForwardWithoutWANPool(t *testing.T) {
+	testServer, _ := newTestManagementServer(t, nil)
+
+	response, err := http.Get(testServer.URL + "/v1/members?dc=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("GET /v1/members?dc=eu = %d, want %d", response.StatusCode, http.StatusBadRequest)
+	}
+	response, err = http.Get(testServer.URL + "/v1/wan/members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /v1/wan/members = %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestForwardedQueryHandler_OnlyMembers(t *testing.T) {
+	remote, _ := newTestDatacenter(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/v1/members", http.StatusOK},
+		{http.MethodGet, "/v1/self", http.StatusNotFound},
+		{http.MethodPost, "/v1/leave", http.StatusNotFound},
+		{http.MethodPost, "/v1/members/Eve@eu/force-leave", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+tt.path, func(t *testing.T) {
+			request, _ := http.NewRequest(tt.method, remote.URL+tt.path, nil)
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, response.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestManagementAPI_WANInfo(t *testing.T) {
+	remote, _ := newTestDatacenter(t)
+	testServer, _ := newTestWANServer(t, remote)
+
+	response, err := http.Get(testServer.URL + "/v1/info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var document map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&document); err != nil {
+		t.Fatal(err)
+	}
+	requireSchemaFields(t, "node-info.json", document)
+	rawInfo, _ := json.Marshal(document)
+	var info api.NodeInfo
+	json.Unmarshal(rawInfo, &info)
+	if info.Datacenter != config.DefaultDatacenter || info.WAN == nil {
+		t.Fatalf("GET /v1/info = %+v, want datacenter %s in the WAN pool", info, config.DefaultDatacenter)
+	}
+	if info.WAN.ServerPort != config.DefaultWANPort || info.WAN.Members[api.MemberStateAlive] != 2 ||
+		!reflect.DeepEqual(info.WAN.Datacenters, []string{config.DefaultDatacenter, "eu"}) {
+		t.Errorf("GET /v1/info describes the WAN pool as %+v, want 2 alive servers, in dc1 and eu", info.WAN)
+	}
+
+	response, err = http.Get(testServer.URL + "/v1/wan/members")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var memberInfos []api.MemberInfo
+	if err := json.NewDecoder(response.Body).Decode(&memberInfos); err != nil {
+		t.Fatal(err)
+	}
+	if len(memberInfos) != 2 || memberInfos[0].Datacenter != "eu" {
+		t.Errorf("GET /v1/wan/members = %+v, want the 2 servers of eu", memberInfos)
+	}
+}
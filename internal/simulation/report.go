@@ -0,0 +1,149 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Report is how the cluster did during a scenario, times are in seconds of simulated time
+type Report struct {
+	Nodes    int     `json:"nodes"`
+	Duration float64 `json:"duration"`
+	Seed     int64   `json:"seed"`
+	// Convergence is how long the cluster took to agree on who is alive, from the start and after every event
+	Convergence []Convergence `json:"convergence"`
+	// Detections are the times a node considered a member failed
+	Detections     int `json:"detections"`
+	FalsePositives int `json:"falsePositives"`
+	// FalsePositiveRate is the share of the detections that were about a member that was alive and reachable
+	FalsePositiveRate float64          `json:"falsePositiveRate"`
+	DetectionLatency  DetectionLatency `json:"detectionLatency"`
+	NodeReports       []NodeReport     `json:"perNode"`
+}
+
+// Convergence is how long the cluster took to agree on who is alive after an event, if it did before the next one
+type Convergence struct {
+	Event     string  `json:"event"`
+	At        float64 `json:"at"`
+	Converged bool    `json:"converged"`
+	Seconds   float64 `json:"seconds,omitempty"`
+}
+
+// DetectionLatency is how long the nodes took to consider a member failed, once it crashed or could no longer be reached
+type DetectionLatency struct {
+	Detected int `json:"detected"`
+	// Undetected are the failures the node did not detect before the member was back, or the scenario ended
+	Undetected int     `json:"undetected"`
+	Min        float64 `json:"min"`
+	Mean       float64 `json:"mean"`
+	Max        float64 `json:"max"`
+	total      float64
+}
+
+func (d *DetectionLatency) add(latency float64) {
+	if d.Detected == 0 || latency < d.Min {
+		d.Min = latency
+	}
+	d.Max = math.Max(d.Max, latency)
+	d.Detected++
+	d.total += latency
+	d.Mean = d.total / float64(d.Detected)
+}
+
+// NodeReport is the message load on a single node, and the failures it detected
+type NodeReport struct {
+	Node              string  `json:"node"`
+	Sent              int     `json:"sent"`
+	Received          int     `json:"received"`
+	Dropped           int     `json:"dropped"`
+	SentPerSecond     float64 `json:"sentPerSecond"`
+	ReceivedPerSecond float64 `json:"receivedPerSecond"`
+	Detections        int     `json:"detections"`
+	FalsePositives    int     `json:"falsePositives"`
+}
+
+func newReport(scenario *Scenario) *Report {
+	report := &Report{
+		Nodes:       scenario.Nodes,
+		Duration:    scenario.Duration.Seconds(),
+		Seed:        scenario.Seed,
+		Convergence: make([]Convergence, 0),
+		NodeReports: make([]NodeReport, scenario.Nodes),
+	}
+	for i := range report.NodeReports {
+		report.NodeReports[i].Node = fmt.Sprintf("Node%d", i)
+	}
+	return report
+}
+
+// finish adds what can only be known once the scenario ended
+func (r *Report) finish(s *simulation) {
+	r.DetectionLatency.Undetected += len(s.undetected)
+	if r.Detections > 0 {
+		r.FalsePositiveRate = float64(r.FalsePositives) / float64(r.Detections)
+	}
+	for i, n := range s.nodes {
+		stats := s.network.Stats(n.ip)
+		nodeReport := &r.NodeReports[i]
+		nodeReport.Sent = stats.Sent
+		nodeReport.Received = stats.Received
+		nodeReport.Dropped = stats.Dropped
+		nodeReport.SentPerSecond = float64(stats.Sent) / r.Duration
+		nodeReport.ReceivedPerSecond = float64(stats.Received) / r.Duration
+	}
+}
+
+// WriteJSON writes the report as a single JSON document
+func (r *Report) WriteJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteCSV writes the report as scope,metric,value rows, so the reports of several runs can be appended and compared
+func (r *Report) WriteCSV(out io.Writer) error {
+	writer := csv.NewWriter(out)
+	rows := [][]string{{"scope", "metric", "value"}}
+	add := func(scope string, metric string, value string) {
+		rows = append(rows, []string{scope, metric, value})
+	}
+	add("cluster", "nodes", strconv.Itoa(r.Nodes))
+	add("cluster", "duration", formatFloat(r.Duration))
+	add("cluster", "seed", strconv.FormatInt(r.Seed, 10))
+	add("cluster", "detections", strconv.Itoa(r.Detections))
+	add("cluster", "falsePositives", strconv.Itoa(r.FalsePositives))
+	add("cluster", "falsePositiveRate", formatFloat(r.FalsePositiveRate))
+	add("cluster", "detectionLatencyDetected", strconv.Itoa(r.DetectionLatency.Detected))
+	add("cluster", "detectionLatencyUndetected", strconv.Itoa(r.DetectionLatency.Undetected))
+	add("cluster", "detectionLatencyMin", formatFloat(r.DetectionLatency.Min))
+	add("cluster", "detectionLatencyMean", formatFloat(r.DetectionLatency.Mean))
+	add("cluster", "detectionLatencyMax", formatFloat(r.DetectionLatency.Max))
+	for _, convergence := range r.Convergence {
+		scope := fmt.Sprintf("%s at %s", convergence.Event, formatFloat(convergence.At))
+		add(scope, "converged", strconv.FormatBool(convergence.Converged))
+		if convergence.Converged {
+			add(scope, "convergence", formatFloat(convergence.Seconds))
+		}
+	}
+	for _, nodeReport := range r.NodeReports {
+		add(nodeReport.Node, "sent", strconv.Itoa(nodeReport.Sent))
+		add(nodeReport.Node, "received", strconv.Itoa(nodeReport.Received))
+		add(nodeReport.Node, "dropped", strconv.Itoa(nodeReport.Dropped))
+		add(nodeReport.Node, "sentPerSecond", formatFloat(nodeReport.SentPerSecond))
+		add(nodeReport.Node, "receivedPerSecond", formatFloat(nodeReport.ReceivedPerSecond))
+		add(nodeReport.Node, "detections", strconv.Itoa(nodeReport.Detections))
+		add(nodeReport.Node, "falsePositives", strconv.Itoa(nodeReport.FalsePositives))
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
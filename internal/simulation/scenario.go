@@ -0,0 +1,202 @@
+package simulation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/transport"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultNodes    = 5
+	DefaultDuration = 5 * time.Minute
+	DefaultStep     = 100 * time.Millisecond
+	DefaultSeed     = 1
+)
+
+// Scenario describes a simulated cluster, the network it runs on, and what happens to it over time
+type Scenario struct {
+	Nodes    int           `yaml:"nodes"`
+	Duration time.Duration `yaml:"duration"`
+	// Step is how far the clock moves at a time, smaller steps are more precise and take longer
+	Step time.Duration `yaml:"step"`
+	Seed int64         `yaml:"seed"`
+	// Membership are the settings every node runs with, anything not set keeps its default
+	Membership config.MembershipConfig `yaml:"membership"`
+	Conditions Conditions              `yaml:"conditions"`
+	Events     []Event                 `yaml:"events"`
+}
+
+// Conditions are what the datagrams go through, see transport.Conditions
+type Conditions struct {
+	Latency     time.Duration `yaml:"latency"`
+	Jitter      time.Duration `yaml:"jitter"`
+	Loss        float64       `yaml:"loss"`
+	Duplication float64       `yaml:"duplication"`
+	Reordering  float64       `yaml:"reordering"`
+}
+
+func (c Conditions) transport() transport.Conditions {
+	return transport.Conditions{
+		Latency:     c.Latency,
+		Jitter:      c.Jitter,
+		Loss:        c.Loss,
+		Duplication: c.Duplication,
+		Reordering:  c.Reordering,
+	}
+}
+
+// Event is something that happens to the cluster at a point in time, exactly one of its actions is set
+type Event struct {
+	At time.Duration `yaml:"at"`
+	// Fail stops the nodes without a goodbye, like a crash
+	Fail []int `yaml:"fail,omitempty"`
+	// Recover starts failed nodes again, with an empty membership list
+	Recover []int `yaml:"recover,omitempty"`
+	// Leave lets the nodes leave the cluster gracefully, after which they stop
+	Leave []int `yaml:"leave,omitempty"`
+	// Partition splits the network into groups, nodes in different groups, or in a group and in none, cannot reach each other
+	Partition [][]int `yaml:"partition,omitempty"`
+	// Heal removes all partitions
+	Heal bool `yaml:"heal,omitempty"`
+	// Conditions replaces the conditions of the network
+	Conditions *Conditions `yaml:"conditions,omitempty"`
+}
+
+// Name describes the event in the report
+func (e Event) Name() string {
+	switch {
+	case e.Fail != nil:
+		return fmt.Sprintf("fail %v", e.Fail)
+	case e.Recover != nil:
+		return fmt.Sprintf("recover %v", e.Recover)
+	case e.Leave != nil:
+		return fmt.Sprintf("leave %v", e.Leave)
+	case e.Partition != nil:
+		return fmt.Sprintf("partition %v", e.Partition)
+	case e.Heal:
+		return "heal"
+	case e.Conditions != nil:
+		return fmt.Sprintf("conditions %+v", *e.Conditions)
+	}
+	return "nothing"
+}
+
+func (e Event) actions() int {
+	actions := 0
+	for _, set := range []bool{e.Fail != nil, e.Recover != nil, e.Leave != nil, e.Partition != nil, e.Heal, e.Conditions != nil} {
+		if set {
+			actions++
+		}
+	}
+	return actions
+}
+
+// DefaultScenario is a cluster that forms, and nothing else happens to
+func DefaultScenario() *Scenario {
+	return &Scenario{
+		Nodes:      DefaultNodes,
+		Duration:   DefaultDuration,
+		Step:       DefaultStep,
+		Seed:       DefaultSeed,
+		Membership: config.Default().Membership,
+	}
+}
+
+// LoadScenario reads a YAML scenario file, over the defaults
+func LoadScenario(file string) (*Scenario, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read scenario file: %w", err)
+	}
+	scenario := DefaultScenario()
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(scenario); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse scenario file %s: %w", file, err)
+	}
+	// the events are applied in order of time, the order in the file breaks ties
+	sort.SliceStable(scenario.Events, func(i, j int) bool {
+		return scenario.Events[i].At < scenario.Events[j].At
+	})
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// Validate checks the scenario, and the membership settings of its nodes, and reports all the problems it finds at once
+func (s *Scenario) Validate() error {
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if s.Nodes < 1 || s.Nodes > 254 {
+		addProblem("nodes must be between 1 and 254, got %d", s.Nodes)
+	}
+	if s.Duration <= 0 {
+		addProblem("duration must be a positive duration, like 5m, got %v", s.Duration)
+	}
+	if s.Step <= 0 {
+		addProblem("step must be a positive duration, like 100ms, got %v", s.Step)
+	}
+	checkConditions := func(key string, conditions Conditions) {
+		if conditions.Latency < 0 || conditions.Jitter < 0 {
+			addProblem("%s.latency and %s.jitter must not be negative", key, key)
+		}
+		for _, chance := range []struct {
+			key   string
+			value float64
+		}{{"loss", conditions.Loss}, {"duplication", conditions.Duplication}, {"reordering", conditions.Reordering}} {
+			if chance.value < 0 || chance.value > 1 {
+				addProblem("%s.%s must be a chance between 0 and 1, got %v", key, chance.key, chance.value)
+			}
+		}
+	}
+	checkConditions("conditions", s.Conditions)
+	checkNodes := func(key string, nodes []int) {
+		for _, node := range nodes {
+			if node < 0 || node >= s.Nodes {
+				addProblem("%s refers to node %d, the nodes are 0 to %d", key, node, s.Nodes-1)
+			}
+		}
+	}
+	for i, event := range s.Events {
+		key := fmt.Sprintf("events[%d]", i)
+		if event.actions() != 1 {
+			addProblem("%s must have exactly one of fail, recover, leave, partition, heal or conditions", key)
+		}
+		if event.At < 0 || event.At > s.Duration {
+			addProblem("%s.at must be between 0 and the duration (%v), got %v", key, s.Duration, event.At)
+		}
+		checkNodes(key+".fail", event.Fail)
+		checkNodes(key+".recover", event.Recover)
+		checkNodes(key+".leave", event.Leave)
+		for _, group := range event.Partition {
+			checkNodes(key+".partition", group)
+		}
+		if event.Conditions != nil {
+			checkConditions(key+".conditions", *event.Conditions)
+		}
+	}
+
+	// the nodes run with the membership settings of the scenario, and defaults for the rest
+	nodeConfig := config.Default()
+	nodeConfig.Membership = s.Membership
+	if err := nodeConfig.Validate(); err != nil {
+		problems = append(problems, strings.Split(strings.TrimPrefix(err.Error(), "invalid configuration:\n  "), "\n  ")...)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid scenario:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
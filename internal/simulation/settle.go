@@ -0,0 +1,57 @@
+package simulation
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// settleTimeout is how long, in real time, the services get to handle a datagram or tick
+const settleTimeout = 5 * time.Second
+
+// settleInterval is how long, in real time, settle waits before it looks again
+const settleInterval = 10 * time.Microsecond
+
+// settle waits until the services handled the datagrams that arrived and the ticks that fired, and everything that
+// caused, which is when every goroutine waits for the next datagram or tick, so nothing happens until the clock moves on
+func (s *simulation) settle() error {
+	deadline := time.Now().Add(settleTimeout)
+	for s.network.Queued() > 0 || s.clock.Unread() > 0 || othersBusy() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the services did not handle what happened at %v in time", s.clock.Now().Sub(start))
+		}
+		runtime.Gosched()
+		time.Sleep(settleInterval)
+	}
+	return nil
+}
+
+// othersBusy reports whether a goroutine other than ours is running, or can run
+func othersBusy() bool {
+	buffer := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buffer, true)
+		if n < len(buffer) {
+			buffer = buffer[:n]
+			break
+		}
+		buffer = make([]byte, 2*len(buffer))
+	}
+	// the stacks are separated by empty lines, and start with a line like "goroutine 7 [chan receive]:",
+	// ours comes first
+	stacks := bytes.Split(buffer, []byte("\n\n"))
+	for _, stack := range stacks[1:] {
+		start := bytes.IndexByte(stack, '[')
+		if start < 0 {
+			continue
+		}
+		state := stack[start+1:]
+		for _, busy := range []string{"running", "runnable", "syscall"} {
+			if bytes.HasPrefix(state, []byte(busy)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,402 @@
+// Package simulation runs a cluster of boom nodes in a single process, over an in-memory network and a fake clock,
+// so how fast it converges and detects failures can be measured for a scenario.
+package simulation
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/joostvdg/boom/api"
+	"github.com/joostvdg/boom/internal/clock"
+	"github.com/joostvdg/boom/internal/config"
+	"github.com/joostvdg/boom/internal/logging"
+	"github.com/joostvdg/boom/internal/server"
+	"github.com/joostvdg/boom/internal/transport"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// services are those of boom-server, without the management API
+var services = []server.MembershipService{
+	server.HandleClockUpdates,
+	server.HandleMember,
+	server.CleanupMembers,
+	server.HeartbeatCloseMembers,
+	server.MulticastExistence,
+	server.StartMembershipServer,
+	server.ListenForMulticast,
+}
+
+// tickersPerNode is how many tickers the services of a single node start
+const tickersPerNode = 3
+
+// startTimeout is how long, in real time, the services of a node get to start their tickers
+const startTimeout = 5 * time.Second
+
+// start is where the fake clock starts, the same for every run, so runs with the same seed are alike
+var start = time.Date(2022, 9, 13, 23, 25, 38, 0, time.UTC)
+
+type node struct {
+	index          int
+	ip             net.IP
+	seed           int64
+	self           *api.Member
+	serviceContext *server.MembershipServiceContext
+	events         chan api.MemberEvent
+	cancel         context.CancelFunc
+	stopped        chan struct{}
+	running        bool
+	leaving        bool
+}
+
+// pair is a node, the observer, and a member it has an opinion about, the subject
+type pair struct {
+	observer int
+	subject  int
+}
+
+type simulation struct {
+	scenario *Scenario
+	logger   *logging.Logger
+	clock    *clock.Fake
+	network  *transport.Memory
+	nodes    []*node
+	// partitions maps the nodes to the group they are in, nodes that are not in one share group 0
+	partitions map[int]int
+	// undetected are the failures a node has yet to detect, by when they happened
+	undetected map[pair]time.Duration
+	// suspected are the members a node considers failed, until it hears from them again
+	suspected map[pair]bool
+	// converging is the index of the phase of the scenario that has yet to converge, -1 if they all did
+	converging int
+	report     *Report
+}
+
+// Run simulates the scenario, and reports how the cluster did
+func Run(scenario *Scenario, logger *logging.Logger) (*Report, error) {
+	fakeClock := clock.NewFake(start)
+	s := &simulation{
+		scenario:   scenario,
+		logger:     logger,
+		clock:      fakeClock,
+		network:    transport.NewMemory(fakeClock, scenario.Seed),
+		partitions: make(map[int]int),
+		undetected: make(map[pair]time.Duration),
+		suspected:  make(map[pair]bool),
+		converging: -1,
+		report:     newReport(scenario),
+	}
+	s.network.SetConditions(scenario.Conditions.transport())
+	seeds := rand.New(rand.NewSource(scenario.Seed))
+	for i := 0; i < scenario.Nodes; i++ {
+		ip := net.IPv4(10, 0, 0, byte(i+1))
+		ipAddress, _ := api.NewIP4Address(ip.String())
+		s.nodes = append(s.nodes, &node{
+			index: i,
+			ip:    ip,
+			seed:  seeds.Int63(),
+			self: &api.Member{
+				ID:         simulatedNodeID(i),
+				MemberName: fmt.Sprintf("Node%d", i),
+				Hostname:   "boom-sim",
+				IP:         &ipAddress,
+				IPSelf:     &ipAddress,
+				PortSelf:   api.HelloPort,
+			},
+		})
+	}
+	defer s.stopAll()
+	for _, n := range s.nodes {
+		if err := s.startNode(n); err != nil {
+			return nil, err
+		}
+	}
+	s.beginPhase("start", 0)
+
+	nextEvent := 0
+	for elapsed := time.Duration(0); elapsed < scenario.Duration; {
+		for nextEvent < len(scenario.Events) && scenario.Events[nextEvent].At <= elapsed {
+			if err := s.apply(scenario.Events[nextEvent], elapsed); err != nil {
+				return nil, err
+			}
+			nextEvent++
+		}
+		// the timers fire one at a time, so the services handle them in the same order and at the same time in every run
+		target := s.clock.Now().Add(scenario.Step)
+		for s.clock.Next(target) {
+			if err := s.settle(); err != nil {
+				return nil, err
+			}
+		}
+		elapsed += scenario.Step
+		s.stopLeftNodes()
+		s.collectEvents()
+		s.checkConvergence(elapsed)
+	}
+
+	s.report.finish(s)
+	return s.report, nil
+}
+
+// simulatedNodeID is the node ID of a node, the same in every run, which it keeps when it restarts
+func simulatedNodeID(index int) api.NodeID {
+	var id api.NodeID
+	copy(id[:], "boom-sim")
+	binary.BigEndian.PutUint64(id[8:], uint64(index+1))
+	return id
+}
+
+// startNode starts the services of a node with an empty membership list, like a node that (re)starts
+func (s *simulation) startNode(n *node) error {
+	nodeConfig := config.Default()
+	nodeConfig.Name = n.self.MemberName
+	nodeConfig.Membership = s.scenario.Membership
+	ctx, cancel := context.WithCancel(context.Background())
+	n.serviceContext = &server.MembershipServiceContext{
+		Context:           ctx,
+		Config:            nodeConfig,
+		Self:              n.self,
+		Identity:          n.self.Identifier(),
+		HelloMessage:      api.HelloMessage.CreateMemberMessage(n.self),
+		GoodbyeMessage:    api.GoodbyeMessage.CreateMemberMessage(n.self),
+		GoodbyeAck:        api.GoodbyeAckMessage.CreateMemberMessage(n.self),
+		HeartbeatRequest:  api.HeartbeatRequestMessage.CreateMemberMessage(n.self),
+		HeartbeatResponse: api.HeartbeatResponseMessage.CreateMemberMessage(n.self),
+		ServerPort:        n.self.PortSelf,
+		Shutdown:          cancel,
+		Logger:            s.logger.With("node", n.self.MemberName),
+		Clock:             s.clock,
+		Network:           s.network.Host(n.ip),
+		Seed:              n.seed,
+	}
+	n.events = n.serviceContext.SubscribeToEvents()
+	n.cancel = cancel
+	n.stopped = make(chan struct{})
+	n.running = true
+	n.leaving = false
+
+	// time can only move once the services started their tickers, or they would miss it
+	pending := s.clock.Pending()
+	go func(serviceContext *server.MembershipServiceContext, stopped chan struct{}) {
+		defer close(stopped)
+		server.Supervise(serviceContext, services)
+	}(n.serviceContext, n.stopped)
+	deadline := time.Now().Add(startTimeout)
+	for s.clock.Pending() < pending+tickersPerNode {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the services of %s did not start in time", n.self.MemberName)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func (s *simulation) stopNode(n *node) {
+	if !n.running {
+		return
+	}
+	n.cancel()
+	<-n.stopped
+	n.running = false
+	n.leaving = false
+}
+
+func (s *simulation) stopAll() {
+	for _, n := range s.nodes {
+		s.stopNode(n)
+	}
+}
+
+// stopLeftNodes stops the nodes that are done leaving
+func (s *simulation) stopLeftNodes() {
+	for _, n := range s.nodes {
+		if n.leaving && n.serviceContext.Err() != nil {
+			s.stopNode(n)
+		}
+	}
+}
+
+// apply makes the event happen, and starts a phase that has to converge
+func (s *simulation) apply(event Event, elapsed time.Duration) error {
+	s.logger.Info("Applying event", "at", elapsed, "event", event.Name())
+	s.collectEvents()
+	views := s.views()
+	switch {
+	case event.Fail != nil:
+		for _, index := range event.Fail {
+			s.stopNode(s.nodes[index])
+		}
+	case event.Recover != nil:
+		for _, index := range event.Recover {
+			if n := s.nodes[index]; !n.running {
+				if err := s.startNode(n); err != nil {
+					return err
+				}
+			}
+		}
+	case event.Leave != nil:
+		for _, index := range event.Leave {
+			n := s.nodes[index]
+			if !n.running || n.leaving {
+				continue
+			}
+			n.leaving = true
+			go func(serviceContext *server.MembershipServiceContext, stop context.CancelFunc) {
+				server.Leave(serviceContext)
+				stop()
+			}(n.serviceContext, n.cancel)
+		}
+	case event.Partition != nil:
+		s.partitions = make(map[int]int)
+		groups := make([][]net.IP, 0)
+		for i, group := range event.Partition {
+			ips := make([]net.IP, 0)
+			for _, index := range group {
+				s.partitions[index] = i + 1
+				ips = append(ips, s.nodes[index].ip)
+			}
+			groups = append(groups, ips)
+		}
+		s.network.Partition(groups...)
+	case event.Heal:
+		s.partitions = make(map[int]int)
+		s.network.Heal()
+	case event.Conditions != nil:
+		s.network.SetConditions(event.Conditions.transport())
+	}
+
+	// a node that saw a member alive, which it can no longer reach, has a failure to detect
+	for observer, view := range views {
+		if !s.nodes[observer].running || s.nodes[observer].leaving {
+			continue
+		}
+		for subject := range view {
+			key := pair{observer: observer, subject: subject}
+			_, pending := s.undetected[key]
+			if !s.reachable(observer, subject) && !s.nodes[subject].leaving && !pending {
+				s.undetected[key] = elapsed
+			}
+		}
+	}
+	// and a failure it no longer has to detect, as the member is back, or it failed itself
+	for key := range s.undetected {
+		switch {
+		case !s.nodes[key.observer].running:
+			delete(s.undetected, key)
+		case s.reachable(key.observer, key.subject):
+			s.report.DetectionLatency.Undetected++
+			delete(s.undetected, key)
+		}
+	}
+	s.beginPhase(event.Name(), elapsed)
+	return nil
+}
+
+// reachable reports whether the observer can hear from the subject
+func (s *simulation) reachable(observer int, subject int) bool {
+	return s.nodes[observer].running && s.nodes[subject].running && s.partitions[observer] == s.partitions[subject]
+}
+
+// views returns the members every running node sees as alive
+func (s *simulation) views() map[int]map[int]bool {
+	byIdentity := make(map[string]int)
+	for _, n := range s.nodes {
+		byIdentity[n.self.Identifier()] = n.index
+	}
+	views := make(map[int]map[int]bool)
+	for _, n := range s.nodes {
+		if !n.running {
+			continue
+		}
+		view := make(map[int]bool)
+		for _, memberInfo := range n.serviceContext.MemberInfoSnapshot() {
+			if index, ok := byIdentity[memberInfo.ID]; ok && memberInfo.State == api.MemberStateAlive {
+				view[index] = true
+			}
+		}
+		views[n.index] = view
+	}
+	return views
+}
+
+// collectEvents takes the membership events of every node, to find the failures they detected
+func (s *simulation) collectEvents() {
+	byIdentity := make(map[string]int)
+	for _, n := range s.nodes {
+		byIdentity[n.self.Identifier()] = n.index
+	}
+	for _, n := range s.nodes {
+	drain:
+		for {
+			select {
+			case event := <-n.events:
+				if subject, ok := byIdentity[event.Member.ID]; ok {
+					s.handleEvent(n.index, subject, event)
+				}
+			default:
+				break drain
+			}
+		}
+	}
+}
+
+func (s *simulation) handleEvent(observer int, subject int, event api.MemberEvent) {
+	key := pair{observer: observer, subject: subject}
+	elapsed := event.Time.Sub(start)
+	switch event.Type {
+	case api.MemberEventJoin:
+		s.suspected[key] = false
+	case api.MemberEventFailed, api.MemberEventReap:
+		if s.suspected[key] {
+			return
+		}
+		s.suspected[key] = true
+		nodeReport := &s.report.NodeReports[observer]
+		nodeReport.Detections++
+		s.report.Detections++
+		if since, pending := s.undetected[key]; pending {
+			s.report.DetectionLatency.add((elapsed - since).Seconds())
+			delete(s.undetected, key)
+		} else if s.reachable(observer, subject) && !s.nodes[subject].leaving {
+			nodeReport.FalsePositives++
+			s.report.FalsePositives++
+			s.logger.Debug("False positive", "at", elapsed, "observer", observer, "subject", subject, "event", event.Type)
+		}
+	}
+}
+
+// beginPhase starts measuring how long the cluster takes to agree again
+func (s *simulation) beginPhase(name string, elapsed time.Duration) {
+	s.report.Convergence = append(s.report.Convergence, Convergence{Event: name, At: elapsed.Seconds()})
+	s.converging = len(s.report.Convergence) - 1
+}
+
+// checkConvergence checks whether every running node sees exactly the nodes it can reach as alive
+func (s *simulation) checkConvergence(elapsed time.Duration) {
+	if s.converging < 0 {
+		return
+	}
+	for observer, view := range s.views() {
+		if s.nodes[observer].leaving {
+			continue
+		}
+		alive := 0
+		for _, n := range s.nodes {
+			if n.index == observer || n.leaving || !s.reachable(observer, n.index) {
+				continue
+			}
+			if !view[n.index] {
+				return
+			}
+			alive++
+		}
+		if len(view) != alive {
+			return
+		}
+	}
+	phase := &s.report.Convergence[s.converging]
+	phase.Converged = true
+	phase.Seconds = elapsed.Seconds() - phase.At
+	s.converging = -1
+}
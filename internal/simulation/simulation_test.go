@@ -0,0 +1,179 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/csv"
+	"github.com/joostvdg/boom/internal/logging"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeScenarioFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDefaultScenario_IsValid(t *testing.T) {
+	if err := DefaultScenario().Validate(); err != nil {
+		t.Errorf("DefaultScenario().Validate() = %v, want no error", err)
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "Valid", content: `
+nodes: 3
+duration: 2m
+membership:
+  heartbeatInterval: 2s
+events:
+  - at: 1m
+    heal: true
+  - at: 30s
+    fail: [1]
+`},
+		{name: "UnknownField", content: "nodez: 3\n", wantErr: "field nodez not found"},
+		{name: "UnknownNode", content: "nodes: 3\nevents:\n  - at: 1m\n    fail: [3]\n", wantErr: "events[0].fail refers to node 3"},
+		{name: "TwoActions", content: "events:\n  - at: 1m\n    fail: [1]\n    heal: true\n", wantErr: "exactly one of"},
+		{name: "AfterTheEnd", content: "duration: 1m\nevents:\n  - at: 2m\n    heal: true\n", wantErr: "events[0].at must be between"},
+		{name: "Chance", content: "conditions:\n  loss: 2\n", wantErr: "conditions.loss must be a chance"},
+		{name: "Membership", content: "membership:\n  maxShortListSize: 0\n", wantErr: "membership.maxShortListSize must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scenario, err := LoadScenario(writeScenarioFile(t, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadScenario() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scenario.Nodes != 3 || scenario.Step != DefaultStep || scenario.Membership.HeartbeatInterval != 2*time.Second {
+				t.Errorf("LoadScenario() = %+v, want the settings of the file over the defaults", scenario)
+			}
+			if scenario.Membership.MaxShortListSize != DefaultScenario().Membership.MaxShortListSize {
+				t.Errorf("LoadScenario() maxShortListSize = %d, want the default", scenario.Membership.MaxShortListSize)
+			}
+			if scenario.Events[0].At != 30*time.Second || scenario.Events[0].Name() != "fail [1]" {
+				t.Errorf("LoadScenario() events = %+v, want them in order of time", scenario.Events)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    int
+		duration time.Duration
+		events   []Event
+		// wantDetected is how many nodes have to notice a member crashed
+		wantDetected int
+	}{
+		{name: "Fail", nodes: 3, duration: 2 * time.Minute, events: []Event{{At: time.Minute, Fail: []int{2}}}, wantDetected: 2},
+		{name: "LeaveAndPartition", nodes: 4, duration: 4 * time.Minute, events: []Event{
+			{At: time.Minute, Leave: []int{3}},
+			{At: 2 * time.Minute, Partition: [][]int{{0, 1}, {2}}},
+			{At: 3 * time.Minute, Heal: true},
+		}, wantDetected: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scenario := DefaultScenario()
+			scenario.Nodes = tt.nodes
+			scenario.Duration = tt.duration
+			scenario.Events = tt.events
+			report, err := Run(scenario, logging.Nop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(report.Convergence) != len(tt.events)+1 {
+				t.Fatalf("Convergence = %+v, want the start and every event", report.Convergence)
+			}
+			for _, convergence := range report.Convergence {
+				if !convergence.Converged {
+					t.Errorf("%s did not converge, all: %+v", convergence.Event, report.Convergence)
+				}
+			}
+			if latency := report.DetectionLatency; latency.Detected != tt.wantDetected || latency.Min <= 0 || latency.Max > time.Minute.Seconds() {
+				t.Errorf("DetectionLatency = %+v, want %d failures detected within a minute", latency, tt.wantDetected)
+			}
+			for _, nodeReport := range report.NodeReports {
+				if nodeReport.Sent == 0 || nodeReport.Received == 0 {
+					t.Errorf("%s sent %d and received %d datagrams, want both", nodeReport.Node, nodeReport.Sent, nodeReport.Received)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_SameSeed(t *testing.T) {
+	scenario := DefaultScenario()
+	scenario.Nodes = 4
+	scenario.Duration = 2 * time.Minute
+	scenario.Seed = 7
+	scenario.Conditions = Conditions{Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond, Loss: 0.05}
+	scenario.Events = []Event{{At: time.Minute, Fail: []int{3}}}
+
+	var reports []string
+	for i := 0; i < 2; i++ {
+		report, err := Run(scenario, logging.Nop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var output bytes.Buffer
+		if err := report.WriteJSON(&output); err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, output.String())
+	}
+	if reports[0] != reports[1] {
+		t.Errorf("two runs with the same seed differ:\n%s\n%s", reports[0], reports[1])
+	}
+}
+
+func TestReport_WriteCSV(t *testing.T) {
+	report := newReport(&Scenario{Nodes: 1, Duration: time.Minute, Seed: 1})
+	report.Convergence = append(report.Convergence, Convergence{Event: "fail [0]", At: 30, Converged: true, Seconds: 12.5})
+	report.NodeReports[0].Sent = 42
+
+	var out bytes.Buffer
+	if err := report.WriteCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"cluster/nodes":                "1",
+		"fail [0] at 30/converged":     "true",
+		"fail [0] at 30/convergence":   "12.5",
+		"Node0/sent":                   "42",
+		"cluster/detectionLatencyMean": "0",
+	}
+	got := make(map[string]string)
+	for _, row := range rows[1:] {
+		got[row[0]+"/"+row[1]] = row[2]
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("WriteCSV() %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"github.com/joostvdg/boom/internal/config"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
+	"go.opentelemetry.io/otel/sdk/metric/export"
+	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
+	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
+	"go.opentelemetry.io/otel/sdk/resource"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// The endpoints the exporters send to when none is configured
+const (
+	DefaultJaegerEndpoint   = "http://localhost:14268/api/traces"
+	DefaultOTLPGRPCEndpoint = "http://localhost:4317"
+	DefaultOTLPHTTPEndpoint = "http://localhost:4318"
+)
+
+// Resource describes this server to the collectors of traces and metrics
+func Resource(service string, name string, environment string) *resource.Resource {
+	return resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceNameKey.String(service),
+		attribute.String("environment", environment),
+		attribute.String("name", name),
+	)
+}
+
+// NewTracerProvider returns a TracerProvider that samples and exports spans as configured.
+// Shut it down when done, so the spans that are still batched are exported.
+func NewTracerProvider(ctx context.Context, tracing config.TracingConfig, res *resource.Resource) (*tracesdk.TracerProvider, error) {
+	exporter, err := newSpanExporter(ctx, tracing)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the %s span exporter: %w", tracing.Exporter, err)
+	}
+	ratio, err := config.SamplerRatio(tracing.Sampler)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sampler %q: %w", tracing.Sampler, err)
+	}
+	return tracesdk.NewTracerProvider(
+		tracesdk.WithBatcher(exporter),
+		tracesdk.WithResource(res),
+		// follow the decision of the member that started the trace, if any
+		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(ratio))),
+	), nil
+}
+
+func newSpanExporter(ctx context.Context, tracing config.TracingConfig) (tracesdk.SpanExporter, error) {
+	switch tracing.Exporter {
+	case config.ExporterJaeger:
+		endpoint := endpointOrDefault(tracing.Endpoint, DefaultJaegerEndpoint)
+		return jaeger.New(jaeger.WithCollectorEndpoint(jaeger.WithEndpoint(endpoint)))
+	case config.ExporterOTLPGRPC:
+		endpoint, err := url.Parse(endpointOrDefault(tracing.Endpoint, DefaultOTLPGRPCEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, options...)
+	case config.ExporterOTLPHTTP:
+		endpoint, err := url.Parse(endpointOrDefault(tracing.Endpoint, DefaultOTLPHTTPEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		if endpoint.Path != "" && endpoint.Path != "/" {
+			options = append(options, otlptracehttp.WithURLPath(endpoint.Path))
+		}
+		return otlptracehttp.New(ctx, options...)
+	case config.ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", tracing.Exporter)
+	}
+}
+
+// NewMeterProvider returns a started MeterProvider that exports the metrics at every interval, as configured.
+// Stop it when done, so the last measurements are exported.
+func NewMeterProvider(ctx context.Context, metrics config.MetricsConfig, res *resource.Resource) (*controller.Controller, error) {
+	exporter, err := newMetricExporter(ctx, metrics)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the %s metric exporter: %w", metrics.Exporter, err)
+	}
+	meterProvider := controller.New(
+		processor.NewFactory(simple.NewWithHistogramDistribution(), exporter),
+		controller.WithExporter(exporter),
+		controller.WithCollectPeriod(metrics.Interval),
+		controller.WithResource(res),
+	)
+	if err := meterProvider.Start(ctx); err != nil {
+		return nil, err
+	}
+	return meterProvider, nil
+}
+
+func newMetricExporter(ctx context.Context, metrics config.MetricsConfig) (export.Exporter, error) {
+	switch metrics.Exporter {
+	case config.ExporterOTLPGRPC:
+		endpoint, err := url.Parse(endpointOrDefault(metrics.Endpoint, DefaultOTLPGRPCEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+	case config.ExporterOTLPHTTP:
+		endpoint, err := url.Parse(endpointOrDefault(metrics.Endpoint, DefaultOTLPHTTPEndpoint))
+		if err != nil {
+			return nil, err
+		}
+		options := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(endpoint.Host)}
+		if endpoint.Scheme == "http" {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		if endpoint.Path != "" && endpoint.Path != "/" {
+			options = append(options, otlpmetrichttp.WithURLPath(endpoint.Path))
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	case config.ExporterStdout:
+		return stdoutmetric.New(stdoutmetric.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", metrics.Exporter)
+	}
+}
+
+func endpointOrDefault(endpoint string, defaultEndpoint string) string {
+	if endpoint == "" {
+		return defaultEndpoint
+	}
+	return endpoint
+}
@@ -0,0 +1,56 @@
+package telemetry
+
+import (
+	"context"
+	"github.com/joostvdg/boom/internal/config"
+	"testing"
+	"time"
+)
+
+func TestNewTracerProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracing config.TracingConfig
+		wantErr bool
+	}{
+		{name: "Jaeger", tracing: config.TracingConfig{Exporter: config.ExporterJaeger, Sampler: config.SamplerAlways}},
+		{name: "OTLPGRPC", tracing: config.TracingConfig{Exporter: config.ExporterOTLPGRPC, Endpoint: "http://localhost:4317", Sampler: "0.5"}},
+		{name: "OTLPHTTP", tracing: config.TracingConfig{Exporter: config.ExporterOTLPHTTP, Endpoint: "https://collector:4318/custom/traces", Sampler: config.SamplerNever}},
+		{name: "Stdout", tracing: config.TracingConfig{Exporter: config.ExporterStdout, Sampler: config.SamplerAlways}},
+		{name: "UnknownExporter", tracing: config.TracingConfig{Exporter: "zipkin", Sampler: config.SamplerAlways}, wantErr: true},
+		{name: "InvalidSampler", tracing: config.TracingConfig{Exporter: config.ExporterStdout, Sampler: "sometimes"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp, err := NewTracerProvider(context.Background(), tt.tracing, Resource("boom-server", "Test", "test"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTracerProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tp == nil {
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			tp.Shutdown(ctx)
+		})
+	}
+}
+
+func TestNewMeterProvider(t *testing.T) {
+	metrics := config.MetricsConfig{Exporter: config.ExporterStdout, Interval: time.Minute}
+	meterProvider, err := NewMeterProvider(context.Background(), metrics, Resource("boom-server", "Test", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meterProvider.IsRunning() {
+		t.Error("NewMeterProvider() did not start the MeterProvider")
+	}
+	if err := meterProvider.Stop(context.Background()); err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	metrics.Exporter = config.ExporterJaeger
+	if _, err := NewMeterProvider(context.Background(), metrics, Resource("boom-server", "Test", "test")); err == nil {
+		t.Error("NewMeterProvider() with the jaeger exporter did not fail, it only supports traces")
+	}
+}
@@ -0,0 +1,339 @@
+package transport
+
+import (
+	"fmt"
+	"github.com/joostvdg/boom/internal/clock"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// memoryQueueSize is how many datagrams a transport buffers, like the receive buffer of a socket it drops the rest
+const memoryQueueSize = 256
+
+// firstEphemeralPort is where the ports that are picked for a transport start
+const firstEphemeralPort = 32768
+
+// Conditions are what the datagrams on a Memory network go through
+type Conditions struct {
+	Latency time.Duration
+	// Jitter is the most a datagram is delayed on top of the latency
+	Jitter time.Duration
+	// Loss is the chance a datagram is dropped
+	Loss float64
+	// Duplication is the chance a datagram is delivered twice
+	Duplication float64
+	// Reordering is the chance a datagram is held back for another latency and jitter, so the next ones overtake it
+	Reordering float64
+}
+
+// HostStats counts the datagrams of a single host
+type HostStats struct {
+	Sent     int
+	Received int
+	Dropped  int
+}
+
+// Memory is a network within the process, which delivers datagrams according to its Conditions and partitions.
+// Time passes by its clock, and the randomness comes from its seed, so a fake clock makes a run repeatable.
+// The fate of a datagram only depends on the seed, the host that sends it, its recipient, its content and when it is sent,
+// so it does not matter in which order the goroutines of the hosts get to send theirs.
+type Memory struct {
+	clock clock.Clock
+
+	lock       sync.Mutex
+	seed       int64
+	conditions Conditions
+	endpoints  map[string]*memoryTransport
+	groups     map[string][]*memoryTransport
+	// partitions maps the hosts to the partition they are in, hosts that are not in one share partition 0
+	partitions map[string]int
+	stats      map[string]*HostStats
+	nextPort   int
+}
+
+// NewMemory creates an in-memory network, without latency, loss or partitions
+func NewMemory(networkClock clock.Clock, seed int64) *Memory {
+	return &Memory{
+		clock:      networkClock,
+		seed:       seed,
+		endpoints:  make(map[string]*memoryTransport),
+		groups:     make(map[string][]*memoryTransport),
+		partitions: make(map[string]int),
+		stats:      make(map[string]*HostStats),
+		nextPort:   firstEphemeralPort,
+	}
+}
+
+// SetConditions changes what the datagrams that are sent from now on go through
+func (m *Memory) SetConditions(conditions Conditions) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.conditions = conditions
+}
+
+// Partition splits the network, hosts in different groups, or in a group and in none, cannot reach each other
+func (m *Memory) Partition(groups ...[]net.IP) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.partitions = make(map[string]int)
+	for i, group := range groups {
+		for _, ip := range group {
+			m.partitions[ip.String()] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (m *Memory) Heal() {
+	m.Partition()
+}
+
+// Stats returns the datagrams the host sent, received, and that were dropped on their way to it
+func (m *Memory) Stats(ip net.IP) HostStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return *m.hostStats(ip)
+}
+
+// Queued returns how many datagrams arrived that no transport received yet, so a simulation can wait for them to be handled
+func (m *Memory) Queued() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	queued := 0
+	for _, endpoint := range m.endpoints {
+		queued += len(endpoint.queue)
+	}
+	for _, listeners := range m.groups {
+		for _, listener := range listeners {
+			queued += len(listener.queue)
+		}
+	}
+	return queued
+}
+
+// Host returns the network as seen from a host with the given address
+func (m *Memory) Host(ip net.IP) Network {
+	return &memoryHost{network: m, ip: ip.To4()}
+}
+
+func (m *Memory) hostStats(ip net.IP) *HostStats {
+	stats := m.stats[ip.String()]
+	if stats == nil {
+		stats = &HostStats{}
+		m.stats[ip.String()] = stats
+	}
+	return stats
+}
+
+// send decides the fate of every copy of the datagram, and schedules the ones that arrive
+func (m *Memory) send(from *memoryTransport, message []byte, to *net.UDPAddr) {
+	m.lock.Lock()
+	m.hostStats(from.address.IP).Sent++
+	var recipients []*memoryTransport
+	if to.IP.IsMulticast() {
+		recipients = append(recipients, m.groups[to.String()]...)
+	} else if recipient := m.endpoints[to.String()]; recipient != nil {
+		recipients = append(recipients, recipient)
+	}
+
+	type delivery struct {
+		recipient *memoryTransport
+		delay     time.Duration
+	}
+	deliveries := make([]delivery, 0, len(recipients))
+	now := m.clock.Now()
+	for _, recipient := range recipients {
+		random := m.random(from.address.IP, recipient.address, message, now)
+		if m.partitions[from.address.IP.String()] != m.partitions[recipient.host.String()] || random.Float64() < m.conditions.Loss {
+			m.hostStats(recipient.host).Dropped++
+			continue
+		}
+		copies := 1
+		if random.Float64() < m.conditions.Duplication {
+			copies++
+		}
+		for i := 0; i < copies; i++ {
+			delay := m.delay(random)
+			if random.Float64() < m.conditions.Reordering {
+				delay += m.delay(random)
+			}
+			deliveries = append(deliveries, delivery{recipient: recipient, delay: delay})
+		}
+	}
+	m.lock.Unlock()
+
+	origin := *from.address
+	for _, scheduled := range deliveries {
+		datagram := memoryDatagram{message: append([]byte(nil), message...), origin: &origin}
+		recipient := scheduled.recipient
+		if scheduled.delay <= 0 {
+			m.deliver(recipient, datagram)
+			continue
+		}
+		m.clock.AfterFunc(scheduled.delay, func() { m.deliver(recipient, datagram) })
+	}
+}
+
+// random returns the randomness for a single datagram, the port it is sent from is left out as it is picked in the order
+// the transports are opened
+func (m *Memory) random(from net.IP, to *net.UDPAddr, message []byte, now time.Time) *rand.Rand {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d %s %s %d ", m.seed, from, to, now.UnixNano())
+	hash.Write(message)
+	return rand.New(rand.NewSource(int64(hash.Sum64())))
+}
+
+func (m *Memory) delay(random *rand.Rand) time.Duration {
+	delay := m.conditions.Latency
+	if m.conditions.Jitter > 0 {
+		delay += time.Duration(random.Int63n(int64(m.conditions.Jitter)))
+	}
+	return delay
+}
+
+func (m *Memory) deliver(recipient *memoryTransport, datagram memoryDatagram) {
+	recipient.lock.Lock()
+	defer recipient.lock.Unlock()
+	if recipient.closed {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	select {
+	case recipient.queue <- datagram:
+		m.hostStats(recipient.host).Received++
+	default:
+		m.hostStats(recipient.host).Dropped++
+	}
+}
+
+// memoryHost opens transports on a Memory network, for a single host
+type memoryHost struct {
+	network *Memory
+	ip      net.IP
+}
+
+func (h *memoryHost) Listen(address *net.UDPAddr) (Transport, error) {
+	m := h.network
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	port := 0
+	if address != nil {
+		if address.IP != nil && !address.IP.IsUnspecified() && !address.IP.Equal(h.ip) {
+			return nil, fmt.Errorf("cannot listen on %s from host %s", address, h.ip)
+		}
+		port = address.Port
+	}
+	if port == 0 {
+		for m.endpoints[(&net.UDPAddr{IP: h.ip, Port: m.nextPort}).String()] != nil {
+			m.nextPort++
+		}
+		port = m.nextPort
+		m.nextPort++
+	}
+	local := &net.UDPAddr{IP: h.ip, Port: port}
+	if m.endpoints[local.String()] != nil {
+		return nil, fmt.Errorf("address %s is already in use", local)
+	}
+	transport := newMemoryTransport(m, h.ip, local)
+	m.endpoints[local.String()] = transport
+	return transport, nil
+}
+
+func (h *memoryHost) ListenMulticast(group *net.UDPAddr) (Transport, error) {
+	if !group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%s is not a multicast group", group)
+	}
+	m := h.network
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	transport := newMemoryTransport(m, h.ip, group)
+	transport.multicast = true
+	m.groups[group.String()] = append(m.groups[group.String()], transport)
+	return transport, nil
+}
+
+type memoryDatagram struct {
+	message []byte
+	origin  *net.UDPAddr
+}
+
+type memoryTransport struct {
+	network *Memory
+	host    net.IP
+	address *net.UDPAddr
+	// multicast transports receive on the group, they send from the host
+	multicast bool
+	queue     chan memoryDatagram
+	done      chan struct{}
+
+	lock   sync.Mutex
+	closed bool
+}
+
+func newMemoryTransport(network *Memory, host net.IP, address *net.UDPAddr) *memoryTransport {
+	return &memoryTransport{
+		network: network,
+		host:    host,
+		address: address,
+		queue:   make(chan memoryDatagram, memoryQueueSize),
+		done:    make(chan struct{}),
+	}
+}
+
+func (t *memoryTransport) Send(message []byte, address *net.UDPAddr) error {
+	t.lock.Lock()
+	closed := t.closed
+	t.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	from := t
+	if t.multicast {
+		from = &memoryTransport{address: &net.UDPAddr{IP: t.host, Port: t.address.Port}}
+	}
+	t.network.send(from, message, address)
+	return nil
+}
+
+func (t *memoryTransport) Receive(buffer []byte) (int, *net.UDPAddr, error) {
+	select {
+	case datagram := <-t.queue:
+		return copy(buffer, datagram.message), datagram.origin, nil
+	case <-t.done:
+		return 0, nil, ErrClosed
+	}
+}
+
+func (t *memoryTransport) LocalAddress() *net.UDPAddr {
+	return t.address
+}
+
+func (t *memoryTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	t.closed = true
+	close(t.done)
+
+	m := t.network
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !t.multicast {
+		delete(m.endpoints, t.address.String())
+		return nil
+	}
+	listeners := m.groups[t.address.String()]
+	for i, listener := range listeners {
+		if listener == t {
+			m.groups[t.address.String()] = append(listeners[:i:i], listeners[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
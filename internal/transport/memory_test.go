@@ -0,0 +1,155 @@
+package transport
+
+import (
+	"github.com/joostvdg/boom/internal/clock"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	alanIP = net.IPv4(10, 0, 0, 1)
+	basIP  = net.IPv4(10, 0, 0, 2)
+	group  = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 1), Port: 9999}
+)
+
+// received returns the datagrams that are waiting on the transport, without blocking
+func received(transport Transport) []string {
+	messages := make([]string, 0)
+	memory := transport.(*memoryTransport)
+	for {
+		select {
+		case datagram := <-memory.queue:
+			messages = append(messages, string(datagram.message))
+		default:
+			return messages
+		}
+	}
+}
+
+func listen(t *testing.T, network Network, address *net.UDPAddr) Transport {
+	t.Helper()
+	transport, err := network.Listen(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { transport.Close() })
+	return transport
+}
+
+func TestMemory_Conditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions Conditions
+		partition  bool
+		advance    time.Duration
+		want       []string
+	}{
+		{name: "Immediate", want: []string{"one", "two"}},
+		{name: "NotArrivedYet", conditions: Conditions{Latency: time.Second}, advance: 999 * time.Millisecond, want: []string{}},
+		{name: "Latency", conditions: Conditions{Latency: time.Second}, advance: time.Second, want: []string{"one", "two"}},
+		{name: "Loss", conditions: Conditions{Loss: 1}, want: []string{}},
+		{name: "Duplication", conditions: Conditions{Duplication: 1}, want: []string{"one", "one", "two", "two"}},
+		{name: "Partition", partition: true, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClock := clock.NewFake(time.Unix(0, 0))
+			memory := NewMemory(fakeClock, 1)
+			memory.SetConditions(tt.conditions)
+			if tt.partition {
+				memory.Partition([]net.IP{alanIP})
+			}
+			alan := listen(t, memory.Host(alanIP), nil)
+			bas := listen(t, memory.Host(basIP), &net.UDPAddr{Port: 7777})
+
+			for _, message := range []string{"one", "two"} {
+				if err := alan.Send([]byte(message), bas.LocalAddress()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fakeClock.Advance(tt.advance)
+			if got := received(bas); len(got) != len(tt.want) {
+				t.Errorf("received %v, want %v", got, tt.want)
+			} else {
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Errorf("received %v, want %v", got, tt.want)
+					}
+				}
+			}
+			stats := memory.Stats(basIP)
+			if stats.Received != len(tt.want) {
+				t.Errorf("Stats() = %+v, want %d received", stats, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestMemory_Reordering(t *testing.T) {
+	fakeClock := clock.NewFake(time.Unix(0, 0))
+	memory := NewMemory(fakeClock, 1)
+	alan := listen(t, memory.Host(alanIP), nil)
+	bas := listen(t, memory.Host(basIP), &net.UDPAddr{Port: 7777})
+
+	memory.SetConditions(Conditions{Latency: time.Second, Reordering: 1})
+	alan.Send([]byte("held back"), bas.LocalAddress())
+	memory.SetConditions(Conditions{Latency: time.Second})
+	alan.Send([]byte("overtakes"), bas.LocalAddress())
+	fakeClock.Advance(2 * time.Second)
+
+	got := received(bas)
+	if len(got) != 2 || got[0] != "overtakes" || got[1] != "held back" {
+		t.Errorf("received %v, want the second datagram first", got)
+	}
+}
+
+func TestMemory_Addresses(t *testing.T) {
+	memory := NewMemory(clock.NewFake(time.Unix(0, 0)), 1)
+	alanNetwork := memory.Host(alanIP)
+	server := listen(t, alanNetwork, &net.UDPAddr{IP: net.IPv4zero, Port: 7777})
+	if server.LocalAddress().String() != "10.0.0.1:7777" {
+		t.Errorf("LocalAddress() = %v, want the address of the host", server.LocalAddress())
+	}
+	if _, err := alanNetwork.Listen(&net.UDPAddr{Port: 7777}); err == nil {
+		t.Errorf("listening twice on the same address succeeded")
+	}
+	if _, err := alanNetwork.Listen(&net.UDPAddr{IP: basIP, Port: 7777}); err == nil {
+		t.Errorf("listening on the address of another host succeeded")
+	}
+
+	bas := listen(t, memory.Host(basIP), nil)
+	bas.Send([]byte("hello"), server.LocalAddress())
+	buffer := make([]byte, 16)
+	numberOfBytes, origin, err := server.Receive(buffer)
+	if err != nil || string(buffer[:numberOfBytes]) != "hello" || !origin.IP.Equal(basIP) {
+		t.Errorf("Receive() = %q from %v, %v, want hello from %v", buffer[:numberOfBytes], origin, err, basIP)
+	}
+
+	server.Close()
+	if _, _, err := server.Receive(buffer); err != ErrClosed {
+		t.Errorf("Receive() on a closed transport = %v, want %v", err, ErrClosed)
+	}
+	// the address is free again
+	listen(t, alanNetwork, &net.UDPAddr{Port: 7777})
+}
+
+func TestMemory_Multicast(t *testing.T) {
+	memory := NewMemory(clock.NewFake(time.Unix(0, 0)), 1)
+	listeners := make([]Transport, 0)
+	for _, ip := range []net.IP{alanIP, basIP} {
+		listener, err := memory.Host(ip).ListenMulticast(group)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		listeners = append(listeners, listener)
+	}
+	sender := listen(t, memory.Host(alanIP), nil)
+	sender.Send([]byte("hello"), group)
+	for i, listener := range listeners {
+		if got := received(listener); len(got) != 1 {
+			t.Errorf("listener %d received %v, want the hello", i, got)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"errors"
+	"github.com/joostvdg/boom/api"
+	"net"
+	"time"
+)
+
+// ErrClosed is returned when sending or receiving on a transport that is closed
+var ErrClosed = errors.New("transport is closed")
+
+// Transport sends and receives the datagrams of the membership protocol on a single local address
+type Transport interface {
+	Send(message []byte, address *net.UDPAddr) error
+	// Receive blocks until a datagram arrives, and returns an error once the transport is closed
+	Receive(buffer []byte) (int, *net.UDPAddr, error)
+	LocalAddress() *net.UDPAddr
+	Close() error
+}
+
+// Network opens transports, over UDP or within the process
+type Network interface {
+	// Listen opens a transport on the address, a nil address or port 0 picks a free port
+	Listen(address *net.UDPAddr) (Transport, error)
+	// ListenMulticast opens a transport that receives the datagrams sent to the multicast group
+	ListenMulticast(group *net.UDPAddr) (Transport, error)
+}
+
+// UDP is the network of the machine
+var UDP Network = udpNetwork{}
+
+type udpNetwork struct{}
+
+func (udpNetwork) Listen(address *net.UDPAddr) (Transport, error) {
+	connection, err := net.ListenUDP(api.MembershipNetwork, address)
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport{connection: connection}, nil
+}
+
+func (udpNetwork) ListenMulticast(group *net.UDPAddr) (Transport, error) {
+	connection, err := net.ListenMulticastUDP(api.MembershipNetwork, nil, group)
+	if err != nil {
+		return nil, err
+	}
+	return &udpTransport{connection: connection}, nil
+}
+
+type udpTransport struct {
+	connection *net.UDPConn
+}
+
+func (t *udpTransport) Send(message []byte, address *net.UDPAddr) error {
+	_, err := t.connection.WriteToUDP(message, address)
+	return err
+}
+
+func (t *udpTransport) Receive(buffer []byte) (int, *net.UDPAddr, error) {
+	return t.connection.ReadFromUDP(buffer)
+}
+
+func (t *udpTransport) LocalAddress() *net.UDPAddr {
+	return t.connection.LocalAddr().(*net.UDPAddr)
+}
+
+// Close also sets the read deadline, else a pending read does not always return, see link below:
+// https://github.com/golang/go/issues/20280#issuecomment-655588450
+func (t *udpTransport) Close() error {
+	t.connection.SetReadDeadline(time.Now())
+	return t.connection.Close()
+}